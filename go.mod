@@ -3,8 +3,12 @@ module simple-backup
 go 1.24.0
 
 require (
+	github.com/pkg/sftp v1.13.6
 	github.com/spf13/pflag v1.0.10
+	golang.org/x/crypto v0.38.0
 	golang.org/x/sys v0.39.0
 	golang.org/x/term v0.38.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/kr/fs v0.1.0 // indirect
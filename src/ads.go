@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// With 'include_ads', NTFS alternate data streams of copied files (e.g. 'Zone.Identifier' with the download origin)
+// are copied too. On NTFS and ReFS destinations they are stored natively, next to the copied content.
+// Other file systems can't keep them, so they go to a sidecar directory in the backup report instead:
+// 'report/ads/<file path>/<stream name>', listed in the manifest like copied files, so 'verify' checks them
+// and the signature covers them. Windows only; the tar stream doesn't carry them.
+// Metadata records 'include_ads', so unlisted sidecars of backups made before they were listed are not
+// reported as unexpected files.
+
+const ADSDirName string = "ads"
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// WHERE ALTERNATE DATA STREAMS ARE STORED
+type adsTarget struct {
+	sidecar string // sidecar directory, empty if streams are stored natively
+}
+
+
+
+//////////////  ADS FUNCTIONS  ////////////////////////////////////////////////
+
+// START COPYING ALTERNATE DATA STREAMS (if configured)
+// Chooses native or sidecar storage by the file system of the destination.
+func (app *BackupApp) startADS() {
+	if !app.BkpConfig.IncludeADS || app.toStdout {
+		return
+	}
+	if runtime.GOOS != "windows" {
+		logger.Info(fmt.Sprintf("%q is supported on Windows only, it's ignored.\n", "include_ads"))
+		return
+	}
+
+	fsName, err := fileSystemName(app.bkpDestFullPath)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Can't read file system of destination, alternate data streams go to sidecar: %v\n", err))
+	}
+	switch strings.ToUpper(fsName) {
+	case "NTFS", "REFS":
+		app.ads = &adsTarget{}
+	default:
+		app.ads = &adsTarget{sidecar: filepath.Join(app.bkpDestFullPath, ReportDirName, ADSDirName)}
+		logger.Info(fmt.Sprintf("Destination can't store alternate data streams, they are copied to %s.\n", app.ads.sidecar))
+	}
+}
+
+
+// COPY ALTERNATE DATA STREAMS OF THE FILE
+// Failures are reported, but don't fail the file: its content is already copied.
+func (app *BackupApp) copyStreams(src, dest string) {
+	streams, err := listStreams(src)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Alternate data streams of %s are not copied: %v\n", src, err))
+		return
+	}
+
+	for _, stream := range streams {
+		target := dest + ":" + stream
+		if app.ads.sidecar != "" {
+			rel, err := filepath.Rel(app.bkpDestFullPath, dest)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Alternate data stream %q of %s is not copied: %v\n", stream, src, err))
+				continue
+			}
+			target = filepath.Join(app.ads.sidecar, rel, stream)
+		}
+		if err := app.copyStream(src+":"+stream, target, app.ads.sidecar != ""); err != nil {
+			logger.Warn(fmt.Sprintf("Alternate data stream %q of %s is not copied: %v\n", stream, src, err))
+		}
+	}
+}
+
+
+// copyStream copies one alternate data stream into the target (another stream, or a sidecar file).
+// With 'record', the target is listed in the manifest (sidecar files).
+func (app *BackupApp) copyStream(src, target string, record bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	hash := newHash(app.BkpConfig.HashAlgorithm)
+	written, err := app.copyBuffered(out, io.TeeReader(in, hash))
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if record {
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		app.recordFile(target, written, info.ModTime(), hash.Sum(nil))
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// listStreams returns no streams: alternate data streams exist on Windows only.
+func listStreams(path string) ([]string, error) {
+	return nil, nil
+}
+
+
+// fileSystemName is not needed outside of Windows.
+func fileSystemName(dir string) (string, error) {
+	return "", errors.New("not supported on this platform")
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procFindFirstStreamW = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+
+// WIN32_FIND_STREAM_DATA
+type findStreamData struct {
+	size int64
+	name [windows.MAX_PATH + 36]uint16
+}
+
+
+// listStreams returns names of alternate data streams of the file (without the main stream and the ':$DATA' suffix).
+func listStreams(path string) ([]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data findStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+
+	var streams []string
+	for {
+		// Names look like ':Zone.Identifier:$DATA'; the main stream is '::$DATA'
+		name, isData := strings.CutSuffix(windows.UTF16ToString(data.name[:]), ":$DATA")
+		if name = strings.TrimPrefix(name, ":"); isData && name != "" {
+			streams = append(streams, name)
+		}
+		ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+				return streams, nil
+			}
+			return streams, err
+		}
+	}
+}
+
+
+// fileSystemName returns the name of the file system of the volume with the directory (e.g. 'NTFS').
+func fileSystemName(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(abs) + `\`)
+	if err != nil {
+		return "", err
+	}
+
+	fsName := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumeInformation(root, nil, 0, nil, nil, nil, &fsName[0], uint32(len(fsName))); err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(fsName), nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"simple-backup/src/style"
+	"time"
+)
+
+// 'advise' projects how the destination fills over time, from the history of complete backups and the
+// estimate of the next one: how many more backups fit, when the free space above 'min_free_space' runs out
+// with the current 'backups_to_keep', and which 'backups_to_keep' keeps it from running out within a horizon.
+// Each run is projected one at a time: the backup grows by the average growth per run seen so far,
+// retention removes the oldest backups above 'backups_to_keep' and gives their space back.
+// With 'dedup: hardlink' a backup takes only new and changed data (measured between consecutive manifests),
+// without it the whole backup. Runs are as frequent as the past ones on average, or as '--interval' says.
+// It's an estimate: files that are packed ('pack_small_files') are not linked, and backups moved to trash
+// take space until 'trash_grace_period' passes.
+
+const (
+	AdviseHorizonDefault string = "365d"
+	AdviseMaxRuns        int    = 100000 // runs projected at most, "never fills" beyond that
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// GROWTH MEASURED OVER COMPLETE BACKUPS
+type capacityTrend struct {
+	next     int64         // estimated size of the next backup
+	growth   float64       // average size change per run
+	changed  int64         // average new and changed data per run, -1 if not known
+	interval time.Duration // average time between runs, zero if not known
+	existing []int64       // sizes of existing backups, oldest first
+	dedup    bool
+}
+
+
+
+//////////////  ADVISE COMMAND  ///////////////////////////////////////////////
+
+// RUN 'ADVISE' COMMAND
+// Exits with non-zero code if the destination fills within the horizon with the current 'backups_to_keep'.
+func runAdviseCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		horizon    = flags.String("horizon", AdviseHorizonDefault, "How long backups should fit the destination (e.g. '180d', '8760h').")
+		interval   = flags.String("interval", "", "Time between runs (e.g. '1d'). Measured from history if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	horizonParsed, err := parseDuration(*horizon)
+	if err != nil || horizonParsed <= 0 {
+		logger.Fatal(fmt.Sprintf("%q value %q is not supported. Expected a positive duration (e.g. '180d').\n\n", "--horizon", *horizon), style.Bold())
+		return 1
+	}
+	var intervalParsed time.Duration
+	if *interval != "" {
+		if intervalParsed, err = parseDuration(*interval); err != nil || intervalParsed <= 0 {
+			logger.Fatal(fmt.Sprintf("%q value %q is not supported. Expected a positive duration (e.g. '1d').\n\n", "--interval", *interval), style.Bold())
+			return 1
+		}
+	}
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	logger.Signature(fmt.Sprintf("\n====  Capacity advice for: %s  ===\n", app.bkpDestFullPath))
+
+	trend, err := app.measureTrend()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to measure growth: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if intervalParsed > 0 {
+		trend.interval = intervalParsed
+	}
+	freeSpace, _, err := getFreeSpace(app.bkpDest)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to get free space of %q: %v\n\n", app.bkpDest, err), style.Bold())
+		return 1
+	}
+	usable := float64(freeSpace) - float64(app.BkpConfig.Retention.minFreeSpaceParsed)
+
+	return app.printAdvice(trend, freeSpace, usable, *horizon, horizonParsed)
+}
+
+
+// MEASURE GROWTH OF BACKUPS
+// The next backup is estimated like 'estimate' does, the rest comes from complete backups.
+func (app *BackupApp) measureTrend() (capacityTrend, error) {
+	trend := capacityTrend{changed: -1, dedup: app.BkpConfig.Dedup == DedupHardlink}
+
+	backups, err := listBackups(app.bkpDestFullPath)
+	if err != nil {
+		return trend, fmt.Errorf("listing backups: %w", err)
+	}
+	history := loadHistory(backups)
+	var complete []historyEntry
+	for _, entry := range history {
+		trend.existing = append(trend.existing, entry.size)
+		if entry.state == BackupComplete {
+			complete = append(complete, entry)
+		}
+	}
+
+	plan, err := app.buildPlan()
+	if err != nil {
+		return trend, err
+	}
+	for _, item := range plan.Items {
+		if item.Error != "" {
+			logger.Warn(fmt.Sprintf("%s is not estimated: %s\n", item.Source, item.Error))
+			continue
+		}
+		if item.Bytes != nil {
+			trend.next += *item.Bytes
+		}
+	}
+
+	if len(complete) < 2 {
+		return trend, nil
+	}
+	first, latest := complete[0], complete[len(complete)-1]
+	runs := len(complete) - 1
+	trend.growth = float64(latest.size-first.size) / float64(runs)
+	trend.interval = latest.created.Sub(first.created) / time.Duration(runs)
+
+	// New and changed data between consecutive backups (files whose path or checksum is new)
+	var changed int64
+	var pairs int
+	var previous map[string]string
+	for _, entry := range complete {
+		manifest, err := readManifest(entry.path)
+		if err != nil {
+			previous = nil
+			continue
+		}
+		current := make(map[string]string, len(manifest))
+		for _, file := range manifest {
+			current[file.path] = file.sum
+			if previous != nil && previous[file.path] != file.sum {
+				changed += file.size
+			}
+		}
+		if previous != nil {
+			pairs++
+		}
+		previous = current
+	}
+	if pairs > 0 {
+		trend.changed = changed / int64(pairs)
+	}
+	return trend, nil
+}
+
+
+// PRINT CAPACITY ADVICE
+func (app *BackupApp) printAdvice(trend capacityTrend, freeSpace uint64, usable float64, label string, horizon time.Duration) int {
+	retention := app.BkpConfig.Retention
+	keep := int(retention.BackupsToKeep)
+
+	logger.Plain("\nMeasured:\n", style.Bold())
+	logger.Plain(fmt.Sprintf("Existing backups: %d\n", len(trend.existing)))
+	logger.Plain(fmt.Sprintf("Next backup: %s (current estimate)\n", formatBytes(uint64(trend.next))))
+	if trend.interval > 0 {
+		logger.Plain(fmt.Sprintf("Time between runs: %s\n", formatAge(trend.interval)))
+	}
+	logger.Plain(fmt.Sprintf("Growth per run: %s\n", formatBytesChange(int64(trend.growth))))
+	if trend.dedup {
+		if trend.changed >= 0 {
+			logger.Plain(fmt.Sprintf("New and changed data per run: %s (taken by each backup with %q)\n", formatBytes(uint64(trend.changed)), "dedup: hardlink"))
+		} else {
+			logger.Plain("New and changed data per run: not known yet, each backup is counted in full\n")
+		}
+	}
+	logger.Plain(fmt.Sprintf("Free space: %s (%q %s)\n", formatBytes(freeSpace), "min_free_space", retention.MinFreeSpace))
+
+	logger.Plain("\nProjection:\n", style.Bold())
+	if usable <= 0 {
+		logger.Err(fmt.Sprintf("Free space is already below %q, lower %q or free up space on the destination.\n\n", "min_free_space", "backups_to_keep"))
+		return 1
+	}
+
+	// Without retention
+	if fit, fills := trend.project(usable, 0, AdviseMaxRuns); fills {
+		logger.Plain(fmt.Sprintf("More backups that fit, if none were removed: %d\n", fit))
+	} else {
+		logger.Plain(fmt.Sprintf("More backups that fit, if none were removed: over %d\n", AdviseMaxRuns))
+	}
+
+	// With the current retention
+	fit, fills := trend.project(usable, keep, AdviseMaxRuns)
+	if fills {
+		logger.Plain(fmt.Sprintf("With %q %d, the destination fills after %d more runs%s.\n", "backups_to_keep", keep, fit, trend.when(fit)))
+	} else {
+		logger.Plain(fmt.Sprintf("With %q %d, the destination doesn't fill at the current growth rate.\n", "backups_to_keep", keep))
+	}
+
+	// Retention for the horizon
+	if trend.interval <= 0 {
+		logger.Plain("\n")
+		logger.Info(fmt.Sprintf("Time between runs is not known (fewer than two complete backups), use %q to project over %s.\n\n", "--interval", label))
+		return 0
+	}
+	runs := min(int(math.Ceil(float64(horizon)/float64(trend.interval))), AdviseMaxRuns)
+
+	// Keeping more backups never takes less space, so the largest that fits is searched by halves
+	best := 0
+	for low, high := int(LimitMinBackupsToKeep), len(trend.existing)+runs; low <= high; {
+		k := (low + high) / 2
+		if _, fills := trend.project(usable, k, runs); fills {
+			high = k - 1
+		} else {
+			best, low = k, k+1
+		}
+	}
+	logger.Plain("\n")
+	switch {
+	case best == 0:
+		logger.Err(fmt.Sprintf("Backups don't fit for %s (%d runs) even with %q %d. A larger destination is needed.\n\n", label, runs, "backups_to_keep", LimitMinBackupsToKeep))
+		return 1
+	case best == len(trend.existing)+runs:
+		logger.Ok(fmt.Sprintf("Backups fit for %s (%d runs) with any %q, none would need to be removed.\n\n", label, runs, "backups_to_keep"), style.NoLabel())
+		return 0
+	case keep > best:
+		logger.Err(fmt.Sprintf("Backups don't fit for %s (%d runs) with %q %d. Set it to %d or less.\n\n", label, runs, "backups_to_keep", keep, best))
+		return 1
+	}
+	logger.Ok(fmt.Sprintf("Backups fit for %s (%d runs) with %q up to %d (currently %d).\n\n", label, runs, "backups_to_keep", best, keep), style.NoLabel())
+	return 0
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// project runs backups one at a time against the usable space, keeping the given number of them
+// (0 keeps all). Returns the number of runs that fit, and true if the space runs out within the limit.
+func (t capacityTrend) project(usable float64, keep, limit int) (int, bool) {
+	// Space given back by removing a backup: all of it, or with dedup the data the next one doesn't share
+	kept := make([]float64, 0, len(t.existing))
+	for _, size := range t.existing {
+		kept = append(kept, t.freed(float64(size)))
+	}
+
+	size := float64(t.next)
+	for run := 0; run < limit; run++ {
+		cost := max(size, 0)
+		if t.dedup && (run > 0 || len(t.existing) > 0) && t.changed >= 0 {
+			cost = float64(t.changed)
+		}
+		if usable -= cost; usable < 0 {
+			return run, true
+		}
+		kept = append(kept, t.freed(size))
+		for keep > 0 && len(kept) > keep {
+			usable += kept[0]
+			kept = kept[1:]
+		}
+		size += t.growth
+	}
+	return limit, false
+}
+
+
+// freed returns the space given back by removing a backup of the size.
+func (t capacityTrend) freed(size float64) float64 {
+	if t.dedup && t.changed >= 0 {
+		return max(float64(t.changed)-t.growth, 0)
+	}
+	return max(size, 0)
+}
+
+
+// when formats the time the given number of runs takes (e.g. ", in about 4.5 months (2027-03-01)"), or nothing if runs are not timed.
+func (t capacityTrend) when(runs int) string {
+	if t.interval <= 0 {
+		return ""
+	}
+	hours := float64(runs) * t.interval.Hours()
+	months := hours / 24 / HistoryDaysPerMonth
+	if hours > float64(math.MaxInt64/int64(time.Hour))/2 {
+		return fmt.Sprintf(", in about %.0f months", months) // past the range of dates
+	}
+	d := time.Duration(hours * float64(time.Hour))
+	return fmt.Sprintf(", in about %.1f months (%s)", months, time.Now().Add(d).Local().Format("2006-01-02"))
+}
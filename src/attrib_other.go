@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// fileAttributes reports whether the item has "hidden" and "system" attributes.
+// There are no such attributes outside of Windows: hidden items are recognized by name only.
+func fileAttributes(info os.FileInfo) (hidden, system bool) {
+	return false, false
+}
+
+
+// copyAttributes does nothing outside of Windows: permissions are copied with the content.
+func copyAttributes(dest string, info os.FileInfo) error {
+	return nil
+}
+
+
+// isCloudPlaceholder reports no placeholders: they are recognized by Windows attributes only.
+func isCloudPlaceholder(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileAttributes reports whether the item has "hidden" and "system" attributes.
+// Remote (SFTP) items don't carry Windows attributes.
+func fileAttributes(info os.FileInfo) (hidden, system bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return false, false
+	}
+	return data.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0, data.FileAttributes&syscall.FILE_ATTRIBUTE_SYSTEM != 0
+}
+
+
+// copyAttributes applies "hidden", "system" and (for files) "read-only" attributes of the source item to its copy.
+// Other attributes of the copy (e.g. "archive", "compressed") are kept as they are.
+func copyAttributes(dest string, info os.FileInfo) error {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+	destPtr, err := syscall.UTF16PtrFromString(dest)
+	if err != nil {
+		return err
+	}
+	current, err := syscall.GetFileAttributes(destPtr)
+	if err != nil {
+		return err
+	}
+
+	// Read-only directories would get in the way of removing old backups (and Windows ignores the attribute for them)
+	var kept uint32 = syscall.FILE_ATTRIBUTE_HIDDEN | syscall.FILE_ATTRIBUTE_SYSTEM
+	if !info.IsDir() {
+		kept |= syscall.FILE_ATTRIBUTE_READONLY
+	}
+	attrs := current&^kept | data.FileAttributes&kept
+	if attrs == current {
+		return nil
+	}
+	return syscall.SetFileAttributes(destPtr, attrs)
+}
+
+
+// isCloudPlaceholder reports whether the file is an online-only placeholder of a cloud sync client (e.g. OneDrive),
+// whose content is downloaded when read.
+func isCloudPlaceholder(info os.FileInfo) bool {
+	const recall = windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS | windows.FILE_ATTRIBUTE_RECALL_ON_OPEN | windows.FILE_ATTRIBUTE_OFFLINE
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && !info.IsDir() && data.FileAttributes&recall != 0
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unattended runs ('--service') can re-verify old backups, so drive rot is caught while other backups
+// still have the files ('background_verify'). After the backup, at most once per 'interval' (e.g. each night
+// of a nightly task), the complete backup verified longest ago (never verified ones first, oldest first)
+// is checked like 'verify', at lowered process priority and within 'max_time' and 'max_read'.
+// A backup that doesn't fit the budget is continued by the next run from the file it stopped at.
+// Verification dates are kept in 'smbkp-verified.yaml' in 'bkp_dest_dir', not in the metadata of backups:
+// metadata is sealed by the completion marker (and protected by 'read_only'). 'verify' records them too.
+// Losing the file only means that backups are verified again.
+
+const (
+	VerifiedFileName               string = "smbkp-verified.yaml"
+	BackgroundVerifyIntervalDefault string = "20h" // a nightly task that starts a bit earlier still verifies
+	BackgroundVerifyMaxTimeDefault  string = "1h"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// BACKGROUND VERIFICATION SETTINGS
+type BackgroundVerifyConfig struct {
+	Enabled         bool   `yaml:"enabled,omitempty"`
+	Interval        string `yaml:"interval,omitempty"` // how often a backup is verified (e.g. "20h", "7d")
+	intervalParsed  time.Duration	// set implicitly by parsing Interval
+	MaxTime         string `yaml:"max_time,omitempty"` // time budget of one verification (e.g. "1h")
+	maxTimeParsed   time.Duration	// set implicitly by parsing MaxTime
+	MaxRead         string `yaml:"max_read,omitempty"` // read budget of one verification (e.g. "50gb", empty - no limit)
+	maxReadParsed   uint64	// set implicitly by parsing MaxRead
+}
+
+
+// VERIFICATION RECORD OF ONE BACKUP
+type verifiedBackup struct {
+	Name       string     `yaml:"name"`
+	VerifiedAt *time.Time `yaml:"verified_at,omitempty"` // when the last verification of the whole backup finished
+	Problems   int        `yaml:"problems"`              // found by that verification
+	ResumeAt   int        `yaml:"resume_at,omitempty"`   // manifest entry the unfinished verification continues from
+	Pending    int        `yaml:"pending_problems,omitempty"` // found so far by the unfinished verification
+}
+
+
+// VERIFICATION RECORDS FILE CONTENT
+type verifiedRecords struct {
+	LastRun *time.Time       `yaml:"last_run,omitempty"` // last background verification
+	Backups []verifiedBackup `yaml:"backups"`
+}
+
+
+// VERIFICATION BUDGET (nil - no limits)
+type verifyBudget struct {
+	deadline time.Time // zero - no time limit
+	maxRead  uint64    // 0 - no read limit
+	readSum  uint64
+}
+
+
+
+//////////////  BACKGROUND VERIFICATION FUNCTIONS  ////////////////////////////
+
+// validate checks the background verification settings.
+func (c *BackgroundVerifyConfig) validate() error {
+	var err error
+	if c.intervalParsed, err = parseDuration(c.Interval); err != nil || c.intervalParsed <= 0 {
+		return fmt.Errorf("%q value %q has invalid format. Expected a positive duration (e.g., '20h', '7d')", "background_verify.interval", c.Interval)
+	}
+	if c.maxTimeParsed, err = parseDuration(c.MaxTime); err != nil || c.maxTimeParsed <= 0 {
+		return fmt.Errorf("%q value %q has invalid format. Expected a positive duration (e.g., '30m', '2h')", "background_verify.max_time", c.MaxTime)
+	}
+	c.maxReadParsed = 0
+	if c.MaxRead != "" {
+		if c.maxReadParsed, err = parseDiskSize(c.MaxRead); err != nil || c.maxReadParsed == 0 {
+			return fmt.Errorf("%q value %q is not supported. Expected a size (e.g. '500mb', '50gb')", "background_verify.max_read", c.MaxRead)
+		}
+	}
+	return nil
+}
+
+
+// spent reports whether the time or read budget is used up.
+func (b *verifyBudget) spent() bool {
+	if b == nil {
+		return false
+	}
+	return (!b.deadline.IsZero() && time.Now().After(b.deadline)) || (b.maxRead > 0 && b.readSum >= b.maxRead)
+}
+
+
+// read counts the bytes read by verification.
+func (b *verifyBudget) read(n int64) {
+	if b != nil && n > 0 {
+		b.readSum += uint64(n)
+	}
+}
+
+
+// VERIFY OLDEST UNVERIFIED BACKUP ('background_verify')
+// Failures are reported, but don't fail the run: the backup itself is done.
+func (app *BackupApp) backgroundVerify(backupRoot string) {
+	c := app.BkpConfig.BackgroundVerify
+	if !c.Enabled || app.toStdout {
+		return
+	}
+	records, err := readVerifiedRecords(backupRoot)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Verification records are not readable, they're recreated: %v\n", err))
+		records = &verifiedRecords{}
+	}
+	if records.LastRun != nil && time.Since(*records.LastRun) < c.intervalParsed {
+		logger.Debug(fmt.Sprintf("Background verification is not due, last one was at %s.\n", records.LastRun.Local().Format(time.DateTime)))
+		return
+	}
+
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Background verification skipped: %v\n", err))
+		return
+	}
+	backup, ok := records.nextBackup(backups)
+	if !ok {
+		return
+	}
+
+	if err := lowerPriority(); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to lower process priority: %v\n", err))
+	}
+	budgetNote := c.MaxTime
+	if c.MaxRead != "" {
+		budgetNote += ", " + c.MaxRead + " read"
+	}
+	logger.Info(fmt.Sprintf("Background verification of the backup verified longest ago (budget %s).\n", budgetNote))
+
+	record := records.backup(backup.name)
+	budget := &verifyBudget{deadline: time.Now().Add(c.maxTimeParsed), maxRead: c.maxReadParsed}
+	problems, next, err := app.verifyBackupPart(backup, record.ResumeAt, budget)
+	now := time.Now().UTC().Truncate(time.Second)
+	records.LastRun = &now
+	switch {
+	case err != nil:
+		logger.Warn(fmt.Sprintf("Background verification of %s failed: %v\n", backup.name, err))
+	case next >= 0:
+		record.ResumeAt, record.Pending = next, record.Pending+problems
+	default:
+		record.VerifiedAt, record.Problems = &now, record.Pending+problems
+		record.ResumeAt, record.Pending = 0, 0
+		if record.Problems > 0 {
+			logger.Err(fmt.Sprintf("Backup %s has %d problems, see the log above. Run 'check' to repair it from other backups.\n", backup.name, record.Problems), style.Bold())
+		} else {
+			logger.Ok(fmt.Sprintf("Backup %s verified.\n", backup.name))
+		}
+	}
+	records.prune(backups)
+	if err := writeVerifiedRecords(backupRoot, records); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to save verification records: %v\n", err))
+	}
+}
+
+
+// recordVerified records the verification of the whole backup by 'verify', so background
+// verification moves on to other backups. Failures are reported only.
+func recordVerified(backupRoot, name string, problems int) {
+	records, err := readVerifiedRecords(backupRoot)
+	if err != nil {
+		records = &verifiedRecords{}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	record := records.backup(name)
+	record.VerifiedAt, record.Problems = &now, problems
+	record.ResumeAt, record.Pending = 0, 0
+	if err := writeVerifiedRecords(backupRoot, records); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to save verification records: %v\n", err))
+	}
+}
+
+
+// nextBackup returns the complete backup to verify: the one with unfinished verification,
+// else never verified ones (oldest first), else the one verified longest ago.
+func (records *verifiedRecords) nextBackup(backups []backupDir) (backupDir, bool) {
+	verifiedAt := make(map[string]time.Time)
+	for _, record := range records.Backups {
+		if record.ResumeAt > 0 {
+			for _, backup := range backups {
+				if backup.name == record.Name && backupSealed(backup.state) {
+					return backup, true
+				}
+			}
+		}
+		if record.VerifiedAt != nil {
+			verifiedAt[record.Name] = *record.VerifiedAt
+		}
+	}
+
+	var candidates []backupDir
+	for _, backup := range backups {
+		if backupSealed(backup.state) {
+			candidates = append(candidates, backup)
+		}
+	}
+	if len(candidates) == 0 {
+		return backupDir{}, false
+	}
+	// Zero time of never verified backups sorts first, ties go to the older backup
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := verifiedAt[candidates[i].name], verifiedAt[candidates[j].name]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return candidates[i].created.Before(candidates[j].created)
+	})
+	return candidates[0], true
+}
+
+
+// backup returns the record of the backup, adding it if it's not recorded yet.
+func (records *verifiedRecords) backup(name string) *verifiedBackup {
+	for i := range records.Backups {
+		if records.Backups[i].Name == name {
+			return &records.Backups[i]
+		}
+	}
+	records.Backups = append(records.Backups, verifiedBackup{Name: name})
+	return &records.Backups[len(records.Backups)-1]
+}
+
+
+// prune drops records of backups that were removed.
+func (records *verifiedRecords) prune(backups []backupDir) {
+	existing := make(map[string]bool, len(backups))
+	for _, backup := range backups {
+		existing[backup.name] = true
+	}
+	kept := records.Backups[:0]
+	for _, record := range records.Backups {
+		if existing[record.Name] {
+			kept = append(kept, record)
+		}
+	}
+	records.Backups = kept
+}
+
+
+// readVerifiedRecords reads the verification records of the backup root. A missing file is empty records.
+func readVerifiedRecords(backupRoot string) (*verifiedRecords, error) {
+	data, err := os.ReadFile(filepath.Join(backupRoot, VerifiedFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &verifiedRecords{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := &verifiedRecords{}
+	if err := yaml.Unmarshal(data, records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", VerifiedFileName, err)
+	}
+	return records, nil
+}
+
+
+// writeVerifiedRecords replaces the verification records of the backup root.
+func writeVerifiedRecords(backupRoot string, records *verifiedRecords) error {
+	data, err := yaml.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(backupRoot, VerifiedFileName), data, 0644)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A source that contains the backup destination would copy earlier backups into each new one
+// (the data doubles with every run), and backups of other tools inside a source are duplicated for nothing.
+// Directories like that are excluded from sources automatically, and listed as skipped:
+//   - the backup root of this run (compared as files, so symlinks and bind mounts are recognized too);
+//   - other simple-backup destinations and backup directories (catalog, canary or metadata file inside);
+//   - backup folders of other tools: Time Machine, Windows Backup, restic and borg repositories.
+// The review warns about sources that contain the destination, and about sources inside of it.
+
+// Backup folders of other tools, recognized by directory name
+var backupFolderNames = map[string]string{
+	"Backups.backupdb":   "Time Machine",
+	"WindowsImageBackup": "Windows Backup",
+}
+
+
+
+//////////////  BACKUP FOLDER FUNCTIONS  //////////////////////////////////////
+
+// backupFolderExclusion returns the reason to skip the directory because it holds backups,
+// or empty string. 'exists' reports whether the directory has a child with the name.
+// 'info' is compared with the backup root for local sources only.
+func (app *BackupApp) backupFolderExclusion(path string, info os.FileInfo, local bool, exists func(name string) bool) string {
+	if !info.IsDir() {
+		return ""
+	}
+	if local && app.isBackupRoot(path, info) {
+		return "excluded: backup destination"
+	}
+	if tool, ok := backupFolderNames[info.Name()]; ok {
+		return fmt.Sprintf("excluded: %s backup", tool)
+	}
+	switch {
+	case exists(CanaryFileName) || exists(LegacyCatalogFileName):
+		return "excluded: simple-backup destination"
+	case exists(MetadataFileName):
+		return "excluded: simple-backup backup"
+	case exists("snapshots") && exists("keys") && exists("config"):
+		return "excluded: restic backup"
+	case exists("nonce") && exists("README") && exists("config"):
+		return "excluded: borg backup"
+	}
+	return ""
+}
+
+
+// isBackupRoot reports whether the local directory is the backup root of the application ('bkp_dest_dir').
+func (app *BackupApp) isBackupRoot(path string, info os.FileInfo) bool {
+	if app.backupRoot == "" {
+		return false
+	}
+	app.backupRootOnce.Do(func() {
+		app.backupRootInfo, _ = os.Stat(app.backupRoot)
+	})
+	if app.backupRootInfo != nil {
+		return os.SameFile(info, app.backupRootInfo)
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && filepath.Clean(abs) == filepath.Clean(app.backupRoot)
+}
+
+
+// WARN ABOUT SOURCE OF THE ITEM OVERLAPPING WITH BACKUP DESTINATION
+func (app *BackupApp) warnDestinationOverlap(item BackupItem) {
+	if app.backupRoot == "" || isStreamItem(item) || isRemoteSource(item.Source) {
+		return
+	}
+	source, err := filepath.Abs(item.Source)
+	if err != nil {
+		return
+	}
+	root := app.backupRoot
+	if real, err := filepath.EvalSymlinks(source); err == nil {
+		source = real
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+
+	switch {
+	case isSubPath(root, source):
+		logger.Warn(fmt.Sprintf("Source is inside the backup destination %q, so backups would be backed up again.\n", root))
+	case isSubPath(source, root):
+		logger.Warn(fmt.Sprintf("Backup destination %q is inside the source, it's excluded automatically.\n", root))
+	}
+}
+
+
+// isSubPath reports whether the path is the parent directory itself or inside of it.
+func isSubPath(parent, path string) bool {
+	rel, err := filepath.Rel(parent, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
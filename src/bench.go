@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sync"
+	"time"
+)
+
+// 'bench' measures how a candidate destination copes with the kinds of writes a backup makes:
+// large sequential files, many small files (with and without parallelism), and metadata operations.
+// All test data is written into a temporary directory, which is removed afterwards.
+const (
+	BenchSizeDefault     string = "256mb"
+	BenchFileSizeDefault string = "16kb"
+	BenchFilesDefault    int    = 500
+	BenchWorkersDefault  int    = 4
+	BenchFsyncSamples    int    = 20
+	BenchChunkSize       int    = 1 * MB
+	BenchTempDirPattern  string = ".smbkp-bench-*"
+	LimitMinBenchFiles   int    = 10
+	LimitMaxBenchWorkers int    = int(LimitMaxCopyWorkers)
+)
+
+// Thresholds used for recommendations
+const (
+	BenchSlowSequential float64       = 20  // MB/s
+	BenchSlowSmallFiles float64       = 100 // files/s
+	BenchParallelGain   float64       = 1.3 // speedup worth enabling copy workers
+	BenchSlowFsync      time.Duration = 10 * time.Millisecond
+	BenchSlowMetadataOp time.Duration = 2 * time.Millisecond
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// BENCHMARK RESULTS
+type benchResult struct {
+	sequential    float64       // MB/s, including final fsync
+	smallFiles    float64       // files/s with a single worker
+	smallParallel float64       // files/s with several workers
+	workers       int
+	fsync         time.Duration // average latency of write+fsync of a small file
+	create        time.Duration // average latencies of metadata operations
+	stat          time.Duration
+	rename        time.Duration
+	remove        time.Duration
+	mkdir         time.Duration
+}
+
+
+
+//////////////  BENCH COMMAND  ////////////////////////////////////////////////
+
+// RUN 'BENCH' COMMAND
+func runBenchCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		dest     = flags.StringP("dest", "d", "", "Destination directory to benchmark (required).")
+		size     = flags.StringP("size", "s", BenchSizeDefault, "Size of the sequential write test file (e.g. 64mb, 1gb).")
+		files    = flags.IntP("files", "f", BenchFilesDefault, "Number of files in small-file and metadata tests.")
+		fileSize = flags.String("file-size", BenchFileSizeDefault, "Size of each file in small-file test (e.g. 4kb).")
+		workers  = flags.IntP("workers", "w", BenchWorkersDefault, "Number of parallel writers in parallel small-file test.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	if *dest == "" {
+		logger.Fatal("Option '--dest' is required.\n", style.Bold())
+		return 1
+	}
+	sizeParsed, err := parseDiskSize(*size)
+	if err != nil || sizeParsed < uint64(BenchChunkSize) {
+		logger.Fatal(fmt.Sprintf("Invalid '--size' value %q: must be at least 1mb.\n", *size), style.Bold())
+		return 1
+	}
+	fileSizeParsed, err := parseDiskSize(*fileSize)
+	if err != nil || fileSizeParsed == 0 || fileSizeParsed > uint64(BenchChunkSize) {
+		logger.Fatal(fmt.Sprintf("Invalid '--file-size' value %q: must be between 1kb and 1mb.\n", *fileSize), style.Bold())
+		return 1
+	}
+	if *files < LimitMinBenchFiles {
+		logger.Warn(fmt.Sprintf("%q value increased from '%d' to '%d', which is allowed minimum.\n", "files", *files, LimitMinBenchFiles))
+		*files = LimitMinBenchFiles
+	}
+	if *workers < 2 || *workers > LimitMaxBenchWorkers {
+		logger.Fatal(fmt.Sprintf("Invalid '--workers' value '%d': must be between 2 and %d.\n", *workers, LimitMaxBenchWorkers), style.Bold())
+		return 1
+	}
+
+	result, err := benchDestination(*dest, sizeParsed, fileSizeParsed, *files, *workers)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Benchmark failed: %v\n", err), style.Bold())
+		return 1
+	}
+
+	printBenchResult(result, fileSizeParsed)
+	return 0
+}
+
+
+// BENCHMARK DESTINATION
+func benchDestination(dest string, size, fileSize uint64, files, workers int) (*benchResult, error) {
+	info, err := os.Stat(dest)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dest)
+	}
+
+	// Both small-file runs keep their files until cleanup
+	required := size + 2*uint64(files)*fileSize
+	if freeSpace, freeSpaceStr, err := getFreeSpace(dest); err == nil && freeSpace < required+LimitMinFreeSpaceParsed {
+		return nil, fmt.Errorf("not enough free space at destination (%s available, %s required)", freeSpaceStr, formatBytes(required+LimitMinFreeSpaceParsed))
+	}
+
+	tmpDir, err := os.MkdirTemp(dest, BenchTempDirPattern)
+	if err != nil {
+		return nil, fmt.Errorf("destination is not writable: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Random data, so compressing or deduplicating file systems don't inflate the numbers
+	chunk := make([]byte, BenchChunkSize)
+	if _, err := rand.Read(chunk); err != nil {
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("Benchmarking %s\n", dest))
+	result := &benchResult{workers: workers}
+
+	spin := newSpinner("Sequential write")
+	spin.update(formatBytes(size))
+	result.sequential, err = benchSequential(filepath.Join(tmpDir, "sequential.bin"), size, chunk)
+	spin.clear()
+	if err != nil {
+		return nil, fmt.Errorf("sequential write: %w", err)
+	}
+
+	spin = newSpinner("Small files")
+	spin.update(fmt.Sprintf("%d files, 1 writer", files))
+	result.smallFiles, err = benchSmallFiles(filepath.Join(tmpDir, "small-1"), files, 1, chunk[:fileSize])
+	spin.clear()
+	if err != nil {
+		return nil, fmt.Errorf("small files: %w", err)
+	}
+
+	spin = newSpinner("Small files")
+	spin.update(fmt.Sprintf("%d files, %d writers", files, workers))
+	result.smallParallel, err = benchSmallFiles(filepath.Join(tmpDir, "small-n"), files, workers, chunk[:fileSize])
+	spin.clear()
+	if err != nil {
+		return nil, fmt.Errorf("parallel small files: %w", err)
+	}
+
+	spin = newSpinner("Metadata operations")
+	spin.update(fmt.Sprintf("%d files", files))
+	err = benchMetadata(filepath.Join(tmpDir, "metadata"), files, chunk[:fileSize], result)
+	spin.clear()
+	if err != nil {
+		return nil, fmt.Errorf("metadata operations: %w", err)
+	}
+
+	return result, nil
+}
+
+
+// benchSequential writes a single large file and returns throughput in MB/s.
+func benchSequential(path string, size uint64, chunk []byte) (float64, error) {
+	start := time.Now()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	for written := uint64(0); written < size; {
+		n := uint64(len(chunk))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := f.Write(chunk[:n]); err != nil {
+			return 0, err
+		}
+		written += n
+	}
+
+	// Data sitting in the OS cache hasn't reached the destination yet
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return float64(size) / float64(MB) / time.Since(start).Seconds(), nil
+}
+
+
+// benchSmallFiles writes 'count' files using 'workers' writers and returns throughput in files/s.
+func benchSmallFiles(dir string, count, workers int, data []byte) (float64, error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	jobs := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%06d", i)), data, 0644); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < count && err == nil; i++ {
+		select {
+		case jobs <- i:
+		case err = <-errs:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case err = <-errs:
+		return 0, err
+	default:
+	}
+
+	// Directory entries must be flushed too, as with 'durability: fsync'
+	if err := syncPath(dir); err != nil && !os.IsPermission(err) {
+		return 0, err
+	}
+
+	return float64(count) / time.Since(start).Seconds(), nil
+}
+
+
+// benchMetadata measures average latencies of file system operations the backup relies on.
+func benchMetadata(dir string, count int, data []byte, result *benchResult) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	names := make([]string, count)
+	for i := range names {
+		names[i] = filepath.Join(dir, fmt.Sprintf("file-%06d", i))
+	}
+
+	// Empty files, so only metadata is measured
+	measure := func(op func(i int) error) (time.Duration, error) {
+		start := time.Now()
+		for i := range names {
+			if err := op(i); err != nil {
+				return 0, err
+			}
+		}
+		return time.Since(start) / time.Duration(count), nil
+	}
+
+	var err error
+	if result.create, err = measure(func(i int) error {
+		f, err := os.Create(names[i])
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}); err != nil {
+		return err
+	}
+	if result.stat, err = measure(func(i int) error {
+		_, err := os.Stat(names[i])
+		return err
+	}); err != nil {
+		return err
+	}
+	if result.rename, err = measure(func(i int) error {
+		return os.Rename(names[i], names[i]+".renamed")
+	}); err != nil {
+		return err
+	}
+	if result.remove, err = measure(func(i int) error {
+		return os.Remove(names[i] + ".renamed")
+	}); err != nil {
+		return err
+	}
+	if result.mkdir, err = measure(func(i int) error {
+		return os.Mkdir(names[i], 0755)
+	}); err != nil {
+		return err
+	}
+
+	// Cost of 'durability: fsync' per file
+	samples := BenchFsyncSamples
+	if samples > count {
+		samples = count
+	}
+	start := time.Now()
+	for i := 0; i < samples; i++ {
+		f, err := os.Create(filepath.Join(names[i], "synced"))
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	result.fsync = time.Since(start) / time.Duration(samples)
+
+	return nil
+}
+
+
+// PRINT BENCHMARK RESULTS AND RECOMMENDATIONS
+func printBenchResult(result *benchResult, fileSize uint64) {
+	speedup := result.smallParallel / result.smallFiles
+
+	logger.Plain("\n")
+	logger.Plain("Throughput:\n", style.Bold())
+	logger.Sub(fmt.Sprintf("%-32s %.1f MB/s\n", "Sequential write:", result.sequential))
+	logger.Sub(fmt.Sprintf("%-32s %.0f files/s\n", fmt.Sprintf("Small files (%s), 1 writer:", formatSmallSize(fileSize)), result.smallFiles))
+	logger.Sub(fmt.Sprintf("%-32s %.0f files/s (x%.1f)\n", fmt.Sprintf("Small files (%s), %d writers:", formatSmallSize(fileSize), result.workers), result.smallParallel, speedup))
+
+	logger.Plain("\n")
+	logger.Plain("Average latency:\n", style.Bold())
+	logger.Sub(fmt.Sprintf("create: %s\n", formatLatency(result.create)))
+	logger.Sub(fmt.Sprintf("stat:   %s\n", formatLatency(result.stat)))
+	logger.Sub(fmt.Sprintf("rename: %s\n", formatLatency(result.rename)))
+	logger.Sub(fmt.Sprintf("remove: %s\n", formatLatency(result.remove)))
+	logger.Sub(fmt.Sprintf("mkdir:  %s\n", formatLatency(result.mkdir)))
+	logger.Sub(fmt.Sprintf("fsync:  %s\n", formatLatency(result.fsync)))
+
+	logger.Plain("\n")
+	logger.Plain("Recommendations:\n", style.Bold())
+
+	if speedup >= BenchParallelGain {
+		logger.Ok(fmt.Sprintf("Parallel writes help on this destination: consider 'copy_workers: %d'.\n", result.workers))
+	} else {
+		logger.Info("Parallel writes don't help on this destination: 'copy_workers: 1' is enough.\n")
+	}
+
+	if result.smallParallel < BenchSlowSmallFiles || result.create > BenchSlowMetadataOp {
+		logger.Warn("Destination is slow with many small files: consider archive mode ('-to-stdout' into a single tar file) or a different drive.\n")
+	}
+
+	if result.fsync > BenchSlowFsync {
+		logger.Warn(fmt.Sprintf("Flushing each file takes %s: 'durability: fsync' will noticeably slow down backups of many files.\n", formatLatency(result.fsync)))
+	}
+
+	if result.sequential < BenchSlowSequential {
+		logger.Warn("Sequential throughput is low: large files will take long to back up, a faster drive or connection is warranted.\n")
+	}
+}
+
+
+// formatLatency rounds the duration for display (e.g. 0.153ms).
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+
+// formatSmallSize formats sizes below 1mb, which formatBytes would show as '0mb'.
+func formatSmallSize(size uint64) string {
+	if size < MB {
+		return fmt.Sprintf("%dkb", size/KB)
+	}
+	return formatBytes(size)
+}
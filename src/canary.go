@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+)
+
+// A canary file with known content is planted next to backups in 'bkp_dest_dir'.
+// Ransomware encrypting the destination alters it along with backups, and retention
+// would then remove the last good (older) backups. So retention refuses to run while the canary is altered.
+// A canary that is missing while backups exist (e.g. renamed by encryption) is alerted about the same way:
+// retention is refused for that run, and a new canary is planted.
+const (
+	CanaryFileName string = "smbkp-canary.txt"
+	CanaryContent  string = "This file is used by Simple Backup to detect tampering with backups.\n" +
+		"Do not modify, move or delete it. If it changes, old backups are no longer removed.\n"
+)
+
+var (
+	errCanaryAltered = errors.New("canary file has been altered")
+	errCanaryMissing = errors.New("canary file is missing, but backups exist")
+)
+
+
+
+//////////////  CANARY FUNCTIONS  /////////////////////////////////////////////
+
+// VERIFY CANARY FILE AT THE START OF BACKUP (planted if missing)
+// Runs before the backup directory is created. Alerts loudly if the canary is altered, or missing while
+// backups exist. Backup still runs, since new backups are needed most at such time.
+func (app *BackupApp) verifyCanary(backupRoot string) {
+	err := checkCanary(backupRoot)
+	switch {
+	case err == nil:
+		return
+
+	case errors.Is(err, os.ErrNotExist):
+		// First run, or the canary was removed (old backups are kept this time)
+		if backups, _ := listBackups(backupRoot); len(backups) > 0 {
+			app.canaryMissing = true
+			logger.Plain("\n")
+			logger.Fatal("!!! CANARY FILE AT BACKUP DESTINATION IS MISSING !!!\n", style.NoLabel(), style.Bold())
+			logger.Fatal(fmt.Sprintf("%q is gone, but backups exist.\n", filepath.Join(backupRoot, CanaryFileName)), style.NoLabel())
+			logger.Fatal("Backups may have been encrypted or tampered with (e.g. by ransomware).\n", style.NoLabel())
+			logger.Fatal("Old backups will NOT be removed by this run. A new canary file is planted.\n\n", style.NoLabel())
+		}
+		if err := plantCanary(backupRoot); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to plant canary file: %v\n", err))
+		}
+
+	case errors.Is(err, errCanaryAltered):
+		logger.Plain("\n")
+		logger.Fatal("!!! CANARY FILE AT BACKUP DESTINATION HAS BEEN ALTERED !!!\n", style.NoLabel(), style.Bold())
+		logger.Fatal(fmt.Sprintf("%q does not match its known content.\n", filepath.Join(backupRoot, CanaryFileName)), style.NoLabel())
+		logger.Fatal("Backups may have been encrypted or tampered with (e.g. by ransomware).\n", style.NoLabel())
+		logger.Fatal("Old backups will NOT be removed. Inspect the destination; once it's safe, delete the canary file.\n\n", style.NoLabel())
+
+	default:
+		logger.Warn(fmt.Sprintf("Failed to verify canary file: %v\n", err))
+	}
+}
+
+
+// canaryGuard returns why old backups must not be removed from the backup root: the canary is altered,
+// or it's missing while backups exist (or was missing at the start of the run). Nil if retention may run.
+func (app *BackupApp) canaryGuard(backupRoot string) error {
+	if app.canaryMissing {
+		return errCanaryMissing
+	}
+	err := checkCanary(backupRoot)
+	if errors.Is(err, errCanaryAltered) {
+		return err
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		if backups, _ := listBackups(backupRoot); len(backups) > 0 {
+			return errCanaryMissing
+		}
+	}
+	return nil
+}
+
+
+// checkCanary verifies the canary file against the hash of its known content.
+func checkCanary(backupRoot string) error {
+	data, err := os.ReadFile(filepath.Join(backupRoot, CanaryFileName))
+	if err != nil {
+		return err
+	}
+	known := sha256.Sum256([]byte(CanaryContent))
+	actual := sha256.Sum256(data)
+	if !bytes.Equal(known[:], actual[:]) {
+		return errCanaryAltered
+	}
+	return nil
+}
+
+
+// plantCanary writes the canary file (read-only, to discourage accidental edits).
+func plantCanary(backupRoot string) error {
+	if err := os.MkdirAll(backupRoot, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(backupRoot, CanaryFileName)
+	if err := os.WriteFile(path, []byte(CanaryContent), 0444); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0444)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The catalog indexes files of all complete backups in 'bkp_dest_dir', one catalog file per backup
+// ('.catalog/<backup>.tsv', built from its manifest). It is refreshed after each run and cleanup: files of new
+// backups are added and files of removed backups are deleted, without rewriting the others. 'find' streams
+// the catalog files one by one instead of walking every backup, so memory use doesn't grow with the number of backups.
+// Catalog files of backups made with 'obfuscate_names' are encrypted like their manifests.
+
+const (
+	CatalogDirName        string = ".catalog"
+	CatalogHeader         string = "# smbkp catalog v2\n# checksum\tsize\tmtime\tpath\n"
+	LegacyCatalogFileName string = "smbkp-catalog.tsv" // single catalog file of earlier versions, replaced on refresh
+	FindLimitDefault      int    = 100
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// CATALOG ENTRY (one file of one backup)
+type catalogEntry struct {
+	backup string // backup directory name
+	manifestEntry
+}
+
+
+
+//////////////  CATALOG FUNCTIONS  ////////////////////////////////////////////
+
+// catalogPath returns the catalog file of the backup.
+func catalogPath(backupRoot, backup string) string {
+	return filepath.Join(backupRoot, CatalogDirName, backup+".tsv")
+}
+
+
+// REFRESH CATALOG OF BACKUP ROOT
+// Catalogs complete backups that have no catalog file yet and deletes catalog files of backups
+// that no longer exist. Failures to catalog a backup are reported at the end, other backups are still cataloged.
+func refreshCatalog(backupRoot string) error {
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	dir := filepath.Join(backupRoot, CatalogDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(backupRoot, LegacyCatalogFileName))
+
+	// Drop removed backups
+	complete := make(map[string]bool)
+	for _, backup := range backups {
+		if backupSealed(backup.state) {
+			complete[backup.name] = true
+		}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	cataloged := make(map[string]bool)
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".tsv")
+		if complete[name] && name != file.Name() {
+			cataloged[name] = true
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	// Add new backups
+	var failed error
+	for _, backup := range backups {
+		if !complete[backup.name] || cataloged[backup.name] {
+			continue
+		}
+		if err := catalogBackup(backupRoot, backup); err != nil {
+			failed = fmt.Errorf("cataloging %s: %w", backup.name, err)
+		}
+	}
+	return failed
+}
+
+
+// catalogBackup writes the catalog file of the backup from its manifest, so a failed write never
+// leaves a truncated catalog file. The file of a backup made with 'obfuscate_names' is encrypted with the config key.
+// Backups without a readable manifest are not cataloged.
+func catalogBackup(backupRoot string, backup backupDir) error {
+	manifest, err := readManifest(backup.path)
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Not cataloging %s: %v\n", backup.name, err))
+		return nil
+	}
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].path < manifest[j].path })
+
+	var buf bytes.Buffer
+	buf.WriteString(CatalogHeader)
+	for _, entry := range manifest {
+		fmt.Fprintf(&buf, "%s\t%d\t%s\t%s\n", entry.sum, entry.size, entry.modTime.UTC().Format(time.RFC3339), manifestPathEscaper.Replace(entry.path))
+	}
+
+	data := buf.Bytes()
+	if backupObfuscated(backup.path) {
+		passphrase, err := configKey()
+		if err != nil {
+			return fmt.Errorf("encrypting catalog: %w", err)
+		}
+		if data, err = sealBytes(data, passphrase, ReportEncryptedMagic); err != nil {
+			return fmt.Errorf("encrypting catalog: %w", err)
+		}
+	}
+	return replaceFile(catalogPath(backupRoot, backup.name), data, 0644)
+}
+
+
+// scanCatalog calls 'fn' for each entry of the catalog file of the backup. Plain catalog files are streamed,
+// encrypted ones are decrypted in memory (one backup at a time).
+func scanCatalog(backupRoot, backup string, fn func(manifestEntry)) error {
+	path := catalogPath(backupRoot, backup)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, 64*KB)
+	var source io.Reader = reader
+	if head, _ := reader.Peek(len(ReportEncryptedMagic)); string(head) == ReportEncryptedMagic {
+		data, err := readReportData(path)
+		if err != nil {
+			return err
+		}
+		source = bytes.NewReader(data)
+	}
+
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*KB), MB)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, err := parseManifestLine(text)
+		if err != nil {
+			return fmt.Errorf("catalog line %d: %w", line, err)
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+
+
+//////////////  FIND COMMAND  /////////////////////////////////////////////////
+
+// RUN 'FIND' COMMAND
+func runFindCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		limit      = flags.Int("limit", FindLimitDefault, "Maximum number of matches to show (0 - all).")
+	)
+	flags.Parse(args)
+
+	if *showHelp || flags.NArg() != 1 {
+		flags.Usage()
+		if *showHelp {
+			return 0
+		}
+		return 1
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	if err := refreshCatalog(app.bkpDestFullPath); err != nil {
+		logger.Warn(fmt.Sprintf("Catalog is not complete: %v\n", err))
+	}
+
+	pattern := flags.Arg(0)
+	matches, err := findInCatalog(app.bkpDestFullPath, pattern)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Catalog failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	logger.Plain(fmt.Sprintf("\nMatches of %q: %d\n", pattern, len(matches)))
+	if len(matches) == 0 {
+		return 0
+	}
+
+	shown := matches
+	if *limit > 0 && len(shown) > *limit {
+		shown = shown[:*limit]
+	}
+	table := style.NewTable("Backup", "Size", "Modified", "Path").AlignRight(1)
+	for _, entry := range shown {
+		table.Row(entry.backup, formatBytes(uint64(entry.size)), entry.modTime.Local().Format("2006-01-02 15:04"), entry.path)
+	}
+	logger.Plain(table.Render())
+	if len(shown) < len(matches) {
+		logger.Info(fmt.Sprintf("%d more matches are not shown (see '--limit').\n", len(matches)-len(shown)))
+	}
+	return 0
+}
+
+
+// findInCatalog returns entries matching the pattern, sorted by path and from the newest backup.
+// Patterns with wildcards match the whole path or the file name; other patterns match any part of the path.
+// Matching is case-insensitive. Catalog files are read one by one and only matches are kept.
+// An unreadable catalog file is rebuilt from the manifest of its backup.
+func findInCatalog(backupRoot, pattern string) ([]catalogEntry, error) {
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+
+	pattern = strings.ToLower(pattern)
+	wildcard := strings.ContainsAny(pattern, "*?[")
+
+	var matches []catalogEntry
+	for _, backup := range backups {
+		if !backupSealed(backup.state) {
+			continue
+		}
+		found := len(matches)
+		match := func(entry manifestEntry) {
+			p := strings.ToLower(entry.path)
+			matched := strings.Contains(p, pattern)
+			if wildcard {
+				full, _ := path.Match(pattern, p)
+				base, _ := path.Match(pattern, path.Base(p))
+				matched = full || base
+			}
+			if matched {
+				matches = append(matches, catalogEntry{backup: backup.name, manifestEntry: entry})
+			}
+		}
+		err := scanCatalog(backupRoot, backup.name, match)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn(fmt.Sprintf("Rebuilding catalog of %s: %v\n", backup.name, err))
+			matches = matches[:found]
+			if err = catalogBackup(backupRoot, backup); err == nil {
+				err = scanCatalog(backupRoot, backup.name, match)
+			}
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn(fmt.Sprintf("Not searching %s: %v\n", backup.name, err))
+			matches = matches[:found]
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].path != matches[j].path {
+			return matches[i].path < matches[j].path
+		}
+		return matches[i].backup > matches[j].backup
+	})
+	return matches, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Files that change while they're copied (logs, mailboxes, documents being saved) would give torn copies,
+// part old and part new content. After a local file is copied, its size and modification time are read again:
+// if they changed, the copy is discarded and the file copied again after 'ChangedRetryDelay',
+// up to 'changed_retries' times. A file that is still changing after that keeps its last copy, and is flagged
+// as "changed" in the skipped report, counted in the item results (metadata 'files_changed') and listed
+// in the summary, since the copy may be inconsistent. Files written into a '-to-stdout' archive can't be
+// copied again, so they are flagged right away. Remote sources are not checked.
+
+const (
+	SkipChanged           string        = "changed"
+	ChangedRetriesDefault uint8         = 3
+	ChangedRetryDelay     time.Duration = time.Second
+)
+
+var errSourceChanged = errors.New("source changed while being copied")
+
+
+
+//////////////  CHANGING FILE FUNCTIONS  //////////////////////////////////////
+
+// sourceChanged reads the size and modification time of the open source file again,
+// and returns errSourceChanged describing the change if they differ from 'info'.
+func sourceChanged(f *os.File, info os.FileInfo) error {
+	now, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSourceChanged, err)
+	}
+	switch {
+	case now.Size() != info.Size():
+		return fmt.Errorf("%w (size %d -> %d bytes)", errSourceChanged, info.Size(), now.Size())
+	case !now.ModTime().Equal(info.ModTime()):
+		return fmt.Errorf("%w (modified at %s)", errSourceChanged, now.ModTime().Format(time.RFC3339))
+	}
+	return nil
+}
+
+
+// COPY FILE, AGAIN IF IT CHANGES MEANWHILE
+// Returns whether the copy kept is of a file that was still changing.
+func (app *BackupApp) copyFile(src, dest string, progressCb func()) (bool, error) {
+	retries := int(ChangedRetriesDefault)
+	if app.BkpConfig.ChangedRetries != nil {
+		retries = int(*app.BkpConfig.ChangedRetries)
+	}
+	if app.tarOut != nil {
+		retries = 0 // already written into the archive
+	}
+	for attempt := 0; ; attempt++ {
+		changed, err := app.copyFileOnce(src, dest, attempt < retries)
+		if errors.Is(err, errSourceChanged) {
+			logger.Verbose(fmt.Sprintf("  %s: %v, copying again (%d/%d)\n", src, err, attempt+1, retries))
+			time.Sleep(ChangedRetryDelay)
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if changed {
+			logger.Warn(fmt.Sprintf("File changed while being copied, the copy may be inconsistent: %s\n", src))
+			app.addSkipped(skippedEntry{path: src, reason: SkipChanged + ": modified while being copied, the copy may be inconsistent"})
+		}
+		progressCb()
+		return changed, nil
+	}
+}
+
+
+// copyFileOnce copies the file and checks that it didn't change meanwhile. With 'retry', a changed file
+// is not finalized and errSourceChanged is returned; otherwise the copy is kept and reported as changed.
+func (app *BackupApp) copyFileOnce(src, dest string, retry bool) (bool, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	settle := func() error {
+		err := sourceChanged(srcFile, srcInfo)
+		if err != nil && retry {
+			return err
+		}
+		changed = err != nil
+		return nil
+	}
+	if err := app.writeFile(dest, srcFile, srcInfo, settle); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"strings"
+)
+
+// 'check' looks for structural damage across all complete backups of the destination, without reading
+// every file ('verify' compares the content of one backup with its manifest):
+//   missing       - file listed in the manifest is not on disk
+//   size differs  - file on disk has another size than in the manifest
+//   broken pack   - packed file points to a missing pack file, or past its end ('pack_small_files')
+//   orphaned pack - pack file that no packed file points to
+//   not linked    - with 'dedup: hardlink', file unchanged since the previous backup (same checksum)
+//                   that is a separate copy instead of a hard link, e.g. after a copy of the repository
+// With '--repair', missing, truncated and broken packed files are restored from another backup
+// that has the same content (checked by its checksum before it's used), orphaned packs are removed
+// and unchanged files are linked again. Files no other backup has are reported as unrecoverable:
+// the next backup run copies them again if they still exist on the source.
+
+const CheckLimitDefault int = 100
+
+// Problem kinds
+const (
+	CheckMissing      string = "missing"
+	CheckSizeDiffers  string = "size differs"
+	CheckBrokenPack   string = "broken pack"
+	CheckOrphanedPack string = "orphaned pack"
+	CheckNotLinked    string = "not linked"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// BACKUP UNDER CHECK
+type checkedBackup struct {
+	backupDir
+	entries    []manifestEntry
+	packs      map[string]packEntry // packed files by path on disk
+	obfuscated bool
+	algorithm  string
+	unlocked   bool // read-only protection was lifted for the repair and must be restored
+	repacked   bool // pack index lost entries and must be rewritten
+}
+
+
+// PROBLEM FOUND BY CHECK
+type checkProblem struct {
+	backup   *checkedBackup
+	entry    manifestEntry // file with the problem (path is the pack file name for orphaned packs)
+	kind     string
+	previous *checkedBackup // backup the file should be linked to (not linked)
+	result   string         // after repair: "repaired" or why it's unrecoverable
+}
+
+
+// FILE CONTENT AVAILABLE IN A BACKUP (repair source)
+type contentRef struct {
+	backup *checkedBackup
+	entry  manifestEntry
+}
+
+
+
+//////////////  CHECK COMMAND  ////////////////////////////////////////////////
+
+// RUN 'CHECK' COMMAND
+// Exits with non-zero code if any problem is left unrepaired.
+func runCheckCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		repair     = flags.Bool("repair", false, "Repair what can be repaired from the other backups.")
+		limit      = flags.Int("limit", CheckLimitDefault, "Maximum number of problems to list (0 - all).")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backups, err := loadCheckedBackups(app.bkpDestFullPath)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+	logger.Signature(fmt.Sprintf("\n====  Checking %d backups in: %s  ===\n", len(backups), app.bkpDestFullPath))
+
+	var problems []*checkProblem
+	spin := newSpinner("Checking")
+	for i, backup := range backups {
+		spin.update(backup.name)
+		var previous *checkedBackup
+		if app.BkpConfig.Dedup == DedupHardlink && i+1 < len(backups) {
+			previous = backups[i+1]
+		}
+		found, err := checkBackup(backup, previous)
+		if err != nil {
+			spin.clear()
+			logger.Fatal(fmt.Sprintf("Checking %s failed: %v\n\n", backup.name, err), style.Bold())
+			return 1
+		}
+		problems = append(problems, found...)
+	}
+	spin.clear()
+
+	if *repair && len(problems) > 0 {
+		key, err := app.signingKey()
+		if err != nil {
+			logger.Fatal(fmt.Sprintf("Repair failed: %v\n\n", err), style.Bold())
+			return 1
+		}
+		if err := repairProblems(backups, problems, key, app.BkpConfig.immutableBackups()); err != nil {
+			logger.Fatal(fmt.Sprintf("Repair failed: %v\n\n", err), style.Bold())
+			return 1
+		}
+	}
+	return printCheck(problems, *repair, *limit)
+}
+
+
+// loadCheckedBackups reads manifests and pack indexes of the complete backups, newest first.
+// Backups made with 'obfuscate_names' need the config key.
+func loadCheckedBackups(backupRoot string) ([]*checkedBackup, error) {
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+
+	var checked []*checkedBackup
+	for _, backup := range backups {
+		if !backupSealed(backup.state) {
+			continue
+		}
+		b := &checkedBackup{backupDir: backup, obfuscated: backupObfuscated(backup.path)}
+		if b.entries, err = readManifest(backup.path); err != nil {
+			return nil, fmt.Errorf("reading manifest of %s: %w", backup.name, err)
+		}
+		if b.algorithm, err = manifestAlgorithm(backup.path); err != nil {
+			return nil, fmt.Errorf("reading manifest of %s: %w", backup.name, err)
+		}
+		if b.packs, err = readPackIndex(backup.path); err != nil {
+			return nil, fmt.Errorf("reading pack index of %s: %w", backup.name, err)
+		}
+		checked = append(checked, b)
+	}
+	if len(checked) == 0 {
+		return nil, fmt.Errorf("no complete backups found in %q", backupRoot)
+	}
+	return checked, nil
+}
+
+
+// CHECK ONE BACKUP
+// 'previous' is the backup unchanged files should be linked from ('dedup: hardlink'), or nil.
+func checkBackup(b, previous *checkedBackup) ([]*checkProblem, error) {
+	var problems []*checkProblem
+	packSizes := make(map[string]int64) // -1 if missing
+	referenced := make(map[string]bool)
+
+	var previousFiles map[string]manifestEntry
+	if previous != nil && previous.algorithm == b.algorithm {
+		previousFiles = make(map[string]manifestEntry, len(previous.entries))
+		for _, entry := range previous.entries {
+			previousFiles[entry.path] = entry
+		}
+	}
+
+	for _, entry := range b.entries {
+		disk, err := b.diskPath(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		// Packed file must fit into its pack file
+		if pack, ok := b.packs[disk]; ok {
+			referenced[pack.pack] = true
+			size, known := packSizes[pack.pack]
+			if !known {
+				size = -1
+				if info, err := os.Stat(filepath.Join(b.path, ReportDirName, PackDirName, pack.pack)); err == nil {
+					size = info.Size()
+				}
+				packSizes[pack.pack] = size
+			}
+			if pack.size != entry.size || pack.offset+pack.size > size {
+				problems = append(problems, &checkProblem{backup: b, entry: entry, kind: CheckBrokenPack})
+			}
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(b.path, disk))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, &checkProblem{backup: b, entry: entry, kind: CheckMissing})
+			continue
+		case err != nil:
+			return nil, err
+		case info.Size() != entry.size:
+			problems = append(problems, &checkProblem{backup: b, entry: entry, kind: CheckSizeDiffers})
+			continue
+		}
+
+		// Unchanged file should be the same file as in the previous backup
+		prev, ok := previousFiles[entry.path]
+		if !ok || prev.sum != entry.sum || prev.size != entry.size {
+			continue
+		}
+		prevDisk, err := previous.diskPath(prev)
+		if err != nil {
+			return nil, err
+		}
+		if _, packed := previous.packs[prevDisk]; packed {
+			continue
+		}
+		if prevInfo, err := os.Lstat(filepath.Join(previous.path, prevDisk)); err == nil && !os.SameFile(info, prevInfo) {
+			problems = append(problems, &checkProblem{backup: b, entry: entry, kind: CheckNotLinked, previous: previous})
+		}
+	}
+
+	// Pack files nothing points to
+	packFiles, err := os.ReadDir(filepath.Join(b.path, ReportDirName, PackDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range packFiles {
+		if f.Name() != PackIndexFileName && !referenced[f.Name()] {
+			problems = append(problems, &checkProblem{backup: b, entry: manifestEntry{path: f.Name()}, kind: CheckOrphanedPack})
+		}
+	}
+	return problems, nil
+}
+
+
+// diskPath returns the path of the manifest entry on disk, relative to the backup directory (as in the pack index).
+func (b *checkedBackup) diskPath(entry manifestEntry) (string, error) {
+	disk, err := backupDiskPath(entry.path, b.obfuscated)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", b.name, err)
+	}
+	return disk, nil
+}
+
+
+
+//////////////  REPAIR  ///////////////////////////////////////////////////////
+
+// REPAIR PROBLEMS FROM THE OTHER BACKUPS
+// Sets the result of each problem. Returns an error only if a backup is left in an inconsistent state.
+func repairProblems(backups []*checkedBackup, problems []*checkProblem, key []byte, immutable bool) error {
+	// Content available in each backup, by checksum
+	sources := make(map[string][]contentRef)
+	for _, b := range backups {
+		for _, entry := range b.entries {
+			sources[b.algorithm+":"+entry.sum] = append(sources[b.algorithm+":"+entry.sum], contentRef{backup: b, entry: entry})
+		}
+	}
+	broken := make(map[*checkedBackup]map[string]bool) // damaged files of each backup, not usable as sources
+	for _, p := range problems {
+		if p.kind != CheckOrphanedPack && p.kind != CheckNotLinked {
+			if broken[p.backup] == nil {
+				broken[p.backup] = make(map[string]bool)
+			}
+			broken[p.backup][p.entry.path] = true
+		}
+	}
+
+	spin := newSpinner("Repairing")
+	for i, p := range problems {
+		spin.update(fmt.Sprintf("%d/%d", i+1, len(problems)))
+		if err := p.backup.unlock(); err != nil {
+			spin.clear()
+			return err
+		}
+
+		var err error
+		switch p.kind {
+		case CheckOrphanedPack:
+			err = os.Remove(filepath.Join(p.backup.path, ReportDirName, PackDirName, p.entry.path))
+		case CheckNotLinked:
+			err = relinkUnchanged(p)
+		default:
+			err = errors.New("no other backup has this content")
+			for _, ref := range sources[p.backup.algorithm+":"+p.entry.sum] {
+				if broken[ref.backup][ref.entry.path] {
+					continue
+				}
+				if err = restoreFromBackup(p, ref); err == nil {
+					delete(broken[p.backup], p.entry.path)
+					break
+				}
+			}
+		}
+		p.result = "repaired"
+		if err != nil {
+			p.result = err.Error()
+		}
+	}
+	spin.clear()
+
+	// Pack indexes lose the entries of restored files, then protection is restored
+	for _, b := range backups {
+		if b.repacked {
+			if err := b.writePackIndex(key); err != nil {
+				return fmt.Errorf("writing pack index of %s: %w", b.name, err)
+			}
+		}
+		if b.unlocked {
+			if err := lockBackup(b.path, immutable); err != nil {
+				return fmt.Errorf("restoring read-only protection of %s: %w", b.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+
+// restoreFromBackup restores the damaged file from the same content in another backup.
+// The source is checked against its checksum first, and hard-linked if it's a plain file.
+func restoreFromBackup(p *checkProblem, ref contentRef) error {
+	refDisk, err := ref.backup.diskPath(ref.entry)
+	if err != nil {
+		return err
+	}
+	var size int64
+	var sum string
+	pack, packed := ref.backup.packs[refDisk]
+	if packed {
+		size, sum, err = packedChecksum(ref.backup.path, pack, ref.backup.algorithm)
+	} else {
+		size, sum, err = fileChecksum(filepath.Join(ref.backup.path, refDisk), ref.backup.algorithm)
+	}
+	if err != nil {
+		return err
+	}
+	if size != p.entry.size || sum != p.entry.sum {
+		return fmt.Errorf("copy in %s is damaged too", ref.backup.name)
+	}
+
+	disk, err := p.backup.diskPath(p.entry)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(p.backup.path, disk)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if !packed && os.Link(filepath.Join(ref.backup.path, refDisk), dest) == nil {
+		p.backup.dropPacked(disk)
+		return nil
+	}
+	var r io.Reader
+	var mode os.FileMode
+	if packed {
+		f, packReader, err := openPacked(ref.backup.path, pack)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, mode = packReader, pack.mode
+	} else {
+		f, err := os.Open(filepath.Join(ref.backup.path, refDisk))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		r, mode = f, info.Mode().Perm()
+	}
+	if err := writeRestored(dest, r, mode); err != nil {
+		return err
+	}
+	p.backup.dropPacked(disk)
+	return nil
+}
+
+
+// writeRestored writes the restored content to the destination file.
+func writeRestored(dest string, r io.Reader, mode os.FileMode) error {
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dest, mode)
+}
+
+
+// relinkUnchanged replaces the copy of an unchanged file with a hard link to the previous backup,
+// once both are checked against the checksum.
+func relinkUnchanged(p *checkProblem) error {
+	disk, err := p.backup.diskPath(p.entry)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(p.backup.path, disk)
+	if _, sum, err := fileChecksum(dest, p.backup.algorithm); err != nil || sum != p.entry.sum {
+		return fmt.Errorf("content doesn't match the manifest, run 'verify'")
+	}
+
+	previous := p.previous
+	prevDisk, err := previous.diskPath(p.entry)
+	if err != nil {
+		return err
+	}
+	prevPath := filepath.Join(previous.path, prevDisk)
+	if _, sum, err := fileChecksum(prevPath, previous.algorithm); err != nil || sum != p.entry.sum {
+		return fmt.Errorf("copy in %s doesn't match the manifest", previous.name)
+	}
+
+	tmp := dest + ".smbkp-link"
+	if err := os.Link(prevPath, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+
+// unlock lifts read-only protection of the backup before its first repair.
+func (b *checkedBackup) unlock() error {
+	if b.unlocked {
+		return nil
+	}
+	info, err := os.Stat(b.path)
+	if err != nil || info.Mode().Perm()&0200 != 0 {
+		return err
+	}
+	if err := unlockBackup(b.path); err != nil {
+		return fmt.Errorf("lifting read-only protection of %s: %w", b.name, err)
+	}
+	b.unlocked = true
+	return nil
+}
+
+
+// dropPacked removes the restored file from the pack index of the backup (it's a plain file now).
+func (b *checkedBackup) dropPacked(disk string) {
+	if _, ok := b.packs[disk]; ok {
+		delete(b.packs, disk)
+		b.repacked = true
+	}
+}
+
+
+// writePackIndex rewrites the pack index of the backup (encrypted if the backup has obfuscated names).
+// A signed backup gets the new index signed with 'key'.
+func (b *checkedBackup) writePackIndex(key []byte) error {
+	path := filepath.Join(b.path, ReportDirName, PackDirName, PackIndexFileName)
+	if len(b.packs) == 0 {
+		if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+		return updateSignature(b.path, packIndexName(), nil)
+	}
+	entries := make([]packEntry, 0, len(b.packs))
+	for _, entry := range b.packs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].pack != entries[j].pack {
+			return entries[i].pack < entries[j].pack
+		}
+		return entries[i].offset < entries[j].offset
+	})
+
+	// Pack files left without entries (e.g. truncated) are removed
+	kept := make(map[string]bool)
+	for _, entry := range entries {
+		kept[entry.pack] = true
+	}
+	packFiles, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	for _, f := range packFiles {
+		if f.Name() != PackIndexFileName && !kept[f.Name()] {
+			if err := os.Remove(filepath.Join(filepath.Dir(path), f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	data := packIndexText(entries)
+	if b.obfuscated {
+		passphrase, err := configKey()
+		if err != nil {
+			return err
+		}
+		if data, err = sealBytes(data, passphrase, ReportEncryptedMagic); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return updateSignature(b.path, packIndexName(), key)
+}
+
+
+// PRINT CHECK RESULTS
+// Returns exit code: 1 if any problem is left.
+func printCheck(problems []*checkProblem, repaired bool, limit int) int {
+	logger.Plain("\n")
+	if len(problems) == 0 {
+		logger.Ok("No problems found.\n", style.NoLabel())
+		return 0
+	}
+
+	shown := problems
+	if limit > 0 && len(shown) > limit {
+		shown = shown[:limit]
+	}
+	table := style.NewTable("Backup", "Problem", "Path")
+	if repaired {
+		table = style.NewTable("Backup", "Problem", "Path", "Repair")
+	}
+	left := 0
+	for _, p := range problems {
+		if p.result != "repaired" {
+			left++
+		}
+	}
+	for _, p := range shown {
+		if repaired {
+			table.Row(p.backup.name, p.kind, p.entry.path, p.result)
+		} else {
+			table.Row(p.backup.name, p.kind, p.entry.path)
+		}
+	}
+	logger.Plain(table.Render())
+	if len(shown) < len(problems) {
+		logger.Info(fmt.Sprintf("%d more problems are not shown (see '--limit').\n", len(problems)-len(shown)))
+	}
+
+	logger.Plain("\n")
+	switch {
+	case !repaired:
+		logger.Warn(fmt.Sprintf("%d problems found. Run with '--repair' to repair them from the other backups.\n", len(problems)))
+	case left == 0:
+		logger.Ok(fmt.Sprintf("All %d problems are repaired.\n", len(problems)), style.NoLabel())
+		return 0
+	default:
+		logger.Err(fmt.Sprintf("%d of %d problems are repaired, %d are unrecoverable%s.\n", len(problems)-left, len(problems), left, unrecoverableHint(problems)))
+	}
+	return 1
+}
+
+
+// unrecoverableHint suggests what to do about files that no backup has anymore.
+func unrecoverableHint(problems []*checkProblem) string {
+	for _, p := range problems {
+		if p.result != "repaired" && strings.HasPrefix(p.result, "no other backup") {
+			return "; the next backup copies lost files again if they still exist on the source"
+		}
+	}
+	return ""
+}
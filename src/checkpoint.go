@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// While a backup runs, a progress checkpoint (items, files and bytes done) is saved to the state file
+// every 'ProgressCheckpointEvery', so 'status' can report the run from another terminal (e.g. of a scheduled run).
+// Percentage and ETA are based on the items counted so far. The checkpoint is cleared when the run ends;
+// one that is no longer updated is reported as an interrupted run.
+
+const (
+	ProgressCheckpointEvery time.Duration = 30 * time.Second
+	ProgressStaleAfter      time.Duration = 3 * ProgressCheckpointEvery // checkpoint of a run that is gone
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PROGRESS CHECKPOINT OF THE RUNNING BACKUP (saved in state file)
+type runProgress struct {
+	Started      time.Time `yaml:"started"`
+	Updated      time.Time `yaml:"updated"`
+	PID          int       `yaml:"pid"`
+	Items        int       `yaml:"items"`
+	ItemsStarted int64     `yaml:"items_started"`
+	ItemsDone    int64     `yaml:"items_done"`
+	FilesDone    int64     `yaml:"files_done"`
+	FilesTotal   int64     `yaml:"files_total"` // of the items counted so far
+	BytesDone    int64     `yaml:"bytes_done"`
+	BytesTotal   int64     `yaml:"bytes_total"` // of the items counted so far
+}
+
+
+// RUNNING TOTALS OF THE CURRENT RUN
+// Updated by copy workers and items backed up in parallel, read by the checkpoints.
+type runCounters struct {
+	itemsStarted atomic.Int64
+	itemsDone    atomic.Int64
+	filesDone    atomic.Int64
+	filesTotal   atomic.Int64
+	bytesDone    atomic.Int64
+	bytesTotal   atomic.Int64
+}
+
+
+
+//////////////  CHECKPOINT FUNCTIONS  /////////////////////////////////////////
+
+// counted adds enumerated files of an item to the run totals.
+func (c *runCounters) counted(files int, bytes int64) {
+	c.filesTotal.Add(int64(files))
+	c.bytesTotal.Add(bytes)
+}
+
+
+// fileDone adds a processed file (copied, linked or skipped as inaccessible) to the run totals.
+func (c *runCounters) fileDone(size int64) {
+	c.filesDone.Add(1)
+	c.bytesDone.Add(size)
+}
+
+
+// START SAVING PROGRESS CHECKPOINTS OF THE RUN
+// 'root' is the backup root the run is recorded under. Returns the function that stops the checkpoints.
+func (app *BackupApp) startProgressCheckpoints(root string) (stop func()) {
+	if app.toStdout {
+		return func() {}
+	}
+	started := time.Now().UTC().Truncate(time.Second)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer handlePanic()
+		defer close(finished)
+		ticker := time.NewTicker(ProgressCheckpointEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				app.saveProgressCheckpoint(root, started)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+
+// saveProgressCheckpoint writes the current progress into the state file.
+// Failures are not reported to the console, the run outcome is saved anyway when it ends.
+func (app *BackupApp) saveProgressCheckpoint(root string, started time.Time) {
+	state, err := readState()
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Progress checkpoint is not saved: %v\n", err))
+		return
+	}
+	c := &app.counters
+	state.run(root).Progress = &runProgress{
+		Started:      started,
+		Updated:      time.Now().UTC().Truncate(time.Second),
+		PID:          os.Getpid(),
+		Items:        len(app.BkpConfig.BkpItems),
+		ItemsStarted: c.itemsStarted.Load(),
+		ItemsDone:    c.itemsDone.Load(),
+		FilesDone:    c.filesDone.Load(),
+		FilesTotal:   c.filesTotal.Load(),
+		BytesDone:    c.bytesDone.Load(),
+		BytesTotal:   c.bytesTotal.Load(),
+	}
+	if err := writeState(state); err != nil {
+		logger.Debug(fmt.Sprintf("Progress checkpoint is not saved: %v\n", err))
+	}
+}
+
+
+// describe returns the progress for 'status', e.g. "62%, item 2/5, 1200/3000 files, ETA 18 min".
+func (p *runProgress) describe() string {
+	msg := fmt.Sprintf("item %d/%d, %d/%d files", p.ItemsStarted, p.Items, p.FilesDone, p.FilesTotal)
+	if p.BytesTotal == 0 {
+		return msg
+	}
+	msg = fmt.Sprintf("%d%%, %s", min(p.BytesDone*100/p.BytesTotal, 100), msg)
+
+	// Rate of the run so far, applied to the rest of the counted bytes
+	elapsed := p.Updated.Sub(p.Started)
+	if p.BytesDone > 0 && elapsed > 0 && p.BytesDone < p.BytesTotal {
+		eta := time.Duration(float64(elapsed) * float64(p.BytesTotal-p.BytesDone) / float64(p.BytesDone))
+		msg += ", ETA " + formatETA(eta-time.Since(p.Updated))
+	}
+	return msg
+}
+
+
+// formatETA formats the remaining time with minute precision, e.g. "18 min" or "2h 05m".
+func formatETA(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1 min"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"simple-backup/src/style"
+)
+
+// 'cleanup' applies retention to existing backups without running a backup.
+
+
+
+//////////////  CLEANUP COMMAND  //////////////////////////////////////////////
+
+// RUN 'CLEANUP' COMMAND
+func runCleanupCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile     = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest        = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		dryRun         = flags.Bool("dry-run", false, "Show which backups would be removed, without removing them.")
+		nonInteractive = flags.BoolP("non-interactive", "n", false, "Skip all user prompts.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, *nonInteractive, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	logger.Plain(fmt.Sprintf("Backups to keep: %d\n", app.BkpConfig.Retention.BackupsToKeep))
+	if err := app.cleanupBackups(app.bkpDestFullPath, "", *dryRun); err != nil {
+		return 1
+	}
+	if !*dryRun {
+		if err := refreshCatalog(app.bkpDestFullPath); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to update catalog: %v\n", err))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Backup directory names carry UTC time (e.g. 'smbkp-20240101-120000Z'), so backups sort the same way
+// across DST changes and when the drive moves between machines in different time zones.
+// Names made by earlier versions have no zone ('smbkp-20240101-120000') and are read as local time.
+// Before the run, the system clock is compared with the newest backup: a clock that is behind it
+// (e.g. reset by a dead CMOS battery) dates the new backup before the existing ones,
+// so retention would treat it as the oldest.
+
+const (
+	ClockSkewTolerance       time.Duration = time.Hour
+	LegacyClockSkewTolerance time.Duration = 26 * time.Hour // local time of another zone may be this far ahead
+)
+
+
+
+//////////////  CLOCK FUNCTIONS  //////////////////////////////////////////////
+
+// clockBehind returns the newest backup under the backup root and how far it's ahead of 'now',
+// or false if the system clock looks right.
+func clockBehind(backupRoot string, now time.Time) (backupDir, time.Duration, bool) {
+	backups, err := listBackups(backupRoot)
+	if err != nil || len(backups) == 0 {
+		return backupDir{}, 0, false
+	}
+	newest := backups[0]
+	tolerance := ClockSkewTolerance
+	if stamp := strings.TrimPrefix(newest.name, Prefix+"-"); !strings.HasPrefix(stamp[min(len(stamp), len(LegacyTimestampFormat)):], "Z") {
+		tolerance = LegacyClockSkewTolerance // name without zone
+	}
+	ahead := newest.created.Sub(now)
+	return newest, ahead, ahead > tolerance
+}
+
+
+// WARN IF SYSTEM CLOCK IS BEHIND THE NEWEST BACKUP
+func checkClock(backupRoot string) {
+	if newest, ahead, ok := clockBehind(backupRoot, time.Now()); ok {
+		logger.Warn(fmt.Sprintf("System clock is %s behind the newest backup %q. Check system clock and time zone: the new backup would be treated as older than existing ones.\n", formatAge(ahead), newest.name))
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+
+	"github.com/spf13/pflag"
+)
+
+// Sub-commands are selected by the first command-line argument and parse their own options.
+// Running without a sub-command (or with "backup") performs the backup.
+// Sub-commands write to console only. Those working with backups (e.g. 'verify', 'check', 'find', 'unpack')
+// load the configuration file like the backup does ('-config', '-bkp-dest') to locate them and read their settings.
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// SUB-COMMAND
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(cmd *command, args []string) int // returns exit code
+}
+
+
+// Registered sub-commands, in the order they are listed in help
+var commands = []command{
+	{
+		name:    "bench",
+		usage:   "bench --dest <path> [options]",
+		summary: "Measure write throughput and metadata latency of a candidate backup destination.",
+		run:     runBenchCommand,
+	},
+	{
+		name:    "doctor",
+		usage:   "doctor [options]",
+		summary: "Diagnose configuration, sources, destination and privileges, and suggest fixes.",
+		run:     runDoctorCommand,
+	},
+	{
+		name:    "cleanup",
+		usage:   "cleanup [options]",
+		summary: "Remove old backups according to retention settings, without running a backup.",
+		run:     runCleanupCommand,
+	},
+	{
+		name:    "verify",
+		usage:   "verify [<backup>|latest] [options]",
+		summary: "Check backup content against its manifest, and the manifest against its signature.",
+		run:     runVerifyCommand,
+	},
+	{
+		name:    "check",
+		usage:   "check [options]",
+		summary: "Check all backups for missing files, damaged packs and broken hard links, and repair them from each other.",
+		run:     runCheckCommand,
+	},
+	{
+		name:    "unpack",
+		usage:   "unpack [<backup>|latest] [options]",
+		summary: "Extract files packed by 'pack_small_files' into place, so the backup is a plain copy for restore.",
+		run:     runUnpackCommand,
+	},
+	{
+		name:    "refresh",
+		usage:   "refresh [<backup>|latest] [options]",
+		summary: "Apply current source permissions and attributes to unchanged files of a backup, without copying data.",
+		run:     runRefreshCommand,
+	},
+	{
+		name:    "export",
+		usage:   "export [<backup>|latest] --to <file> [options]",
+		summary: "Write a backup into a single .tar, .tar.gz or .tar.zst archive, readable without smbkp.",
+		run:     runExportCommand,
+	},
+	{
+		name:    "import",
+		usage:   "import <dir> [options]",
+		summary: "Adopt a copy made by other means on the destination drive as a backup, without copying it again.",
+		run:     runImportCommand,
+	},
+	{
+		name:    "migrate",
+		usage:   "migrate [options]",
+		summary: "Convert existing backups to the configured layout (packing, dedup, 'bkp_dest_dir'), keeping their history.",
+		run:     runMigrateCommand,
+	},
+	{
+		name:    "report",
+		usage:   "report [<backup>|latest] [options]",
+		summary: "Show per-item size breakdown and the largest files and directories of a backup.",
+		run:     runReportCommand,
+	},
+	{
+		name:    "find",
+		usage:   "find <pattern> [options]",
+		summary: "Find files across all backups by name or path (wildcards '*', '?' supported), using the backup catalog.",
+		run:     runFindCommand,
+	},
+	{
+		name:    "plan",
+		usage:   "plan [options]",
+		summary: "Print the plan of the next backup (effective config, item estimates, retention actions) as YAML or JSON.",
+		run:     runPlanCommand,
+	},
+	{
+		name:    "estimate",
+		usage:   "estimate [options]",
+		summary: "Print per-item and total sizes of the next backup, and whether it fits the destination.",
+		run:     runEstimateCommand,
+	},
+	{
+		name:    "advise",
+		usage:   "advise [options]",
+		summary: "Project how many more backups fit the destination, when it fills, and which 'backups_to_keep' fits a horizon.",
+		run:     runAdviseCommand,
+	},
+	{
+		name:    "compare",
+		usage:   "compare [<backup>|latest] [options]",
+		summary: "Compare live sources with a backup and list files that are missing from it or changed since.",
+		run:     runCompareCommand,
+	},
+	{
+		name:    "status",
+		usage:   "status [options]",
+		summary: "Show the last successful backup of each destination, and exit with non-zero code if any is stale.",
+		run:     runStatusCommand,
+	},
+	{
+		name:    "history",
+		usage:   "history [options]",
+		summary: "List backups with their duration and size, or show growth trends across runs (--stats).",
+		run:     runHistoryCommand,
+	},
+	{
+		name:    "config",
+		usage:   "config encrypt|decrypt <file>",
+		summary: "Encrypt the configuration file at rest, or decrypt it for editing (key from SMBKP_CONFIG_KEY or keychain).",
+		run:     runConfigCommand,
+	},
+	{
+		name:    "version",
+		usage:   "version [--check]",
+		summary: "Show version, or check whether a newer release exists (--check).",
+		run:     runVersionCommand,
+	},
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// findCommand returns the registered sub-command by name.
+func findCommand(name string) (*command, bool) {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i], true
+		}
+	}
+	return nil, false
+}
+
+
+// newCommandFlags creates a flag set for the sub-command with the common '-help' option.
+func newCommandFlags(cmd *command) (*pflag.FlagSet, *bool) {
+	flags := pflag.NewFlagSet(cmd.name, pflag.ExitOnError)
+	showHelp := flags.BoolP("help", "h", false, "Show help and exit.")
+	flags.Usage = func() { printCommandHelp(cmd, flags) }
+	return flags, showHelp
+}
+
+
+// printCommandHelp prints usage of the sub-command.
+func printCommandHelp(cmd *command, flags *pflag.FlagSet) {
+	fmt.Printf("\n%s\n", cmd.summary)
+	fmt.Println("\nUsage:")
+	fmt.Printf("  simple-backup(.exe) %s\n", cmd.usage)
+	fmt.Println("\nOptions:")
+	flags.PrintDefaults()
+}
+
+
+// initConsoleLogger sets up the global logger without log file, for sub-commands.
+func initConsoleLogger() {
+	logger = style.New(log.New(io.Discard, "", log.LstdFlags))
+}
+
+
+// resolveBackup finds the backup by directory name, path, or "latest" (default) in the backup root.
+func resolveBackup(backupRoot, ref string) (backupDir, error) {
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		return backupDir{}, fmt.Errorf("listing backups: %w", err)
+	}
+
+	if ref == "" || ref == "latest" {
+		if len(backups) == 0 {
+			return backupDir{}, fmt.Errorf("no backups found in %q", backupRoot)
+		}
+		return backups[0], nil
+	}
+
+	name := filepath.Base(filepath.Clean(ref))
+	for _, backup := range backups {
+		if backup.name == name {
+			return backup, nil
+		}
+	}
+	return backupDir{}, fmt.Errorf("backup %q is not found in %q", ref, backupRoot)
+}
+
+
+// RUN SUB-COMMAND AND EXIT
+// Returns only if the arguments don't start with a sub-command name.
+func dispatchCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	if cmd, ok := findCommand(args[0]); ok {
+		os.Exit(cmd.run(cmd, args[1:]))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"time"
+)
+
+// 'compare' answers "is everything I care about actually protected right now?":
+// live sources are enumerated the same way the backup does it (patterns and limits apply),
+// and each file is looked up in the manifest of the backup (the latest complete one by default).
+// Files absent from the backup, or modified since it was made, are reported.
+// Stream items have no files to compare and are left out.
+
+const CompareLimitDefault int = 100
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// SOURCE FILE NOT PROTECTED BY THE BACKUP
+type compareGap struct {
+	path   string // source path
+	reason string
+}
+
+
+// COMPARISON OF ONE ITEM
+type itemComparison struct {
+	source    string
+	files     int
+	protected int
+	missing   []compareGap // not in the backup
+	changed   []compareGap // modified since the backup
+	err       error
+}
+
+
+
+//////////////  COMPARE COMMAND  //////////////////////////////////////////////
+
+// RUN 'COMPARE' COMMAND
+// Exits with non-zero code if any source file is missing from the backup or changed since it was made.
+func runCompareCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		limit      = flags.Int("limit", CompareLimitDefault, "Maximum number of missing and changed files to list (0 - all).")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	defer app.closeRemoteClients()
+
+	backup, err := resolveCompleteBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+	manifest, err := readManifest(backup.path)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to read manifest of %s: %v\n\n", backup.name, err), style.Bold())
+		return 1
+	}
+	files := make(map[string]manifestEntry, len(manifest))
+	for _, entry := range manifest {
+		files[entry.path] = entry
+	}
+
+	logger.Signature(fmt.Sprintf("\n====  Live sources compared with: %s (%s ago)  ===\n", backup.name, formatAge(time.Since(backup.created))))
+	var results []itemComparison
+	for _, item := range app.BkpConfig.BkpItems {
+		if isStreamItem(item) {
+			continue
+		}
+		logger.Plain(fmt.Sprintf("Comparing %s\n", itemSourceLabel(item)))
+		results = append(results, app.compareItem(item, files))
+	}
+
+	return printComparison(results, *limit)
+}
+
+
+// COMPARE LIVE SOURCE OF THE ITEM WITH BACKUP MANIFEST
+func (app *BackupApp) compareItem(item BackupItem, files map[string]manifestEntry) itemComparison {
+	result := itemComparison{source: itemSourceLabel(item)}
+	work, err := app.enumerateItem(item)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	dest := path.Clean(filepath.ToSlash(item.Destination))
+	for _, entry := range work.entries {
+		if entry.linkTarget != "" || entry.info.IsDir() {
+			continue
+		}
+		result.files++
+
+		// Single-file source is copied to the item destination itself
+		key := dest
+		if work.root.IsDir() {
+			key = path.Join(dest, filepath.ToSlash(entry.relPath))
+		}
+		backedUp, ok := files[key]
+		modTime := entry.info.ModTime().UTC().Truncate(time.Second) // manifest keeps whole seconds
+		sameTime := work.mtimes.same(modTime, backedUp.modTime)
+		switch {
+		case !ok:
+			result.missing = append(result.missing, compareGap{path: entry.path, reason: "not in backup"})
+		case !sameTime && modTime.After(backedUp.modTime):
+			result.changed = append(result.changed, compareGap{path: entry.path, reason: fmt.Sprintf("modified %s", entry.info.ModTime().Local().Format("2006-01-02 15:04"))})
+		case !sameTime || entry.info.Size() != backedUp.size:
+			result.changed = append(result.changed, compareGap{path: entry.path, reason: "size or modification time differs"})
+		default:
+			result.protected++
+		}
+	}
+	return result
+}
+
+
+// PRINT COMPARISON RESULTS
+// Returns exit code: 1 if anything is not protected.
+func printComparison(results []itemComparison, limit int) int {
+	logger.Plain("\nItems:\n", style.Bold())
+	table := style.NewTable("Files", "Protected", "Missing", "Changed", "Source").AlignRight(0, 1, 2, 3)
+	var files, missing, changed, failed int
+	var gaps []compareGap
+	for _, result := range results {
+		if result.err != nil {
+			table.Row("-", "-", "-", "-", fmt.Sprintf("%s (%v)", result.source, result.err))
+			failed++
+			continue
+		}
+		table.Row(fmt.Sprint(result.files), fmt.Sprint(result.protected), fmt.Sprint(len(result.missing)), fmt.Sprint(len(result.changed)), result.source)
+		files += result.files
+		missing += len(result.missing)
+		changed += len(result.changed)
+		gaps = append(gaps, result.missing...)
+		gaps = append(gaps, result.changed...)
+	}
+	logger.Plain(table.Render())
+
+	if len(gaps) > 0 {
+		shown := gaps
+		if limit > 0 && len(shown) > limit {
+			shown = shown[:limit]
+		}
+		logger.Plain("\nNot protected:\n", style.Bold())
+		table = style.NewTable("Reason", "Path")
+		for _, gap := range shown {
+			table.Row(gap.reason, gap.path)
+		}
+		logger.Plain(table.Render())
+		if len(shown) < len(gaps) {
+			logger.Info(fmt.Sprintf("%d more files are not shown (see '--limit').\n", len(gaps)-len(shown)))
+		}
+	}
+
+	logger.Plain("\n")
+	if failed > 0 {
+		logger.Err(fmt.Sprintf("%d items could not be compared.\n", failed))
+	}
+	if missing+changed > 0 {
+		logger.Warn(fmt.Sprintf("%d of %d source files are not protected: %d missing from the backup, %d changed since. Run a backup to protect them.\n", missing+changed, files, missing, changed))
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	logger.Ok(fmt.Sprintf("All %d source files are protected.\n", files), style.NoLabel())
+	return 0
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// resolveCompleteBackup finds the backup by name, or the latest complete backup for "latest" (or empty reference).
+// Partial backups have no manifest to compare with.
+func resolveCompleteBackup(backupRoot, ref string) (backupDir, error) {
+	if ref != "" && ref != "latest" {
+		return resolveBackup(backupRoot, ref)
+	}
+	backups, err := listBackups(backupRoot)
+	if err != nil {
+		return backupDir{}, fmt.Errorf("listing backups: %w", err)
+	}
+	for _, backup := range backups {
+		if backup.state == BackupComplete {
+			return backup, nil
+		}
+	}
+	return backupDir{}, fmt.Errorf("no complete backups found in %q", backupRoot)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"simple-backup/src/style"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// The config file on the backup drive reveals the directory structure of the machine, so it can be
+// encrypted at rest ('config encrypt'). The encrypted file keeps its name (so destinations are still
+// auto-discovered) and is decrypted transparently when loaded. The key is a passphrase taken from
+// SMBKP_CONFIG_KEY, or from the OS keychain (see configKeychainSecret): Windows Credential Manager
+// (generic credential 'simple-backup-config'), macOS Keychain or Secret Service on Linux ('secret-tool').
+// File format: header line, then base64 of scrypt salt, AES-256-GCM nonce and ciphertext.
+
+const (
+	ConfigKeyEnv         string = "SMBKP_CONFIG_KEY"
+	ConfigKeychainTarget string = "simple-backup-config"
+	ConfigEncryptedMagic string = "# smbkp encrypted config v1\n"
+	configSaltSize       int    = 16
+)
+
+var (
+	keychainKey  string // config key from the keychain, read once
+	keychainOnce sync.Once
+
+	errConfigKeyMissing = fmt.Errorf("no key is available: set %s or store the key in the keychain as %q", ConfigKeyEnv, ConfigKeychainTarget)
+)
+
+
+
+//////////////  CONFIG ENCRYPTION FUNCTIONS  //////////////////////////////////
+
+// isEncryptedConfig reports whether the config file content is encrypted.
+func isEncryptedConfig(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ConfigEncryptedMagic))
+}
+
+
+// configKey returns the config encryption passphrase from the environment or the keychain.
+// The keychain is asked once per process.
+func configKey() (string, error) {
+	if key := os.Getenv(ConfigKeyEnv); key != "" {
+		return key, nil
+	}
+	keychainOnce.Do(func() {
+		keychainKey, _ = configKeychainSecret()
+	})
+	if keychainKey == "" {
+		return "", errConfigKeyMissing
+	}
+	return keychainKey, nil
+}
+
+
+// configCipher derives the AES-GCM cipher from the passphrase and salt.
+func configCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+
+// sealBytes encrypts the content with the passphrase, prefixed with the header line ('magic').
+func sealBytes(data []byte, passphrase, magic string) ([]byte, error) {
+	salt := make([]byte, configSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := configCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(append(salt, nonce...), nonce, data, nil)
+	return []byte(magic + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+
+// openBytes returns the plain content of the content encrypted by 'sealBytes'.
+func openBytes(data []byte, passphrase, magic string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(magic):])))
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted content: %w", err)
+	}
+	if len(sealed) < configSaltSize {
+		return nil, errors.New("encrypted content is truncated")
+	}
+	aead, err := configCipher(passphrase, sealed[:configSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[configSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted content is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("decrypting: wrong key, or the file is damaged")
+	}
+	return plain, nil
+}
+
+
+// readConfigFile reads the config file, decrypting it if needed.
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !isEncryptedConfig(data) {
+		return data, err
+	}
+	key, err := configKey()
+	if err != nil {
+		return nil, fmt.Errorf("config file is encrypted, but %w", err)
+	}
+	return openBytes(data, key, ConfigEncryptedMagic)
+}
+
+
+
+//////////////  CONFIG COMMAND  ///////////////////////////////////////////////
+
+// RUN 'CONFIG' COMMAND
+// 'config encrypt <file>' and 'config decrypt <file>' rewrite the file in place.
+func runConfigCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	flags.Parse(args)
+
+	if *showHelp || flags.NArg() != 2 || (flags.Arg(0) != "encrypt" && flags.Arg(0) != "decrypt") {
+		flags.Usage()
+		if *showHelp {
+			return 0
+		}
+		return 1
+	}
+
+	initConsoleLogger()
+
+	action, path := flags.Arg(0), flags.Arg(1)
+	var err error
+	if action == "encrypt" {
+		err = encryptConfigFile(path)
+	} else {
+		err = decryptConfigFile(path)
+	}
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to %s %q: %v\n\n", action, path, err), style.Bold())
+		return 1
+	}
+	logger.Ok(fmt.Sprintf("Config file %q is %sed.\n", path, action), style.NoLabel())
+	return 0
+}
+
+
+// OFFER TO ENCRYPT GENERATED CONFIG FILE
+// Asked only when a key is available and stdin is a terminal; otherwise prints how to do it later.
+func offerConfigEncryption(path string) {
+	hint := fmt.Sprintf("The file reveals your directory structure. To encrypt it, set %s (or store the key in the keychain as %q) and run 'config encrypt %s'.\n", ConfigKeyEnv, ConfigKeychainTarget, path)
+	if _, err := configKey(); err != nil || !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Print(hint)
+		return
+	}
+	fmt.Printf("Encrypt it now? It can be decrypted for editing with 'config decrypt %s'. (only \"yes\" will be accepted to confirm)\n", path)
+	response, _ := readLine(0)
+	if !isAnswer(response, msgAnswerYes) {
+		return
+	}
+	if err := encryptConfigFile(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encrypt %q: %v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Config file %q is encrypted.\n", path)
+}
+
+
+// encryptConfigFile encrypts the config file in place, after checking that it's a valid configuration.
+func encryptConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isEncryptedConfig(data) {
+		return errors.New("file is already encrypted")
+	}
+	if err := parseConfig(data); err != nil {
+		return err
+	}
+	key, err := configKey()
+	if err != nil {
+		return err
+	}
+	encrypted, err := sealBytes(data, key, ConfigEncryptedMagic)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, encrypted, 0600)
+}
+
+
+// decryptConfigFile decrypts the config file in place, so it can be edited.
+func decryptConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !isEncryptedConfig(data) {
+		return errors.New("file is not encrypted")
+	}
+	plain, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, plain, 0600)
+}
+
+
+// parseConfig checks that the content is a valid configuration.
+func parseConfig(data []byte) error {
+	config := NewConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("parsing config file: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+
+// replaceFile writes the content next to the file and renames it over, so a failed write keeps the original.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
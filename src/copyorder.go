@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sort"
+)
+
+// Files of an item are copied in the order of 'copy_order', after its directories are created:
+//   newest   - most recently modified first (default), so if the run is interrupted (laptop lid closed,
+//              drive pulled), the freshest data, which is the least likely to be in an earlier backup, is safe
+//   smallest - smallest first, the most files are safe soonest
+//   largest  - largest first, so with several 'copy_workers' large files are started early, and their ranges
+//              are shared by idle workers (see rangecopy.go)
+//   path     - in the order the source is walked
+// Items copied while they are scanned ('copy_while_scanning') are copied in walk order.
+
+const (
+	CopyOrderNewest   string = "newest"
+	CopyOrderSmallest string = "smallest"
+	CopyOrderLargest  string = "largest"
+	CopyOrderPath     string = "path"
+)
+
+var copyOrders = []string{CopyOrderNewest, CopyOrderSmallest, CopyOrderLargest, CopyOrderPath}
+
+
+
+//////////////  COPY ORDER FUNCTIONS  /////////////////////////////////////////
+
+// sortCopyQueue sorts the files of the item in the copy order. Files that tie keep the walk order.
+func sortCopyQueue(files []workEntry, order string) {
+	switch order {
+	case CopyOrderNewest:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].info.ModTime().After(files[j].info.ModTime()) })
+	case CopyOrderSmallest:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].info.Size() < files[j].info.Size() })
+	case CopyOrderLargest:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].info.Size() > files[j].info.Size() })
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Databases that are open while copied may be copied in an inconsistent state.
+// With 'sqlite_backup: true', SQLite databases of the item are copied with the SQLite backup API ('sqlite3 .backup'),
+// which produces a consistent copy even while applications write to them; their journal files ('-wal', '-shm',
+// '-journal') are not copied, as the copy already includes their content. Requires the 'sqlite3' tool.
+// Known always-open databases (browser profiles, Outlook data files) are reported with guidance.
+
+const SQLiteHeader string = "SQLite format 3\x00"
+
+var (
+	sqliteExtensions = []string{".sqlite", ".sqlite3", ".db", ".db3"}
+	sqliteJournals   = []string{"-wal", "-shm", "-journal"}
+	chromeDatabases  = []string{"History", "Cookies", "Web Data", "Login Data", "Favicons", "Top Sites"}
+
+	sqliteToolOnce sync.Once
+	sqliteTool     string // path of 'sqlite3', empty if not installed
+)
+
+
+
+//////////////  DATABASE FUNCTIONS  ///////////////////////////////////////////
+
+// databaseFile notes known always-open databases of the item, and returns the reason to skip SQLite journals
+// (with 'sqlite_backup'), or empty string.
+func (wl *workList) databaseFile(item BackupItem, path string) string {
+	name := filepath.Base(path)
+	if kind := knownDatabase(path); kind != "" {
+		if wl.databases == nil {
+			wl.databases = make(map[string]string)
+		}
+		if _, ok := wl.databases[kind]; !ok {
+			wl.databases[kind] = path
+		}
+	}
+
+	if item.SQLiteBackup {
+		for _, suffix := range sqliteJournals {
+			db, ok := strings.CutSuffix(path, suffix)
+			if _, err := os.Stat(db); ok && isSQLiteCandidate(filepath.Base(db)) && err == nil {
+				return "excluded: " + name + " is included in SQLite backup of " + filepath.Base(db)
+			}
+		}
+	}
+	return ""
+}
+
+
+// knownDatabase returns the kind of always-open database the file belongs to, or empty string.
+func knownDatabase(path string) string {
+	name := filepath.Base(path)
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".pst") || strings.HasSuffix(lower, ".ost"):
+		return "Outlook data file"
+	case name == "places.sqlite" || name == "cookies.sqlite":
+		return "Firefox profile"
+	case slices.Contains(chromeDatabases, name) && (strings.Contains(lower, "user data") || strings.Contains(lower, "chrom")):
+		return "Chrome/Chromium profile"
+	}
+	return ""
+}
+
+
+// REPORT KNOWN ALWAYS-OPEN DATABASES OF THE ITEM
+func (app *BackupApp) reportDatabases(work *workList) {
+	for kind, example := range work.databases {
+		advice := "close the application before the backup, or set 'sqlite_backup: true' (or 'snapshot: true') for the item"
+		switch {
+		case kind == "Outlook data file":
+			advice = "close Outlook before the backup, or set 'snapshot: true' for the item"
+		case work.item.SQLiteBackup:
+			continue // copied consistently
+		}
+		logger.Warn(fmt.Sprintf("Found %s (e.g. %s), which is usually open and may be copied inconsistently: %s.\n", kind, example, advice))
+	}
+}
+
+
+// isSQLiteCandidate reports whether the file name suggests a SQLite database (the header is checked before copying).
+func isSQLiteCandidate(name string) bool {
+	return slices.Contains(sqliteExtensions, strings.ToLower(filepath.Ext(name))) || slices.Contains(chromeDatabases, name)
+}
+
+
+// isSQLiteDatabase reports whether the file starts with the SQLite header.
+func isSQLiteDatabase(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(SQLiteHeader))
+	_, err = io.ReadFull(f, header)
+	return err == nil && bytes.Equal(header, []byte(SQLiteHeader))
+}
+
+
+// COPY SQLITE DATABASE WITH THE BACKUP API
+// Returns false if the file has to be copied as usual (not a SQLite database, or 'sqlite3' is not installed).
+func (app *BackupApp) copySQLite(work *workList, entry workEntry, dest string) (bool, error) {
+	if !work.item.SQLiteBackup || app.tarOut != nil || !isSQLiteCandidate(entry.info.Name()) || !isSQLiteDatabase(entry.path) {
+		return false, nil
+	}
+	sqliteToolOnce.Do(func() {
+		sqliteTool, _ = exec.LookPath("sqlite3")
+		if sqliteTool == "" {
+			logger.Warn(fmt.Sprintf("%q is not installed, SQLite databases are copied as regular files (%q).\n", "sqlite3", "sqlite_backup"))
+		}
+	})
+	if sqliteTool == "" {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return true, err
+	}
+	os.Remove(dest) // '.backup' writes into an existing database instead of replacing it
+	quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(dest) + `"`
+	if out, err := exec.Command(sqliteTool, "-readonly", entry.path, ".backup "+quoted).CombinedOutput(); err != nil {
+		return true, fmt.Errorf("sqlite3 .backup: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// The copy is a consistent database rather than the bytes of the source, so it's hashed as written
+	if err := os.Chmod(dest, entry.info.Mode().Perm()); err != nil {
+		return true, err
+	}
+	size, sum, err := fileChecksum(dest, app.BkpConfig.HashAlgorithm)
+	if err != nil {
+		return true, err
+	}
+	rawSum, err := hex.DecodeString(sum)
+	if err != nil {
+		return true, err
+	}
+	app.recordFile(dest, size, entry.info.ModTime(), rawSum)
+	return true, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sync"
+	"time"
+)
+
+// With 'dedup: hardlink', files unchanged since the previous complete backup (same path, size and
+// modification time in its manifest, see 'mtime_tolerance') are hard-linked from it instead of being copied again.
+// Every backup still looks complete on its own, but unchanged files take space only once.
+// Files that can't be linked (e.g. another file system, immutable files) are copied as usual.
+// With 'read_only', backups sharing files are made read-only but not immutable (see protect.go), and lifting
+// the protection of a backup for retention leaves the files it shares with newer backups read-only.
+
+const (
+	DedupNone     string = "none"
+	DedupHardlink string = "hardlink"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PREVIOUS BACKUP TO LINK UNCHANGED FILES FROM
+type dedupBase struct {
+	dir    string                   // backup directory
+	files  map[string]manifestEntry // manifest entries by path
+	denied sync.Once                // warns once about files that may not be linked
+}
+
+
+
+//////////////  DEDUP FUNCTIONS  //////////////////////////////////////////////
+
+// LOAD PREVIOUS COMPLETE BACKUP FOR HARD-LINKING
+// Leaves deduplication off if the mode is not enabled, for tar stream, or if there is no backup with manifest.
+func (app *BackupApp) loadDedupBase() {
+	if app.BkpConfig.Dedup != DedupHardlink || app.toStdout {
+		return
+	}
+	backup, ok := app.previousBackup()
+	if !ok {
+		logger.Info("No previous complete backup to deduplicate against, all files will be copied.\n")
+		return
+	}
+	entries, err := readManifest(backup.path)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Deduplication is off for this run, manifest of %s is not readable: %v\n", backup.name, err))
+		return
+	}
+	// Checksums are taken over from the previous manifest, so they must be of the same algorithm
+	if algorithm, err := manifestAlgorithm(backup.path); err != nil || algorithm != app.BkpConfig.HashAlgorithm {
+		logger.Info(fmt.Sprintf("Deduplication is off for this run, %s has checksums of another 'hash_algorithm'.\n", backup.name))
+		return
+	}
+
+	base := &dedupBase{dir: backup.path, files: make(map[string]manifestEntry, len(entries))}
+	for _, entry := range entries {
+		base.files[entry.path] = entry
+	}
+	app.dedup = base
+	logger.Info(fmt.Sprintf("Unchanged files will be hard-linked from %s.\n", backup.name))
+}
+
+
+// LINK UNCHANGED FILE FROM PREVIOUS BACKUP
+// Returns false if the file has to be copied (changed, new, or linking failed).
+func (app *BackupApp) linkUnchanged(work *workList, entry workEntry, dest string) bool {
+	if app.dedup == nil {
+		return false
+	}
+	rel, err := filepath.Rel(app.bkpDestFullPath, dest)
+	if err != nil {
+		return false
+	}
+	prev, ok := app.dedup.files[app.manifestPath(rel)]
+	modTime := entry.info.ModTime().UTC().Truncate(time.Second) // manifest keeps whole seconds
+	if !ok || prev.size != entry.info.Size() || !work.mtimes.same(prev.modTime, modTime) {
+		return false
+	}
+	sum, err := hex.DecodeString(prev.sum)
+	if err != nil {
+		return false
+	}
+
+	if err := os.Link(filepath.Join(app.dedup.dir, rel), dest); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			app.dedup.denied.Do(func() {
+				logger.Warn(fmt.Sprintf("Unchanged files can't be linked from %s (immutable?), they're copied: %v\n", filepath.Base(app.dedup.dir), err))
+			})
+		}
+		if logger.Enabled(style.LevelDebug) {
+			logger.Debug(fmt.Sprintf("Not linked %s: %v\n", entry.path, err))
+		}
+		return false
+	}
+	app.recordFile(dest, prev.size, prev.modTime, sum)
+	work.linked.Add(prev.size)
+	work.linkedFiles.Add(1)
+	return true
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"simple-backup/src/style"
+	"time"
+)
+
+// Destination writes are routed either to the backup directory on disk,
+// or into a tar stream on stdout when '-to-stdout' is specified.
+
+// Returned by copyOffload when the platform or file systems can't copy the file by themselves
+var errOffloadUnsupported = errors.New("copy offload is not supported")
+
+
+
+//////////////  DESTINATION WRITERS  //////////////////////////////////////////
+
+// CREATE DESTINATION DIRECTORY
+func (app *BackupApp) makeDir(dest string, mode os.FileMode) error {
+	if app.tarOut == nil {
+		return os.MkdirAll(dest, mode)
+	}
+
+	name, err := app.archiveName(dest)
+	if err != nil {
+		return err
+	}
+	return app.tarOut.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(mode.Perm()),
+		ModTime:  app.startTime,
+	})
+}
+
+
+// COPY ATTRIBUTES OF SOURCE DIRECTORY TO DESTINATION
+// Files get their attributes when written. Tar stream doesn't keep them.
+func (app *BackupApp) copyDirAttributes(dest string, info os.FileInfo) error {
+	if app.tarOut != nil {
+		return nil
+	}
+	if err := copyAttributes(dest, info); err != nil {
+		return fmt.Errorf("copying attributes: %w", err)
+	}
+	return nil
+}
+
+
+// CREATE DESTINATION SYMLINK
+func (app *BackupApp) makeSymlink(target, dest string) error {
+	if app.tarOut == nil {
+		return os.Symlink(target, dest)
+	}
+
+	name, err := app.archiveName(dest)
+	if err != nil {
+		return err
+	}
+	return app.tarOut.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: filepath.ToSlash(target),
+		Mode:     0777,
+		ModTime:  app.startTime,
+	})
+}
+
+
+// WRITE DESTINATION FILE FROM READER
+// 'info' describes the source file; its size must match the reader content in tar mode.
+// 'settle' (optional) is called once the content is copied, its error leaves the copy out of the backup
+// (see changing.go).
+func (app *BackupApp) writeFile(dest string, r io.Reader, info os.FileInfo, settle func() error) error {
+	srcFile, _ := r.(*os.File)
+	limited := app.rateLimit != nil && app.rateLimit.dest // UNC destination on a metered connection
+	if limited {
+		r = app.limitRate(r)
+	}
+	if app.uploadLimit != nil {
+		r = app.limitUpload(r)
+		limited = true
+	}
+	progress := app.progressOf(dest)
+	if name, err := filepath.Rel(app.bkpDestFullPath, dest); err == nil {
+		r = progress.startFile(name, info.Size(), r)
+	}
+	tracked := r
+
+	// Small files are appended to pack files ('pack_small_files')
+	if app.packed(info) {
+		return app.packFile(dest, r, info, settle)
+	}
+
+	// Checksum for the manifest is calculated on the fly, so the content is read only once
+	// (unless hashing workers take care of it after the copy)
+	hash := newHash(app.BkpConfig.HashAlgorithm)
+	if app.hashes == nil {
+		r = io.TeeReader(r, hash)
+	}
+
+	if app.tarOut == nil {
+		// Ensure destination directory exists
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+
+		// Local files are copied by the OS when they don't have to be hashed on the way
+		durable := app.BkpConfig.Durability == DurabilityFsync
+		written, err := int64(0), errOffloadUnsupported
+		chunked := app.chunkedUploads() // UNC destination, uploads are resumed if the connection drops
+		ranged := !limited && !chunked && app.rangedCopyable(srcFile, info) // read back for the checksum below
+		if ranged {
+			written, err = app.copyRanged(dest, srcFile, info.Size(), durable, func(n int64) { progress.copied(tracked, n) })
+		} else if srcFile != nil && app.hashes != nil && !limited && !chunked {
+			written, err = copyOffload(dest, srcFile, int(app.BkpConfig.copyBufferSizeParsed), durable, func(n int64) { progress.copied(r, n) })
+			switch {
+			case !logger.Enabled(style.LevelDebug):
+			case err == nil:
+				logger.Debug(fmt.Sprintf("Copied by the OS (%s): %s\n", offloadMethod, dest))
+			case err == errOffloadUnsupported:
+				logger.Debug(fmt.Sprintf("Copy offload is not supported, using copy buffer: %s\n", dest))
+			}
+		}
+		if err == errOffloadUnsupported && chunked {
+			written, err = app.copyChunked(dest, r, info.Size(), func(note string) { progress.fileNote(tracked, note) })
+		} else if err == errOffloadUnsupported {
+			written, err = app.copyToFile(dest, r, durable)
+		}
+		if err != nil {
+			return err
+		}
+		if settle != nil {
+			if err := settle(); err != nil {
+				return err
+			}
+		}
+
+		// Read-only attribute (copied below) would block writing the streams
+		if app.ads != nil && srcFile != nil {
+			app.copyStreams(srcFile.Name(), dest)
+		}
+
+		// Copy file permissions and attributes
+		if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := copyAttributes(dest, info); err != nil {
+			return fmt.Errorf("copying attributes: %w", err)
+		}
+		if app.hashes != nil {
+			app.queueHashing(hashJob{dest: dest, size: written, modTime: info.ModTime()})
+			return nil
+		}
+		if ranged {
+			return app.hashCopied(hashJob{dest: dest, size: written, modTime: info.ModTime()})
+		}
+		app.recordFile(dest, written, info.ModTime(), hash.Sum(nil))
+		return nil
+	}
+
+	name, err := app.archiveName(dest)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	}
+	if err := app.tarOut.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// A file that changed size while being read would corrupt the archive, so stick to the header size
+	written, err := app.copyBuffered(app.tarOut, io.LimitReader(r, info.Size()))
+	if err == nil && written < info.Size() {
+		return fmt.Errorf("file shrunk while being archived (%d of %d bytes read)", written, info.Size())
+	}
+	if err != nil {
+		return err
+	}
+	if settle != nil {
+		if err := settle(); err != nil {
+			return err
+		}
+	}
+	app.recordFile(dest, written, info.ModTime(), hash.Sum(nil))
+	return nil
+}
+
+
+// copyToFile writes the reader into a new file at 'dest' through the copy buffer.
+// With 'durable', the content is synced to disk, not just to the OS cache.
+func (app *BackupApp) copyToFile(dest string, r io.Reader, durable bool) (int64, error) {
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer destFile.Close()
+
+	written, err := app.copyBuffered(destFile, r)
+	if err == nil && durable {
+		err = destFile.Sync()
+	}
+	return written, err
+}
+
+
+// WRITE DESTINATION FILE FROM MEMORY (backup metadata, reports, etc.)
+func (app *BackupApp) writeBytes(dest string, data []byte) error {
+	if app.tarOut == nil {
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		if app.BkpConfig.Durability == DurabilityFsync {
+			return f.Sync()
+		}
+		return nil
+	}
+
+	name, err := app.archiveName(dest)
+	if err != nil {
+		return err
+	}
+	if err := app.tarOut.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err = app.tarOut.Write(data)
+	return err
+}
+
+
+// COPY USING POOLED BUFFER OF 'COPY_BUFFER_SIZE'
+// Reader/writer optimizations (ReadFrom/WriteTo) are bypassed on purpose: their default buffering
+// is slow on some network file systems, and large reads let SFTP issue concurrent requests.
+func (app *BackupApp) copyBuffered(w io.Writer, r io.Reader) (int64, error) {
+	bufPtr, ok := app.copyBuffers.Get().(*[]byte)
+	if !ok || uint64(len(*bufPtr)) != app.BkpConfig.copyBufferSizeParsed {
+		buf := make([]byte, app.BkpConfig.copyBufferSizeParsed)
+		bufPtr = &buf
+	}
+	defer app.copyBuffers.Put(bufPtr)
+
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, *bufPtr)
+}
+
+
+// FSYNC BACKUP DIRECTORIES
+// Makes directory entries of the copied files (and of the backup directory itself) durable.
+// File contents are synced as they are written. Directories can't be synced on Windows.
+func (app *BackupApp) syncDirs() error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	err := filepath.WalkDir(app.bkpDestFullPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return syncPath(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return syncPath(filepath.Dir(app.bkpDestFullPath))
+}
+
+
+// syncPath flushes the file or directory to disk.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+
+// archiveName converts destination path into the slash-separated name inside the tar stream,
+// rooted at the backup directory name (e.g. 'smbkp-20240101-120000Z/docs/file.txt').
+func (app *BackupApp) archiveName(dest string) (string, error) {
+	rel, err := filepath.Rel(filepath.Dir(app.bkpDestFullPath), dest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+
+
+//////////////  TAR STREAM  ///////////////////////////////////////////////////
+
+// START TAR STREAM ON STDOUT
+// The stream goes through the stages of the stream pipeline (compression, encryption) on its way.
+func (app *BackupApp) openTarStream() error {
+	pipeline, w, err := openPipeline(app.streamStages(), os.Stdout)
+	if err != nil {
+		return err
+	}
+	app.pipeline = pipeline
+	app.tarOut = tar.NewWriter(w)
+	return nil
+}
+
+
+// FINALIZE TAR STREAM
+func (app *BackupApp) closeTarStream() error {
+	if app.tarOut == nil {
+		return nil
+	}
+	err := app.tarOut.Close()
+	app.tarOut = nil
+	if app.pipeline != nil {
+		if pipelineErr := app.pipeline.close(); err == nil {
+			err = pipelineErr
+		}
+		app.pipeline = nil
+	}
+	return err
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"simple-backup/src/style"
+	"strings"
+	"time"
+)
+
+// 'doctor' runs environment diagnostics and suggests fixes, without making a backup.
+// Every check reports OK, warning or failure; failures make the command exit with non-zero code.
+const (
+	DoctorSampleFiles    int           = 20   // files read from each source
+	DoctorSampleEntries  int           = 5000 // entries walked in each source while sampling
+	DoctorLongPathLength int           = 300  // beyond Windows MAX_PATH (260)
+	DoctorLongNameLength int           = 255  // common file name limit
+	DoctorMinClockYear   int           = 2020
+	DoctorMaxClockSkew   time.Duration = time.Minute
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// DIAGNOSTICS RESULTS
+type doctor struct {
+	passed   int
+	warnings int
+	failures int
+}
+
+
+// ok reports a passed check.
+func (d *doctor) ok(msg string) {
+	d.passed++
+	logger.Ok(msg + "\n")
+}
+
+
+// warn reports a problem that doesn't prevent backups, with a suggested fix.
+func (d *doctor) warn(msg, fix string) {
+	d.warnings++
+	logger.Warn(msg + "\n")
+	if fix != "" {
+		logger.Sub(fmt.Sprintf("          Fix: %s\n", fix))
+	}
+}
+
+
+// fail reports a problem that prevents (or would break) backups, with a suggested fix.
+func (d *doctor) fail(msg, fix string) {
+	d.failures++
+	logger.Err(msg + "\n")
+	if fix != "" {
+		logger.Sub(fmt.Sprintf("        Fix: %s\n", fix))
+	}
+}
+
+
+// info reports a finding that needs no action.
+func (d *doctor) info(msg string) {
+	logger.Info(msg + "\n")
+}
+
+
+// section prints the check group header.
+func (d *doctor) section(title string) {
+	logger.Plain(fmt.Sprintf("\n%s\n", title), style.Bold())
+}
+
+
+
+//////////////  DOCTOR COMMAND  ///////////////////////////////////////////////
+
+// RUN 'DOCTOR' COMMAND
+func runDoctorCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+	logger.Signature("\n===========  Simple Backup Diagnostics  ===========\n")
+
+	d := &doctor{}
+	d.checkClock()
+
+	// Configuration is loaded the same way as for backup
+	d.section("Configuration")
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		d.fail(fmt.Sprintf("Configuration can't be loaded: %v", err), "Fix the reported problem, or generate a valid template with '-init-config' and compare.")
+	} else {
+		d.ok(fmt.Sprintf("Configuration %q is valid.", app.configFile))
+		if len(app.BkpConfig.BkpItems) == 0 {
+			d.warn("No items listed under 'bkp_items', backup would do nothing.", "Add sources to back up under 'bkp_items'.")
+		}
+	}
+
+	// Destination can still be checked with defaults, if it's known
+	if app == nil && *bkpDest != "" {
+		app = &BackupApp{BkpConfig: *NewConfig(), bkpDest: *bkpDest}
+		app.bkpDestFullPath = filepath.Join(app.bkpDest, app.BkpConfig.BkpDestDir)
+	}
+	if app != nil {
+		d.checkDestination(app)
+		d.checkSources(app)
+		app.closeRemoteClients()
+	}
+
+	d.section("Privileges")
+	d.checkPrivileges()
+
+	logger.Plain("\n")
+	summary := fmt.Sprintf("%d checks passed, %d warnings, %d failures.\n\n", d.passed, d.warnings, d.failures)
+	switch {
+	case d.failures > 0:
+		logger.Err(summary, style.NoLabel(), style.Bold())
+		return 1
+	case d.warnings > 0:
+		logger.Warn(summary, style.NoLabel(), style.Bold())
+	default:
+		logger.Ok(summary, style.NoLabel(), style.Bold())
+	}
+	return 0
+}
+
+
+// CHECK SYSTEM CLOCK
+// Backup names and retention order rely on the clock.
+func (d *doctor) checkClock() {
+	d.section("Clock")
+	now := time.Now()
+	if now.Year() < DoctorMinClockYear {
+		d.fail(fmt.Sprintf("System clock reports %s.", now.Format(time.RFC822)), "Set correct date and time, and enable network time synchronization.")
+		return
+	}
+	zone, _ := now.Zone()
+	d.ok(fmt.Sprintf("System clock reports %s (%s).", now.Format(time.RFC822), zone))
+}
+
+
+// CHECK BACKUP DESTINATION
+func (d *doctor) checkDestination(app *BackupApp) {
+	d.section("Destination")
+
+	info, err := os.Stat(app.bkpDest)
+	if err != nil || !info.IsDir() {
+		d.fail(fmt.Sprintf("Backup destination %q is not accessible.", app.bkpDest), "Connect or mount the drive, or specify the correct path with '-bkp-dest'.")
+		return
+	}
+
+	// Test writes go into the backup directory, if it already exists
+	testRoot := app.bkpDest
+	if info, err := os.Stat(app.bkpDestFullPath); err == nil && info.IsDir() {
+		testRoot = app.bkpDestFullPath
+	}
+
+	tmpDir, err := os.MkdirTemp(testRoot, fmt.Sprintf(".%s-doctor-*", Prefix))
+	if err != nil {
+		d.fail(fmt.Sprintf("Backup destination %q is not writable: %v", testRoot, err), "Check that the drive is not mounted read-only, and that the current user may write to it.")
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testFile := filepath.Join(tmpDir, "test")
+	if err := os.WriteFile(testFile, []byte(Prefix), 0644); err != nil {
+		d.fail(fmt.Sprintf("Can't write files at destination: %v", err), "Check free space, quotas and permissions of the destination.")
+		return
+	}
+	if err := syncPath(testFile); err != nil {
+		d.warn(fmt.Sprintf("Destination doesn't support flushing files to disk: %v", err), "Use 'durability: none' with this destination.")
+	}
+	d.ok(fmt.Sprintf("Backup destination %q is writable.", testRoot))
+
+	// Files stamped by a network share with its own clock confuse age-based decisions
+	if info, err := os.Stat(testFile); err == nil {
+		skew := time.Since(info.ModTime())
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > DoctorMaxClockSkew {
+			d.warn(fmt.Sprintf("Destination file times differ from system clock by %s.", skew.Round(time.Second)), "Synchronize clocks of this machine and the file server hosting the destination.")
+		}
+	}
+
+	// Free space
+	freeSpace, freeSpaceStr, err := getFreeSpace(app.bkpDest)
+	switch {
+	case err != nil:
+		d.fail(fmt.Sprintf("Can't read free space of destination: %v", err), "Make sure the destination is a local drive or a mounted share.")
+	case freeSpace < app.BkpConfig.Retention.minFreeSpaceParsed:
+		d.fail(fmt.Sprintf("Available free space (%s) is less than required minimum (%s).", freeSpaceStr, app.BkpConfig.Retention.MinFreeSpace), "Free up space on the destination, or lower 'backups_to_keep' so older backups are removed.")
+	default:
+		d.ok(fmt.Sprintf("Available free space %s (required minimum %s).", freeSpaceStr, app.BkpConfig.Retention.MinFreeSpace))
+	}
+
+	// Long paths and names
+	longName := strings.Repeat("n", DoctorLongNameLength)
+	if err := os.WriteFile(filepath.Join(tmpDir, longName), nil, 0644); err != nil {
+		d.warn(fmt.Sprintf("Destination doesn't accept %d-character file names.", DoctorLongNameLength), "Files with long names will fail to copy; use a destination with a different file system (e.g. eCryptfs and some network shares limit names).")
+	} else {
+		d.ok(fmt.Sprintf("Destination accepts %d-character file names.", DoctorLongNameLength))
+	}
+
+	longDir := tmpDir
+	for len(longDir) < DoctorLongPathLength {
+		longDir = filepath.Join(longDir, strings.Repeat("d", 50))
+	}
+	if err := os.MkdirAll(longDir, 0755); err != nil {
+		fix := "Files with deep paths will fail to copy; use a shorter 'bkp_dest_dir' or item destinations."
+		if runtime.GOOS == "windows" {
+			fix = "Enable long paths (set 'LongPathsEnabled' to 1 under HKLM\\SYSTEM\\CurrentControlSet\\Control\\FileSystem)."
+		}
+		d.warn(fmt.Sprintf("Destination doesn't accept paths longer than %d characters.", DoctorLongPathLength), fix)
+	} else {
+		d.ok(fmt.Sprintf("Destination accepts paths longer than %d characters.", DoctorLongPathLength))
+	}
+
+	d.checkFileSystem(tmpDir)
+
+	switch err := app.canaryGuard(app.bkpDestFullPath); {
+	case errors.Is(err, errCanaryAltered):
+		d.fail(fmt.Sprintf("Canary file %q has been altered, backups may be encrypted or tampered with.", CanaryFileName), "Inspect the backups; once it's safe, delete the canary file so a new one is planted.")
+	case errors.Is(err, errCanaryMissing):
+		d.fail(fmt.Sprintf("Canary file %q is missing, backups may be encrypted or tampered with.", CanaryFileName), "Inspect the backups; once it's safe, run a backup to plant a new one (old backups are kept by that run).")
+	}
+
+	// Backups dated in the future are kept forever, while newer ones get removed
+	if newest, ahead, ok := clockBehind(app.bkpDestFullPath, time.Now()); ok {
+		d.warn(fmt.Sprintf("Latest backup %q is dated %s in the future.", newest.name, formatAge(ahead)), "Check system clock and time zone; remove or rename backups with wrong dates.")
+	}
+}
+
+
+// CHECK BACKUP SOURCES
+// Reads a sample of files from each source, since reading everything would take as long as the backup.
+func (d *doctor) checkSources(app *BackupApp) {
+	if len(app.BkpConfig.BkpItems) == 0 {
+		return
+	}
+	d.section("Sources")
+
+	for _, item := range app.BkpConfig.BkpItems {
+		label := itemSourceLabel(item)
+
+		switch {
+		case isStdinItem(item):
+			d.ok(fmt.Sprintf("%s: read from stdin, not checked.", label))
+
+		case isStreamItem(item):
+			name := strings.Fields(item.Command)[0]
+			if _, err := exec.LookPath(name); err != nil {
+				d.warn(fmt.Sprintf("%s: %q is not found in PATH.", label, name), "Install the program, or use full path to it in 'command'.")
+			} else {
+				d.ok(fmt.Sprintf("%s: %q is found.", label, name))
+			}
+
+		case isRemoteSource(item.Source):
+			rs, err := parseRemoteSource(item.Source)
+			if err == nil {
+				client, clientErr := app.sftpClient(rs, item.SSHKey)
+				if err = clientErr; err == nil {
+					_, err = client.Stat(rs.Path)
+				}
+			}
+			if err != nil {
+				d.fail(fmt.Sprintf("%s: %v", label, err), "Check that the host is reachable, its key is in 'known_hosts', and 'ssh_key' (or ssh-agent) grants access.")
+			} else {
+				d.ok(fmt.Sprintf("%s: accessible over SFTP.", label))
+			}
+
+		default:
+			sampled, failed, err := app.sampleSource(item)
+			switch {
+			case err != nil:
+				d.fail(fmt.Sprintf("%s: %v", label, err), "Check that the path exists and the current user may read it.")
+			case failed > 0:
+				d.warn(fmt.Sprintf("%s: %d of %d sampled files can't be read.", label, failed, sampled), "Run as a user with access to these files, or exclude them with 'exclude' patterns.")
+			default:
+				d.ok(fmt.Sprintf("%s: %d sampled files are readable.", label, sampled))
+			}
+		}
+	}
+}
+
+
+// sampleSource reads the beginning of up to DoctorSampleFiles files of the local source.
+// Returns the number of sampled files and how many of them failed to read.
+func (app *BackupApp) sampleSource(item BackupItem) (int, int, error) {
+	info, err := os.Stat(item.Source)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !info.IsDir() {
+		if err := readSample(item.Source); err != nil {
+			return 1, 1, nil
+		}
+		return 1, 0, nil
+	}
+
+	patterns, err := compilePatterns(item.Include, item.Exclude)
+	if err != nil {
+		return 0, 0, err
+	}
+	sampled, failed, walked := 0, 0, 0
+	errStop := errors.New("enough samples")
+	err = filepath.WalkDir(item.Source, func(path string, entry fs.DirEntry, err error) error {
+		walked++
+		if sampled >= DoctorSampleFiles || walked > DoctorSampleEntries {
+			return errStop
+		}
+		if err != nil {
+			if path == item.Source {
+				return err
+			}
+			sampled++
+			failed++
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath, err := filepath.Rel(item.Source, path); err == nil && path != item.Source && patterns.exclusion(relPath, entry.IsDir()) != "" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		sampled++
+		if readSample(path) != nil {
+			failed++
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return sampled, failed, err
+	}
+	return sampled, failed, nil
+}
+
+
+// readSample reads the first block of the file.
+func readSample(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Read(make([]byte, 4*KB))
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// checkFileSystem is not supported on this platform.
+func (d *doctor) checkFileSystem(dir string) {}
+
+
+// checkPrivileges is not supported on this platform.
+func (d *doctor) checkPrivileges() {
+	d.info("Privilege checks are not supported on this platform.")
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkFileSystem reports whether the destination supports extended attributes.
+func (d *doctor) checkFileSystem(dir string) {
+	path := filepath.Join(dir, "test")
+	err := unix.Setxattr(path, "user.smbkp.doctor", []byte(Prefix), 0)
+	switch {
+	case err == nil:
+		d.ok("Destination supports extended attributes.")
+	case errors.Is(err, unix.ENOTSUP):
+		d.warn("Destination doesn't support extended attributes.", "Use a file system with extended attributes (e.g. ext4, APFS) if tags, labels or ACLs of sources matter.")
+	default:
+		d.warn(fmt.Sprintf("Can't set extended attributes at destination: %v", err), "Check mount options of the destination (e.g. 'user_xattr').")
+	}
+}
+
+
+// checkPrivileges reports whether files of other users are readable.
+func (d *doctor) checkPrivileges() {
+	if os.Geteuid() == 0 {
+		d.ok("Running as root: files of all users are readable.")
+		return
+	}
+	name := fmt.Sprintf("uid %d", os.Geteuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	d.info(fmt.Sprintf("Running as %q: files of other users may be unreadable (see 'Sources' above).", name))
+}
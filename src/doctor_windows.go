@@ -0,0 +1,39 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// checkFileSystem reports destination file systems that lose file attributes or limit file sizes.
+func (d *doctor) checkFileSystem(dir string) {
+	name, err := fileSystemName(dir)
+	if err != nil {
+		d.warn(fmt.Sprintf("Can't read file system of destination: %v", err), "")
+		return
+	}
+
+	switch strings.ToUpper(name) {
+	case "NTFS", "REFS":
+		d.ok(fmt.Sprintf("Destination file system is %s.", name))
+	case "FAT32", "FAT":
+		d.warn(fmt.Sprintf("Destination file system is %s: files over 4gb can't be stored, and file attributes are lost.", name), "Reformat the destination drive as NTFS or exFAT.")
+	default:
+		d.warn(fmt.Sprintf("Destination file system is %s: file attributes and alternate data streams may be lost.", name), "Use an NTFS destination if attributes of sources matter.")
+	}
+}
+
+
+// checkPrivileges reports whether the process is elevated, which is required for Volume Shadow Copy
+// (reading locked files) and for reading protected folders.
+func (d *doctor) checkPrivileges() {
+	if windows.GetCurrentProcessToken().IsElevated() {
+		d.ok("Running as administrator: Volume Shadow Copy and protected folders are accessible.")
+		return
+	}
+	d.warn("Not running as administrator: locked files can't be read via Volume Shadow Copy, and protected folders are skipped.", "Run from an elevated command prompt (\"Run as administrator\").")
+}
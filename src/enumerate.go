@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// ENTRY OF THE ITEM WORK LIST (selected during enumeration)
+type workEntry struct {
+	path       string      // full source path
+	relPath    string      // OS-specific path relative to the item source
+	info       os.FileInfo // Lstat info for directories and symlinks to directories, Stat info for files
+	linkTarget string      // set for symlinks to directories, which are recreated rather than followed
+}
+
+
+// SOURCE PATH THAT WAS NOT COPIED (listed in backup report)
+type skippedEntry struct {
+	path   string
+	reason string
+	label  bool // 'path' is the label of an item without a source path (stream), summarized as is
+}
+
+
+// WORK LIST OF THE ITEM
+// Produced once by enumeration and consumed by the copy phase, so the source is walked only once.
+type workList struct {
+	item         BackupItem
+	root         os.FileInfo  // item source itself
+	remote       *sftp.Client // set for remote sources, entries are read over SFTP
+	entries      []workEntry
+	skipped      []skippedEntry
+	truncated    string // set if item limits left some of the source out
+	files        int
+	dirs         int
+	bytes        int64
+	elapsed      time.Duration
+	placeholders []workEntry       // online-only files of cloud sync clients (any 'cloud_placeholders' policy)
+	databases    map[string]string // known always-open databases found, example path by kind
+	linked       atomic.Int64      // bytes hard-linked from the previous backup instead of copied
+	linkedFiles  atomic.Int64
+	copiedFiles  atomic.Int64 // files copied or hard-linked (item results)
+	copiedBytes  atomic.Int64
+	skippedFiles atomic.Int64 // files skipped while being copied (inaccessible)
+	failedFiles  atomic.Int64
+	changedFiles atomic.Int64 // files still changing after their last copy (see changing.go)
+	feed         chan workEntry  // set if entries are copied while enumeration runs ('copy_while_scanning')
+	onAdd        func(workEntry) // called for each added entry that reports progress
+	mtimes       mtimeRule       // how modification times of the source are compared with the manifest
+	queued       bool            // files are in the queue of the run ('--continue')
+	resumed      int             // files copied by the interrupted run, counted in 'files' but not listed
+	patterns     *patternMatcher // include and exclude patterns of the item, compiled when the walk starts
+}
+
+
+// total returns the number of entries that report progress when processed.
+func (wl *workList) total() int {
+	return wl.files - wl.resumed + wl.dirs
+}
+
+
+// fileCopied counts the file copied (or hard-linked) into the item results. Safe for concurrent use.
+func (wl *workList) fileCopied(size int64) {
+	wl.copiedFiles.Add(1)
+	wl.copiedBytes.Add(size)
+}
+
+
+// add appends the entry (or passes it to the copy, see 'copy_while_scanning') and updates totals.
+func (wl *workList) add(entry workEntry) {
+	if logger.Enabled(style.LevelDebug) {
+		logger.Debug(fmt.Sprintf("Included %s\n", entry.path))
+	}
+	switch {
+	case entry.linkTarget != "":
+		// symlinks to directories are recreated and don't report progress
+	case entry.info.IsDir():
+		wl.dirs++
+	default:
+		wl.files++
+		wl.bytes += entry.info.Size()
+	}
+	if wl.onAdd != nil && entry.linkTarget == "" {
+		wl.onAdd(entry)
+	}
+	if wl.feed != nil {
+		wl.feed <- entry // blocks while the copy is behind by the whole feed
+		return
+	}
+	wl.entries = append(wl.entries, entry)
+}
+
+
+
+// skip records the source path that was left out of the work list.
+func (wl *workList) skip(path, reason string) {
+	wl.skipped = append(wl.skipped, skippedEntry{path: path, reason: reason})
+}
+
+
+
+// attributeExclusion returns the reason to skip the item because of its hidden or system attribute,
+// or empty string if item settings allow it.
+func attributeExclusion(item BackupItem, info os.FileInfo) string {
+	hidden, system := fileAttributes(info)
+	if item.IncludeHidden != nil && !*item.IncludeHidden && (hidden || strings.HasPrefix(info.Name(), ".")) {
+		return "excluded: hidden"
+	}
+	if item.SkipSystemAttrib && system {
+		return "excluded: system"
+	}
+	return ""
+}
+
+
+
+// optOutExclusion returns the reason to skip the item because it opted out of backups:
+// by the "nodump" flag ('skip_nodump'), or, for directories, by a marker file inside ('exclude_if_present').
+// 'exists' reports whether the directory has a child with the name.
+func optOutExclusion(item BackupItem, path string, info os.FileInfo, exists func(name string) bool) string {
+	if item.SkipNodump && hasNodumpFlag(path, info) {
+		return "excluded: nodump"
+	}
+	if info.IsDir() {
+		for _, marker := range item.ExcludeIfPresent {
+			if exists(marker) {
+				return "excluded: marker " + marker
+			}
+		}
+	}
+	return ""
+}
+
+
+
+// beyondDepth reports whether the entry is deeper than 'max_depth' of the item, and records it as skipped.
+// Only the first level beyond the limit is reached by the walk, deeper directories are not read at all.
+func (wl *workList) beyondDepth(item BackupItem, path, relPath string) bool {
+	if item.MaxDepth == 0 || strings.Count(relPath, string(filepath.Separator)) < int(item.MaxDepth) {
+		return false
+	}
+	wl.skip(path, "excluded: max_depth")
+	wl.truncated = fmt.Sprintf("%q limit reached, items deeper than %d levels are not copied", "max_depth", item.MaxDepth)
+	return true
+}
+
+
+// filesLimitReached reports whether 'max_files' of the item is reached.
+// The enumeration must stop then, the rest of the source is not walked.
+func (wl *workList) filesLimitReached(item BackupItem) bool {
+	if item.MaxFiles == 0 || wl.files < int(item.MaxFiles) {
+		return false
+	}
+	wl.truncated = fmt.Sprintf("%q limit reached, only the first %d files are copied", "max_files", item.MaxFiles)
+	return true
+}
+
+
+
+//////////////  ENUMERATION  //////////////////////////////////////////////////
+
+// ENUMERATE ITEM SOURCE INTO WORK LIST
+// Shows a spinner with running counts, since walking a large tree may take minutes.
+func (app *BackupApp) enumerateItem(item BackupItem) (*workList, error) {
+	start := time.Now()
+	spin := newSpinner("Counting")
+	defer spin.clear()
+
+	var wl *workList
+	var err error
+
+	switch {
+	case isStreamItem(item):
+		wl = &workList{files: 1} // A single stream
+	case isRemoteSource(item.Source):
+		wl, err = app.enumerateRemote(item, spin)
+	default:
+		wl, err = app.enumerateLocal(item, spin)
+	}
+
+	if wl != nil {
+		wl.item = item
+		wl.mtimes = app.mtimeRule(item)
+		wl.elapsed = time.Since(start)
+	}
+	return wl, err
+}
+
+
+// ENUMERATE LOCAL SOURCE, APPLYING INCLUDE/EXCLUDE PATTERNS
+func (app *BackupApp) enumerateLocal(item BackupItem, spin *spinner) (*workList, error) {
+	srcInfo, err := os.Stat(item.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := &workList{root: srcInfo}
+
+	if !srcInfo.IsDir() {
+		wl.add(workEntry{path: item.Source, info: srcInfo}) // A single file
+		return wl, nil
+	}
+
+	// Each real directory is walked once, which breaks symlink cycles and avoids duplicate content
+	visited := make(map[string]string)
+	return wl, app.walkLocal(item, wl, spin, item.Source, "", visited)
+}
+
+
+// WALK LOCAL DIRECTORY INTO WORK LIST
+// 'relBase' is the path of the directory relative to the item source ('root' differs from it for followed symlinks).
+// 'visited' maps real paths of walked directories to their paths relative to the item source.
+func (app *BackupApp) walkLocal(item BackupItem, wl *workList, spin *spinner, root, relBase string, visited map[string]string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if wl.patterns == nil {
+		if wl.patterns, err = compilePatterns(item.Include, item.Exclude); err != nil {
+			return err
+		}
+	}
+
+	// Each entry is decided on before a directory is listed, so excluded trees are not read at all:
+	// the directory is skipped on its own Lstat and listed once in the skipped report
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		var info os.FileInfo
+		if err == nil {
+			info, err = d.Info()
+		}
+		if err != nil {
+			if isWindowsProtectedPath(path, err) {
+				wl.skip(path, "protected: "+err.Error())
+				return nil
+			}
+			if os.IsPermission(err) {
+				wl.skip(path, SkipInaccessible+": "+err.Error())
+				return nil
+			}
+			return err
+		}
+
+		// Calculate relative path
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		// Skip root directory (followed symlink is already in the work list)
+		if rel == "." {
+			visited[realRoot] = filepath.Join(".", relBase)
+			return nil
+		}
+		relPath := filepath.Join(relBase, rel)
+
+		// Check include/exclude patterns
+		if reason := wl.patterns.exclusion(relPath, info.IsDir()); reason != "" {
+			wl.skip(path, reason)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if reason := attributeExclusion(item, info); reason != "" {
+			wl.skip(path, reason)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		exists := func(name string) bool {
+			_, err := os.Lstat(filepath.Join(path, name))
+			return err == nil
+		}
+		if reason := optOutExclusion(item, path, info, exists); reason != "" {
+			wl.skip(path, reason)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if reason := app.backupFolderExclusion(path, info, true, exists); reason != "" {
+			wl.skip(path, reason)
+			return filepath.SkipDir
+		}
+		if isCloudPlaceholder(info) && wl.cloudPlaceholder(app.BkpConfig.CloudPlaceholders, path, info) {
+			return nil
+		}
+		if !info.IsDir() {
+			if reason := wl.databaseFile(item, path); reason != "" {
+				wl.skip(path, reason)
+				return nil
+			}
+		}
+		if wl.beyondDepth(item, path, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entry := workEntry{path: path, relPath: relPath, info: info}
+
+		if info.IsDir() {
+			// Reached again through a followed symlink
+			real := filepath.Join(realRoot, rel)
+			if first, ok := visited[real]; ok {
+				wl.skip(path, fmt.Sprintf("duplicate: same directory as %q", first))
+				return filepath.SkipDir
+			}
+			visited[real] = relPath
+		}
+
+		// Resolve symlinks now, so the copy phase knows what to do with them
+		if info.Mode()&os.ModeSymlink != 0 {
+			stat, err := os.Stat(path) // This follows the symlink
+			if err != nil {
+				return err
+			}
+			switch {
+			case stat.IsDir() && item.FollowSymlinks:
+				// It's a symlink to a directory, its content will be copied, unless it was (or is being) walked already
+				target, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					return err
+				}
+				if first, ok := visited[target]; ok {
+					spin.clear()
+					logger.Warn(fmt.Sprintf("Symlink %q points to already copied directory %q, not following it (possible cycle).\n", relPath, first))
+					wl.skip(path, fmt.Sprintf("symlink cycle: same directory as %q", first))
+					return nil
+				}
+				entry.info = stat
+				wl.add(entry)
+				return app.walkLocal(item, wl, spin, target, relPath, visited)
+			case stat.IsDir():
+				// It's a symlink to a directory, it will be recreated
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				entry.linkTarget = target
+			default:
+				// It's a symlink to a file, it will be copied as a regular file
+				entry.info = stat
+			}
+		}
+
+		if wl.filesLimitReached(item) {
+			return filepath.SkipAll
+		}
+		wl.add(entry)
+		spin.update(fmt.Sprintf("%d files, %d directories, %s", wl.files, wl.dirs, formatBytes(uint64(wl.bytes))))
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"simple-backup/src/style"
+)
+
+// 'estimate' is a quick capacity-planning tool: it enumerates items the same way the backup does
+// (patterns and limits apply) and prints per-item and total sizes, whether the next backup fits
+// the free space of the destination, and what retention would free after it.
+// It's the human-readable counterpart of 'plan', without prompts or writes.
+
+
+
+//////////////  ESTIMATE COMMAND  /////////////////////////////////////////////
+
+// RUN 'ESTIMATE' COMMAND
+// Exits with non-zero code if the next backup doesn't fit the free space, or an item can't be enumerated.
+func runEstimateCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	defer app.closeRemoteClients()
+
+	plan, err := app.buildPlan()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Estimate failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	return app.printEstimate(plan)
+}
+
+
+// PRINT ESTIMATE OF THE NEXT BACKUP
+func (app *BackupApp) printEstimate(plan *backupPlan) int {
+	logger.Signature("\n====  Estimate of the next backup  ===\n")
+
+	// Items
+	table := style.NewTable("Files", "Dirs", "Size", "Skipped", "Source", "Notes").AlignRight(0, 1, 2, 3)
+	var files, dirs, skipped, failed int
+	var total int64
+	for i, item := range plan.Items {
+		if item.Error != "" {
+			table.Row("-", "-", "-", "-", item.Source, "error: "+item.Error)
+			failed++
+			continue
+		}
+		size, note := "unknown", item.Truncated
+		if item.Bytes != nil {
+			size = formatBytes(uint64(*item.Bytes))
+			total += *item.Bytes
+		}
+		if item.OverMaxSize {
+			config := app.BkpConfig.BkpItems[i]
+			action := config.MaxSizeAction
+			if action == "" {
+				action = MaxSizeAbort
+			}
+			note = fmt.Sprintf("over %q %s (%s)", "max_size", config.MaxSize, action)
+		}
+		table.Row(fmt.Sprint(item.Files), fmt.Sprint(item.Dirs), size, fmt.Sprint(item.Skipped), item.Source, note)
+		files += item.Files
+		dirs += item.Dirs
+		skipped += item.Skipped
+	}
+	table.Totals(fmt.Sprint(files), fmt.Sprint(dirs), formatBytes(uint64(total)), fmt.Sprint(skipped), "Total", "")
+	logger.Plain(table.Render())
+
+	// Fit against free space
+	dest := plan.Destination
+	retention := app.BkpConfig.Retention
+	logger.Plain("\nDestination:\n", style.Bold())
+	logger.Plain(fmt.Sprintf("Free space: %s\n", formatBytes(dest.FreeSpace)))
+	logger.Plain(fmt.Sprintf("Required: %s (backup size plus %q %s)\n", formatBytes(dest.RequiredSpace), "min_free_space", retention.MinFreeSpace))
+
+	// Retention runs after a successful backup
+	var freed uint64
+	for _, backup := range plan.Retention.Remove {
+		freed += backup.Size
+	}
+	logger.Plain(fmt.Sprintf("Existing backups: %d, retention keeps %d and would then %s %d (%s)\n",
+		plan.Retention.ExistingBackups, retention.BackupsToKeep, plan.Retention.Action, len(plan.Retention.Remove), formatBytes(freed)))
+	if dest.Sufficient && total > 0 {
+		room := (dest.FreeSpace - dest.RequiredSpace) / uint64(total)
+		logger.Plain(fmt.Sprintf("Room for about %d more backups of this size after the next one (without deduplication).\n", room))
+	}
+
+	logger.Plain("\n")
+	if failed > 0 {
+		logger.Err(fmt.Sprintf("%d items could not be enumerated.\n", failed))
+	}
+	if !dest.Sufficient {
+		logger.Err(fmt.Sprintf("The next backup doesn't fit: %s more space is needed.\n", formatBytes(dest.RequiredSpace-min(dest.FreeSpace, dest.RequiredSpace))))
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	logger.Ok("The next backup fits the destination.\n", style.NoLabel())
+	return 0
+}
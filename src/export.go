@@ -0,0 +1,409 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 'export' writes a complete backup into a single archive (.tar, .tar.gz or .tar.zst by the file extension),
+// to hand it to someone without smbkp: any tar tool extracts it into a plain directory named after the backup.
+// Files are read in whatever form the backup stores them (packed by 'pack_small_files', hard-linked by
+// 'dedup: hardlink', under obfuscated names with 'obfuscate_names') and archived under their real paths,
+// with permissions of the backed up copy and source modification times from the manifest.
+// Content is checked against the manifest on the way, damaged files are reported.
+// Symlinks recreated by the backup are archived too, unless names are obfuscated (they are not in the manifest).
+// Report files are not archived. Zstd compression uses the 'zstd' tool from PATH.
+// Parts of a backup spanning volumes ('span_volumes') are stitched together: files of all parts go into one
+// archive, named after the part that is exported. All parts must be attached.
+
+// Archive formats by file extension
+var exportFormats = []struct {
+	extension string
+	stage     pipelineStage // compression, nil for plain tar
+}{
+	{".tar", nil},
+	{".tar.gz", gzipStage{}},
+	{".tgz", gzipStage{}},
+	{".tar.zst", zstdStage{}},
+	{".tzst", zstdStage{}},
+}
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// FILE OR SYMLINK TO ARCHIVE
+type exportEntry struct {
+	path     string        // real path in the backup, slash-separated
+	file     manifestEntry // regular files
+	linkName string        // symlinks
+	part     *exportPart   // backup directory the entry is in
+}
+
+
+// BACKUP DIRECTORY TO ARCHIVE (one of several, if the backup spans volumes)
+type exportPart struct {
+	backup     backupDir
+	obfuscated bool
+	protected  bool // read-only ('read_only')
+	packs      map[string]packEntry
+	algorithm  string
+}
+
+
+// EXPORT RESULTS
+type exportResult struct {
+	files   int
+	links   int
+	bytes   int64
+	damaged []string // files that don't match the manifest
+}
+
+
+
+//////////////  EXPORT COMMAND  ///////////////////////////////////////////////
+
+// RUN 'EXPORT' COMMAND
+// Exits with non-zero code if the archive is not written, or has damaged files.
+func runExportCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		to         = flags.String("to", "", "Archive file to write (.tar, .tar.gz, .tgz, .tar.zst or .tzst).")
+		force      = flags.Bool("force", false, "Overwrite the archive file if it exists.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	if *to == "" {
+		logger.Fatal(fmt.Sprintf("%q is required.\n\n", "--to"), style.Bold())
+		return 1
+	}
+	stage, err := exportStage(*to)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+	if _, err := os.Lstat(*to); err == nil && !*force {
+		logger.Fatal(fmt.Sprintf("File %q already exists. Use '--force' to overwrite it.\n\n", *to), style.Bold())
+		return 1
+	}
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backup, err := resolveCompleteBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+	if !backupSealed(backup.state) {
+		logger.Fatal(fmt.Sprintf("Backup %s is not complete, it has no manifest to export from.\n\n", backup.name), style.Bold())
+		return 1
+	}
+
+	parts, err := app.spanParts(backup)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+
+	logger.Signature(fmt.Sprintf("\n====  Exporting: %s (%s ago) to %s  ===\n", backup.name, formatAge(time.Since(backup.created)), *to))
+	if len(parts) > 1 {
+		logger.Plain(fmt.Sprintf("Backup spans %d volumes, parts:\n", len(parts)))
+		for _, part := range parts {
+			logger.Sub(fmt.Sprintf("  %s\n", part.path))
+		}
+	}
+	result, err := exportBackup(parts, backup.name, *to, stage)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Export failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	logger.Plain(fmt.Sprintf("\nArchived %d files (%s) and %d symlinks.\n", result.files, formatBytes(uint64(result.bytes)), result.links))
+	if len(result.damaged) > 0 {
+		for _, p := range result.damaged {
+			logger.Plain(fmt.Sprintf("  %s\n", p))
+		}
+		logger.Err(fmt.Sprintf("%d files don't match the manifest and are archived damaged. Run 'verify' on the backup for details.\n\n", len(result.damaged)))
+		return 1
+	}
+	logger.Ok(fmt.Sprintf("Backup %s is exported to %s.\n\n", backup.name, *to), style.Bold())
+	return 0
+}
+
+
+// exportStage returns the compression stage for the archive file extension (nil for plain tar).
+func exportStage(file string) (pipelineStage, error) {
+	var extensions []string
+	for _, format := range exportFormats {
+		if strings.HasSuffix(strings.ToLower(file), format.extension) {
+			return format.stage, nil
+		}
+		extensions = append(extensions, format.extension)
+	}
+	return nil, fmt.Errorf("%q value %q is not supported. Expected a file name ending with one of: %s", "--to", file, strings.Join(extensions, ", "))
+}
+
+
+// EXPORT BACKUP INTO ARCHIVE FILE
+// Files of all parts go under 'name' in the archive, sorted by path.
+// The archive is written next to the target and renamed into place when complete.
+func exportBackup(parts []backupDir, name, archive string, stage pipelineStage) (result exportResult, err error) {
+	var entries []exportEntry
+	for _, backup := range parts {
+		part, err := openExportPart(backup)
+		if err != nil {
+			return result, err
+		}
+		partEntries, err := exportEntries(backup)
+		if err != nil {
+			return result, err
+		}
+		for i := range partEntries {
+			partEntries[i].part = part
+		}
+		entries = append(entries, partEntries...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	partial := archive + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(partial)
+		}
+	}()
+
+	var stages []pipelineStage
+	if stage != nil {
+		stages = append(stages, stage)
+	}
+	pipeline, w, err := openPipeline(stages, out)
+	if err != nil {
+		return result, err
+	}
+	tw := tar.NewWriter(w)
+
+	spin := newSpinner("Exporting")
+	written := map[string]bool{".": true}
+	for i, entry := range entries {
+		spin.update(fmt.Sprintf("%d/%d", i+1, len(entries)))
+		if err = writeExportDirs(tw, name, path.Dir(entry.path), written, entry.part.backup.created); err != nil {
+			break
+		}
+		if entry.linkName != "" {
+			err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     name + "/" + entry.path,
+				Linkname: entry.linkName,
+				Mode:     0777,
+				ModTime:  entry.part.backup.created,
+			})
+			result.links++
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		var matches bool
+		if matches, err = writeExportFile(tw, name, entry); err != nil {
+			err = fmt.Errorf("%s: %w", entry.path, err)
+			break
+		}
+		if !matches {
+			result.damaged = append(result.damaged, entry.path)
+		}
+		result.files++
+		result.bytes += entry.file.size
+	}
+	spin.clear()
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := pipeline.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return result, err
+	}
+	if err = out.Close(); err != nil {
+		return result, err
+	}
+	err = os.Rename(partial, archive)
+	return result, err
+}
+
+
+// openExportPart reads what is needed to archive files of the backup directory.
+func openExportPart(backup backupDir) (*exportPart, error) {
+	algorithm, err := manifestAlgorithm(backup.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest of %s: %w", backup.name, err)
+	}
+	packs, err := readPackIndex(backup.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pack index of %s: %w", backup.name, err)
+	}
+	info, err := os.Stat(backup.path)
+	if err != nil {
+		return nil, err
+	}
+	return &exportPart{
+		backup:     backup,
+		obfuscated: backupObfuscated(backup.path),
+		protected:  info.Mode().Perm()&0200 == 0,
+		packs:      packs,
+		algorithm:  algorithm,
+	}, nil
+}
+
+
+// exportEntries returns the files (from the manifest) and symlinks of the backup, sorted by path.
+func exportEntries(backup backupDir) ([]exportEntry, error) {
+	manifest, err := readManifest(backup.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	entries := make([]exportEntry, 0, len(manifest))
+	for _, file := range manifest {
+		entries = append(entries, exportEntry{path: file.path, file: file})
+	}
+
+	// Symlinks are recreated in the backup, but not listed in the manifest
+	if !backupObfuscated(backup.path) {
+		err := filepath.WalkDir(backup.path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(backup.path, p)
+			if d.IsDir() && rel == ReportDirName {
+				return filepath.SkipDir
+			}
+			if d.Type()&fs.ModeSymlink == 0 {
+				return nil
+			}
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, exportEntry{path: filepath.ToSlash(rel), linkName: filepath.ToSlash(target)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing symlinks: %w", err)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+
+// writeExportDirs archives the directory and its parents, unless they are already archived.
+func writeExportDirs(tw *tar.Writer, root, dir string, written map[string]bool, modTime time.Time) error {
+	if written[dir] {
+		return nil
+	}
+	if err := writeExportDirs(tw, root, path.Dir(dir), written, modTime); err != nil {
+		return err
+	}
+	written[dir] = true
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     root + "/" + dir + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+
+// writeExportFile archives the file from the backup (plain or packed), checking its content on the way.
+// Write permissions removed from read-only backups ('protected') are given back to the owner.
+// Returns false if the content doesn't match the manifest.
+func writeExportFile(tw *tar.Writer, root string, entry exportEntry) (bool, error) {
+	part := entry.part
+	diskPath, err := backupDiskPath(entry.path, part.obfuscated)
+	if err != nil {
+		return false, err
+	}
+
+	var r io.Reader
+	var mode os.FileMode
+	if pack, ok := part.packs[diskPath]; ok {
+		f, packReader, err := openPacked(part.backup.path, pack)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		r, mode = packReader, pack.mode
+	} else {
+		f, err := os.Open(filepath.Join(part.backup.path, filepath.FromSlash(diskPath)))
+		if errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("file is missing from the backup, run 'check --repair'")
+		}
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		r, mode = f, info.Mode().Perm()
+	}
+
+	if part.protected {
+		mode |= 0200
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     root + "/" + entry.path,
+		Size:     entry.file.size,
+		Mode:     int64(mode),
+		ModTime:  entry.file.modTime,
+	}); err != nil {
+		return false, err
+	}
+
+	// The archive needs exactly the manifest size, whatever the file has
+	h := newHash(part.algorithm)
+	written, err := io.Copy(tw, io.TeeReader(io.LimitReader(r, entry.file.size), h))
+	if err != nil {
+		return false, err
+	}
+	if written < entry.file.size {
+		if _, err := tw.Write(make([]byte, entry.file.size-written)); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)) == entry.file.sum, nil
+}
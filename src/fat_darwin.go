@@ -0,0 +1,15 @@
+//go:build darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// isFATVolume reports whether the path is on a FAT or exFAT file system.
+func isFATVolume(path string) bool {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return false
+	}
+	name := unix.ByteSliceToString(fs.Fstypename[:])
+	return name == "msdos" || name == "exfat"
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// isFATVolume reports whether the path is on a FAT or exFAT file system (exFAT through FUSE is not recognized).
+func isFATVolume(path string) bool {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return false
+	}
+	return fs.Type == unix.MSDOS_SUPER_MAGIC || fs.Type == unix.EXFAT_SUPER_MAGIC
+}
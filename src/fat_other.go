@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// isFATVolume is not detected on this platform.
+func isFATVolume(path string) bool {
+	return false
+}
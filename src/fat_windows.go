@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "strings"
+
+// isFATVolume reports whether the path is on a FAT, FAT32 or exFAT volume.
+func isFATVolume(path string) bool {
+	name, err := fileSystemName(path)
+	if err != nil {
+		return false
+	}
+	name = strings.ToUpper(name)
+	return strings.HasPrefix(name, "FAT") || name == "EXFAT"
+}
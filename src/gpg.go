@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// With 'encryption.gpg_recipients', the '-to-stdout' tar stream is piped through 'gpg --encrypt'
+// (the encryption stage of the stream pipeline, see pipeline.go), so existing GPG keys (including smartcards)
+// protect the archive: stdout gets the OpenPGP message only.
+// Recipients are checked before the run starts, so a typo doesn't surface after the whole source was read.
+// Directory backups are not encrypted, so the option is rejected without '-to-stdout'.
+
+const (
+	GPGBinaryDefault string = "gpg"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// ENCRYPTION SETTINGS
+type EncryptionConfig struct {
+	GPGRecipients	[]string `yaml:"gpg_recipients,omitempty"` // key IDs, fingerprints or emails the archive is encrypted to
+	GPGBinary		string   `yaml:"gpg_binary,omitempty"` // path to gpg (default "gpg" from PATH)
+}
+
+
+// ENCRYPTION STAGE OF THE STREAM PIPELINE
+type gpgStage struct {
+	config EncryptionConfig
+}
+
+
+
+//////////////  GPG FUNCTIONS  ////////////////////////////////////////////////
+
+// gpgBinary returns the configured gpg executable.
+func (c EncryptionConfig) gpgBinary() string {
+	if c.GPGBinary != "" {
+		return c.GPGBinary
+	}
+	return GPGBinaryDefault
+}
+
+
+// validate checks the encryption settings (recipients are checked against the keyring when the run starts).
+func (c EncryptionConfig) validate() error {
+	for _, recipient := range c.GPGRecipients {
+		if strings.TrimSpace(recipient) == "" {
+			return fmt.Errorf("%q can't contain empty values", "encryption.gpg_recipients")
+		}
+	}
+	return nil
+}
+
+
+// CHECK THAT ALL RECIPIENTS HAVE USABLE PUBLIC KEYS
+func checkGPGRecipients(config EncryptionConfig) error {
+	for _, recipient := range config.GPGRecipients {
+		out, err := exec.Command(config.gpgBinary(), "--batch", "--list-keys", "--with-colons", "--", recipient).CombinedOutput()
+		if err != nil {
+			if len(out) > 0 {
+				return fmt.Errorf("no public key for GPG recipient %q: %s", recipient, strings.TrimSpace(string(out)))
+			}
+			return fmt.Errorf("no public key for GPG recipient %q: %w", recipient, err)
+		}
+	}
+	return nil
+}
+
+
+func (s gpgStage) name() string { return "gpg" }
+
+
+// START GPG WRITING THE ENCRYPTED STREAM TO THE NEXT STAGE
+func (s gpgStage) wrap(next io.Writer) (io.WriteCloser, error) {
+	args := []string{"--batch", "--yes", "--encrypt", "--output", "-"}
+	for _, recipient := range s.config.GPGRecipients {
+		args = append(args, "--recipient", recipient)
+	}
+	return startProcessPipe("gpg", exec.Command(s.config.gpgBinary(), args...), next)
+}
+
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Manifest checksums use 'hash_algorithm'. By default files are hashed on the fly while being copied.
+// With 'hash_workers', copied files are queued to a separate pool of hashing workers instead,
+// so hashing doesn't hold up copying (the queue is bounded, so memory use stays flat).
+// The tar stream can't be read back, so it is always hashed on the fly.
+
+const (
+	HashSHA256          string = "sha256"
+	HashBLAKE2b         string = "blake2b" // BLAKE2b-256, faster than sha256 on CPUs without SHA extensions
+	HashCRC64           string = "crc64"   // non-cryptographic: detects corruption, but not deliberate changes
+	HashQueuePerWorker  int    = 64        // copied files waiting for each hashing worker
+	LimitMaxHashWorkers uint16 = 32
+)
+
+var hashAlgorithms = []string{HashSHA256, HashBLAKE2b, HashCRC64}
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// COPIED FILE WAITING TO BE HASHED
+type hashJob struct {
+	dest    string
+	size    int64
+	modTime time.Time // modification time of the source file
+}
+
+
+// POOL OF HASHING WORKERS
+type hashPool struct {
+	jobs    chan hashJob
+	wg      sync.WaitGroup
+	pending sync.WaitGroup // queued files that are not hashed yet
+}
+
+
+
+//////////////  HASHING FUNCTIONS  ////////////////////////////////////////////
+
+// newHash returns a hash of the algorithm (sha256 for unknown ones, like in manifests without algorithm).
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case HashBLAKE2b:
+		h, _ := blake2b.New256(nil) // fails only for keys over 64 bytes
+		return h
+	case HashCRC64:
+		return crc64.New(crc64.MakeTable(crc64.ECMA))
+	default:
+		return sha256.New()
+	}
+}
+
+
+// START HASHING WORKERS (if configured and backup is written to disk)
+func (app *BackupApp) startHashPool() {
+	workers := int(app.BkpConfig.HashWorkers)
+	if workers == 0 || app.toStdout {
+		return
+	}
+
+	pool := &hashPool{jobs: make(chan hashJob, workers*HashQueuePerWorker)}
+	for w := 0; w < workers; w++ {
+		pool.wg.Add(1)
+		go func() {
+			defer handlePanic()
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				if err := app.hashCopied(job); err != nil {
+					logger.Err(fmt.Sprintf("Failed to hash %s, it's not listed in the manifest: %v\n", job.dest, err))
+				}
+				pool.pending.Done()
+			}
+		}()
+	}
+	app.hashes = pool
+}
+
+
+// hashCopied reads the copied file back and records it in the manifest.
+func (app *BackupApp) hashCopied(job hashJob) error {
+	f, err := os.Open(job.dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := newHash(app.BkpConfig.HashAlgorithm)
+	if _, err := app.copyBuffered(h, f); err != nil {
+		return err
+	}
+	app.recordFile(job.dest, job.size, job.modTime, h.Sum(nil))
+	return nil
+}
+
+
+// queueHashing sends the copied file to hashing workers.
+func (app *BackupApp) queueHashing(job hashJob) {
+	app.hashes.pending.Add(1)
+	app.hashes.jobs <- job
+}
+
+
+// waitHashing waits until files queued so far are hashed, keeping the workers running.
+func (app *BackupApp) waitHashing() {
+	if app.hashes != nil {
+		app.hashes.pending.Wait()
+	}
+}
+
+
+// WAIT UNTIL ALL QUEUED FILES ARE HASHED
+// Must be called before the manifest is written.
+func (app *BackupApp) finishHashing() {
+	if app.hashes == nil {
+		return
+	}
+	close(app.hashes.jobs)
+	app.hashes.wg.Wait()
+	app.hashes = nil
+}
+
+
+// manifestAlgorithm returns the checksum algorithm of the backup manifest (from its column header).
+func manifestAlgorithm(dir string) (string, error) {
+	data, err := readReportData(filepath.Join(dir, ReportDirName, ManifestFileName))
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, isComment := strings.CutPrefix(scanner.Text(), "# ")
+		if !isComment {
+			break
+		}
+		column, _, _ := strings.Cut(line, "\t")
+		if slices.Contains(hashAlgorithms, column) {
+			return column, nil
+		}
+	}
+	return HashSHA256, scanner.Err()
+}
+
+
+// fileChecksum returns the size and hex-encoded checksum of the file.
+func fileChecksum(path, algorithm string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := newHash(algorithm)
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
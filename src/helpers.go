@@ -1,9 +1,9 @@
 package main
 
 import (
-    "bufio"
+    "crypto/rand"
+    "encoding/hex"
     "fmt"
-    "golang.org/x/term"
     "gopkg.in/yaml.v3"
     "os"
     "path/filepath"
@@ -15,6 +15,7 @@ import (
 )
 
 const (
+	KB = 1024
 	MB = 1024 * 1024
 	GB = 1024 * 1024 * 1024
 )
@@ -33,29 +34,316 @@ const defaultConfigTemplateYAML = "" +
 "  # Minimum free space that is required to be available on the destination media (min 10mb)\n" +
 "  # Accepted format: XXmb or XXgb\n" +
 "  min_free_space: 10gb\n" +
+"  # Ask for confirmation before removing more than this number of backups in one pass\n" +
+"  # (e.g. after lowering 'backups_to_keep'). In non-interactive mode such cleanup is skipped.\n" +
+"  # Optional, defaults to 3. Set to 0 to never ask.\n" +
+"  confirm_above: 3\n" +
+"  # Move pruned backups into '.trash' under 'bkp_dest_dir' instead of deleting them right away.\n" +
+"  # Trashed backups are purged after 'trash_grace_period', or earlier if free space drops below 'min_free_space'.\n" +
+"  # Optional, defaults to false.\n" +
+"  use_trash: false\n" +
+"  # How long trashed backups are kept. Accepted format: XXd, XXh or XXm (e.g. 7d, 36h).\n" +
+"  # Optional, defaults to 7d.\n" +
+"  trash_grace_period: 7d\n" +
 "\n" +
 "# Root directory on the destination media, where backups will be stored.\n" +
 "# Each backup will create it's own unique folder under this path.\n" +
 "bkp_dest_dir: SimpleBackups\n" +
 "\n" +
+"# Refresh rate of the progress status line while copying large files (min 100ms).\n" +
+"# Optional, defaults to 500ms.\n" +
+"progress_interval: 500ms\n" +
+"\n" +
+"# Number of files copied concurrently within an item (1-32). Helps with many small files\n" +
+"# and with network sources/destinations. Local files of 64mb and more are copied in 16mb ranges that idle\n" +
+"# workers share, so a few large files don't leave the other workers idle. Optional, defaults to 1.\n" +
+"copy_workers: 1\n" +
+"\n" +
+"# Order files of an item are copied in: 'newest' - most recently modified first, so if the run is\n" +
+"# interrupted (laptop lid closed), the freshest data is the most likely to be safe already;\n" +
+"# 'smallest' - smallest first; 'largest' - largest first, so with several 'copy_workers' the large\n" +
+"# files are started (and shared) early; 'path' - in the order of the source.\n" +
+"# Items copied while they are scanned ('copy_while_scanning') are copied in path order. Optional, defaults to newest.\n" +
+"# copy_order: largest\n" +
+"\n" +
+"# Number of items backed up at the same time, for destinations faster than any single source.\n" +
+"# Items start in the listed order (see item 'after' to wait for other items). Ignored for '-to-stdout'\n" +
+"# and when any item uses 'run_as'. Maximum is 16.\n" +
+"# Optional, defaults to 1 (one item at a time).\n" +
+"# parallel_items: 2\n" +
+"\n" +
+"# Start copying directory items as soon as the first files are found, instead of after counting\n" +
+"# the whole tree (local sources only). Cuts the time to the first copied byte on huge trees; the progress\n" +
+"# percentage is approximate until counting completes, and items with 'max_size' are still counted first.\n" +
+"# Optional, defaults to false.\n" +
+"# copy_while_scanning: true\n" +
+"\n" +
+"# Files up to this size (up to 1mb) are appended to pack files of the backup ('report/packs') instead of\n" +
+"# being created one by one: backups of millions of tiny files get much faster. Packed files keep their\n" +
+"# content, permissions and modification time. There is no restore command, so run 'smbkp unpack' to extract\n" +
+"# them into place before restoring by copying ('export' reads them as they are). The pack index is signed too.\n" +
+"# Optional, disabled by default.\n" +
+"# pack_small_files: 16kb\n" +
+"\n" +
+"# Size of the buffer used to copy each file (64kb-64mb). Larger buffers (e.g. 4mb-8mb)\n" +
+"# speed up copying to/from network file systems. Optional, defaults to 1mb.\n" +
+"copy_buffer_size: 1mb\n" +
+"\n" +
+"# Checksum algorithm of the backup manifest: sha256, blake2b (faster on CPUs without SHA extensions)\n" +
+"# or crc64 (fastest, non-cryptographic: detects corruption, but not deliberate changes). Optional, defaults to sha256.\n" +
+"hash_algorithm: sha256\n" +
+"\n" +
+"# Number of workers hashing copied files for the manifest (0-32). With 0, files are hashed while\n" +
+"# they are copied; otherwise hashing runs separately and doesn't slow down copying (copied files\n" +
+"# are read back, usually from the OS cache; local files are then copied by the OS: copy_file_range\n" +
+"# on Linux, CopyFileEx on Windows, which also keeps attributes). Optional, defaults to 0.\n" +
+"hash_workers: 0\n" +
+"\n" +
+"# Memory use guideline for very large runs (millions of files) on small devices, e.g. 256mb (min 64mb).\n" +
+"# Garbage is collected more eagerly close to the limit, the manifest is written to disk while copying\n" +
+"# (in copy order; the \"changes since\" summary is skipped), and items with huge file lists are reported.\n" +
+"# Optional, not limited by default.\n" +
+"# max_memory: 256mb\n" +
+"\n" +
+"# Preset for single-board computers and tiny NAS devices (e.g. Raspberry Pi): 1 copy worker, 64kb buffer,\n" +
+"# hashing while copying, 'max_memory' of 128mb (unless set), slower progress refresh, and lowered CPU and\n" +
+"# I/O priority of the process. Overrides 'copy_workers', 'copy_buffer_size' and 'hash_workers'.\n" +
+"# Same as '--low-resource' command line option. Optional, defaults to false.\n" +
+"low_resource: false\n" +
+"\n" +
+"# Copy NTFS alternate data streams of files (e.g. 'Zone.Identifier'), Windows only. They are stored natively\n" +
+"# on NTFS/ReFS destinations, and in 'report/ads' of the backup on other file systems (listed in the manifest).\n" +
+"# Optional, defaults to false.\n" +
+"include_ads: false\n" +
+"\n" +
+"# Online-only placeholder files of cloud sync clients (e.g. OneDrive \"Files On-Demand\"), Windows only.\n" +
+"# Accepted values: skip (not copied, listed in the skipped report), hydrate (downloaded and copied),\n" +
+"# record (not copied, listed with sizes in 'report/smbkp-placeholders.tsv'). Optional, defaults to record.\n" +
+"cloud_placeholders: record\n" +
+"\n" +
+"# 'status' command reports the destination as stale if it had no successful backup for this long.\n" +
+"# Accepted format: duration (e.g. 36h, 7d). Optional, defaults to 7d.\n" +
+"stale_after: 7d\n" +
+"\n" +
+"# Check once per week whether a newer release exists, and print a one-line notice with its release notes link.\n" +
+"# Optional, defaults to true.\n" +
+"update_check: true\n" +
+"\n" +
+"# Obfuscate file and directory names in the backup, and encrypt its manifest, metadata and reports,\n" +
+"# so a stolen backup drive doesn't reveal the directory structure (file contents are not encrypted).\n" +
+"# Requires the config key (SMBKP_CONFIG_KEY or keychain, see the 'config' command); without it, commands\n" +
+"# like 'verify', 'find' and 'report' can't read such backups. Can't be combined with 'max_memory' and 'include_ads'.\n" +
+"# Optional, defaults to false.\n" +
+"# obfuscate_names: true\n" +
+"\n" +
+"# Compress '-to-stdout' archives. The tar stream goes through compression, then encryption (see 'encryption' below).\n" +
+"# Accepted values: none, gzip. Directory backups are copied as they are.\n" +
+"# Optional, defaults to none.\n" +
+"# compression: gzip\n" +
+"\n" +
+"# Encrypt '-to-stdout' archives to GPG public keys: the tar stream is piped through 'gpg --encrypt',\n" +
+"# so existing keys (including smartcards) protect the archive. Decrypt with 'gpg -d archive.tar.gpg | tar x' (add 'z' to 'tar x' with 'compression: gzip').\n" +
+"# Recipient keys must be in the keyring and trusted (gpg runs in batch mode). Not supported for directory backups.\n" +
+"# Optional, no encryption by default.\n" +
+"# encryption:\n" +
+"#   gpg_recipients: ['alice@example.com', '0x1234ABCD5678EF90']\n" +
+"#   gpg_binary: 'gpg'     # optional, path to gpg (default - from PATH)\n" +
+"\n" +
+"# Whether copied files and directories are flushed to disk (fsync) before the backup is reported complete.\n" +
+"# Accepted values: fsync, none. Use 'fsync' for removable drives that may be unplugged\n" +
+"# or lose power right after backup. Optional, defaults to none.\n" +
+"durability: none\n" +
+"\n" +
+"# Hard-link files unchanged since the previous complete backup (same path, size and modification time)\n" +
+"# instead of copying them again. Each backup still contains all files, but unchanged ones take space only once.\n" +
+"# The summary shows how much was linked and how much was newly stored. Requires a destination file system\n" +
+"# with hard links (e.g. ext4, NTFS, APFS; not FAT/exFAT, where files are copied as usual).\n" +
+"# Accepted values: none, hardlink. Optional, defaults to none.\n" +
+"dedup: none\n" +
+"\n" +
+"# Make completed backups read-only, as a protection against accidental changes and basic ransomware.\n" +
+"# On Linux the immutable flag ('chattr +i') is also set when running as root.\n" +
+"# Retention lifts the protection before removing old backups. With 'dedup: hardlink' the immutable flag\n" +
+"# is not set, since backups share files, and files shared with newer backups stay read-only when an old one\n" +
+"# is removed. Optional, defaults to false.\n" +
+"read_only: false\n" +
+"\n" +
+"# File with a secret key to sign backup manifests with (HMAC-SHA256), so that 'verify' command\n" +
+"# can detect modified or planted files. Keep the key off the backup drive.\n" +
+"# SMBKP_SIGNING_KEY environment variable takes precedence. Optional, backups are not signed by default.\n" +
+"# signing_key_file: /home/user/.config/smbkp/signing.key\n" +
+"\n" +
+"# Console output settings (the log file is not affected). Optional, defaults are shown below.\n" +
+"# display:\n" +
+"#   theme: default      # 'default', 'basic' (8 colors only) or 'mono' (no colors). NO_COLOR env disables colors too.\n" +
+"#   emoji: true         # 'false' uses text (e.g. [OK], [FAILED]) instead of emoji\n" +
+"#   verbosity: normal   # 'quiet' (warnings and errors only), 'normal', 'verbose' (e.g. skipped files) or 'debug'\n" +
+"#   show_sub: true      # 'false' hides secondary messages, like item file counts\n" +
+"\n" +
+"# How long interactive prompts (e.g. \"Proceed with backup?\") wait for an answer, e.g. 60s or 5m.\n" +
+"# Protects runs started by a scheduler without '-n' from hanging forever. Optional, prompts wait forever by default.\n" +
+"# prompt_timeout: 60s\n" +
+"# Answer applied when a prompt times out (or stdin is not available), and logged: 'cancel' or 'proceed'.\n" +
+"# 'proceed' starts the backup, continues after errors and removes old backups when asked. Optional, defaults to cancel.\n" +
+"# prompt_default: cancel\n" +
+"\n" +
+"# Destination of items that don't specify one: 'leaf' - the source leaf name (e.g. 'Documents'),\n" +
+"# 'mirror' - the whole source path (e.g. 'C/Users/MyUser/Documents' or 'home/MyUser/Documents',\n" +
+"# host name first for remote sources), so items with the same leaf name don't collide.\n" +
+"# Optional, defaults to leaf.\n" +
+"# layout: mirror\n" +
+"\n" +
+"# What happens when a local item source is not found before the run (e.g. external drive is detached):\n" +
+"# 'error' - the run doesn't start, 'warn' - the run starts and these items fail,\n" +
+"# 'skip' - the run starts and these items are skipped (not counted as failures).\n" +
+"# Optional, defaults to warn.\n" +
+"# missing_source: skip\n" +
+"\n" +
+"# How far modification times may differ for a file to count as unchanged ('dedup', 'compare').\n" +
+"# 'auto' - 2s for sources on FAT/exFAT (2-second time steps) and exact for others,\n" +
+"# or a duration (e.g. '2s'). On FAT/exFAT, whole-hour shifts (DST change, another time zone) are ignored too.\n" +
+"# Optional, defaults to auto.\n" +
+"# mtime_tolerance: 2s\n" +
+"\n" +
+"# Files that change while being copied (logs, documents being saved) are copied again after a second,\n" +
+"# up to 'changed_retries' times. A file still changing after that keeps its last copy, which may be inconsistent:\n" +
+"# it's flagged in the summary, the item results and the skipped report. 0 - the first copy is kept (and flagged).\n" +
+"# Optional, defaults to 3.\n" +
+"# changed_retries: 3\n" +
+"\n" +
+"# Laptops: keep backups from draining the battery, and from being cut short by sleep.\n" +
+"# 'min_battery' - on battery charged below this percentage, 'on_low_battery' applies (0 - no limit):\n" +
+"# 'refuse' (default) - the run doesn't start (a started run goes on), 'pause' - the run waits for the charger,\n" +
+"# before it starts and between files while copying (the battery is checked every 30 seconds).\n" +
+"# 'inhibit_sleep' asks the OS not to sleep while the backup runs (systemd-inhibit on Linux,\n" +
+"# SetThreadExecutionState on Windows, caffeinate on macOS). Optional, no limits by default.\n" +
+"# power:\n" +
+"#   min_battery: 30\n" +
+"#   on_low_battery: pause\n" +
+"#   inhibit_sleep: true\n" +
+"\n" +
+"# Metered connections (mobile hotspot, capped plans), for runs that transfer data over the network:\n" +
+"# 'ssh://' sources and UNC destinations. 'on_metered': 'run' - as usual, 'defer' - the run doesn't start\n" +
+"# (the next scheduled run tries again), 'throttle' - transfers are limited to 'metered_rate' per second.\n" +
+"# Detected when the run starts: the \"Metered connection\" setting on Windows, NetworkManager metered hint on Linux.\n" +
+"# 'upload_schedule' limits writes to a UNC destination by time of day (local time): comma-separated windows\n" +
+"# 'HH:MM-HH:MM <rate>' (the first match applies) and 'else <rate>' for the rest of the day, where rate is\n" +
+"# 'full' or a size per second. The rate follows the clock while the run goes on (no schedule by default).\n" +
+"# Files are written to a UNC destination in chunks of 'upload_chunk', each flushed to the server before the next.\n" +
+"# When the connection drops, the upload resumes from the last confirmed chunk (checked against its checksum),\n" +
+"# up to 'upload_retries' times per chunk (0 - a dropped connection fails the file). The chunk is shown in the progress line.\n" +
+"# Optional, defaults are shown below.\n" +
+"# network:\n" +
+"#   on_metered: run\n" +
+"#   metered_rate: 256kb\n" +
+"#   upload_schedule: '22:00-06:00 full, else 1mb'\n" +
+"#   upload_chunk: 8mb\n" +
+"#   upload_retries: 5\n" +
+"\n" +
+"# More drives for backups that don't fit on the destination (e.g. several smaller old drives).\n" +
+"# Items that don't fit go to the next volume in the list, each volume gets a backup directory of its own\n" +
+"# (a part) in the same 'bkp_dest_dir', with its own retention. An item is never split between volumes.\n" +
+"# Sources are measured before the run, like 'estimate'. 'export' stitches the parts into one archive.\n" +
+"# Optional, the destination only by default.\n" +
+"# span_volumes:\n" +
+"#   - '/mnt/old-drive-2'\n" +
+"#   - '/mnt/old-drive-3'\n" +
+"\n" +
+"# Destination drives used in turns, e.g. offsite rotation: each run goes to the least recently used drive\n" +
+"# that is attached, drives that are away are skipped. A drive counts as attached if 'bkp_dest_dir' or this\n" +
+"# config file is in its root (copy the config file to a new drive first). Each drive keeps its own backups,\n" +
+"# 'backups_to_keep' of a drive overrides 'retention.backups_to_keep' on it. 'status' shows which backups\n" +
+"# each drive holds. Optional, no rotation by default.\n" +
+"# rotation:\n" +
+"#   drives:\n" +
+"#     - path: '/mnt/offsite-a'\n" +
+"#       name: 'Offsite A'\n" +
+"#       backups_to_keep: 4\n" +
+"#     - path: '/mnt/offsite-b'\n" +
+"#       name: 'Offsite B'\n" +
+"\n" +
+"# Re-verification of old backups by unattended runs ('--service'), so drive rot is caught early: after the backup,\n" +
+"# at most once per 'interval', the backup verified longest ago (never verified first) is checked like 'verify',\n" +
+"# at low process priority, within 'max_time' and 'max_read' (a backup that doesn't fit is continued by the next run).\n" +
+"# Verification dates are kept in 'smbkp-verified.yaml' in 'bkp_dest_dir'. Optional, off by default.\n" +
+"# background_verify:\n" +
+"#   enabled: true\n" +
+"#   interval: 20h\n" +
+"#   max_time: 1h\n" +
+"#   max_read: 50gb\n" +
+"\n" +
 "# List of the items to be backed up. Each item must specify `source` and `destination`,\n" +
 "# where `source` is the path to a file or folder to be backed up,\n" +
 "# and `destination` is path on the destination media relative to `bkp_dest_dir/bkp_unique_folder`.\n" +
+"# `destination` can contain placeholders, expanded when the run starts: {{year}}, {{month}}, {{day}},\n" +
+"# {{date}} (YYYY-MM-DD), {{time}} (HHMMSS), {{week}} (ISO week), {{weekday}} and {{host}},\n" +
+"# e.g. 'photos/{{year}}/{{date}}' organizes items by date inside the backup.\n" +
 "bkp_items:\n" +
 "  - source: '/home/MyUser/Documents/'\n" +
 "    destination: 'MyUser/files'\n" +
 "    # `Include` is optional. Allows to filter the child items\n" +
 "    # to be included into backup if the `source` is a directory.\n" +
-"    # Supports wildcards '*'. Defaults to all items.\n" +
+"    # Patterns are relative to `source`: '*' and '?' match within one directory level ('*.pdf' - top level only),\n" +
+"    # '**' matches any number of levels ('**/*.pdf' - at any depth). Defaults to all items.\n" +
 "    include:\n" +
 "      - '*.pdf'\n" +
 "      - 'important*'\n" +
 "    # `Exclude` is optional. Allows to filter out the child items\n" +
 "    # that are included from the `source` if it's a directory.\n" +
-"    # Supports wildcards '*'. Takes priority over `include`.\n" +
+"    # Same patterns as `include`, an excluded directory is skipped with everything in it.\n" +
+"    # Takes priority over `include`.\n" +
 "    exclude:\n" +
 "      - 'temp*'\n" +
 "      - '.cache'\n" +
+"    # `include_hidden` is optional. Set to `false` to skip hidden child items\n" +
+"    # (names starting with '.', and items with \"hidden\" attribute on Windows). Defaults to `true`.\n" +
+"    include_hidden: true\n" +
+"    # `skip_system_attrib` is optional, Windows only. Set to `true` to skip child items\n" +
+"    # with \"system\" attribute. Defaults to `false`. Copied items keep their \"hidden\", \"system\"\n" +
+"    # and \"read-only\" attributes (except in `-to-stdout` archives).\n" +
+"    skip_system_attrib: false\n" +
+"    # `max_depth` and `max_files` are optional safeguards against runaway walks\n" +
+"    # (e.g. accidentally included '/'). Child items deeper than `max_depth` levels are not copied,\n" +
+"    # and enumeration stops after `max_files` files. The item is then reported as copied partially.\n" +
+"    # Defaults to 0 (unlimited).\n" +
+"    max_depth: 0\n" +
+"    max_files: 0\n" +
+"    # `follow_symlinks` is optional. Set to `true` to copy the content of symlinked directories\n" +
+"    # instead of recreating the symlinks. Each directory is copied once, symlink cycles are skipped\n" +
+"    # with a warning. Not supported for remote sources. Defaults to `false`.\n" +
+"    follow_symlinks: false\n" +
+"    # `skip_nodump` and `exclude_if_present` are optional, they let users and applications opt data out\n" +
+"    # of backups. With `skip_nodump: true`, child items with the \"nodump\" flag are skipped\n" +
+"    # (`chattr +d` on Linux, `chflags nodump` on macOS/BSD; local sources only). Directories containing\n" +
+"    # any of the `exclude_if_present` files are skipped entirely. Default to `false` and none.\n" +
+"    skip_nodump: false\n" +
+"    exclude_if_present:\n" +
+"      - '.nobackup'\n" +
+"    # `snapshot` is optional. Set to `true` to copy the item from a read-only snapshot of its volume,\n" +
+"    # taken right before the copy, so files changing during the backup are captured at one point in time.\n" +
+"    # Supported for btrfs on Linux and APFS on macOS (requires root); the live source is copied otherwise,\n" +
+"    # with a warning. The snapshot is removed after the item. Defaults to `false`.\n" +
+"    snapshot: false\n" +
+"    # `sqlite_backup` is optional. Set to `true` to copy SQLite databases of the item with the SQLite\n" +
+"    # backup API (requires the `sqlite3` tool), which gives a consistent copy even while applications\n" +
+"    # (e.g. browsers) write to them. Their journal files are not copied. Defaults to `false`.\n" +
+"    # Known always-open databases (browser profiles, Outlook data files) are reported with guidance.\n" +
+"    sqlite_backup: false\n" +
+"    # `max_size` is optional. Size limit of the item after patterns are applied (e.g. '50gb'), so one runaway\n" +
+"    # folder (e.g. Downloads) doesn't fill the destination. Over the limit, `max_size_action` applies:\n" +
+"    # `abort` - the item is not copied and counts as failed (default), `truncate` - files are copied\n" +
+"    # until the limit and the rest is listed as skipped, `prompt` - ask whether to copy the item partially\n" +
+"    # (non-interactive runs abort).\n" +
+"    # max_size: 50gb\n" +
+"    # max_size_action: abort\n" +
+"    # `after` is optional. Destinations of items listed before this one that must finish before it starts\n" +
+"    # (e.g. a database dump that has to be complete before its directory is copied), with 'parallel_items'.\n" +
+"    # after: ['db-dump']\n" +
+"    # `run_as` is optional, Unix only. Name (or ID) of the user to read the item as, when the backup\n" +
+"    # runs as root (e.g. a user's home on a multi-user server): the user's permissions apply, and copies\n" +
+"    # are owned by the user. Files the user can't read are skipped as inaccessible. Not supported for\n" +
+"    # remote sources and streams.\n" +
+"    # run_as: alice\n" +
 "\n" +
 "# Example of Backup Items config for Windows:\n" +
 "# bkp_items:\n" +
@@ -66,7 +354,8 @@ const defaultConfigTemplateYAML = "" +
 "#       - '.*'\n" +
 "#     exclude:\n" +
 "#       - 'Documents\\My *'\n" +
-"#       - .virtualenvs\n"
+"#       - .virtualenvs\n" +
+"#     skip_system_attrib: true\n"
 
 // getYAMLKeysRecursively inspects a Go type and returns a nested map
 // representing the YAML keys and subkeys, with empty placeholders for values.
@@ -164,6 +453,63 @@ func formatDurationSeconds(d time.Duration) string {
 }
 
 
+// readPatternFile reads include/exclude patterns, one per line. Empty lines and lines starting with '#' are ignored.
+func readPatternFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+
+// parseDuration parses a duration like time.ParseDuration, and also accepts whole days (e.g. "7d").
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if days, found := strings.CutSuffix(value, "d"); found {
+		num, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days: %w", err)
+		}
+		return time.Duration(num) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+
+// newRunID returns a random identifier of the run, used to correlate its log lines, metadata and summary.
+func newRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+
+// formatAge formats the age of a backup in days and hours (e.g. 3d 4h, 5h, <1h).
+func formatAge(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	switch {
+	case d < time.Hour:
+		return "<1h"
+	case days == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+}
+
+
 // formatBytes converts a size in bytes to a human-readable string in MB or GB.
 func formatBytes(bytes uint64) string {
 	if bytes < GB {
@@ -185,6 +531,9 @@ func parseDiskSize(sizeStr string) (uint64, error) {
 	var valueStr string
 
 	switch {
+	case strings.HasSuffix(sizeStr, "kb"):
+		multiplier = 1024
+		valueStr = strings.TrimSuffix(sizeStr, "kb")
 	case strings.HasSuffix(sizeStr, "mb"):
 		multiplier = 1024 * 1024
 		valueStr = strings.TrimSuffix(sizeStr, "mb")
@@ -192,7 +541,7 @@ func parseDiskSize(sizeStr string) (uint64, error) {
 		multiplier = 1024 * 1024 * 1024
 		valueStr = strings.TrimSuffix(sizeStr, "gb")
 	default:
-		return 0, fmt.Errorf("invalid format: must end with 'mb' or 'gb'")
+		return 0, fmt.Errorf("invalid format: must end with 'kb', 'mb' or 'gb'")
 	}
 
 	num, err := strconv.ParseInt(strings.TrimSpace(valueStr), 10, 64)
@@ -248,22 +597,17 @@ func isWindowsProtectedPath(path string, err error) bool {
 		return true
 	}
 
-	// As a fallback, treat generic permission-denied errors on Windows as skippable
-	// for counting/copying purposes when walking.
-	if os.IsPermission(err) {
-		return true
-	}
-
+	// Other permission-denied errors are counted as inaccessible by the caller
 	return false
 }
 
 
 // EXIT APP WITH OPTIONAL INTERACTIVE PAUSE
 func exitApp(nonInteractive bool, code int) {
+	logger.Flush()
 	if !nonInteractive {
-		logger.Plain("Press Enter to exit...")
-		reader := bufio.NewReader(os.Stdin)
-		_, _ = reader.ReadString('\n')
+		logger.Plain(tr(msgPressEnter))
+		readLine(exitPauseTimeout)
 	}
 	os.Exit(code)
 }
@@ -293,16 +637,27 @@ func generateExampleConfig(destPath string) (string, error) {
 
 
 func getTerminalWidth() int {
-    // Get the file descriptor for terminal output
-    fd := int(os.Stdout.Fd())
-
-    // Get width and height
-    width, _, err := term.GetSize(fd)
+    // Console output may be redirected to stderr (e.g. '-to-stdout'), so ask the logger
+    width, ok := logger.Terminal()
 
-    if err != nil {
+    if !ok {
         // Handle case where output is redirected to a file/pipe
         return 70 // return a hard limit of 70 chars
     }
 
     return width
 }
+
+
+// ellipsize shortens the text to the given number of runes, replacing its beginning with "..."
+// (the end of a path is usually the most informative part).
+func ellipsize(text string, max int) string {
+    runes := []rune(text)
+    if len(runes) <= max {
+        return text
+    }
+    if max <= 3 {
+        return string(runes[len(runes)-max:])
+    }
+    return "..." + string(runes[len(runes)-max+3:])
+}
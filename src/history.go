@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"time"
+)
+
+// 'history' lists backups in the backup root with their duration and size.
+// With '--stats' it shows growth trends over complete backups instead: size of each backup over time,
+// growth of each item, average duration and throughput, and how long free space on the destination
+// lasts at the current growth rate, so a drive replacement can be planned before it's an emergency.
+// Sizes come from the manifests (like in 'report'), so they are sizes of the backed up data,
+// not space taken on disk (hard-linked files are counted in every backup).
+
+const HistoryDaysPerMonth float64 = 30.44
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// BACKUP IN HISTORY
+type historyEntry struct {
+	backupDir
+	duration time.Duration    // zero if the run didn't finish
+	files    int
+	size     int64
+	items    map[string]int64 // size by item destination
+	failed   int              // items that failed
+}
+
+
+// GROWTH OF ONE ITEM BETWEEN THE FIRST AND THE LATEST BACKUP
+type itemGrowth struct {
+	item          string
+	first, latest int64
+}
+
+
+
+//////////////  HISTORY COMMAND  //////////////////////////////////////////////
+
+// RUN 'HISTORY' COMMAND
+func runHistoryCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		stats      = flags.Bool("stats", false, "Show growth trends: size per backup over time, per-item growth, average duration and throughput.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backups, err := listBackups(app.bkpDestFullPath)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to list backups: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if len(backups) == 0 {
+		logger.Info(fmt.Sprintf("No backups found in %s.\n", app.bkpDestFullPath))
+		return 0
+	}
+
+	history := loadHistory(backups)
+	if *stats {
+		app.printHistoryStats(history)
+	} else {
+		printHistory(history)
+	}
+	return 0
+}
+
+
+// LOAD HISTORY OF BACKUPS (oldest first)
+// Backups whose size can't be read are listed without it.
+func loadHistory(backups []backupDir) []historyEntry {
+	history := make([]historyEntry, 0, len(backups))
+	for i := len(backups) - 1; i >= 0; i-- {
+		entry := historyEntry{backupDir: backups[i], items: make(map[string]int64)}
+
+		var destinations []string
+		if meta, err := readMetadata(entry.path); err == nil {
+			if meta.Finished != nil {
+				entry.duration = meta.Finished.Sub(meta.Started)
+			}
+			for _, item := range meta.Items {
+				destinations = append(destinations, path.Clean(filepath.ToSlash(item.Destination)))
+				if !item.Success {
+					entry.failed++
+				}
+			}
+		}
+
+		files, err := backupFiles(entry.backupDir)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Size of %s is not known: %v\n", entry.name, err))
+		}
+		for _, file := range files {
+			entry.files++
+			entry.size += file.size
+			entry.items[itemOfPath(file.path, destinations)] += file.size
+		}
+		history = append(history, entry)
+	}
+	return history
+}
+
+
+// PRINT BACKUPS (newest first)
+func printHistory(history []historyEntry) {
+	logger.Signature(fmt.Sprintf("\n====  Backup history: %d backups  ===\n", len(history)))
+	table := style.NewTable("Backup", "State", "Created", "Duration", "Files", "Size", "Failed items").AlignRight(3, 4, 5, 6)
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		duration := "-"
+		if entry.duration > 0 {
+			duration = formatDurationSeconds(entry.duration)
+		}
+		table.Row(entry.name, entry.state, entry.created.Local().Format("2006-01-02 15:04"), duration,
+			fmt.Sprint(entry.files), formatBytes(uint64(entry.size)), fmt.Sprint(entry.failed))
+	}
+	logger.Plain(table.Render())
+	logger.Plain("\n")
+}
+
+
+// PRINT GROWTH TRENDS OVER COMPLETE BACKUPS
+func (app *BackupApp) printHistoryStats(history []historyEntry) {
+	var complete []historyEntry
+	for _, entry := range history {
+		if entry.state == BackupComplete {
+			complete = append(complete, entry)
+		}
+	}
+	logger.Signature(fmt.Sprintf("\n====  Backup trends: %d complete backups  ===\n", len(complete)))
+	if len(complete) == 0 {
+		logger.Info("No complete backups to show trends for.\n")
+		return
+	}
+
+	// Size per backup over time
+	logger.Plain("\nSize per backup:\n", style.Bold())
+	table := style.NewTable("Created", "Backup", "Files", "Size", "Change").AlignRight(2, 3, 4)
+	for i, entry := range complete {
+		change := "-"
+		if i > 0 {
+			change = formatBytesChange(entry.size - complete[i-1].size)
+		}
+		table.Row(entry.created.Local().Format("2006-01-02 15:04"), entry.name, fmt.Sprint(entry.files), formatBytes(uint64(entry.size)), change)
+	}
+	logger.Plain(table.Render())
+
+	first, latest := complete[0], complete[len(complete)-1]
+	months := latest.created.Sub(first.created).Hours() / 24 / HistoryDaysPerMonth
+
+	// Growth per item (items of the latest backup, and items that were dropped since the first one)
+	growth := make(map[string]*itemGrowth)
+	for _, entry := range []historyEntry{first, latest} {
+		for item := range entry.items {
+			growth[item] = &itemGrowth{item: item, first: first.items[item], latest: latest.items[item]}
+		}
+	}
+	items := make([]*itemGrowth, 0, len(growth))
+	for _, item := range growth {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if d1, d2 := items[i].latest-items[i].first, items[j].latest-items[j].first; d1 != d2 {
+			return d1 > d2
+		}
+		return items[i].item < items[j].item
+	})
+	logger.Plain(fmt.Sprintf("\nGrowth per item (%s to %s):\n", first.created.Local().Format("2006-01-02"), latest.created.Local().Format("2006-01-02")), style.Bold())
+	table = style.NewTable("First", "Latest", "Change", "Per month", "Item").AlignRight(0, 1, 2, 3)
+	for _, item := range items {
+		table.Row(formatBytes(uint64(item.first)), formatBytes(uint64(item.latest)), formatBytesChange(item.latest-item.first),
+			monthlyChange(item.latest-item.first, months), item.item)
+	}
+	logger.Plain(table.Render())
+
+	// Duration and throughput (of runs that finished)
+	var duration time.Duration
+	var size int64
+	var runs int
+	for _, entry := range complete {
+		if entry.duration > 0 {
+			duration += entry.duration
+			size += entry.size
+			runs++
+		}
+	}
+	logger.Plain("\nTrends:\n", style.Bold())
+	if runs > 0 {
+		logger.Plain(fmt.Sprintf("Average duration: %s\n", formatDurationSeconds(duration/time.Duration(runs))))
+		logger.Plain(fmt.Sprintf("Average throughput: %s/s\n", formatBytes(uint64(float64(size)/duration.Seconds()))))
+	}
+	logger.Plain(fmt.Sprintf("Backup size: %s -> %s (%s per month)\n", formatBytes(uint64(first.size)), formatBytes(uint64(latest.size)), monthlyChange(latest.size-first.size, months)))
+
+	// Free space forecast (each kept backup grows, so the growth is multiplied by 'backups_to_keep')
+	freeSpace, _, err := getFreeSpace(app.bkpDest)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to get free space of %q: %v\n", app.bkpDest, err))
+		return
+	}
+	logger.Plain(fmt.Sprintf("Free space on destination: %s\n", formatBytes(freeSpace)))
+	if months < 1.0/HistoryDaysPerMonth || latest.size <= first.size {
+		logger.Plain("\n")
+		return
+	}
+	perMonth := float64(latest.size-first.size) / months * float64(app.BkpConfig.Retention.BackupsToKeep)
+	usable := float64(freeSpace) - float64(app.BkpConfig.Retention.minFreeSpaceParsed)
+	if usable <= 0 {
+		logger.Warn(fmt.Sprintf("Free space is already below %q (%s).\n", "min_free_space", app.BkpConfig.Retention.MinFreeSpace))
+		return
+	}
+	left := usable / perMonth
+	msg := fmt.Sprintf("At this rate, free space above %q lasts about %.1f months (%d kept backups).\n", "min_free_space", left, app.BkpConfig.Retention.BackupsToKeep)
+	if left < 3 {
+		logger.Warn(msg)
+	} else {
+		logger.Plain(msg)
+	}
+	logger.Plain("\n")
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// formatBytesChange formats a size difference with its sign (e.g. "+120mb", "-1,2gb").
+func formatBytesChange(delta int64) string {
+	if delta < 0 {
+		return "-" + formatBytes(uint64(-delta))
+	}
+	return "+" + formatBytes(uint64(delta))
+}
+
+
+// monthlyChange formats the size difference per month, or "-" if the period is too short to tell.
+func monthlyChange(delta int64, months float64) string {
+	if months < 1.0/HistoryDaysPerMonth {
+		return "-"
+	}
+	return formatBytesChange(int64(float64(delta) / months))
+}
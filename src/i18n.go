@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Messages of the interactive flow (prompts, answers and warnings around them) are localized,
+// so that people who don't read English can run backups by themselves.
+// Language is taken from '--lang', or detected from LC_ALL/LC_MESSAGES/LANG and the OS settings.
+// English is the fallback for unknown languages and for messages without translation.
+// Configuration, log details and errors stay in English, so they can be searched for and reported.
+
+const LanguageDefault string = "en"
+
+// Message keys
+type message string
+
+const (
+	msgProceedPrompt       message = "proceed_prompt"
+	msgBackupCancelled     message = "backup_cancelled"
+	msgExitOnErrorPrompt   message = "exit_on_error_prompt"
+	msgItemsFailed         message = "items_failed"
+	msgCleanupPrompt       message = "cleanup_prompt"
+	msgCleanupSkipped      message = "cleanup_skipped"
+	msgCleanupSkippedNI    message = "cleanup_skipped_non_interactive"
+	msgRemoveBackupsPrompt message = "remove_backups_prompt"
+	msgPressEnter          message = "press_enter"
+	msgBackupCompleted     message = "backup_completed"
+	msgBackupFailed        message = "backup_failed"
+	msgPromptTimedOut      message = "prompt_timed_out"
+	msgPromptNoInput       message = "prompt_no_input"
+	msgMaxSizePrompt       message = "max_size_prompt"
+	msgAnswerYes           message = "answer_yes"
+	msgAnswerNo            message = "answer_no"
+	msgSetupWelcome        message = "setup_welcome"
+	msgSetupDrives         message = "setup_drives"
+	msgSetupNoDrives       message = "setup_no_drives"
+	msgSetupDrivePrompt    message = "setup_drive_prompt"
+	msgSetupFolders        message = "setup_folders"
+	msgSetupFoldersPrompt  message = "setup_folders_prompt"
+	msgSetupFolderAdded    message = "setup_folder_added"
+	msgSetupNoFolders      message = "setup_no_folders"
+	msgSetupKeepPrompt     message = "setup_keep_prompt"
+	msgSetupInvalid        message = "setup_invalid"
+	msgSetupWritten        message = "setup_written"
+	msgSetupCancelled      message = "setup_cancelled"
+)
+
+// Message catalogs by language
+var catalogs = map[string]map[message]string{
+	"en": {
+		msgProceedPrompt:       "Proceed with backup? (only \"yes\" will be accepted to confirm)\n",
+		msgBackupCancelled:     "Backup cancelled by user.\n\n",
+		msgExitOnErrorPrompt:   "\"exitOnError\" is set to True. Exit now? (type \"no\" to continue execution)\n",
+		msgItemsFailed:         "Backup failed for some items.\n",
+		msgCleanupPrompt:       "Cleanup old backups now? (only \"yes\" will be accepted to confirm)\n",
+		msgCleanupSkipped:      "Skipping cleanup of old backups.\n",
+		msgCleanupSkippedNI:    "Backup failed for some items; skipping cleanup of old backups in non-interactive mode.\n",
+		msgRemoveBackupsPrompt: "Remove %d backups? (only \"yes\" will be accepted to confirm)\n",
+		msgPressEnter:          "Press Enter to exit...",
+		msgBackupCompleted:     "BACKUP COMPLETED SUCCESSFULLY!\n\n",
+		msgBackupFailed:        "BACKUP FAILED!\n\n",
+		msgPromptTimedOut:      "No answer in %s, applying the default answer: %s.\n",
+		msgPromptNoInput:       "No input available, applying the default answer: %s.\n",
+		msgMaxSizePrompt:       "Copy the item partially, up to %s? (only \"yes\" will be accepted to confirm, otherwise the item is not copied)\n",
+		msgAnswerYes:           "yes",
+		msgAnswerNo:            "no",
+		msgSetupWelcome:        "\nNo backup configuration is found. Let's set up a backup (press Ctrl+C to stop at any time).\n",
+		msgSetupDrives:         "\nWhere should backups be stored? Found drives:\n",
+		msgSetupNoDrives:       "\nWhere should backups be stored? No external drives are found.\n",
+		msgSetupDrivePrompt:    "Type the number of the drive, or the path to a folder on it:\n",
+		msgSetupFolders:        "\nWhich folders should be backed up? Suggested:\n",
+		msgSetupFoldersPrompt:  "Type the numbers of the folders (e.g. \"1 3\"), or the path to another folder. Empty line when done:\n",
+		msgSetupFolderAdded:    "Added: %s\n",
+		msgSetupNoFolders:      "Add at least one folder.\n",
+		msgSetupKeepPrompt:     "\nHow many backups to keep? Older ones are removed when there are more. (Enter for %d)\n",
+		msgSetupInvalid:        "%q is not one of the choices, try again.\n",
+		msgSetupWritten:        "\nConfiguration is saved to %s. Run the app again any time to make a new backup.\nThe first backup is reviewed below.\n",
+		msgSetupCancelled:      "Setup cancelled, nothing is saved.\n",
+	},
+	"ru": {
+		msgProceedPrompt:       "Начать резервное копирование? (для подтверждения введите \"да\" или \"yes\")\n",
+		msgBackupCancelled:     "Резервное копирование отменено пользователем.\n\n",
+		msgExitOnErrorPrompt:   "Включён режим \"exitOnError\". Завершить работу? (введите \"нет\" или \"no\", чтобы продолжить)\n",
+		msgItemsFailed:         "Некоторые элементы не удалось скопировать.\n",
+		msgCleanupPrompt:       "Удалить старые резервные копии сейчас? (для подтверждения введите \"да\" или \"yes\")\n",
+		msgCleanupSkipped:      "Удаление старых резервных копий пропущено.\n",
+		msgCleanupSkippedNI:    "Некоторые элементы не удалось скопировать; удаление старых резервных копий в неинтерактивном режиме пропущено.\n",
+		msgRemoveBackupsPrompt: "Удалить резервные копии (%d шт.)? (для подтверждения введите \"да\" или \"yes\")\n",
+		msgPressEnter:          "Нажмите Enter для выхода...",
+		msgBackupCompleted:     "РЕЗЕРВНОЕ КОПИРОВАНИЕ УСПЕШНО ЗАВЕРШЕНО!\n\n",
+		msgBackupFailed:        "РЕЗЕРВНОЕ КОПИРОВАНИЕ НЕ УДАЛОСЬ!\n\n",
+		msgPromptTimedOut:      "Нет ответа за %s, применяется ответ по умолчанию: %s.\n",
+		msgPromptNoInput:       "Ввод недоступен, применяется ответ по умолчанию: %s.\n",
+		msgMaxSizePrompt:       "Скопировать элемент частично, до %s? (для подтверждения введите \"да\" или \"yes\", иначе элемент не копируется)\n",
+		msgAnswerYes:           "да",
+		msgAnswerNo:            "нет",
+		msgSetupWelcome:        "\nНастройки резервного копирования не найдены. Давайте их создадим (Ctrl+C прерывает настройку в любой момент).\n",
+		msgSetupDrives:         "\nГде хранить резервные копии? Найденные диски:\n",
+		msgSetupNoDrives:       "\nГде хранить резервные копии? Внешние диски не найдены.\n",
+		msgSetupDrivePrompt:    "Введите номер диска или путь к папке на нём:\n",
+		msgSetupFolders:        "\nКакие папки копировать? Предлагаемые:\n",
+		msgSetupFoldersPrompt:  "Введите номера папок (например, \"1 3\") или путь к другой папке. Пустая строка - завершить:\n",
+		msgSetupFolderAdded:    "Добавлено: %s\n",
+		msgSetupNoFolders:      "Добавьте хотя бы одну папку.\n",
+		msgSetupKeepPrompt:     "\nСколько резервных копий хранить? Более старые удаляются. (Enter - %d)\n",
+		msgSetupInvalid:        "%q не подходит, попробуйте ещё раз.\n",
+		msgSetupWritten:        "\nНастройки сохранены в %s. Для новой резервной копии просто запустите программу снова.\nНиже - обзор первой резервной копии.\n",
+		msgSetupCancelled:      "Настройка отменена, ничего не сохранено.\n",
+	},
+}
+
+// Current language of the messages
+var language = LanguageDefault
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// tr returns the message in the current language, formatted with the arguments (if any).
+func tr(key message, args ...any) string {
+	text, ok := catalogs[language][key]
+	if !ok {
+		text = catalogs[LanguageDefault][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+
+// isAnswer reports whether the user response is the answer (e.g. msgAnswerYes),
+// in English or in the current language.
+func isAnswer(response string, answer message) bool {
+	response = strings.TrimSpace(strings.ToLower(response))
+	return response == catalogs[LanguageDefault][answer] || response == strings.ToLower(tr(answer))
+}
+
+
+// languages returns the supported language codes, sorted.
+func languages() []string {
+	codes := make([]string, 0, len(catalogs))
+	for code := range catalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+
+// SET MESSAGE LANGUAGE
+// Empty 'lang' means detection from environment and OS settings, falling back to English.
+func setLanguage(lang string) error {
+	if lang == "" {
+		language = detectLanguage()
+		return nil
+	}
+	code := languageCode(lang)
+	if _, ok := catalogs[code]; !ok {
+		return fmt.Errorf("language %q is not supported. Expected one of: %s", lang, strings.Join(languages(), ", "))
+	}
+	language = code
+	return nil
+}
+
+
+// detectLanguage returns the supported language of the user environment, or the default one.
+func detectLanguage() string {
+	candidates := []string{os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG"), systemLanguage()}
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, ok := catalogs[languageCode(candidate)]; ok {
+			return languageCode(candidate)
+		}
+		return LanguageDefault // The first set variable wins, like in gettext
+	}
+	return LanguageDefault
+}
+
+
+// languageCode extracts the language from locale name, e.g. "ru" from "ru_RU.UTF-8" or "ru-RU".
+func languageCode(locale string) string {
+	code, _, _ := strings.Cut(strings.ToLower(locale), ".")
+	code, _, _ = strings.Cut(code, "_")
+	code, _, _ = strings.Cut(code, "-")
+	return code
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 'import' adopts a copy made by other means (drag and drop, rsync, robocopy) that is already on the backup
+// destination drive as a complete backup, so switching to smbkp doesn't copy terabytes that are already there.
+// The copy must be laid out like a backup: a directory per item destination (e.g. 'documents/...'),
+// or, with a single item in the config, just the content of that item.
+// Files are hashed into a manifest, then the directory is moved (renamed, same drive only) into 'bkp_dest_dir'
+// as a new backup with metadata, report and completion marker, as if a run had copied it.
+// Modification times of the copy are recorded as source ones, so with 'dedup: hardlink' the next run links
+// unchanged files instead of copying them (copies that didn't keep modification times are copied again).
+// Files outside of item destinations are rejected rather than moved into the backup.
+// Not supported with 'obfuscate_names' (names of the copy are real).
+
+const ImportExtraPathsShown int = 10 // paths outside of item destinations listed in the error
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// ITEM FOUND IN THE COPY
+type importedItem struct {
+	item  BackupItem
+	files int
+	bytes int64
+}
+
+
+
+//////////////  IMPORT COMMAND  ///////////////////////////////////////////////
+
+// RUN 'IMPORT' COMMAND
+func runImportCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	if flags.NArg() != 1 {
+		logger.Fatal("Directory to import is required.\n\n", style.Bold())
+		return 1
+	}
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if app.BkpConfig.ObfuscateNames {
+		logger.Fatal(fmt.Sprintf("Import is not supported with %q: names of the copy would be real.\n\n", "obfuscate_names"), style.Bold())
+		return 1
+	}
+
+	name, err := app.importCopy(flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Import failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	logger.Ok(fmt.Sprintf("\nCopy is imported as backup %s.\n\n", name), style.Bold())
+	return 0
+}
+
+
+// IMPORT COPY AS A NEW BACKUP
+// Returns the name of the backup.
+func (app *BackupApp) importCopy(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%q is not a directory", dir)
+	}
+	backupRoot := app.bkpDestFullPath
+	if rel, err := filepath.Rel(backupRoot, dir); err == nil && strings.HasPrefix(filepath.ToSlash(rel)+"/", Prefix+"-") {
+		return "", fmt.Errorf("%q is a backup already", dir)
+	}
+
+	// Copy of a single item may hold just its content
+	items, single, err := app.importLayout(dir)
+	if err != nil {
+		return "", err
+	}
+	logger.Signature(fmt.Sprintf("\n====  Importing: %s  ===\n", dir))
+	for _, imported := range items {
+		logger.Plain(fmt.Sprintf("  %s -> %s\n", itemSourceLabel(imported.item), imported.item.Destination))
+	}
+
+	// Everything is hashed before the copy is moved, so a failure leaves it where it was
+	app.startTime = time.Now()
+	entries, err := app.importManifest(dir, items, single)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s", Prefix, app.startTime.UTC().Format(BackupTimestampFormat))
+	if err := os.MkdirAll(backupRoot, 0755); err != nil {
+		return "", err
+	}
+	target := filepath.Join(backupRoot, name)
+	if single {
+		if target, err = createBackupDir(backupRoot, name); err != nil {
+			return "", fmt.Errorf("creating backup directory: %w", err)
+		}
+		dest := filepath.Join(target, filepath.FromSlash(items[0].item.Destination))
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+			err = moveCopy(dir, dest)
+		}
+		if err != nil {
+			os.RemoveAll(target) // nothing was moved into it
+			return "", err
+		}
+	} else {
+		if _, err := os.Lstat(target); err == nil {
+			return "", fmt.Errorf("backup %s already exists", name)
+		}
+		if err := moveCopy(dir, target); err != nil {
+			return "", err
+		}
+	}
+
+	app.bkpDestFullPath = target
+	app.manifest = entries
+	meta := app.newMetadata()
+	var results []BackupResult
+	var summary []summaryLine
+	for _, imported := range items {
+		results = append(results, BackupResult{Item: imported.item, Success: true, Files: imported.files, Bytes: imported.bytes})
+		summary = append(summary, summaryLine{print: logger.Plain, msg: fmt.Sprintf("Imported %d files (%s): %s\n", imported.files, formatBytes(uint64(imported.bytes)), imported.item.Destination)})
+	}
+	meta.finish(results, true)
+	if err := app.finalizeBackup(meta, summary); err != nil {
+		return "", fmt.Errorf("copy is moved to %q, but not finalized: %w", target, err)
+	}
+	if err := refreshCatalog(backupRoot); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to update catalog: %v\n", err))
+	}
+	return filepath.Base(target), nil
+}
+
+
+// importLayout matches the copy with item destinations. Returns the items found in it,
+// and true if the copy is the content of the only item.
+func (app *BackupApp) importLayout(dir string) ([]importedItem, bool, error) {
+	var items []importedItem
+	for _, item := range app.BkpConfig.BkpItems {
+		if isStreamItem(item) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(item.Destination))); err == nil {
+			items = append(items, importedItem{item: item})
+		}
+	}
+	if len(items) > 0 {
+		return items, false, nil
+	}
+	if len(app.BkpConfig.BkpItems) == 1 && !isStreamItem(app.BkpConfig.BkpItems[0]) {
+		return []importedItem{{item: app.BkpConfig.BkpItems[0]}}, true, nil
+	}
+
+	var destinations []string
+	for _, item := range app.BkpConfig.BkpItems {
+		destinations = append(destinations, item.Destination)
+	}
+	return nil, false, fmt.Errorf("none of the item destinations is found in %q. Expected some of: %s", dir, strings.Join(destinations, ", "))
+}
+
+
+// importManifest hashes the files of the copy into manifest entries (paths as in the backup).
+// Files outside of item destinations are an error.
+func (app *BackupApp) importManifest(dir string, items []importedItem, single bool) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	var extra []string
+	spin := newSpinner("Hashing")
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if single {
+			rel = path.Join(items[0].item.Destination, rel)
+		}
+
+		i := importItemIndex(items, rel)
+		if i < 0 {
+			extra = append(extra, rel)
+			return nil
+		}
+		spin.update(rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, sum, err := fileChecksum(p, app.BkpConfig.HashAlgorithm)
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", rel, err)
+		}
+		entries = append(entries, manifestEntry{sum: sum, size: size, modTime: info.ModTime().UTC().Truncate(time.Second), path: rel})
+		items[i].files++
+		items[i].bytes += size
+		return nil
+	})
+	spin.clear()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extra) > 0 {
+		shown := extra[:min(len(extra), ImportExtraPathsShown)]
+		return nil, fmt.Errorf("%d files are outside of item destinations, move them out of the copy first: %s", len(extra), strings.Join(shown, ", "))
+	}
+	return entries, nil
+}
+
+
+// importItemIndex returns the index of the item whose destination holds the path, or -1.
+func importItemIndex(items []importedItem, rel string) int {
+	for i, imported := range items {
+		dest := path.Clean(imported.item.Destination)
+		if rel == dest || strings.HasPrefix(rel, dest+"/") {
+			return i
+		}
+	}
+	return -1
+}
+
+
+// moveCopy renames the copy into the backup. Copies on another drive are not moved (that would copy them).
+func moveCopy(from, to string) error {
+	err := os.Rename(from, to)
+	if errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("%q is not on the backup destination drive, copy it with a backup run instead", from)
+	}
+	return err
+}
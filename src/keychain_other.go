@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// configKeychainSecret reads the config key from macOS Keychain
+// ('security add-generic-password -s simple-backup-config -a smbkp -w'),
+// or from Secret Service on Linux ('secret-tool store --label=simple-backup service simple-backup-config').
+func configKeychainSecret() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", ConfigKeychainTarget, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", ConfigKeychainTarget)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
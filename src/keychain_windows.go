@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+// configKeychainSecret reads the config key from the generic credential in Credential Manager
+// ('cmdkey /generic:simple-backup-config /user:smbkp /pass').
+func configKeychainSecret() (string, error) {
+	_, password, err := readStoredCredential(ConfigKeychainTarget)
+	return password, err
+}
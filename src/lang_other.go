@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// systemLanguage returns empty string: locale environment variables are the OS settings here.
+func systemLanguage() string {
+	return ""
+}
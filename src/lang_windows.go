@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// systemLanguage returns the preferred UI language of the user (e.g. "ru-RU"), or empty string.
+func systemLanguage() string {
+	langs, err := windows.GetUserPreferredUILanguages(windows.MUI_LANGUAGE_NAME)
+	if err != nil || len(langs) == 0 {
+		return ""
+	}
+	return langs[0]
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// linkCount returns the number of hard links to the file (1 if it's not known).
+// Read from the file handle, since directory listings don't report it on Windows.
+func linkCount(path string, info os.FileInfo) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	var data windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(f.Fd()), &data); err != nil {
+		return 1
+	}
+	return uint64(data.NumberOfLinks)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Low-resource mode tunes the app for single-board computers and tiny NAS devices:
+// one copy worker, small buffers, hashing while copying, a memory limit, slower progress refresh,
+// and lowered CPU and I/O priority of the process. Selected by '--low-resource' or 'low_resource: true'.
+
+const (
+	LowResourceCopyBufferSize   string        = "64kb"
+	LowResourceMaxMemory        string        = "128mb"
+	LowResourceProgressInterval time.Duration = 2 * time.Second
+)
+
+
+
+//////////////  LOW-RESOURCE MODE  ////////////////////////////////////////////
+
+// APPLY LOW-RESOURCE PRESET
+// Overrides the configured workers and buffers; 'max_memory' is only set if not configured.
+func (app *BackupApp) applyLowResource(enabled bool) {
+	if !enabled && !app.BkpConfig.LowResource {
+		return
+	}
+	c := &app.BkpConfig
+	c.LowResource = true
+
+	c.CopyWorkers = 1
+	c.HashWorkers = 0
+	c.CopyBufferSize = LowResourceCopyBufferSize
+	c.copyBufferSizeParsed, _ = parseDiskSize(LowResourceCopyBufferSize)
+	if c.maxMemoryParsed == 0 {
+		c.MaxMemory = LowResourceMaxMemory
+		c.maxMemoryParsed, _ = parseDiskSize(LowResourceMaxMemory)
+	}
+	if c.progressIntervalParsed < LowResourceProgressInterval {
+		c.ProgressInterval = LowResourceProgressInterval.String()
+		c.progressIntervalParsed = LowResourceProgressInterval
+	}
+
+	if err := lowerPriority(); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to lower process priority: %v\n", err))
+	}
+	logger.Info(fmt.Sprintf("Low-resource mode: 1 copy worker, %s buffer, memory limit %s, lowered process priority.\n", c.CopyBufferSize, c.MaxMemory))
+}
@@ -1,20 +1,26 @@
 package main
 
 import (
-	"bufio"
+	"archive/tar"
 	"errors"
 	"fmt"
 	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"regexp"
 	"path/filepath"
 	"simple-backup/src/style"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/sftp"
+
 	// debug
 	// "reflect"
 )
@@ -25,13 +31,30 @@ var logger *style.Style
 // LIMITS AND DEFAULTS
 const (
 	Prefix string					= "smbkp"
+	BackupTimestampFormat string	= "20060102-150405Z" // UTC
+	LegacyTimestampFormat string	= "20060102-150405"  // local time, names of backups made by earlier versions
+	MaxBackupNameCollisions int	= 100 // suffixes tried when backup directory name is taken
 	Version string					= "0.1.0"	
 	BackupDestDirDefault string  	= "smbkp"
 	ConfigFileDefault string		= ".smbkp.yaml"
 	LimitMinBackupsToKeep uint16	= 1
 	LimitMinFreeSpace string		= "10mb"
 	LimitMinFreeSpaceParsed uint64	= 10485760
+	ConfirmAboveDefault uint16		= 3
+	ProgressIntervalDefault string	= "500ms"
+	CopyWorkersDefault uint16		= 1
+	DurabilityFsync string			= "fsync"
+	DurabilityNone string			= "none"
+	LayoutLeaf string				= "leaf"   // item destination defaults to the source leaf name
+	LayoutMirror string				= "mirror" // item destination defaults to the whole source path
+	LimitMaxCopyWorkers uint16		= 32
+	LimitMinMaxMemory uint64		= 64 * MB
+	LimitMinProgressInterval time.Duration = 100 * time.Millisecond
 	MinFreeSpacePattern	string		= `^\d+(mb|gb)$`
+	CopyBufferSizePattern string	= `^\d+(kb|mb)$`
+	CopyBufferSizeDefault string	= "1mb"
+	LimitMinCopyBufferSize uint64	= 64 * KB
+	LimitMaxCopyBufferSize uint64	= 64 * MB
 )
 
 
@@ -41,22 +64,97 @@ const (
 // BACKUP CONFIG OBJECT
 type Config struct {
 	BkpDestDir		string `yaml:"bkp_dest_dir"`
-	Retention struct {
-		BackupsToKeep 		uint16 `yaml:"backups_to_keep"`
-		MinFreeSpace  		string `yaml:"min_free_space"`
-		minFreeSpaceParsed	uint64	// set implicitly by parsing MinFreeSpace
-	} `yaml:"retention"`
+	Retention RetentionConfig `yaml:"retention"`
 	DriveInfo *DriveInfo `yaml:"drive_info,omitempty"`
 	BkpItems  []BackupItem `yaml:"bkp_items"`
+	CopyWorkers				uint16 `yaml:"copy_workers,omitempty"` // number of files copied concurrently within an item
+	CopyWhileScanning		bool   `yaml:"copy_while_scanning,omitempty"` // start copying directory items before they are fully enumerated
+	CopyOrder				string `yaml:"copy_order,omitempty"` // order files of an item are copied in: "newest", "smallest", "largest" or "path"
+	PackSmallFiles			string `yaml:"pack_small_files,omitempty"` // files up to this size are packed into pack files (e.g. "16kb")
+	packSmallFilesParsed	uint64	// set implicitly by parsing PackSmallFiles
+	CopyBufferSize			string `yaml:"copy_buffer_size,omitempty"` // size of the buffer used to copy each file
+	copyBufferSizeParsed	uint64	// set implicitly by parsing CopyBufferSize
+	Durability				string `yaml:"durability,omitempty"` // "fsync" or "none"
+	ProgressInterval		string `yaml:"progress_interval,omitempty"` // status line refresh rate while copying large files
+	progressIntervalParsed	time.Duration	// set implicitly by parsing ProgressInterval
+	ReadOnly				bool   `yaml:"read_only,omitempty"` // protect completed backups against changes
+	SigningKeyFile			string `yaml:"signing_key_file,omitempty"` // key to sign manifests with (SMBKP_SIGNING_KEY env takes precedence)
+	Display					DisplayConfig `yaml:"display,omitempty"`
+	PromptTimeout			string `yaml:"prompt_timeout,omitempty"` // how long prompts wait for an answer (empty - forever)
+	promptTimeoutParsed		time.Duration	// set implicitly by parsing PromptTimeout
+	PromptDefault			string `yaml:"prompt_default,omitempty"` // answer applied on timeout: "cancel" or "proceed"
+	Dedup					string `yaml:"dedup,omitempty"` // "none" or "hardlink" (link unchanged files from the previous backup)
+	HashAlgorithm			string `yaml:"hash_algorithm,omitempty"` // manifest checksums: "sha256", "blake2b" or "crc64"
+	HashWorkers				uint16 `yaml:"hash_workers,omitempty"` // hash copied files in a separate pool (0 - hash while copying)
+	MaxMemory				string `yaml:"max_memory,omitempty"` // memory use guideline for large runs (e.g. "256mb")
+	maxMemoryParsed			uint64	// set implicitly by parsing MaxMemory
+	LowResource				bool   `yaml:"low_resource,omitempty"` // preset for single-board computers and tiny NAS devices
+	IncludeADS				bool   `yaml:"include_ads,omitempty"` // copy NTFS alternate data streams (Windows only)
+	CloudPlaceholders		string `yaml:"cloud_placeholders,omitempty"` // online-only files of cloud sync clients: "skip", "hydrate" or "record"
+	StaleAfter				string `yaml:"stale_after,omitempty"` // 'status' reports the destination as stale after this time without a successful backup
+	UpdateCheck				*bool  `yaml:"update_check,omitempty"` // false - don't check for newer releases (default true)
+	ParallelItems			uint16 `yaml:"parallel_items,omitempty"` // number of items backed up at the same time (default 1)
+	ObfuscateNames			bool   `yaml:"obfuscate_names,omitempty"` // obfuscate names in the destination, encrypt manifest and reports (needs config key)
+	Encryption				EncryptionConfig `yaml:"encryption,omitempty"`
+	Compression				string `yaml:"compression,omitempty"` // '-to-stdout' archives: "none" or "gzip"
+	Layout					string `yaml:"layout,omitempty"` // default item destinations: "leaf" or "mirror"
+	MissingSource			string `yaml:"missing_source,omitempty"` // local source not found before the run: "error", "warn" or "skip"
+	ChangedRetries			*uint8 `yaml:"changed_retries,omitempty"` // copies of a file that changes while being copied (0 - the first copy is kept, default 3)
+	MtimeTolerance			string `yaml:"mtime_tolerance,omitempty"` // modification time difference of unchanged files: "auto" or duration (e.g. "2s")
+	mtimeToleranceParsed	time.Duration	// set implicitly by parsing MtimeTolerance
+	Power					PowerConfig `yaml:"power,omitempty"` // battery limits and sleep inhibition on laptops
+	Network					NetworkConfig `yaml:"network,omitempty"` // transfers over metered connections
+	SpanVolumes				[]string `yaml:"span_volumes,omitempty"` // more drives the backup continues on when the destination is full
+	Rotation				RotationConfig `yaml:"rotation,omitempty"` // destination drives used in turns (offsite rotation)
+	BackgroundVerify		BackgroundVerifyConfig `yaml:"background_verify,omitempty"` // re-verify old backups after unattended runs
+}
+
+
+// DISPLAY SETTINGS (console output only, log file is not affected)
+type DisplayConfig struct {
+	Theme		string `yaml:"theme,omitempty"` // "default", "basic" (8 colors) or "mono" (no colors)
+	Emoji		*bool  `yaml:"emoji,omitempty"` // false - use text instead of emoji (default true)
+	Verbosity	string `yaml:"verbosity,omitempty"` // "quiet", "normal" (default), "verbose" or "debug"
+	ShowSub		*bool  `yaml:"show_sub,omitempty"` // false - hide secondary messages, like item counts (default true)
+}
+
+
+// RETENTION SETTINGS
+type RetentionConfig struct {
+	BackupsToKeep 		uint16 `yaml:"backups_to_keep"`
+	MinFreeSpace  		string `yaml:"min_free_space"`
+	minFreeSpaceParsed	uint64	// set implicitly by parsing MinFreeSpace
+	ConfirmAbove		uint16 `yaml:"confirm_above"` // ask before removing more backups than this in one pass (0 - never ask)
+	UseTrash			bool   `yaml:"use_trash"` // move pruned backups into '.trash' instead of removing them
+	TrashGracePeriod	string `yaml:"trash_grace_period"` // how long trashed backups are kept (e.g. "7d", "36h")
+	trashGracePeriodParsed	time.Duration	// set implicitly by parsing TrashGracePeriod
 }
 
 
 // OBJECT FOR EACH ENTRY UNDER 'BKP_ITEMS'
 type BackupItem struct {
-	Source      string   `yaml:"source"`
-	Destination string   `yaml:"destination"`
-	Include     []string `yaml:"include,omitempty"`
-	Exclude     []string `yaml:"exclude,omitempty"`
+	Type             string   `yaml:"type,omitempty"`               // "path" (default) or "stream"
+	Source           string   `yaml:"source"`
+	Destination      string   `yaml:"destination"`                  // may contain placeholders, e.g. "photos/{{year}}/{{date}}"
+	Command          string   `yaml:"command,omitempty"`            // "stream" items only: read command's stdout instead of stdin
+	Include          []string `yaml:"include,omitempty"`
+	Exclude          []string `yaml:"exclude,omitempty"`
+	SSHKey           string   `yaml:"ssh_key,omitempty"`            // private key for 'ssh://' sources (defaults to ssh-agent and ~/.ssh keys)
+	IncludeHidden    *bool    `yaml:"include_hidden,omitempty"`     // false - skip dot-files and items with hidden attribute (default true)
+	SkipSystemAttrib bool     `yaml:"skip_system_attrib,omitempty"` // skip items with system attribute (Windows only)
+	MaxDepth         uint16   `yaml:"max_depth,omitempty"`          // directory levels below source to copy (0 - unlimited)
+	MaxFiles         uint32   `yaml:"max_files,omitempty"`          // stop enumeration after this many files (0 - unlimited)
+	FollowSymlinks   bool     `yaml:"follow_symlinks,omitempty"`    // copy content of symlinked directories instead of recreating symlinks
+	RunAs            string   `yaml:"run_as,omitempty"`             // read the item as this user (Unix only, requires root)
+	SkipNodump       bool     `yaml:"skip_nodump,omitempty"`        // skip items with "nodump" flag (chattr +d, chflags nodump)
+	ExcludeIfPresent []string `yaml:"exclude_if_present,omitempty"` // skip directories containing any of these files (e.g. ".nobackup")
+	Snapshot         bool     `yaml:"snapshot,omitempty"`           // copy from a read-only snapshot of the source volume (btrfs, APFS)
+	After            []string `yaml:"after,omitempty"`              // destinations of earlier items that must finish first ('parallel_items')
+	SQLiteBackup     bool     `yaml:"sqlite_backup,omitempty"`      // copy SQLite databases with the backup API ('sqlite3' tool)
+	MaxSize          string   `yaml:"max_size,omitempty"`           // size limit of the item (e.g. "50gb")
+	maxSizeParsed    uint64   // set implicitly by parsing MaxSize
+	MaxSizeAction    string   `yaml:"max_size_action,omitempty"`    // over 'max_size': "abort" (default), "truncate" or "prompt"
+	missing          bool     // set implicitly if the source is not found before the run ('missing_source: skip')
 }
 
 // DRIVE INFO METADATA (optional)
@@ -68,21 +166,86 @@ type DriveInfo struct {
 
 // BACKUP OUTCOME TRACKING OBJECT
 type BackupResult struct {
-	Item    BackupItem
-	Success bool
-	Error   error
-	Elapsed time.Duration
+	Item         BackupItem
+	Success      bool
+	Error        error
+	Truncated    string // why the item was copied only partially (limits reached)
+	Missing      bool   // source was not found, the item is skipped ('missing_source: skip')
+	Files        int    // files selected for copying
+	Bytes        int64  // size of files selected for copying (unknown for streams)
+	Linked       int64  // size of files hard-linked from the previous backup ('dedup: hardlink')
+	LinkedFiles  int
+	FilesCopied  int   // files copied or hard-linked
+	FilesSkipped int   // selected files skipped while being copied (inaccessible)
+	FilesFailed  int   // files that failed to copy (the item stops at the first one)
+	FilesChanged int   // files copied while they were still changing (copies may be inconsistent)
+	BytesCopied  int64 // size of files copied or hard-linked
+	Elapsed      time.Duration
+}
+
+
+// copiedShare returns the percentage of selected files that were copied (or linked).
+func (r BackupResult) copiedShare() float64 {
+	if r.Files == 0 {
+		return 100
+	}
+	return float64(r.FilesCopied) * 100 / float64(r.Files)
+}
+
+
+// BACKUP DIRECTORY FOUND AT DESTINATION
+type backupDir struct {
+	name    string
+	path    string
+	created time.Time
+	state   string // complete, partial or legacy
 }
 
 
 // MAIN APPLICATION OBJECT
 type BackupApp struct {
 	configFile		string
+	runID           string // identifies the run in log lines, metadata and summary
 	BkpConfig       Config
 	bkpDest         string
 	bkpDestFullPath	string
+	backupRoot      string      // 'bkp_dest_dir' on the destination ('bkpDestFullPath' becomes the backup directory of the run)
+	backupRootInfo  os.FileInfo // backup root, to recognize it in sources (nil if it doesn't exist yet)
+	backupRootOnce  sync.Once
 	exitOnError     bool
 	nonInteractive  bool
+	assumeYes       bool // skip backup confirmation only, other prompts are still shown
+	toStdout        bool
+	startTime       time.Time
+	tarOut          *tar.Writer             // set when backup is streamed to stdout
+	remoteClients   map[string]*sftp.Client // open SFTP sessions, keyed by user@host:port
+	remoteMu        sync.Mutex              // guards 'remoteClients'
+	progress        *progress               // status line of the item being backed up
+	board           *progressBoard          // shared status line of items backed up in parallel ('parallel_items')
+	itemMu          sync.Mutex              // serializes bookkeeping and prompts of items backed up in parallel
+	copyBuffers     sync.Pool               // reusable copy buffers of 'copy_buffer_size'
+	manifest        []manifestEntry         // files copied by the current run
+	manifestMu      sync.Mutex
+	manifestSpool   *manifestSpool          // manifest streamed to disk instead of 'manifest' ('max_memory')
+	packs           *packWriter             // pack files of small files ('pack_small_files')
+	counters        runCounters             // totals of the run for progress checkpoints
+	skipped         []skippedEntry          // source paths not copied by the current run
+	skippedMu       sync.Mutex              // guards 'skipped' while files are copied concurrently
+	placeholders    []workEntry             // online-only files of cloud sync clients found by the current run
+	dedup           *dedupBase              // previous backup to link unchanged files from ('dedup: hardlink')
+	hashes          *hashPool               // hashing workers, if 'hash_workers' is set
+	ranges          *rangeBoard             // large files copied in ranges by several 'copy_workers'
+	ads             *adsTarget              // where alternate data streams are copied, if 'include_ads' is set
+	names           *nameObfuscator         // set if 'obfuscate_names' is enabled for the current run
+	pipeline        *streamPipeline         // stages the tar stream goes through (compression, encryption)
+	resume          *resumeState            // interrupted run being continued ('--continue')
+	journal         *runJournal             // files copied by the run, so it can be continued if interrupted
+	power           powerGuard              // battery checks while copying ('on_low_battery: pause')
+	rateLimit       *rateLimiter            // set if transfers are limited on a metered connection ('on_metered: throttle')
+	uploadLimit     *rateLimiter            // set if writes to a UNC destination follow 'upload_schedule'
+	span            *SpanMetadata           // set if the run is a part of a backup spanning volumes ('span_volumes')
+	rotation        *RotationDrive          // drive selected for the run ('rotation')
+	canaryMissing   bool                    // canary was missing next to existing backups, old backups are not removed
 }
 
 
@@ -94,6 +257,11 @@ func main() {
 	// printYAMLKeysForType(reflect.TypeOf(BackupApp{}))
 	// os.Exit(0)
 
+	// Internal errors end with a diagnostic bundle instead of a raw panic
+	defer handlePanic()
+
+	// Sub-commands have their own options (does not run backup)
+	dispatchCommand(os.Args[1:])
 
 	// Command-line args
 	var (
@@ -102,7 +270,23 @@ func main() {
 		exitOnError    = pflag.BoolP("exit-on-error", "e", false, "Exit immediately on any copy operation failure.")
 		logDir         = pflag.StringP("log-dir", "l", "", "Path to a directory to store log file.")
 		nonInteractive = pflag.BoolP("non-interactive", "n", false, "Skip all user prompts.")
+		assumeYes      = pflag.BoolP("yes", "y", false, "Start backup without confirmation. Unlike -non-interactive, other prompts (e.g. on errors) are still shown.")
+		streamDest     = pflag.StringP("stream", "s", "", "Back up stdin into the specified file (relative to the backup directory), in addition to configured items.")
+		includeFrom    = pflag.String("include-from", "", "Read include patterns from file (one per line) and add them to every item.")
+		excludeFrom    = pflag.String("exclude-from", "", "Read exclude patterns from file (one per line) and add them to every item.")
+		toStdout       = pflag.BoolP("to-stdout", "t", false, "Write the backup as a tar stream to stdout instead of the backup destination. Console output goes to stderr.")
 		initConfig     = pflag.BoolP("init-config", "i", false, "Generate example configuration file '.smbkp.yaml' and exit. Optionally accepts destination directory as the first positional argument.")
+		logFormat      = pflag.String("log-format", LogFormatText, "Log file format: text, or json (one JSON object per line, for log collectors).")
+		service        = pflag.Bool("service", false, "Unattended service mode: non-interactive, no colors or emoji, JSON log file, no update check.")
+		logLevel       = pflag.String("log-level", "normal", "Log file verbosity: quiet, normal, verbose (e.g. skipped files) or debug (every file decision).")
+		theme          = pflag.String("theme", "", "Color theme of console output: default, basic (8 colors) or mono (no colors). Overrides 'display.theme'.")
+		verbosity      = pflag.String("verbosity", "", "Console output verbosity: quiet, normal, verbose or debug. Overrides 'display.verbosity'.")
+		noEmoji        = pflag.Bool("no-emoji", false, "Use text instead of emoji in console output.")
+		lowResource    = pflag.Bool("low-resource", false, "Tune for single-board computers and tiny NAS devices: 1 copy worker, small buffers, memory limit, low process priority.")
+		destCredential = pflag.String("dest-credential", "", "Windows Credential Manager entry with the user and password for a UNC destination (e.g. 'nas'). See also SMBKP_DEST_USER and SMBKP_DEST_PASSWORD.")
+		elevate        = pflag.Bool("elevate", false, "Relaunch as administrator (UAC prompt) or root (sudo) if not running so, to read protected files.")
+		continueRun    = pflag.Bool("continue", false, "Continue the interrupted run: copy the files it had queued but not copied yet into its backup, without walking the sources again.")
+		lang           = pflag.String("lang", "", "Language of prompts and messages of the interactive flow: en or ru. Detected from LANG and OS settings by default.")
 		showHelp       = pflag.BoolP("help", "h", false, "Show help and exit.")
 		showVersion    = pflag.BoolP("version", "v", false, "Show version info and exit.")
 	)
@@ -124,9 +308,22 @@ func main() {
 		}
 
 		fmt.Printf("Example configuration file generated at %s\n", destPath)
+		offerConfigEncryption(destPath)
 		return
 	}
 
+	// Optional "backup" command (same as no command, other commands are dispatched above)
+	if pflag.NArg() > 0 && pflag.Arg(0) != "backup" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Use '-help' to see usage.\n", pflag.Arg(0))
+		os.Exit(1)
+	}
+
+	// Language of the interactive flow
+	if err := setLanguage(*lang); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	// Show help
 	if *showHelp {
 		printHelp()
@@ -139,7 +336,49 @@ func main() {
 		return
 	}
 
+	// First run: guided setup writes the config, then the first backup is reviewed as usual
+	if needsSetup() {
+		dest, ok := runSetup()
+		if !ok {
+			fmt.Print(tr(msgPressEnter)) // logger is not set up yet
+			readLine(0)
+			os.Exit(1)
+		}
+		*bkpDest = dest
+	}
+
+	// Relaunch elevated if asked to (on Windows, the elevated copy runs in a new console window)
+	if *elevate && !isElevated() {
+		if err := relaunchElevated(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to relaunch as %s, continuing without privileges: %v\n", privilegedUser(), err)
+		} else {
+			return
+		}
+	}
+
+	destCredentialTarget = *destCredential
+
+	// Service mode defaults (explicit options still apply)
+	if *service {
+		*nonInteractive = true
+		*noEmoji = true
+		if *theme == "" {
+			*theme = "mono"
+		}
+		if !pflag.CommandLine.Changed("log-format") {
+			*logFormat = LogFormatJSON
+		}
+	}
+	if err := validateLogFormat(*logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// Every log line has the run ID, so overlapping runs can be told apart
+	runID := newRunID()
+
 	// Set up logging
+	logObj := log.New(io.Discard, "", log.LstdFlags)
 	if *logDir != "" {
 		logStartTime := time.Now()
 		logFileName := fmt.Sprintf("smbkp-%s.log", logStartTime.Format("20060102-150405"))
@@ -157,38 +396,153 @@ func main() {
 		}
 		defer logFile.Close()
 
-		logObj := log.New(logFile, "", log.LstdFlags)
-		logger = style.New(logObj)
-		logger.Info("Logging initialized.\n")
+		logObj = log.New(logFile, fmt.Sprintf("run=%s ", runID), log.LstdFlags|log.Lmsgprefix)
+		if *logFormat == LogFormatJSON {
+			logObj = log.New(logFile, "", 0)
+		}
+	}
+	logger = style.New(logObj)
+	if *logDir != "" && *logFormat == LogFormatJSON {
+		logger.SetLogJSON(jsonLogFields(runID))
+	}
+	logger.KeepHistory(LogExcerptMessages)
+	if level, err := style.ParseLevel(*logLevel); err != nil {
+		logger.Fatal(fmt.Sprintf("%q: %v\n\n", "-log-level", err), style.Bold())
+		exitApp(*nonInteractive, 1)
+	} else {
+		logger.SetLogLevel(level)
+	}
+
+	// Tar stream owns stdout, so console output goes to stderr
+	if *toStdout {
+		logger.SetOutput(os.Stderr)
+	}
 
+	if *logDir != "" {
+		logger.Info("Logging initialized.\n")
 	} else {
-		logObj := log.New(io.Discard, "", log.LstdFlags)
-		logger = style.New(logObj)
 		logger.Warn("Log directory not specified, writing to console only.\n")
 	}
 
 	// Initiate main app
-	app, err := NewBackupApp(*bkpDest, *configFile, *exitOnError, *nonInteractive)
+	app, err := NewBackupApp(*bkpDest, *configFile, *exitOnError, *nonInteractive, *toStdout)
 	if err != nil {
 		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
 		exitApp(*nonInteractive, 1)
 	}
 
+	app.runID = runID
+	setCrashContext(*logDir, runID, *nonInteractive, app)
+	app.applyLowResource(*lowResource)
+	app.applyMemoryLimit()
+	exitPauseTimeout = app.BkpConfig.promptTimeoutParsed
+
+	if err := app.applyDisplay(*theme, *verbosity, *noEmoji); err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		exitApp(*nonInteractive, 1)
+	}
+
+	// Patterns from files apply to this run only
+	if err := app.addPatternsFromFiles(*includeFrom, *excludeFrom); err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		exitApp(*nonInteractive, 1)
+	}
+
+	// Stdin stream (from command-line or config) disables prompts
+	if *streamDest != "" {
+		err = app.addStdinStream(*streamDest)
+	} else {
+		err = app.reserveStdin()
+	}
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		exitApp(true, 1)
+	}
+
+	app.assumeYes = *assumeYes
+
+	// Interrupted run is continued in its backup directory
+	if *continueRun {
+		if err := app.prepareContinue(); err != nil {
+			logger.Fatal(fmt.Sprintf("Failed to continue: %v\n\n", err), style.Bold())
+			exitApp(app.nonInteractive, 1)
+		}
+	}
+
+	// Rotated drives take turns, the run goes to the least recently used one ('rotation')
+	if !*continueRun {
+		if err := app.selectRotationDrive(); err != nil {
+			logger.Fatal(fmt.Sprintf("Backup not started: %v\n\n", err), style.Bold())
+			exitApp(app.nonInteractive, 1)
+		}
+	}
+
+	// Laptop on a low battery doesn't start the run, or waits for the charger ('power')
+	if err := app.checkPower(); err != nil {
+		logger.Fatal(fmt.Sprintf("Backup not started: %v\n\n", err), style.Bold())
+		exitApp(app.nonInteractive, 1)
+	}
+
+	// Mobile hotspot or capped connection defers the run, or slows transfers down ('network')
+	if err := app.checkNetwork(); err != nil {
+		logger.Fatal(fmt.Sprintf("Backup deferred: %v\n\n", err), style.Bold())
+		exitApp(app.nonInteractive, 1)
+	}
+
+	// Notice about newer release (checked once per week)
+	if !*service {
+		app.checkForUpdates()
+	}
+
+	// Items that don't fit on the destination continue on other volumes ('span_volumes')
+	parts, err := app.planSpan()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Backup not started: %v\n\n", err), style.Bold())
+		exitApp(app.nonInteractive, 1)
+	}
+	if parts != nil {
+		printSpanPlan(parts)
+	}
+
 	// Review backup configuration before proceeding
 	if err = reviewBackupConfig(app); err != nil {
 		logger.Fatal(fmt.Sprintf("Review failed: %v\n\n", err), style.Bold())
 		exitApp(app.nonInteractive, 1)
 	}
 
-	// Run backup
-	if err := app.runBackup(); err != nil {
+	// Run backup (outcome is kept for 'status'), one part after another when it spans volumes
+	runs := []*BackupApp{app}
+	destRoot := app.bkpDestFullPath
+	if parts != nil {
+		runs = nil
+		for i := range parts {
+			runs = append(runs, app.spanPartApp(parts, i+1))
+		}
+	}
+	for _, run := range runs {
+		setCrashContext(*logDir, runID, *nonInteractive, run)
+		backupRoot := run.bkpDestFullPath
+		stopCheckpoints := run.startProgressCheckpoints(backupRoot)
+		err = run.runBackup()
+		stopCheckpoints()
+		run.recordRunState(backupRoot, err)
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
 		logger.Plain("\n")
-		logger.Err("BACKUP FAILED!\n\n", style.NoLabel(), style.Bold())
+		logger.Err(tr(msgBackupFailed), style.NoLabel(), style.Bold())
 		exitApp(app.nonInteractive, 2)
 	}
 
 	logger.Plain("\n")
-	logger.Ok("BACKUP COMPLETED SUCCESSFULLY!\n\n", style.NoLabel(), style.Bold())
+	logger.Ok(tr(msgBackupCompleted), style.NoLabel(), style.Bold())
+
+	// Unattended runs re-verify the backup verified longest ago ('background_verify')
+	if *service {
+		app.backgroundVerify(destRoot)
+	}
 	exitApp(app.nonInteractive, 0)
 }
 
@@ -197,9 +551,15 @@ func main() {
 func printHelp() {
 	fmt.Println("\n================  Simple Backup  ================")
 	fmt.Println("\nUsage:")
-	fmt.Println("  simple-backup(.exe) [options]")
+	fmt.Println("  simple-backup(.exe) [backup] [options]")
+	fmt.Println("  simple-backup(.exe) <command> [options]")
 	fmt.Println("\nOptions:")
 	pflag.PrintDefaults()
+	fmt.Println("\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("  Use '<command> --help' to see command options.")
 	fmt.Println("\nNote: If -bkp-dest is not specified, the app will search for any drives/mounts")
 	fmt.Printf("      that contain '%s' file in their root directory.\n", ConfigFileDefault)
 	fmt.Println("      First drive matching this criteria will be selected.")
@@ -214,16 +574,20 @@ func printVersion() {
 
 
 // MAIN APP INIT
-func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive bool) (*BackupApp, error) {
+func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive, toStdout bool) (*BackupApp, error) {
 	app := &BackupApp{
 		BkpConfig:		*NewConfig(), // Set defaults first
 		bkpDest:        bkpDest,
 		exitOnError:    exitOnError,
 		nonInteractive: nonInteractive,
+		toStdout:       toStdout,
 	}
 
 	// Case: Backup Destination explicitly specified by user
 	if bkpDest != "" {
+		if err := connectDestination(bkpDest); err != nil {
+			return nil, err
+		}
 		logger.Plain(fmt.Sprintf("Trying to access specified backup destination %q... ", bkpDest))
 		_, err := os.Stat(bkpDest)
 		if err != nil {
@@ -240,7 +604,8 @@ func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive bool)
 	// Case: Config File explicitly specified by user
 	if configFile != "" {
 		// Case: Config File explicitly specified by User, but Backup Destination is NOT
-		if app.bkpDest == "" {
+		// (not needed when backup is streamed to stdout)
+		if app.bkpDest == "" && !app.toStdout {
 			return nil, fmt.Errorf("%q is not provided, but it is required when %q is specified", "-bkp-dest", "-config")
 		}
 		// Case: Both Config File and Backup Destination explicitly specified by user
@@ -251,8 +616,8 @@ func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive bool)
 	}
 
 	// Case: Backup Destination is NOT specified
-	// (this means that Config File is NOT specified ether)
-	if app.bkpDest == "" {
+	// (this means that Config File is NOT specified ether, unless backup is streamed to stdout)
+	if app.bkpDest == "" && app.configFile == "" {
 		// Get available drives and mount points
 		logger.Info(fmt.Sprintf("%q is not specified.\n", "-bkp-dest"))
 		logger.Plain("Retrieving available drives and common mount points... ")
@@ -300,9 +665,11 @@ func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive bool)
 		}
 	}
 
-	// Creating full backup destination path (bkpDest/bkp_dest_dir/<unique_dir>) #REVIEW The usnique path can be constructed using the timestamp wnen backup starts
-	// fullPath, _ := generateUniquePath(app.bkpDest, app.BkpConfig.BkpDestDir)
+	// Backup root (bkpDest/bkp_dest_dir), the timestamped backup directory is added when the run starts
 	app.bkpDestFullPath = filepath.Join(app.bkpDest, app.BkpConfig.BkpDestDir)
+	if !app.toStdout {
+		app.backupRoot, _ = filepath.Abs(app.bkpDestFullPath)
+	}
 
 	return app, nil
 }
@@ -312,23 +679,39 @@ func NewBackupApp(bkpDest, configFile string, exitOnError, nonInteractive bool)
 func NewConfig() *Config {
 	return &Config{
 		BkpDestDir: BackupDestDirDefault,
-		Retention: struct {
-			BackupsToKeep uint16    `yaml:"backups_to_keep"`
-			MinFreeSpace  string `yaml:"min_free_space"`
-			minFreeSpaceParsed uint64
-		}{
+		Retention: RetentionConfig{
 			BackupsToKeep: 		LimitMinBackupsToKeep,
 			MinFreeSpace:  		LimitMinFreeSpace,
 			minFreeSpaceParsed:	LimitMinFreeSpaceParsed,
+			ConfirmAbove:		ConfirmAboveDefault,
+			TrashGracePeriod:	TrashGracePeriodDefault,
 		},
 		BkpItems: []BackupItem{},
+		CopyWorkers: CopyWorkersDefault,
+		CopyOrder: CopyOrderNewest,
+		CopyBufferSize: CopyBufferSizeDefault,
+		Durability: DurabilityNone,
+		ProgressInterval: ProgressIntervalDefault,
+		PromptDefault: PromptDefaultCancel,
+		Dedup: DedupNone,
+		HashAlgorithm: HashSHA256,
+		CloudPlaceholders: CloudPlaceholdersRecord,
+		StaleAfter: StaleAfterDefault,
+		Compression: CompressionNone,
+		ParallelItems: ParallelItemsDefault,
+		Layout: LayoutLeaf,
+		MissingSource: MissingSourceWarn,
+		MtimeTolerance: MtimeToleranceAuto,
+		Power: PowerConfig{OnLowBattery: PowerRefuse},
+		Network: NetworkConfig{OnMetered: MeteredRun, MeteredRate: MeteredRateDefault, UploadChunk: UploadChunkDefault, UploadRetries: UploadRetriesDefault},
+		BackgroundVerify: BackgroundVerifyConfig{Interval: BackgroundVerifyIntervalDefault, MaxTime: BackgroundVerifyMaxTimeDefault},
 	}
 }
 
 
 // LOAD MAIN CONFIG FROM FILE
 func (app *BackupApp) loadConfig(configFile string) error {
-	data, err := os.ReadFile(configFile)
+	data, err := readConfigFile(configFile) // decrypted if encrypted at rest
 
 	if err != nil {
 		logger.Plain("\n")
@@ -383,17 +766,302 @@ func (c *Config) validate() error {
 		msg := fmt.Sprintf("%q value increased from '%s' to '%s', which is allowed minimum.\n", "min_free_space", c.Retention.MinFreeSpace, LimitMinFreeSpace)
 		logger.Warn(msg)
 		c.Retention.MinFreeSpace = LimitMinFreeSpace
-		c.Retention.minFreeSpaceParsed = LimitMinFreeSpaceParsed
+		minFreeSpaceParsed = LimitMinFreeSpaceParsed
 	}
 	c.Retention.minFreeSpaceParsed = minFreeSpaceParsed
 
-	// Set destination attribute of each item under bkp_items to item's source leaf, if destination is not specified
+	// Validate trash_grace_period
+	trashGracePeriod, err := parseDuration(c.Retention.TrashGracePeriod)
+	if err != nil || trashGracePeriod < 0 {
+		return fmt.Errorf("%q value %q has invalid format. Expected a duration (e.g., '7d', '36h')", "trash_grace_period", c.Retention.TrashGracePeriod)
+	}
+	c.Retention.trashGracePeriodParsed = trashGracePeriod
+
+	// Validate copy_workers
+	if c.CopyWorkers < 1 {
+		msg := fmt.Sprintf("%q value increased from '%d' to '%d', which is allowed minimum.\n", "copy_workers", c.CopyWorkers, 1)
+		logger.Warn(msg)
+		c.CopyWorkers = 1
+	}
+	if c.CopyWorkers > LimitMaxCopyWorkers {
+		msg := fmt.Sprintf("%q value decreased from '%d' to '%d', which is allowed maximum.\n", "copy_workers", c.CopyWorkers, LimitMaxCopyWorkers)
+		logger.Warn(msg)
+		c.CopyWorkers = LimitMaxCopyWorkers
+	}
+
+	// Validate copy_order
+	c.CopyOrder = strings.ToLower(c.CopyOrder)
+	if !slices.Contains(copyOrders, c.CopyOrder) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "copy_order", c.CopyOrder, strings.Join(copyOrders, ", "))
+	}
+
+	// Validate copy_buffer_size
+	if !regexp.MustCompile(CopyBufferSizePattern).MatchString(strings.ToLower(c.CopyBufferSize)) {
+		return fmt.Errorf(
+			"%q value %q has invalid format. Expected format is a number followed by 'kb' or 'mb' (e.g., '512kb', '4mb')",
+			"copy_buffer_size",
+			c.CopyBufferSize,
+		)
+	}
+	copyBufferSize, err := parseDiskSize(c.CopyBufferSize)
+	if err != nil {
+		return err
+	}
+	if copyBufferSize < LimitMinCopyBufferSize {
+		msg := fmt.Sprintf("%q value increased from '%s' to '%dkb', which is allowed minimum.\n", "copy_buffer_size", c.CopyBufferSize, LimitMinCopyBufferSize/KB)
+		logger.Warn(msg)
+		copyBufferSize = LimitMinCopyBufferSize
+	}
+	if copyBufferSize > LimitMaxCopyBufferSize {
+		msg := fmt.Sprintf("%q value decreased from '%s' to '%dmb', which is allowed maximum.\n", "copy_buffer_size", c.CopyBufferSize, LimitMaxCopyBufferSize/MB)
+		logger.Warn(msg)
+		copyBufferSize = LimitMaxCopyBufferSize
+	}
+	c.copyBufferSizeParsed = copyBufferSize
+
+	// Validate pack_small_files
+	if c.PackSmallFiles != "" {
+		if !regexp.MustCompile(CopyBufferSizePattern).MatchString(strings.ToLower(c.PackSmallFiles)) {
+			return fmt.Errorf("%q value %q has invalid format. Expected format is a number followed by 'kb' or 'mb' (e.g., '16kb')", "pack_small_files", c.PackSmallFiles)
+		}
+		packSmallFiles, err := parseDiskSize(c.PackSmallFiles)
+		if err != nil {
+			return fmt.Errorf("%q: %w", "pack_small_files", err)
+		}
+		if packSmallFiles > LimitMaxPackSmallFiles {
+			msg := fmt.Sprintf("%q value decreased from '%s' to '%dmb', which is allowed maximum.\n", "pack_small_files", c.PackSmallFiles, LimitMaxPackSmallFiles/MB)
+			logger.Warn(msg)
+			packSmallFiles = LimitMaxPackSmallFiles
+		}
+		if packSmallFiles > 0 && c.IncludeADS {
+			return fmt.Errorf("%q can't be used with %q", "pack_small_files", "include_ads")
+		}
+		c.packSmallFilesParsed = packSmallFiles
+	}
+
+	// Validate durability
+	c.Durability = strings.ToLower(c.Durability)
+	if c.Durability != DurabilityFsync && c.Durability != DurabilityNone {
+		return fmt.Errorf("%q value %q is not supported. Expected %q or %q", "durability", c.Durability, DurabilityFsync, DurabilityNone)
+	}
+
+	// Validate progress_interval
+	progressInterval, err := time.ParseDuration(c.ProgressInterval)
+	if err != nil {
+		return fmt.Errorf("%q value %q has invalid format. Expected a duration (e.g., '500ms', '2s')", "progress_interval", c.ProgressInterval)
+	}
+	if progressInterval < LimitMinProgressInterval {
+		msg := fmt.Sprintf("%q value increased from '%s' to '%s', which is allowed minimum.\n", "progress_interval", c.ProgressInterval, LimitMinProgressInterval)
+		logger.Warn(msg)
+		progressInterval = LimitMinProgressInterval
+	}
+	c.progressIntervalParsed = progressInterval
+
+	// Validate prompt_timeout and prompt_default
+	if c.PromptTimeout != "" {
+		promptTimeout, err := parseDuration(c.PromptTimeout)
+		if err != nil || promptTimeout <= 0 {
+			return fmt.Errorf("%q value %q has invalid format. Expected a positive duration (e.g., '60s', '5m')", "prompt_timeout", c.PromptTimeout)
+		}
+		c.promptTimeoutParsed = promptTimeout
+	}
+	c.PromptDefault = strings.ToLower(c.PromptDefault)
+	if c.PromptDefault != PromptDefaultCancel && c.PromptDefault != PromptDefaultProceed {
+		return fmt.Errorf("%q value %q is not supported. Expected %q or %q", "prompt_default", c.PromptDefault, PromptDefaultCancel, PromptDefaultProceed)
+	}
+
+	// Validate hash_algorithm and hash_workers
+	c.HashAlgorithm = strings.ToLower(c.HashAlgorithm)
+	if !slices.Contains(hashAlgorithms, c.HashAlgorithm) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "hash_algorithm", c.HashAlgorithm, strings.Join(hashAlgorithms, ", "))
+	}
+	if c.HashWorkers > LimitMaxHashWorkers {
+		msg := fmt.Sprintf("%q value decreased from '%d' to '%d', which is allowed maximum.\n", "hash_workers", c.HashWorkers, LimitMaxHashWorkers)
+		logger.Warn(msg)
+		c.HashWorkers = LimitMaxHashWorkers
+	}
+
+	// Validate max_memory
+	if c.MaxMemory != "" {
+		if !regexp.MustCompile(MinFreeSpacePattern).MatchString(strings.ToLower(c.MaxMemory)) {
+			return fmt.Errorf("%q value %q has invalid format. Expected format is a number followed by 'mb' or 'gb' (e.g., '256mb', '2gb')", "max_memory", c.MaxMemory)
+		}
+		maxMemory, err := parseDiskSize(c.MaxMemory)
+		if err != nil {
+			return fmt.Errorf("%q: %w", "max_memory", err)
+		}
+		if maxMemory < LimitMinMaxMemory {
+			msg := fmt.Sprintf("%q value increased from '%s' to '%s', which is allowed minimum.\n", "max_memory", c.MaxMemory, formatBytes(LimitMinMaxMemory))
+			logger.Warn(msg)
+			maxMemory = LimitMinMaxMemory
+		}
+		c.maxMemoryParsed = maxMemory
+	}
+
+	// Validate stale_after
+	if staleAfter, err := parseDuration(c.StaleAfter); err != nil || staleAfter <= 0 {
+		return fmt.Errorf("%q value %q has invalid format. Expected a positive duration (e.g., '36h', '7d')", "stale_after", c.StaleAfter)
+	}
+
+	// Validate cloud_placeholders
+	c.CloudPlaceholders = strings.ToLower(c.CloudPlaceholders)
+	if !slices.Contains(cloudPlaceholderPolicies, c.CloudPlaceholders) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "cloud_placeholders", c.CloudPlaceholders, strings.Join(cloudPlaceholderPolicies, ", "))
+	}
+
+	// Validate obfuscate_names (streamed manifest and stream sidecar would be written with real names)
+	if c.ObfuscateNames && c.MaxMemory != "" {
+		return fmt.Errorf("%q can't be used with %q", "obfuscate_names", "max_memory")
+	}
+	if c.ObfuscateNames && c.IncludeADS {
+		return fmt.Errorf("%q can't be used with %q", "obfuscate_names", "include_ads")
+	}
+
+	// Validate encryption and compression
+	if err := c.Encryption.validate(); err != nil {
+		return err
+	}
+	c.Compression = strings.ToLower(c.Compression)
+	if !slices.Contains(compressionMethods, c.Compression) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "compression", c.Compression, strings.Join(compressionMethods, ", "))
+	}
+
+	// Validate power and network settings
+	if err := c.Power.validate(); err != nil {
+		return err
+	}
+	if err := c.Network.validate(); err != nil {
+		return err
+	}
+	if err := validateSpanVolumes(c.SpanVolumes); err != nil {
+		return err
+	}
+	if err := c.Rotation.validate(); err != nil {
+		return err
+	}
+	if err := c.BackgroundVerify.validate(); err != nil {
+		return err
+	}
+
+	// Validate dedup
+	c.Dedup = strings.ToLower(c.Dedup)
+	if c.Dedup != DedupNone && c.Dedup != DedupHardlink {
+		return fmt.Errorf("%q value %q is not supported. Expected %q or %q", "dedup", c.Dedup, DedupNone, DedupHardlink)
+	}
+
+	// Validate display settings (applied after config is loaded)
+	if c.Display.Theme != "" && !slices.Contains(style.ThemeNames(), strings.ToLower(c.Display.Theme)) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "display.theme", c.Display.Theme, strings.Join(style.ThemeNames(), ", "))
+	}
+	if c.Display.Verbosity != "" {
+		if _, err := style.ParseLevel(c.Display.Verbosity); err != nil {
+			return fmt.Errorf("%q: %w", "display.verbosity", err)
+		}
+	}
+
+	// Validate layout
+	c.Layout = strings.ToLower(c.Layout)
+	if c.Layout != LayoutLeaf && c.Layout != LayoutMirror {
+		return fmt.Errorf("%q value %q is not supported. Expected %q or %q", "layout", c.Layout, LayoutLeaf, LayoutMirror)
+	}
+
+	// Validate missing_source
+	c.MissingSource = strings.ToLower(c.MissingSource)
+	if !slices.Contains(missingSourcePolicies, c.MissingSource) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "missing_source", c.MissingSource, strings.Join(missingSourcePolicies, ", "))
+	}
+
+	// Validate mtime_tolerance
+	c.MtimeTolerance = strings.ToLower(c.MtimeTolerance)
+	if c.MtimeTolerance != MtimeToleranceAuto {
+		tolerance, err := time.ParseDuration(c.MtimeTolerance)
+		if err != nil || tolerance < 0 {
+			return fmt.Errorf("%q value %q has invalid format. Expected %q or a duration (e.g., '2s', '0s')", "mtime_tolerance", c.MtimeTolerance, MtimeToleranceAuto)
+		}
+		c.mtimeToleranceParsed = tolerance
+	}
+
+	// Expand placeholders of item destinations (once, so all items of the run get the same time)
+	now := time.Now()
 	for i := range c.BkpItems {
+		destination, err := expandDestination(c.BkpItems[i].Destination, now)
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+		c.BkpItems[i].Destination = destination
+	}
+
+	// Set destination attribute of each item under bkp_items to item's source leaf (or whole path with 'layout: mirror'),
+	// if destination is not specified
+	for i := range c.BkpItems {
+		if c.BkpItems[i].RunAs != "" {
+			if err := validateRunAs(c.BkpItems[i]); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		if c.BkpItems[i].Snapshot {
+			if err := validateSnapshot(c.BkpItems[i]); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		if c.BkpItems[i].SQLiteBackup && (isStreamItem(c.BkpItems[i]) || isRemoteSource(c.BkpItems[i].Source)) {
+			return fmt.Errorf("item %d: %q is supported for local path items only", i+1, "sqlite_backup")
+		}
+		if isStreamItem(c.BkpItems[i]) {
+			if err := validateStreamItem(c.BkpItems[i]); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+			continue
+		}
+		if err := validateMaxSize(&c.BkpItems[i]); err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+		if c.BkpItems[i].Type != "" && !strings.EqualFold(c.BkpItems[i].Type, ItemTypePath) {
+			return fmt.Errorf("item %d: %q value %q is not supported. Expected %q or %q", i+1, "type", c.BkpItems[i].Type, ItemTypePath, ItemTypeStream)
+		}
+		if isRemoteSource(c.BkpItems[i].Source) {
+			if _, err := parseRemoteSource(c.BkpItems[i].Source); err != nil {
+				return err
+			}
+			if c.BkpItems[i].FollowSymlinks {
+				return fmt.Errorf("item %d: %q is not supported for remote sources", i+1, "follow_symlinks")
+			}
+			if c.BkpItems[i].SkipNodump {
+				return fmt.Errorf("item %d: %q is not supported for remote sources", i+1, "skip_nodump")
+			}
+			if c.BkpItems[i].Destination == "" {
+				c.BkpItems[i].Destination = defaultDestination(c.Layout, c.BkpItems[i].Source)
+			}
+			continue
+		}
 		if c.BkpItems[i].Destination == "" {
-			c.BkpItems[i].Destination = filepath.Base(c.BkpItems[i].Source)
+			c.BkpItems[i].Destination = defaultDestination(c.Layout, c.BkpItems[i].Source)
+		}
+	}
+
+	// Backup report folder shares the backup directory with items
+	for i, item := range c.BkpItems {
+		if err := validateDestination(item.Destination); err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+		if _, err := compilePatterns(item.Include, item.Exclude); err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
 		}
 	}
 
+	// Items don't overwrite each other's content
+	if err := checkDestinationCollisions(c.BkpItems); err != nil {
+		return err
+	}
+
+	// Validate parallel_items and item dependencies
+	if c.ParallelItems > LimitMaxParallelItems {
+		return fmt.Errorf("%q value %d exceeds the maximum of %d", "parallel_items", c.ParallelItems, LimitMaxParallelItems)
+	}
+	if _, err := itemDependencies(c.BkpItems); err != nil {
+		return err
+	}
+
 
 	// Future validation for schedule type, etc., can be added here.
 	return nil
@@ -405,7 +1073,15 @@ func reviewBackupConfig(app *BackupApp) error {
 	logger.Signature("\n=========  Backup Configuration Review  =========\n")
 	logger.Plain(fmt.Sprintf("Config file: %s\n", app.configFile))
 	logger.Plain("Backup destination: ")
-	logger.Info(fmt.Sprintf("%s\n", app.bkpDestFullPath), style.NoLabel())
+	if app.toStdout {
+		logger.Info("<stdout> (tar stream)\n", style.NoLabel())
+	} else {
+		logger.Info(fmt.Sprintf("%s\n", app.bkpDestFullPath), style.NoLabel())
+	}
+
+	if app.rotation != nil {
+		logger.Sub(fmt.Sprintf("  Rotation drive: %s\n", app.rotation.Name))
+	}
 
 	// Optional drive metadata
 	if app.BkpConfig.DriveInfo != nil {
@@ -417,34 +1093,81 @@ func reviewBackupConfig(app *BackupApp) error {
 		}
 	}
 
-	// Validate min_free_space
-	logger.Plain(fmt.Sprintf("Minimum required free space: %s\n", app.BkpConfig.Retention.MinFreeSpace))
+	// Validate min_free_space (retention does not apply to tar stream)
+	if !app.toStdout {
+		logger.Plain(fmt.Sprintf("Minimum required free space: %s\n", app.BkpConfig.Retention.MinFreeSpace))
 
-	availableFreeSpace, availableFreeSpaceFormatted, err := getFreeSpace(app.bkpDest)
-	if err != nil {
-		return fmt.Errorf("reading free space: %w", err)
-	}
+		availableFreeSpace, availableFreeSpaceFormatted, err := getFreeSpace(app.bkpDest)
+		if err != nil {
+			return fmt.Errorf("reading free space: %w", err)
+		}
 
-	logger.Plain(fmt.Sprintf("Available free space: %s\n", availableFreeSpaceFormatted)) // Check space on the root of the backup destination
+		logger.Plain(fmt.Sprintf("Available free space: %s\n", availableFreeSpaceFormatted)) // Check space on the root of the backup destination
 
-	if availableFreeSpace < app.BkpConfig.Retention.minFreeSpaceParsed {
-		return fmt.Errorf("available free space (%s) is less than required minimum (%s)", availableFreeSpaceFormatted, app.BkpConfig.Retention.MinFreeSpace)
-	}
+		// Trashed backups give way to the new one
+		if availableFreeSpace < app.BkpConfig.Retention.minFreeSpaceParsed && app.BkpConfig.Retention.UseTrash {
+			availableFreeSpace, err = app.purgeTrashForSpace(app.bkpDestFullPath, app.BkpConfig.Retention.minFreeSpaceParsed)
+			if err != nil {
+				return fmt.Errorf("purging trash: %w", err)
+			}
+			availableFreeSpaceFormatted = formatBytes(availableFreeSpace)
+			logger.Plain(fmt.Sprintf("Available free space after purging trash: %s\n", availableFreeSpaceFormatted))
+		}
+
+		if availableFreeSpace < app.BkpConfig.Retention.minFreeSpaceParsed {
+			return fmt.Errorf("available free space (%s) is less than required minimum (%s)", availableFreeSpaceFormatted, app.BkpConfig.Retention.MinFreeSpace)
+		}
 
-	logger.Plain(fmt.Sprintf("Backups to keep: %d\n", app.BkpConfig.Retention.BackupsToKeep))
+		logger.Plain(fmt.Sprintf("Backups to keep: %d\n", app.BkpConfig.Retention.BackupsToKeep))
+		checkClock(app.bkpDestFullPath)
+	}
+	key, err := app.signingKey()
+	if err != nil {
+		return err
+	}
+	logger.Plain(fmt.Sprintf("Manifest signing: %t\n", key != nil))
+	logger.Plain(fmt.Sprintf("Obfuscated names: %t\n", app.BkpConfig.ObfuscateNames))
+	if recipients := app.BkpConfig.Encryption.GPGRecipients; len(recipients) > 0 {
+		if !app.toStdout {
+			return fmt.Errorf("%q applies to '-to-stdout' archives only, directory backups would not be encrypted", "encryption.gpg_recipients")
+		}
+		if err := checkGPGRecipients(app.BkpConfig.Encryption); err != nil {
+			return err
+		}
+		logger.Plain(fmt.Sprintf("GPG recipients: %s\n", strings.Join(recipients, ", ")))
+	}
+	if app.BkpConfig.Compression != CompressionNone && !app.toStdout {
+		logger.Warn(fmt.Sprintf("%q applies to '-to-stdout' archives only, files are copied as they are.\n", "compression"))
+	}
+	if stages := app.streamStages(); app.toStdout && len(stages) > 0 {
+		logger.Plain(fmt.Sprintf("Stream pipeline: %s\n", describePipeline(stages)))
+	}
+	logger.Plain(fmt.Sprintf("Run ID: %s\n", app.runID))
 	logger.Plain(fmt.Sprintf("Non-interactive: %t\n", app.nonInteractive))
+	logger.Plain(fmt.Sprintf("Confirmed in advance: %t\n", app.assumeYes))
 	logger.Plain(fmt.Sprintf("Exit on error: %t\n", app.exitOnError))
 	logger.Plain("\n")
 
 	// Validate bkp_items
 	logger.Plain(fmt.Sprintf("Items to backup: %d\n", len(app.BkpConfig.BkpItems)))
+	if workers, reason := app.parallelItems(); reason != "" {
+		logger.Info(fmt.Sprintf("Items are backed up one by one (%q is ignored): %s.\n", "parallel_items", reason))
+	} else if workers > 1 {
+		logger.Plain(fmt.Sprintf("Parallel items: %d\n", workers))
+	}
+	if app.BkpConfig.CopyWhileScanning {
+		logger.Plain("Copy while scanning: true\n")
+	}
+	if app.BkpConfig.packSmallFilesParsed > 0 && !app.toStdout {
+		logger.Plain(fmt.Sprintf("Pack small files: up to %s\n", app.BkpConfig.PackSmallFiles))
+	}
 	if len(app.BkpConfig.BkpItems) == 0 {
 		logger.Warn("No items listed under 'bkp_items' in the config file, nothing to backup. Exiting.\n\n")
 		exitApp(app.nonInteractive, 0)
 	}
 
 	for i, item := range app.BkpConfig.BkpItems {
-		logger.Plain(fmt.Sprintf("\n  [%d] Source: %s\n", i+1, item.Source))
+		logger.Plain(fmt.Sprintf("\n  [%d] Source: %s\n", i+1, itemSourceLabel(item)))
 		logger.Plain(fmt.Sprintf("      Destination: %s\n", item.Destination))
 		if len(item.Include) > 0 {
 			logger.Plain(fmt.Sprintf("      Include: %v\n", strings.Join(item.Include, ", ")))
@@ -452,22 +1175,40 @@ func reviewBackupConfig(app *BackupApp) error {
 		if len(item.Exclude) > 0 {
 			logger.Plain(fmt.Sprintf("      Exclude: %v\n", strings.Join(item.Exclude, ", ")))
 		}
+		if len(item.After) > 0 {
+			logger.Plain(fmt.Sprintf("      After: %v\n", strings.Join(item.After, ", ")))
+		}
+		app.warnDestinationOverlap(item)
+		if rule := app.mtimeRule(item); rule.fat || rule.tolerance > 0 {
+			logger.Plain(fmt.Sprintf("      Mtime tolerance: %s\n", rule.describe()))
+		}
+		if item.MaxSize != "" {
+			action := item.MaxSizeAction
+			if action == "" {
+				action = MaxSizeAbort
+			}
+			logger.Plain(fmt.Sprintf("      Max size: %s (%s)\n", item.MaxSize, action))
+		}
 	}
 
-	// Non-Interactive mode: Skip user prompt and continue with backup
-	if app.nonInteractive {
+	// Detached drives are reported once, before the run
+	logger.Plain("\n")
+	if err := app.checkSources(); err != nil {
+		return err
+	}
+
+	// Non-Interactive mode or '-yes': Skip user prompt and continue with backup
+	if app.nonInteractive || app.assumeYes {
 		return nil
 	}
 
 	// Interactive mode: Prompt user for confirmation before running backup
-	logger.Info("\nProceed with backup? (only \"yes\" will be accepted to confirm)\n", style.NoLabel())
-	var response string
-	fmt.Scanln(&response)
-	response = strings.TrimSpace(strings.ToLower(response))
+	logger.Info("\n"+tr(msgProceedPrompt), style.NoLabel())
+	proceed := app.awaitAnswer(msgAnswerYes)
 	logger.Plain("\n")
 
-	if response != "yes" {
-		logger.Warn("Backup cancelled by user.\n\n")
+	if !proceed {
+		logger.Warn(tr(msgBackupCancelled))
         os.Exit(0)
 	}
 
@@ -481,187 +1222,293 @@ func reviewBackupConfig(app *BackupApp) error {
 // EXECUTE BACKUP
 func (app *BackupApp) runBackup() error {
 	startTime := time.Now()
-	timestamp := startTime.Format("20060102-150405")
+	timestamp := startTime.UTC().Format(BackupTimestampFormat)
+	app.startTime = startTime
 
 	logger.Signature(fmt.Sprintf("\n====  Backup started on: %s  ===\n", startTime.Format(time.RFC822)))
 
-	// Create backup directory
-	app.bkpDestFullPath = filepath.Join(app.bkpDestFullPath, fmt.Sprintf("%s-%s", Prefix, timestamp))
-	logger.Plain(fmt.Sprintf("Creating backup directory %q... ", app.bkpDestFullPath))
-	if err := os.MkdirAll(app.bkpDestFullPath, 0755); err != nil {
-		logger.Plain("\n")
-		return fmt.Errorf("creating backup directory: %w", err)
+	if err := app.startNameObfuscation(); err != nil {
+		return err
 	}
-	logger.Ok("\n")
-
-	// Copy backup items
-	var results []BackupResult
-	var failedCount int
-	var successCount int
-	var totalCount int
 
-	for i, item := range app.BkpConfig.BkpItems {
-		totalCount++
+	// Tampering with the destination is detected before anything else is done there
+	if !app.toStdout {
+		app.verifyCanary(app.bkpDestFullPath)
+	}
 
-		// Create log message for the item that is currently being backed up
-		cur_item_message := fmt.Sprintf("\n[%d/%d] Backing up: %s", i+1, len(app.BkpConfig.BkpItems), item.Source)
-		if len(item.Include) != 0 {
-			cur_item_message = cur_item_message + fmt.Sprintf("  (Include: %v)\n", strings.Join(item.Include, ", "))
-		} else {
-			cur_item_message = cur_item_message + fmt.Sprintf("  (Exclude: %v)\n", strings.Join(item.Exclude, ", "))
+	// Create backup directory (or start tar stream)
+	name := fmt.Sprintf("%s-%s", Prefix, timestamp)
+	if app.toStdout {
+		app.bkpDestFullPath = filepath.Join(app.bkpDestFullPath, name)
+		logger.Plain(fmt.Sprintf("Streaming backup %q to stdout... ", name))
+		if err := app.openTarStream(); err != nil {
+			logger.Plain("\n")
+			return fmt.Errorf("starting tar stream: %w", err)
 		}
-
-		// Fit the log message into the terminal
-		runes := []rune(cur_item_message)
-		if len(runes) >= getTerminalWidth() {
-			cur_item_message = string(runes[:(getTerminalWidth()-6)]) + "... )\n"
+		if err := app.makeDir(app.bkpDestFullPath, 0755); err != nil {
+			logger.Plain("\n")
+			return fmt.Errorf("starting tar stream: %w", err)
 		}
+	} else if app.resume != nil {
+		// Packed files have no index until the end, they are copied again
+		app.bkpDestFullPath = app.resume.backup.path
+		logger.Plain(fmt.Sprintf("Continuing in backup directory %q... ", app.bkpDestFullPath))
+		if err := os.RemoveAll(filepath.Join(app.bkpDestFullPath, ReportDirName, PackDirName)); err != nil {
+			logger.Plain("\n")
+			return fmt.Errorf("removing pack files of the interrupted run: %w", err)
+		}
+	} else {
+		path, err := createBackupDir(app.bkpDestFullPath, name)
+		if err != nil {
+			return fmt.Errorf("creating backup directory: %w", err)
+		}
+		app.bkpDestFullPath = path
+		logger.Plain(fmt.Sprintf("Creating backup directory %q... ", app.bkpDestFullPath))
+	}
 
-		// Log the message
-		logger.Plain(cur_item_message)
+	// Metadata is written upfront, so an interrupted run is recognized as partial backup
+	// (tar stream can't rewrite it, so it only gets the final version)
+	metadata := app.newMetadata()
+	if !app.toStdout {
+		if _, err := app.writeMetadata(metadata); err != nil {
+			logger.Plain("\n")
+			return err
+		}
+	}
+	logger.Ok("\n")
 
-		totalItems, err := app.countTotalItems(item)
-		if err != nil {
-			logger.Err(fmt.Sprintf("Failed to count items for backup: %v\n", err))
-			failedCount++
+	// Unchanged files are linked from the previous backup ('dedup: hardlink')
+	app.loadDedupBase()
 
-			// Record this failure in results so the summary and detailed output stay in sync.
-			result := BackupResult{
-				Item:    item,
-				Success: false,
-				Error:   err,
-				Elapsed: 0,
-			}
-			results = append(results, result)
-
-			if app.exitOnError {
-				if !app.nonInteractive {
-					logger.Warn("\n\"exitOnError\" is set to True. Exit now? (type \"no\" to continue execution)\n", style.NoLabel())
-					reader := bufio.NewReader(os.Stdin)
-					response, _ := reader.ReadString('\n')
-					response = strings.TrimSpace(strings.ToLower(response))
-					if response != "no" {
-						return fmt.Errorf("backup stopped (with user consent) due to error: %w", err)
-					}
-				} else {
-					return fmt.Errorf("backup stopped (\nexitOnError\n is True) due to error: %w", err)
-				}
-			}
+	// Copied files are hashed by a separate pool ('hash_workers')
+	app.startHashPool()
 
-			continue
+	// Large files are shared by copy workers ('copy_workers')
+	app.startRangeCopies()
+
+	// Files that need more privileges are skipped, not failed
+	app.checkPrivileges()
+
+	// Alternate data streams are copied along with files ('include_ads')
+	app.startADS()
+
+	// Small files are appended to pack files ('pack_small_files')
+	app.startPacking()
+
+	// Manifest entries go to disk right away ('max_memory')
+	if err := app.startManifestSpool(); err != nil {
+		return err
+	}
+
+	// Copied files are journaled, so the run can be continued if interrupted ('--continue')
+	if err := app.startJournal(); err != nil {
+		return err
+	}
+	defer app.closeJournal()
+
+	// Computer doesn't go to sleep in the middle of the run ('power.inhibit_sleep')
+	defer app.preventSleep()()
+
+	// Remote sessions are reused across items and closed when the run is over
+	defer app.closeRemoteClients()
+
+	// Copy backup items (several at a time with 'parallel_items')
+	results, err := app.runItems()
+	if err != nil {
+		return err
+	}
+	var failedCount int
+	var successCount int
+	var missingCount int
+	totalCount := len(results)
+	for _, result := range results {
+		if result.Missing {
+			missingCount++
+		} else if result.Success {
+			successCount++
+		} else {
+			failedCount++
 		}
+	}
 
-		var processedItems int
-		lastUpdate := -1
-
-		progressCb := func() {
-			processedItems++
-			if totalItems > 0 {
-				percentage := int(float64(processedItems) * 100 / float64(totalItems))
-				if percentage > lastUpdate {
-					progressBarLength := 50
-					completed := int(float64(percentage) / 100.0 * float64(progressBarLength))
-					remaining := progressBarLength - completed
-					if remaining < 0 {
-						remaining = 0
-					}
-					progressBar := strings.Repeat("■", completed) + strings.Repeat(".", remaining)
-					// logger.Plain(fmt.Sprintf("\r[%s]", progressBar)) # Using standard print to show incomplete progress bar in console only to avoid cluttering of log file
-					fmt.Printf("\r[%s]", progressBar)
-					lastUpdate = percentage
-				}
-			}
+	// Manifest and changes summary need checksums of all copied files
+	app.finishHashing()
+
+	// Pack index lists the packed files
+	if err := app.finishPacking(); err != nil {
+		logger.Err(fmt.Sprintf("Failed to finish pack files: %v\n", err))
+		failedCount++
+	}
+
+	// Make sure directory entries reached the disk
+	if !app.toStdout && app.BkpConfig.Durability == DurabilityFsync {
+		if err := app.syncDirs(); err != nil {
+			logger.Err(fmt.Sprintf("Failed to flush backup directories to disk: %v\n", err))
+			failedCount++
 		}
+	}
 
-		itemStart := time.Now()
+	totalElapsed := time.Since(startTime)
 
-		err = app.backupItem(item, progressCb)
-		elapsed := time.Since(itemStart)
+	// Prepare summary
+	var summary []summaryLine
+	addSummary := func(print func(string, ...style.Option), msg string, opts ...style.Option) {
+		summary = append(summary, summaryLine{print: print, msg: msg, opts: opts})
+	}
 
-		result := BackupResult{
-			Item:    item,
-			Success: err == nil,
-			Error:   err,
-			Elapsed: elapsed,
+	addSummary(logger.Signature, "\n===============  Backup  Summary  ===============\n")
+	addSummary(logger.Plain, "Backup destination: ")
+	if app.toStdout {
+		addSummary(logger.Info, fmt.Sprintf("<stdout>: %s\n", filepath.Base(app.bkpDestFullPath)), style.NoLabel())
+	} else {
+		addSummary(logger.Info, fmt.Sprintf("%s\n", app.bkpDestFullPath), style.NoLabel())
+	}
+	addSummary(logger.Plain, fmt.Sprintf("Run ID: %s\n", app.runID))
+	if app.span != nil {
+		addSummary(logger.Plain, fmt.Sprintf("Volume: %d of %d\n", app.span.Volume, app.span.Volumes))
+	}
+	addSummary(logger.Plain, fmt.Sprintf("Total time: %s\n", formatDurationSeconds(totalElapsed)))
+	addSummary(logger.Plain, fmt.Sprintf("Total items: %d\n", totalCount))
+	addSummary(logger.Plain, fmt.Sprintf("Successful: %d\n", successCount))
+	addSummary(logger.Plain, fmt.Sprintf("Failed: %d\n", failedCount))
+	if missingCount > 0 {
+		addSummary(logger.Plain, fmt.Sprintf("Skipped (source not found): %d\n", missingCount))
+	}
+	if len(app.placeholders) > 0 {
+		addSummary(logger.Plain, fmt.Sprintf("Cloud placeholders: %d (%s)\n", len(app.placeholders), app.BkpConfig.CloudPlaceholders))
+	}
+	if inaccessible := app.inaccessibleCount(); inaccessible > 0 {
+		addSummary(logger.Plain, fmt.Sprintf("Inaccessible (skipped): %d\n", inaccessible))
+		if !isElevated() {
+			addSummary(logger.Warn, fmt.Sprintf("Run with '--elevate' (as %s) to include inaccessible files.\n", privilegedUser()))
 		}
-		results = append(results, result)
+	}
+	for _, line := range app.problemSummary() {
+		addSummary(logger.Plain, line)
+	}
 
-		if err != nil {
-			failedCount++
-			if errors.Is(err, os.ErrNotExist) {
-				logger.Err(fmt.Sprintf("\n❌ %v\n", err), style.NoLabel())
-			} else {
-				logger.Err(fmt.Sprintf("\n❌ (%s): %v\n", formatDurationSeconds(elapsed), err), style.NoLabel())
+	if failedCount != 0 {
+		addSummary(logger.Plain, "\n")
+		addSummary(logger.Err, fmt.Sprintf("Backup completed with %d failures\n", failedCount))
+	}
+
+	// Compared by manifests, so the tar stream (nothing to read back) is not compared
+	if !app.toStdout {
+		if changes, ok := app.changesSincePrevious(); ok {
+			addSummary(logger.Signature, fmt.Sprintf("\nChanges since %s\n", changes.since))
+			addSummary(logger.Plain, fmt.Sprintf("Added: %d, Removed: %d, Modified: %d\n", len(changes.added), len(changes.removed), len(changes.modified)))
+			for _, line := range changes.topChanges(SummaryTopChanges) {
+				addSummary(logger.Plain, fmt.Sprintf("  %s\n", line))
 			}
+		}
+	}
 
-			if app.exitOnError {
-				if !app.nonInteractive {
-					logger.Warn("\n\"exitOnError\" is set to True. Exit now? (type \"no\" to continue execution)\n", style.NoLabel())
-					reader := bufio.NewReader(os.Stdin)
-					response, _ := reader.ReadString('\n')
-					response = strings.TrimSpace(strings.ToLower(response))
-					if response != "no" {
-						return fmt.Errorf("backup stopped due to error: %w", err)
-					}
-				} else {
-					return fmt.Errorf("backup stopped due to error: %w", err)
-				}
+	addSummary(logger.Signature, "\nDetailed Results\n")
+	table := style.NewTable("#", "", "Item", "Files", "Size", "Time", "Details").AlignRight(0, 3, 4)
+	var totalFiles int
+	var totalBytes int64
+	for i, result := range results {
+		status := logger.Icon("✅", "[OK]")
+		details := ""
+		switch {
+		case !result.Success:
+			status = logger.Icon("❌", "[FAILED]")
+			details = result.Error.Error()
+		case result.Missing:
+			status = logger.Icon("⏭️", "[SKIPPED]")
+			details = "source not found"
+		case result.Truncated != "":
+			status = logger.Icon("⚠️", "[PARTIAL]")
+			details = "copied partially: " + result.Truncated
+		}
+		if share := result.copiedShare(); share < 100 && !isStreamItem(result.Item) {
+			copied := fmt.Sprintf("%.1f%% of files copied", share)
+			if result.FilesSkipped > 0 {
+				copied += fmt.Sprintf(", %d inaccessible", result.FilesSkipped)
 			}
-		} else {
-			// Successful backup for this item.
-			successCount++
-			progressBarLength := 50
-			progressBar := strings.Repeat("■", progressBarLength)
-			logger.Plain(fmt.Sprintf("\r[%s] ", progressBar))
-			logger.Ok(fmt.Sprintf(" (%s)\n", formatDurationSeconds(result.Elapsed)))
+			details = strings.TrimPrefix(details+"; "+copied, "; ")
+		}
+		if result.FilesChanged > 0 {
+			details = strings.TrimPrefix(details+fmt.Sprintf("; %d changed while copied", result.FilesChanged), "; ")
 		}
+		size := formatBytes(uint64(result.Bytes))
+		if isStreamItem(result.Item) {
+			size = "-"
+		}
+		table.Row(fmt.Sprint(i+1), status, itemSourceLabel(result.Item), fmt.Sprint(result.Files), size, formatDurationSeconds(result.Elapsed), details)
+		totalFiles += result.Files
+		totalBytes += result.Bytes
+	}
+	table.Totals("", "", "Total", fmt.Sprint(totalFiles), formatBytes(uint64(totalBytes)), formatDurationSeconds(totalElapsed))
+	addSummary(logger.Plain, table.Render())
+
+	// Real cost of the backup, when unchanged files are linked rather than copied
+	if app.dedup != nil {
+		addSummary(logger.Signature, "\nDeduplication\n")
+		dedupTable := style.NewTable("Item", "Linked files", "Linked", "Copied files", "Stored").AlignRight(1, 2, 3, 4)
+		var totalLinked int64
+		var totalLinkedFiles int
+		for _, result := range results {
+			dedupTable.Row(itemSourceLabel(result.Item), fmt.Sprint(result.LinkedFiles), formatBytes(uint64(result.Linked)), fmt.Sprint(result.Files-result.LinkedFiles), formatBytes(uint64(result.Bytes-result.Linked)))
+			totalLinked += result.Linked
+			totalLinkedFiles += result.LinkedFiles
+		}
+		dedupTable.Totals("Total", fmt.Sprint(totalLinkedFiles), formatBytes(uint64(totalLinked)), fmt.Sprint(totalFiles-totalLinkedFiles), formatBytes(uint64(totalBytes-totalLinked)))
+		addSummary(logger.Plain, dedupTable.Render())
 	}
 
-	totalElapsed := time.Since(startTime)
+	// Nothing is left to continue
+	app.finishJournal()
+
+	// Save metadata and summary, and mark the backup complete
+	metadata.finish(results, failedCount == 0)
+	if err := app.finalizeBackup(metadata, summary); err != nil {
+		logger.Err(fmt.Sprintf("Failed to finalize backup: %v\n", err))
+		failedCount++
+	} else if app.BkpConfig.ReadOnly && !app.toStdout {
+		if err := lockBackup(app.bkpDestFullPath, app.BkpConfig.immutableBackups()); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to make backup read-only: %v\n", err))
+		}
+	}
+
+	// Finalize tar stream
+	if app.toStdout {
+		if err := app.closeTarStream(); err != nil {
+			logger.Err(fmt.Sprintf("Failed to finalize tar stream: %v\n", err))
+			failedCount++
+		}
+	}
 	
-	// Cleanup old backups
-	if failedCount == 0 {
+	// Cleanup old backups (retention does not apply to tar stream)
+	if app.toStdout {
+		// nothing to clean up
+	} else if failedCount == 0 {
 		app.cleanupOldBackups()
 	} else {
 		if app.nonInteractive {
-			logger.Warn("Backup failed for some items; skipping cleanup of old backups in non-interactive mode.\n")
+			logger.Warn(tr(msgCleanupSkippedNI))
 		} else {
 			logger.Plain("\n")
-			logger.Warn("Backup failed for some items.\n")
-			logger.Warn("Cleanup old backups now? (only \"yes\" will be accepted to confirm)\n", style.NoLabel())
-			reader := bufio.NewReader(os.Stdin)
-			response, _ := reader.ReadString('\n')
-			response = strings.TrimSpace(strings.ToLower(response))
-			if response == "yes" {
+			logger.Warn(tr(msgItemsFailed))
+			logger.Warn(tr(msgCleanupPrompt), style.NoLabel())
+			if app.awaitAnswer(msgAnswerYes) {
 				app.cleanupOldBackups()
 			} else {
-				logger.Warn("Skipping cleanup of old backups.\n", style.NoLabel())
+				logger.Warn(tr(msgCleanupSkipped), style.NoLabel())
 			}
 		}
 	}
 
-	// Print summary
-	logger.Signature("\n===============  Backup  Summary  ===============\n")
-	logger.Plain("Backup destination: ")
-	logger.Info(fmt.Sprintf("%s\n", app.bkpDestFullPath), style.NoLabel())
-	// logger.Plain(fmt.Sprintf("Backup destination: %v\n", app.bkpDestFullPath))
-	logger.Plain(fmt.Sprintf("Total time: %s\n", formatDurationSeconds(totalElapsed)))
-	logger.Plain(fmt.Sprintf("Total items: %d\n", totalCount))
-	logger.Plain(fmt.Sprintf("Successful: %d\n", successCount))
-	logger.Plain(fmt.Sprintf("Failed: %d\n", failedCount))
-
-	if failedCount != 0 {
-		logger.Plain("\n")
-		logger.Err(fmt.Sprintf("Backup completed with %d failures\n", failedCount))
+	// Index the new backup (and forget removed ones) for 'find'
+	if !app.toStdout {
+		if err := refreshCatalog(filepath.Dir(app.bkpDestFullPath)); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to update catalog: %v\n", err))
+		}
 	}
 
-	logger.Signature("\nDetailed Results\n")
-	for i, result := range results {
-		status := "✅"
-		if !result.Success {
-			status = "❌"
-		}
-		logger.Plain(fmt.Sprintf("[%d] %s %s (%s)\n", i+1, status, result.Item.Source, formatDurationSeconds(result.Elapsed)))
+	// Print summary
+	for _, line := range summary {
+		line.print(line.msg, line.opts...)
 	}
 
 	if failedCount > 0 {
@@ -672,243 +1519,726 @@ func (app *BackupApp) runBackup() error {
 }
 
 
-// BACKUP EACH INDIVIDUAL ITEM
-func (app *BackupApp) backupItem(item BackupItem, progressCb func()) error {
-	srcPath := item.Source
-	destPath := filepath.Join(app.bkpDestFullPath, item.Destination)
+// CREATE TIMESTAMPED BACKUP DIRECTORY UNDER BACKUP ROOT
+// If the name is taken (e.g. two runs within the same second), suffixes "-1", "-2", ... are tried.
+// The directory is created atomically, so concurrent runs never share it.
+func createBackupDir(backupRoot, name string) (string, error) {
+	if err := os.MkdirAll(backupRoot, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(backupRoot, name)
+	for i := 1; i <= MaxBackupNameCollisions; i++ {
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		path = filepath.Join(backupRoot, fmt.Sprintf("%s-%d", name, i))
+	}
+	return "", fmt.Errorf("%q and %d more names with suffixes are already taken", name, MaxBackupNameCollisions)
+}
+
+
+// BACK UP ITEM OF THE RUN
+// Prints the item header and outcome, and records paths that were not copied. Failure of the item is
+// in the result; the returned error is fatal for the run (credentials of 'run_as' can't be restored).
+func (app *BackupApp) runItem(index int, item BackupItem) (BackupResult, error) {
+	app.counters.itemsStarted.Add(1)
+	defer app.counters.itemsDone.Add(1)
+
+	label := fmt.Sprintf("[%d/%d]", index+1, len(app.BkpConfig.BkpItems))
+	prefix := "" // messages of items backed up in parallel are told apart by the item number
+	if app.board != nil {
+		prefix = label + " "
+	}
+
+	// Create log message for the item that is currently being backed up
+	cur_item_message := fmt.Sprintf("\n%s Backing up: %s", label, itemSourceLabel(item))
+	if len(item.Include) != 0 {
+		cur_item_message = cur_item_message + fmt.Sprintf("  (Include: %v)\n", strings.Join(item.Include, ", "))
+	} else {
+		cur_item_message = cur_item_message + fmt.Sprintf("  (Exclude: %v)\n", strings.Join(item.Exclude, ", "))
+	}
+
+	// Fit the log message into the terminal (width is re-checked for every item, in case it was resized)
+	runes := []rune(cur_item_message)
+	if width := getTerminalWidth(); len(runes) >= width && width > 6 {
+		cur_item_message = string(runes[:(width-6)]) + "... )\n"
+	}
+
+	// Log the message
+	logger.Plain(cur_item_message)
 
-	// Check if source is a file or directory
-	srcInfo, err := os.Stat(srcPath)
+	// Source was not found before the run ('missing_source: skip')
+	if item.missing {
+		logger.Warn(fmt.Sprintf("%sSource not found, item is skipped.\n", prefix))
+		app.addSkipped(skippedEntry{path: itemSourceLabel(item), reason: "missing: source not found", label: isStreamItem(item)})
+		return BackupResult{Item: item, Success: true, Missing: true}, nil
+	}
+
+	// Item is read from a snapshot of its volume ('snapshot'), with credentials of its 'run_as' user
+	source, releaseSnapshot := app.snapshotItem(item, index)
+	restoreUser, err := app.runAsItemUser(source)
+	var work *workList
+	var scanned <-chan error // set if the item is copied while it's being enumerated ('copy_while_scanning')
+	var progress *progress
+	if err == nil {
+		if resumed := app.resumedWork(index, source); resumed != nil {
+			work = resumed
+		} else if root := app.scanAheadRoot(source); root != nil {
+			progress = app.startItemProgress(label, item, 0)
+			work, scanned = app.enumerateAhead(source, root, func(entry workEntry) {
+				progress.grow()
+				if !entry.info.IsDir() {
+					app.counters.counted(1, entry.info.Size())
+				}
+			})
+		} else {
+			work, err = app.enumerateItem(source)
+		}
+	}
+	if err == nil && scanned == nil {
+		app.itemMu.Lock()
+		err = app.applyMaxSize(item, work)
+		app.itemMu.Unlock()
+	}
 	if err != nil {
-		return fmt.Errorf("accessing source path: %w", err)
+		if err := restoreUser(); err != nil {
+			return BackupResult{}, fmt.Errorf("restoring credentials after item: %w", err)
+		}
+		releaseSnapshot()
+		if errors.Is(err, errItemTooLarge) {
+			logger.Err(fmt.Sprintf("%sItem is not copied: %v\n", prefix, err))
+		} else {
+			logger.Err(fmt.Sprintf("%sFailed to count items for backup: %v\n", prefix, err))
+		}
+		app.addSkipped(skippedEntry{path: itemSourceLabel(item), reason: "failed: " + err.Error(), label: isStreamItem(item)})
+
+		// Record this failure in results so the summary and detailed output stay in sync.
+		return BackupResult{Item: item, Success: false, Error: err}, nil
 	}
 
-	if srcInfo.IsDir() {
-		if err := os.MkdirAll(destPath, srcInfo.Mode()); err != nil {
-			return fmt.Errorf("creating destination directory: %w", err)
+	if scanned == nil {
+		app.counters.counted(work.files, work.bytes)
+		app.reportEnumeration(prefix, work)
+		progress = app.startItemProgress(label, item, work.total())
+	}
+
+	itemStart := time.Now()
+
+	err = app.backupItem(source, work, progress.itemDone)
+	if scanned != nil {
+		err = finishScanning(work, scanned, err)
+	}
+	if err := restoreUser(); err != nil {
+		return BackupResult{}, fmt.Errorf("restoring credentials after item: %w", err)
+	}
+	releaseSnapshot()
+	elapsed := time.Since(itemStart)
+	progress.clear()
+	if app.board == nil {
+		app.progress = nil
+	}
+	if scanned != nil {
+		app.reportEnumeration(prefix, work)
+	}
+
+	result := BackupResult{
+		Item:         item,
+		Success:      err == nil,
+		Error:        err,
+		Truncated:    work.truncated,
+		Files:        work.files,
+		Bytes:        work.bytes,
+		Linked:       work.linked.Load(),
+		LinkedFiles:  int(work.linkedFiles.Load()),
+		FilesCopied:  int(work.copiedFiles.Load()),
+		FilesSkipped: int(work.skippedFiles.Load()),
+		FilesFailed:  int(work.failedFiles.Load()),
+		FilesChanged: int(work.changedFiles.Load()),
+		BytesCopied:  work.copiedBytes.Load(),
+		Elapsed:      elapsed,
+	}
+	if isStreamItem(item) && err == nil {
+		result.FilesCopied = 1 // a stream is not read through copyEntry
+	}
+
+	if err != nil {
+		app.addSkipped(skippedEntry{path: itemSourceLabel(item), reason: "failed: " + err.Error(), label: isStreamItem(item)})
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Err(fmt.Sprintf("\n%s%s %v\n", prefix, logger.Icon("❌", "[FAILED]"), err), style.NoLabel())
+		} else {
+			logger.Err(fmt.Sprintf("\n%s%s (%s): %v\n", prefix, logger.Icon("❌", "[FAILED]"), formatDurationSeconds(elapsed), err), style.NoLabel())
 		}
-		return app.copyDirectory(srcPath, destPath, item.Include, item.Exclude, progressCb)
+		return result, nil
+	}
+
+	// Successful backup for this item.
+	if app.board != nil {
+		logger.Ok(fmt.Sprintf("%s%s (%s)\n", prefix, itemSourceLabel(item), formatDurationSeconds(elapsed)))
 	} else {
-		return app.copyFile(srcPath, destPath, progressCb)
+		logger.Plain(fmt.Sprintf("\r[%s] ", progressBar(100, getTerminalWidth()-3)))
+		logger.Ok(fmt.Sprintf(" (%s)\n", formatDurationSeconds(result.Elapsed)))
 	}
+	return result, nil
 }
 
 
-// COUNT TOTAL NUMBER OF ITEMS TO BACKUP
-func (app *BackupApp) countTotalItems(item BackupItem) (int, error) {
-	var totalItems int
-	srcInfo, err := os.Stat(item.Source)
-	if err != nil {
-		return 0, err
+// reportEnumeration prints what the enumeration of the item found and records skipped entries.
+func (app *BackupApp) reportEnumeration(prefix string, work *workList) {
+	if work.queued && app.resume != nil {
+		logger.Sub(fmt.Sprintf("%sQueued by the interrupted run: %d files, %d of them copied already\n", prefix, work.files, work.resumed))
+	} else if len(work.entries) > 1 || work.feed != nil {
+		logger.Sub(fmt.Sprintf("%sFound %d files, %d directories, %s (%s)\n", prefix, work.files, work.dirs, formatBytes(uint64(work.bytes)), formatDurationSeconds(work.elapsed)))
 	}
 
-	if !srcInfo.IsDir() {
-		return 1, nil // A single file
+	app.checkMemoryEstimate(work)
+	app.itemMu.Lock()
+	app.reportPlaceholders(work)
+	app.itemMu.Unlock()
+	app.reportDatabases(work)
+	app.addSkipped(work.skipped...)
+	for _, skipped := range work.skipped {
+		logger.Verbose(fmt.Sprintf("  Skipped %s (%s)\n", skipped.path, skipped.reason))
 	}
+	if work.truncated != "" {
+		logger.Warn(fmt.Sprintf("%sItem will be copied partially: %s\n", prefix, work.truncated))
+	}
+}
 
-	err = filepath.Walk(item.Source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if isWindowsProtectedPath(path, err) {
-				return nil
-			}
-			return err
-		}
 
-		relPath, err := filepath.Rel(item.Source, path)
-		if err != nil {
-			return err
+// startItemProgress returns the progress status line of the item, shared by items backed up in parallel.
+func (app *BackupApp) startItemProgress(label string, item BackupItem, total int) *progress {
+	progress := newProgress(total, app.BkpConfig.progressIntervalParsed)
+	if app.board != nil {
+		progress.label, progress.root = label, app.destPath(item.Destination)
+		app.board.add(progress)
+	} else {
+		app.progress = progress
+	}
+	return progress
+}
+
+
+// BACKUP EACH INDIVIDUAL ITEM
+func (app *BackupApp) backupItem(item BackupItem, work *workList, progressCb func()) error {
+	destPath := app.destPath(item.Destination)
+
+	// Streams are written into a single destination file
+	if isStreamItem(item) {
+		return app.backupStreamItem(item, destPath, progressCb)
+	}
+
+	// Source is a single file
+	if !work.root.IsDir() {
+		return app.copyEntry(work, work.entries[0], destPath, progressCb)
+	}
+
+	if err := app.makeDir(destPath, work.root.Mode().Perm()|0700); err != nil {
+		return fmt.Errorf("creating destination directory: %w", err)
+	}
+	if err := app.copyDirAttributes(destPath, work.root); err != nil {
+		return err
+	}
+	return app.copyEntries(work, item.Destination, progressCb)
+}
+
+
+// COPY DIRECTORY ENTRIES FROM WORK LIST
+// Directories and symlinks are created first (in walk order), then files are copied by workers.
+// 'dest' is the item destination, relative to the backup directory.
+func (app *BackupApp) copyEntries(work *workList, dest string, progressCb func()) error {
+	// Tar stream is sequential by nature
+	workers := int(app.BkpConfig.CopyWorkers)
+	if app.tarOut != nil || workers < 1 {
+		workers = 1
+	}
+	if work.feed != nil {
+		return app.copyFeed(work, dest, workers, progressCb)
+	}
+
+	var files []workEntry
+	for _, entry := range work.entries {
+		if entry.linkTarget != "" || entry.info.IsDir() {
+			if err := app.createEntry(entry, app.destPath(filepath.Join(dest, entry.relPath)), progressCb); err != nil {
+				return err
+			}
+			continue
 		}
+		files = append(files, entry)
+	}
+	if app.ranges == nil {
+		workers = min(workers, max(len(files), 1))
+	}
+	sortCopyQueue(files, app.BkpConfig.CopyOrder)
+	app.writeQueue(work, files)
 
-		if relPath == "." {
-			return nil
+	if workers == 1 {
+		for _, entry := range files {
+			if err := app.copyEntry(work, entry, app.destPath(filepath.Join(dest, entry.relPath)), progressCb); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		if !app.shouldInclude(relPath, item.Include, item.Exclude) {
-			if info.IsDir() {
-				return filepath.SkipDir
+	// Copy files concurrently, stop dispatching on the first error
+	queue := make(chan workEntry)
+	errs := make(chan error, workers)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer handlePanic()
+			defer wg.Done()
+			for entry := range queue {
+				if err := app.copyEntry(work, entry, app.destPath(filepath.Join(dest, entry.relPath)), progressCb); err != nil {
+					errs <- err
+					stopOnce.Do(func() { close(stop) })
+					return
+				}
 			}
-			return nil
+			// No files left, take ranges of large files other workers are copying
+			for app.ranges.help(app) {
+			}
+		}()
+	}
+
+dispatch:
+	for _, entry := range files {
+		select {
+		case queue <- entry:
+		case <-stop:
+			break dispatch
 		}
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+
+	return <-errs // nil if channel is empty
+}
 
-		totalItems++
-		return nil
-	})
 
-	return totalItems, err
+// CREATE DIRECTORY OR SYMLINK TO DIRECTORY ENTRY
+func (app *BackupApp) createEntry(entry workEntry, destPath string, progressCb func()) error {
+	// Symlink to a directory, recreate the symlink
+	if entry.linkTarget != "" {
+		return app.makeSymlink(entry.linkTarget, destPath)
+	}
+
+	// It's a directory, create it
+	if err := app.makeDir(destPath, entry.info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	if err := app.copyDirAttributes(destPath, entry.info); err != nil {
+		return err
+	}
+	progressCb()
+	return nil
 }
 
 
-// COPY DIRECTORY
-func (app *BackupApp) copyDirectory(src, dest string, include, exclude []string, progressCb func()) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if isWindowsProtectedPath(path, err) {
-				return nil
-			}
-			return err
+// COPY SINGLE FILE ENTRY (local or remote)
+func (app *BackupApp) copyEntry(work *workList, entry workEntry, dest string, progressCb func()) error {
+	app.waitForPower()
+	start := time.Now()
+	if app.linkUnchanged(work, entry, dest) {
+		work.fileCopied(entry.info.Size())
+		app.counters.fileDone(entry.info.Size())
+		progressCb()
+		if logger.Enabled(style.LevelDebug) {
+			logger.Debug(fmt.Sprintf("Linked %s -> %s (%d bytes, unchanged)\n", entry.path, dest, entry.info.Size()))
 		}
+		return nil
+	}
 
-		// Calculate relative path
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
+	var err error
+	if work.remote != nil {
+		err = app.copyRemoteFile(work.remote, entry.path, dest, progressCb)
+	} else if copied, sqliteErr := app.copySQLite(work, entry, dest); copied {
+		err = sqliteErr
+		if err == nil {
+			progressCb()
 		}
-
-		// Skip root directory
-		if relPath == "." {
-			return nil
+	} else {
+		var changed bool
+		if changed, err = app.copyFile(entry.path, dest, progressCb); changed {
+			work.changedFiles.Add(1)
 		}
+	}
 
-		// Check include/exclude patterns
-		if !app.shouldInclude(relPath, include, exclude) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
+	if logger.Enabled(style.LevelDebug) {
+		if err != nil {
+			logger.Debug(fmt.Sprintf("Failed %s: %v (%s)\n", entry.path, err, time.Since(start)))
+		} else {
+			logger.Debug(fmt.Sprintf("Copied %s -> %s (%d bytes, %s)\n", entry.path, dest, entry.info.Size(), time.Since(start)))
 		}
+	}
 
-		destPath := filepath.Join(dest, relPath)
+	// Files that can't be read for lack of permissions don't fail the item
+	if isInaccessible(err, entry.path) {
+		logger.Verbose(fmt.Sprintf("  Skipped %s (%s)\n", entry.path, SkipInaccessible))
+		app.skipInaccessible(entry.path, err)
+		work.skippedFiles.Add(1)
+		app.counters.fileDone(entry.info.Size())
+		progressCb()
+		return nil
+	}
+	if err != nil {
+		work.failedFiles.Add(1)
+		return err
+	}
+	work.fileCopied(entry.info.Size())
+	app.counters.fileDone(entry.info.Size())
+	return nil
+}
 
-		// If it's a directory, create it
-		if info.IsDir() {
-			err := os.MkdirAll(destPath, info.Mode())
-			if err == nil {
-				progressCb()
-			}
+
+// APPLY DISPLAY SETTINGS TO CONSOLE OUTPUT
+// Command-line options take precedence over 'display' config block.
+func (app *BackupApp) applyDisplay(theme, verbosity string, noEmoji bool) error {
+	display := app.BkpConfig.Display
+	if theme == "" {
+		theme = display.Theme
+	}
+	if verbosity == "" {
+		verbosity = display.Verbosity
+	}
+
+	if theme != "" {
+		if err := logger.SetTheme(theme); err != nil {
+			return err
+		}
+	}
+	if verbosity != "" {
+		level, err := style.ParseLevel(verbosity)
+		if err != nil {
 			return err
 		}
+		logger.SetVerbosity(level)
+	}
+	logger.SetEmoji(!noEmoji && (display.Emoji == nil || *display.Emoji))
+	logger.SetShowSub(display.ShowSub == nil || *display.ShowSub)
+	return nil
+}
 
-		// Handle symlinks
-		if info.Mode()&os.ModeSymlink != 0 {
-			// Check what the symlink points to
-			stat, err := os.Stat(path) // This follows the symlink
-			if err != nil {
-				return err
-			}
-			if stat.IsDir() {
-				// It's a symlink to a directory. Recreate the symlink.
-				target, err := os.Readlink(path)
-				if err != nil {
-					return err
-				}
-				return os.Symlink(target, destPath)
-			}
-			// It's a symlink to a file, fall through to copyFile
+
+// ADD INCLUDE/EXCLUDE PATTERNS FROM FILES TO EVERY ITEM (stream items have no patterns)
+func (app *BackupApp) addPatternsFromFiles(includeFile, excludeFile string) error {
+	var include, exclude []string
+	var err error
+	if includeFile != "" {
+		if include, err = readPatternFile(includeFile); err != nil {
+			return fmt.Errorf("reading include patterns: %w", err)
 		}
+	}
+	if excludeFile != "" {
+		if exclude, err = readPatternFile(excludeFile); err != nil {
+			return fmt.Errorf("reading exclude patterns: %w", err)
+		}
+	}
+	if _, err := compilePatterns(include, exclude); err != nil {
+		return err
+	}
 
-		// It's a regular file or a symlink to a file
-		return app.copyFile(path, destPath, progressCb)
-	})
+	for i := range app.BkpConfig.BkpItems {
+		item := &app.BkpConfig.BkpItems[i]
+		if isStreamItem(*item) {
+			continue
+		}
+		item.Include = append(item.Include, include...)
+		item.Exclude = append(item.Exclude, exclude...)
+	}
+	return nil
+}
+
+
+// REMOVE OLDEST BACKUP(S) AFTER BACKUP RUN
+func (app *BackupApp) cleanupOldBackups() error {
+	return app.cleanupBackups(filepath.Dir(app.bkpDestFullPath), app.bkpDestFullPath, false)
 }
 
 
-// COPY FILE
-func (app *BackupApp) copyFile(src, dest string, progressCb func()) error {
-	// Ensure destination directory exists
-	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+// REMOVE OLD BACKUPS FROM BACKUP ROOT
+// The deletion plan is printed before anything is removed. With 'dryRun', nothing is removed.
+// Removing more than 'retention.confirm_above' backups in one pass requires confirmation,
+// and is skipped in non-interactive mode.
+func (app *BackupApp) cleanupBackups(backupRoot, current string, dryRun bool) error {
+	// Altered canary means backups may be encrypted, removing older ones would destroy the good copies
+	if err := app.canaryGuard(backupRoot); err != nil {
+		logger.Err(fmt.Sprintf("Cleanup refused: %v (see %q).\n", err, filepath.Join(backupRoot, CanaryFileName)), style.Bold())
 		return err
 	}
 
-	srcFile, err := os.Open(src)
+	backups, err := listBackups(backupRoot)
 	if err != nil {
+		logger.Err(fmt.Sprintf("Cleanup failed with error: %s\n", err))
 		return err
 	}
-	defer srcFile.Close()
 
-	destFile, err := os.Create(dest)
-	if err != nil {
-		return err
+	if app.BkpConfig.Retention.UseTrash && !dryRun {
+		app.purgeExpiredTrash(backupRoot)
 	}
-	defer destFile.Close()
 
-	_, err = destFile.ReadFrom(srcFile)
-	if err != nil {
-		return err
+	plan := planCleanup(backups, current, int(app.BkpConfig.Retention.BackupsToKeep))
+	plan = guardLastComplete(backups, plan)
+	if len(plan) == 0 {
+		if dryRun {
+			logger.Info("Nothing to clean up.\n")
+		}
+		return nil
 	}
 
-	progressCb()
+	logger.Plain("\nCleanup\n")
+	printDeletionPlan(plan)
+	if app.BkpConfig.Retention.UseTrash {
+		logger.Plain(fmt.Sprintf("Backups will be moved to trash and purged after %s.\n", app.BkpConfig.Retention.TrashGracePeriod))
+	}
 
-	// Copy file permissions
-	srcInfo, err := srcFile.Stat()
-	if err != nil {
-		return err
+	if dryRun {
+		logger.Info("Dry run, nothing was removed.\n")
+		return nil
 	}
 
-	return os.Chmod(dest, srcInfo.Mode())
+	confirmAbove := int(app.BkpConfig.Retention.ConfirmAbove)
+	if confirmAbove > 0 && len(plan) > confirmAbove {
+		if app.nonInteractive {
+			logger.Warn(fmt.Sprintf("%d backups would be removed, which is more than %q (%d); skipping cleanup in non-interactive mode.\n", len(plan), "confirm_above", confirmAbove))
+			return nil
+		}
+		logger.Warn(tr(msgRemoveBackupsPrompt, len(plan)), style.NoLabel())
+		if !app.awaitAnswer(msgAnswerYes) {
+			logger.Warn(tr(msgCleanupSkipped), style.NoLabel())
+			return nil
+		}
+	}
+
+	for _, backup := range plan {
+		if err := app.removeBackup(backupRoot, backup); err != nil {
+			logger.Err(fmt.Sprintf("Failed to remove old backup: %s (%v)\n", backup.path, err))
+		}
+	}
+
+	return nil
 }
 
 
-// EVALUATE INCLUDE/EXCLUDE PATTERNS
-func (app *BackupApp) shouldInclude(path string, include, exclude []string) bool {
-	// If there are include patterns, check if path matches any
-	if len(include) > 0 {
-		included := false
-		for _, pattern := range include {
-			if matched, _ := filepath.Match(pattern, path); matched {
-				included = true
-				break
+// planCleanup selects backups to remove from the list sorted from newest to oldest.
+// Only complete backups (and legacy ones, created before completion markers) count towards 'keep'.
+// Partial backups are removed once a newer complete backup exists, unless another run may still be writing them
+// (see partialInUse); backups of failed runs are removed once a newer complete backup exists.
+// The current backup is never removed, even if clock changes made other backups look newer. It takes the first slot,
+// unless its run failed (a backup that is not listed yet, like the next one of 'plan', is expected to succeed).
+func planCleanup(backups []backupDir, current string, keep int) []backupDir {
+	var plan []backupDir
+	kept := 0
+	if current != "" {
+		kept = 1
+		for _, backup := range backups {
+			if backup.path == current && backup.state != BackupComplete {
+				kept = 0
 			}
-			// Also check if it's a subdirectory of an included directory
-			if strings.HasPrefix(path, pattern+string(filepath.Separator)) {
-				included = true
-				break
+		}
+	}
+	newerComplete := kept > 0
+
+	for _, backup := range backups {
+		if backup.path == current {
+			continue
+		}
+
+		if backup.state == BackupPartial {
+			if !newerComplete {
+				continue
+			}
+			if partialInUse(backup) {
+				logger.Verbose(fmt.Sprintf("Not removing %s: it may still be written by another run.\n", backup.name))
+				continue
+			}
+			plan = append(plan, backup)
+			continue
+		}
+		if backup.state == BackupFailed {
+			if newerComplete {
+				plan = append(plan, backup)
+			}
+			continue
+		}
+
+		newerComplete = true
+		if kept < keep {
+			kept++
+			continue
+		}
+		plan = append(plan, backup)
+	}
+
+	return plan
+}
+
+
+// partialInUse reports whether a partial backup may still be written by another run (e.g. a concurrent
+// or continued one): a run on its destination other than this one has a fresh progress checkpoint (see checkpoint.go),
+// or its directory or run journal was modified within 'ProgressStaleAfter'.
+func partialInUse(backup backupDir) bool {
+	root := filepath.Dir(backup.path)
+	if state, err := readState(); err == nil {
+		for _, run := range state.Runs {
+			p := run.Progress
+			if run.Destination == root && p != nil && p.PID != os.Getpid() && time.Since(p.Updated) <= ProgressStaleAfter {
+				return true
 			}
 		}
-		if !included {
-			return false
+	}
+	for _, path := range []string{backup.path, filepath.Join(backup.path, ReportDirName), filepath.Join(backup.path, ReportDirName, JournalFileName)} {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) <= ProgressStaleAfter {
+			return true
 		}
 	}
+	return false
+}
 
-	// Check exclude patterns (exclude takes priority)
-	for _, pattern := range exclude {
-		if matched, _ := filepath.Match(pattern, path); matched {
-			return false
+
+// guardLastComplete always takes the newest complete (successful) backup off the plan, whatever else is kept
+// (e.g. when the current run failed and older backups are expired). The newest legacy backup is guarded
+// the same way, if there are no complete backups at all.
+func guardLastComplete(backups, plan []backupDir) []backupDir {
+	for _, state := range []string{BackupComplete, BackupLegacy} {
+		var newest *backupDir
+		for i := range backups {
+			if backups[i].state == state {
+				newest = &backups[i] // sorted from newest to oldest
+				break
+			}
 		}
-		// Also check if it's a subdirectory of an excluded directory
-		if strings.HasPrefix(path, pattern+string(filepath.Separator)) {
-			return false
+		if newest == nil {
+			continue
 		}
+
+		var guarded []backupDir
+		for _, backup := range plan {
+			if backup.path == newest.path {
+				logger.Warn(fmt.Sprintf("Not removing %s: it's the last %s backup.\n", newest.name, state))
+				continue
+			}
+			guarded = append(guarded, backup)
+		}
+		return guarded
 	}
 
-	return true
+	return plan
 }
 
 
-// REMOVE OLDEST BACKUP(S)
-func (app *BackupApp) cleanupOldBackups() error {
-	backupRoot := filepath.Dir(app.bkpDestFullPath)
+// PRINT DELETION PLAN (names, ages, sizes and total space reclaimed)
+func printDeletionPlan(plan []backupDir) {
+	var total uint64
+	table := style.NewTable("Backup", "Age", "Size", "State").AlignRight(2)
+	for _, backup := range plan {
+		size := dirSize(backup.path)
+		total += size
+		table.Row(backup.name, formatAge(time.Since(backup.created)), formatBytes(size), backup.state)
+	}
+	logger.Sub(table.Render())
+	logger.Plain(fmt.Sprintf("Backups to remove: %d, space to reclaim: %s\n", len(plan), formatBytes(total)))
+}
+
+
+// dirSize returns the total size of files in the directory (unreadable entries are skipped).
+func dirSize(path string) uint64 {
+	var size uint64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return size
+}
+
 
+// listBackups finds backup directories in the backup root, sorted from newest to oldest.
+// Creation time is parsed from the directory name, or read from backup metadata if the name
+// has no valid timestamp. Directories without the backup prefix or a known time are ignored.
+func listBackups(backupRoot string) ([]backupDir, error) {
 	entries, err := os.ReadDir(backupRoot)
 	if err != nil {
-		logger.Err(fmt.Sprintf("Cleanup failed with error: %s\n", err))
-		return nil
+		return nil, err
 	}
 
-	var backupDirs []os.DirEntry
+	var backups []backupDir
 	for _, entry := range entries {
-		if entry.IsDir() && strings.HasPrefix(entry.Name(), fmt.Sprintf("%s-", Prefix)) {
-			backupDirs = append(backupDirs, entry)
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), fmt.Sprintf("%s-", Prefix)) {
+			continue
 		}
-	}
 
-	if len(backupDirs) <= int(app.BkpConfig.Retention.BackupsToKeep) {
-		return nil
+		path := filepath.Join(backupRoot, entry.Name())
+		created, ok := backupTime(entry.Name())
+		if !ok {
+			meta, err := readMetadata(path)
+			if err != nil {
+				continue
+			}
+			created = meta.Started
+		}
+
+		backups = append(backups, backupDir{
+			name:    entry.Name(),
+			path:    path,
+			created: created,
+			state:   backupState(path),
+		})
 	}
 
-	// Sort by name (which includes timestamp) and remove oldest
-	toDelete := len(backupDirs) - int(app.BkpConfig.Retention.BackupsToKeep)
+	// Same-second backups are told apart by collision suffix ("-2" < "-10", so longer names are newer)
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].created.Equal(backups[j].created) {
+			return backups[i].created.After(backups[j].created)
+		}
+		if len(backups[i].name) != len(backups[j].name) {
+			return len(backups[i].name) > len(backups[j].name)
+		}
+		return backups[i].name > backups[j].name
+	})
+	return backups, nil
+}
+
 
-	if toDelete > 0 {
-		logger.Plain("\nCleanup\n")
+// backupTime parses the creation time from the backup directory name (e.g. 'smbkp-20240101-120000Z').
+// Name may have a suffix after the timestamp (e.g. 'smbkp-20240101-120000Z-1').
+// Names without zone (made by earlier versions) are read as local time.
+func backupTime(name string) (time.Time, bool) {
+	stamp, found := strings.CutPrefix(name, fmt.Sprintf("%s-", Prefix))
+	if !found {
+		return time.Time{}, false
 	}
+	formats := []struct {
+		layout   string
+		location *time.Location
+	}{{BackupTimestampFormat, time.UTC}, {LegacyTimestampFormat, time.Local}}
 
-	for i := 0; i < toDelete; i++ {
-		dirPath := filepath.Join(backupRoot, backupDirs[i].Name())
-		logger.Sub(fmt.Sprintf("  removing old backup: %s\n", dirPath))
-		if err := os.RemoveAll(dirPath); err != nil {
-			logger.Err(fmt.Sprintf("Failed to remove old backup: %s\n", dirPath))
+	for _, format := range formats {
+		if len(stamp) < len(format.layout) {
+			continue
+		}
+		if suffix := stamp[len(format.layout):]; suffix != "" && !strings.HasPrefix(suffix, "-") {
+			continue
+		}
+		if t, err := time.ParseInLocation(format.layout, stamp[:len(format.layout)], format.location); err == nil {
+			return t, true
 		}
 	}
-
-	return nil
+	return time.Time{}, false
 }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each backup gets a manifest of copied files (checksum, size, source modification time and path),
+// so its content can be verified later. With a signing key, the manifest and metadata are also signed
+// with HMAC-SHA256, which makes modified or planted files detectable, not just accidental corruption.
+const (
+	ManifestFileName  string = "smbkp-manifest.tsv"
+	SignatureFileName string = "smbkp-signature.txt"
+	ManifestHeader    string = "# smbkp manifest v1\n# %s\tsize\tmtime\tpath\n" // checksum algorithm as the first column name
+	SigningKeyEnv     string = "SMBKP_SIGNING_KEY" // takes precedence over 'signing_key_file'
+	SummaryTopChanges int    = 10                  // largest changed files listed in the run summary
+)
+
+// Escaping of special characters in manifest paths (one entry per line, tab-separated fields)
+var (
+	manifestPathEscaper   = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	manifestPathUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// MANIFEST ENTRY (one copied file)
+type manifestEntry struct {
+	sum     string // hex-encoded checksum of content ('hash_algorithm')
+	size    int64
+	modTime time.Time // modification time of the source file
+	path    string    // slash-separated, relative to the backup directory
+}
+
+
+
+//////////////  MANIFEST FUNCTIONS  ///////////////////////////////////////////
+
+// RECORD COPIED FILE IN MANIFEST
+// Safe for concurrent use by copy workers.
+func (app *BackupApp) recordFile(dest string, size int64, modTime time.Time, sum []byte) {
+	rel, err := filepath.Rel(app.bkpDestFullPath, dest)
+	if err != nil {
+		return
+	}
+	entry := manifestEntry{
+		sum:     hex.EncodeToString(sum),
+		size:    size,
+		modTime: modTime,
+		path:    app.manifestPath(rel),
+	}
+
+	app.manifestMu.Lock()
+	defer app.manifestMu.Unlock()
+	if app.journal != nil {
+		app.journal.add(entry)
+	}
+	if app.manifestSpool != nil {
+		app.spoolManifestEntry(entry)
+		return
+	}
+	app.manifest = append(app.manifest, entry)
+}
+
+
+// WRITE MANIFEST FILE INTO BACKUP DIRECTORY
+// Returns the written content, so it can be signed.
+func (app *BackupApp) writeManifest() ([]byte, error) {
+	if app.manifestSpool != nil {
+		return app.finishManifestSpool()
+	}
+
+	app.manifestMu.Lock()
+	entries := app.manifest
+	app.manifestMu.Unlock()
+
+	// Copy workers finish in random order, sorting makes manifests comparable
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, ManifestHeader, app.BkpConfig.HashAlgorithm)
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "%s\t%d\t%s\t%s\n", entry.sum, entry.size, entry.modTime.UTC().Format(time.RFC3339), manifestPathEscaper.Replace(entry.path))
+	}
+
+	data, err := app.sealReport(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("encrypting manifest: %w", err)
+	}
+	if err := app.writeBytes(filepath.Join(app.bkpDestFullPath, ReportDirName, ManifestFileName), data); err != nil {
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+	return data, nil
+}
+
+
+// READ MANIFEST FILE OF EXISTING BACKUP
+func readManifest(dir string) ([]manifestEntry, error) {
+	data, err := readReportData(filepath.Join(dir, ReportDirName, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*KB), MB)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, err := parseManifestLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+
+// parseManifestLine parses the manifest entry line (also used by the run journal, see resume.go).
+func parseManifestLine(text string) (manifestEntry, error) {
+	fields := strings.SplitN(text, "\t", 4)
+	if len(fields) != 4 {
+		return manifestEntry{}, fmt.Errorf("expected 4 fields, found %d", len(fields))
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("invalid size: %w", err)
+	}
+	modTime, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("invalid mtime: %w", err)
+	}
+	return manifestEntry{
+		sum:     fields[0],
+		size:    size,
+		modTime: modTime,
+		path:    manifestPathUnescaper.Replace(fields[3]),
+	}, nil
+}
+
+
+
+//////////////  SIGNATURE FUNCTIONS  //////////////////////////////////////////
+
+// signingKey returns the key from environment variable or 'signing_key_file', or nil if none is configured.
+func (app *BackupApp) signingKey() ([]byte, error) {
+	if key := os.Getenv(SigningKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+	if app.BkpConfig.SigningKeyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(app.BkpConfig.SigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("signing key file %q is empty", app.BkpConfig.SigningKeyFile)
+	}
+	return key, nil
+}
+
+
+// signatureText renders HMAC-SHA256 signatures of the files, one line per file in 'sha256sum' layout.
+// Names are slash-separated paths relative to the backup directory.
+func signatureText(key []byte, names []string, contents map[string][]byte) []byte {
+	var buf bytes.Buffer
+	for _, name := range names {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(contents[name])
+		fmt.Fprintf(&buf, "hmac-sha256 %s  %s\n", hex.EncodeToString(mac.Sum(nil)), name)
+	}
+	return buf.Bytes()
+}
+
+
+// verifySignature checks signatures of the backup directory files against the key.
+// Returns names of files whose content doesn't match the signature, including the 'required' files
+// that aren't signed at all (a signature line could have been removed).
+func verifySignature(dir string, key []byte, required []string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ReportDirName, SignatureFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatched []string
+	signed := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "hmac-sha256" {
+			return nil, fmt.Errorf("invalid signature line %q", line)
+		}
+		signed[fields[2]] = true
+		expected, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature line %q", line)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(fields[2])))
+		if err != nil {
+			mismatched = append(mismatched, fields[2])
+			continue
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(content)
+		if !hmac.Equal(mac.Sum(nil), expected) {
+			mismatched = append(mismatched, fields[2])
+		}
+	}
+	for _, name := range required {
+		if !signed[name] {
+			mismatched = append(mismatched, name)
+		}
+	}
+	return mismatched, nil
+}
+
+
+
+// updateSignature re-signs one file of a signed backup after it was rewritten, or drops its line if the file
+// was removed (which needs no key). Backups that aren't signed are left as they are.
+func updateSignature(dir, name string, key []byte) error {
+	path := filepath.Join(dir, ReportDirName, SignatureFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	removed := errors.Is(err, os.ErrNotExist)
+	if err != nil && !removed {
+		return err
+	}
+	if !removed && key == nil {
+		return fmt.Errorf("backup is signed, but no signing key is configured to sign %s again", name)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[2] == name {
+			continue
+		}
+		buf.WriteString(line)
+	}
+	if !removed {
+		buf.Write(signatureText(key, []string{name}, map[string][]byte{name: content}))
+	}
+	return replaceFile(path, buf.Bytes(), 0644)
+}
+
+
+
+//////////////  CHANGES SINCE PREVIOUS BACKUP  ////////////////////////////////
+
+// CHANGES BETWEEN TWO MANIFESTS
+type manifestChanges struct {
+	since    string // name of the previous backup
+	added    []manifestEntry
+	removed  []manifestEntry
+	modified []manifestEntry // entries of the current manifest
+}
+
+
+// compareManifests finds files added, removed and modified (by content) in 'cur' compared to 'prev'.
+func compareManifests(prev, cur []manifestEntry) manifestChanges {
+	var changes manifestChanges
+	prevByPath := make(map[string]manifestEntry, len(prev))
+	for _, entry := range prev {
+		prevByPath[entry.path] = entry
+	}
+
+	for _, entry := range cur {
+		old, ok := prevByPath[entry.path]
+		switch {
+		case !ok:
+			changes.added = append(changes.added, entry)
+		case old.sum != entry.sum:
+			changes.modified = append(changes.modified, entry)
+		}
+		delete(prevByPath, entry.path)
+	}
+	for _, entry := range prevByPath {
+		changes.removed = append(changes.removed, entry)
+	}
+	return changes
+}
+
+
+// PREVIOUS COMPLETE BACKUP OF THE CURRENT RUN
+// The current and future-dated backups are skipped.
+func (app *BackupApp) previousBackup() (backupDir, bool) {
+	backups, err := listBackups(filepath.Dir(app.bkpDestFullPath))
+	if err != nil {
+		return backupDir{}, false
+	}
+
+	current := filepath.Base(app.bkpDestFullPath)
+	started, _ := backupTime(current)
+	for _, backup := range backups {
+		if backup.name == current || backup.state != BackupComplete || backup.created.After(started) {
+			continue
+		}
+		return backup, true
+	}
+	return backupDir{}, false
+}
+
+
+// CHANGES OF THE CURRENT RUN SINCE THE PREVIOUS COMPLETE BACKUP
+// Returns false if there is no previous backup with manifest to compare with
+// (checksums of another algorithm can't be compared either), or if the manifest is streamed to disk ('max_memory').
+func (app *BackupApp) changesSincePrevious() (manifestChanges, bool) {
+	if app.manifestSpool != nil {
+		return manifestChanges{}, false
+	}
+	backup, ok := app.previousBackup()
+	if !ok {
+		return manifestChanges{}, false
+	}
+	if algorithm, err := manifestAlgorithm(backup.path); err != nil || algorithm != app.BkpConfig.HashAlgorithm {
+		return manifestChanges{}, false
+	}
+	prev, err := readManifest(backup.path)
+	if err != nil {
+		return manifestChanges{}, false
+	}
+
+	app.manifestMu.Lock()
+	cur := append([]manifestEntry(nil), app.manifest...)
+	app.manifestMu.Unlock()
+
+	changes := compareManifests(prev, cur)
+	changes.since = backup.name
+	return changes, true
+}
+
+
+// topChanges returns the largest changed files (at most 'limit'), each marked with '+', '-' or '~'.
+func (changes manifestChanges) topChanges(limit int) []string {
+	type change struct {
+		mark  string
+		entry manifestEntry
+	}
+	var all []change
+	for _, entry := range changes.added {
+		all = append(all, change{"+", entry})
+	}
+	for _, entry := range changes.removed {
+		all = append(all, change{"-", entry})
+	}
+	for _, entry := range changes.modified {
+		all = append(all, change{"~", entry})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].entry.size != all[j].entry.size {
+			return all[i].entry.size > all[j].entry.size
+		}
+		return all[i].entry.path < all[j].entry.path
+	})
+	if len(all) > limit {
+		all = all[:limit]
+	}
+
+	lines := make([]string, len(all))
+	for i, c := range all {
+		lines[i] = fmt.Sprintf("%s %s (%s)", c.mark, c.entry.path, formatBytes(uint64(c.entry.size)))
+	}
+	return lines
+}
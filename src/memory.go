@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// 'max_memory' is a guideline for runs over very large trees on small devices (e.g. a Raspberry Pi NAS):
+// - the Go runtime gets it as a soft memory limit, so garbage is collected more eagerly when close to it;
+// - manifest entries are streamed to disk as files are copied, instead of being kept until the end
+//   (the manifest is then in copy order, and the "changes since" summary is skipped, as it needs both manifests in memory);
+// - items whose file list alone would take a large part of the limit are reported, so they can be split.
+// The copy and hashing queues are bounded regardless of the setting.
+
+const (
+	ManifestSpoolSuffix   string = ".part" // manifest being streamed, renamed when the backup is finalized
+	WorkEntryMemoryApprox uint64 = 512     // approximate memory taken by one work list entry, in bytes
+	MemoryWarnShare       uint64 = 2       // warn about items whose file list takes more than 1/N of 'max_memory'
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// MANIFEST STREAMED TO DISK
+type manifestSpool struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+
+
+//////////////  MEMORY FUNCTIONS  /////////////////////////////////////////////
+
+// APPLY 'MAX_MEMORY' TO GO RUNTIME
+func (app *BackupApp) applyMemoryLimit() {
+	if app.BkpConfig.maxMemoryParsed == 0 {
+		return
+	}
+	debug.SetMemoryLimit(int64(app.BkpConfig.maxMemoryParsed))
+	logger.Info(fmt.Sprintf("Memory use is limited to about %s.\n", app.BkpConfig.MaxMemory))
+}
+
+
+// START STREAMING MANIFEST TO DISK
+// Only with 'max_memory' and when the backup is written to disk (tar stream gets the manifest at the end).
+func (app *BackupApp) startManifestSpool() error {
+	if app.BkpConfig.maxMemoryParsed == 0 || app.toStdout {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(app.bkpDestFullPath, ReportDirName), 0755); err != nil {
+		return fmt.Errorf("creating report directory: %w", err)
+	}
+	f, err := os.Create(filepath.Join(app.bkpDestFullPath, ReportDirName, ManifestFileName+ManifestSpoolSuffix))
+	if err != nil {
+		return fmt.Errorf("creating manifest: %w", err)
+	}
+	app.manifestSpool = &manifestSpool{file: f, w: bufio.NewWriter(f)}
+	fmt.Fprintf(app.manifestSpool.w, ManifestHeader, app.BkpConfig.HashAlgorithm)
+	return nil
+}
+
+
+// spoolManifestEntry writes the entry to the streamed manifest. Caller holds 'manifestMu'.
+func (app *BackupApp) spoolManifestEntry(entry manifestEntry) {
+	fmt.Fprintf(app.manifestSpool.w, "%s\t%d\t%s\t%s\n", entry.sum, entry.size, entry.modTime.UTC().Format(time.RFC3339), manifestPathEscaper.Replace(entry.path))
+}
+
+
+// FINISH STREAMED MANIFEST
+// Renames it into the final manifest and returns its content, so it can be signed.
+func (app *BackupApp) finishManifestSpool() ([]byte, error) {
+	app.manifestMu.Lock()
+	defer app.manifestMu.Unlock()
+
+	f := app.manifestSpool.file
+	err := app.manifestSpool.w.Flush()
+	if err == nil && app.BkpConfig.Durability == DurabilityFsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+
+	final := filepath.Join(app.bkpDestFullPath, ReportDirName, ManifestFileName)
+	if err := os.Rename(f.Name(), final); err != nil {
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+	return os.ReadFile(final)
+}
+
+
+// WARN ABOUT ITEMS WHOSE FILE LIST TAKES A LARGE PART OF 'MAX_MEMORY'
+func (app *BackupApp) checkMemoryEstimate(work *workList) {
+	limit := app.BkpConfig.maxMemoryParsed
+	if limit == 0 {
+		return
+	}
+	estimate := uint64(len(work.entries)) * WorkEntryMemoryApprox
+	if estimate > limit/MemoryWarnShare {
+		logger.Warn(fmt.Sprintf("File list of the item takes about %s of memory, which is a large part of %q (%s). Consider splitting the item.\n",
+			formatBytes(estimate), "max_memory", app.BkpConfig.MaxMemory))
+	}
+}
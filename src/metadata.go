@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"simple-backup/src/style"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Each backup directory is self-describing:
+//   smbkp-metadata.yaml  - run details, written when the run starts and rewritten when it ends
+//   COMPLETE             - checksum of the metadata file, written as the very last step of the run
+//   report/              - everything needed to understand the backup years later, on another machine:
+//     smbkp-summary.txt    - the same summary that is printed to console
+//     smbkp-manifest.tsv   - checksums of copied files (see manifest.go)
+//     smbkp-skipped.tsv    - source paths that were not copied (or copied while changing), and why
+//     smbkp-placeholders.tsv - online-only files of cloud sync clients, if recorded (see placeholders.go)
+//     smbkp-log.txt        - console output of the run
+//     smbkp-config.yaml    - config file of the run, so the setup isn't lost with the original config
+//     smbkp-signature.txt  - HMAC signatures of the files above, if signing key is configured
+//     packs/               - small files packed by 'pack_small_files', with their index (see packing.go)
+//     smbkp-journal.tsv, queue/ - only while the run is in progress, to continue it if interrupted (see resume.go)
+// With 'obfuscate_names', the metadata and report files are encrypted (see privacy.go).
+// State kept in 'bkp_dest_dir' is derived from the backups: the catalog is rebuilt from their manifests
+// and history is read from their metadata, so losing it doesn't orphan them.
+// Directories without a valid COMPLETE marker are partial (interrupted) backups. Backups of failed runs are marked
+// as well, but metadata tells them apart ('success: false'): they are not the base of later runs (changes, dedup).
+// Item destinations can't use the 'report' name.
+const (
+	MetadataFileName   string = "smbkp-metadata.yaml"
+	CompleteMarkerName string = "COMPLETE"
+	ReportDirName      string = "report"
+	SummaryFileName    string = "smbkp-summary.txt"
+	SkippedFileName    string = "smbkp-skipped.tsv"
+	LogExcerptFileName string = "smbkp-log.txt"
+	ConfigCopyFileName string = "smbkp-config.yaml"
+	LogExcerptMessages int    = 5000 // console messages kept for the log excerpt
+)
+
+// Placeholder of item destination, e.g. '{{date}}' in 'photos/{{year}}/{{date}}'
+var destinationPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Backup directory states
+const (
+	BackupComplete string = "complete"
+	BackupFailed   string = "failed" // completed, but the run failed (metadata 'success: false')
+	BackupPartial  string = "partial"
+	BackupLegacy   string = "legacy" // created before completion markers were introduced
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// BACKUP METADATA (stored in each backup directory)
+type BackupMetadata struct {
+	Version    string         `yaml:"version"`
+	Name       string         `yaml:"name"`
+	RunID      string         `yaml:"run_id,omitempty"`
+	Host       string         `yaml:"host"`
+	ConfigFile string         `yaml:"config_file"`
+	Started    time.Time      `yaml:"started"`
+	Finished   *time.Time     `yaml:"finished,omitempty"`
+	Success    bool           `yaml:"success"`
+	Items      []ItemMetadata `yaml:"items"`
+	Span       *SpanMetadata  `yaml:"span,omitempty"` // set on parts of a backup spanning volumes
+	IncludeADS bool           `yaml:"include_ads,omitempty"` // alternate data streams were copied (see ads.go)
+}
+
+
+// BACKUP ITEM METADATA
+type ItemMetadata struct {
+	Source       string `yaml:"source"`
+	Destination  string `yaml:"destination"`
+	Success      bool   `yaml:"success"`
+	Error        string `yaml:"error,omitempty"`
+	Truncated    string `yaml:"truncated,omitempty"`
+	Missing      bool   `yaml:"missing,omitempty"` // source not found, item skipped ('missing_source: skip')
+	Files        int    `yaml:"files"`             // selected for copying
+	FilesCopied  int    `yaml:"files_copied"`
+	FilesSkipped int    `yaml:"files_skipped,omitempty"` // inaccessible when copied
+	FilesFailed  int    `yaml:"files_failed,omitempty"`
+	FilesChanged int    `yaml:"files_changed,omitempty"` // changing while copied, copies may be inconsistent
+	Bytes        int64  `yaml:"bytes"`
+	BytesCopied  int64  `yaml:"bytes_copied"`
+	Elapsed      string `yaml:"elapsed"`
+}
+
+
+// SUMMARY LINE (printed to console and saved into the backup directory)
+type summaryLine struct {
+	print func(string, ...style.Option)
+	msg   string
+	opts  []style.Option
+}
+
+
+
+//////////////  METADATA FUNCTIONS  ///////////////////////////////////////////
+
+// NEW BACKUP METADATA FOR THE CURRENT RUN
+func (app *BackupApp) newMetadata() *BackupMetadata {
+	host, _ := os.Hostname()
+	return &BackupMetadata{
+		Version:    Version,
+		Name:       filepath.Base(app.bkpDestFullPath),
+		RunID:      app.runID,
+		Host:       host,
+		ConfigFile: app.configFile,
+		Started:    app.startTime.UTC(),
+		Span:       app.span,
+		IncludeADS: app.ads != nil,
+	}
+}
+
+
+// RECORD ITEM RESULTS AND COMPLETION IN METADATA
+func (meta *BackupMetadata) finish(results []BackupResult, success bool) {
+	finished := time.Now().UTC()
+	meta.Finished = &finished
+	meta.Success = success
+	meta.Items = nil
+	for _, result := range results {
+		item := ItemMetadata{
+			Source:       itemSourceLabel(result.Item),
+			Destination:  result.Item.Destination,
+			Success:      result.Success,
+			Truncated:    result.Truncated,
+			Missing:      result.Missing,
+			Files:        result.Files,
+			FilesCopied:  result.FilesCopied,
+			FilesSkipped: result.FilesSkipped,
+			FilesFailed:  result.FilesFailed,
+			FilesChanged: result.FilesChanged,
+			Bytes:        result.Bytes,
+			BytesCopied:  result.BytesCopied,
+			Elapsed:      formatDurationSeconds(result.Elapsed),
+		}
+		if result.Error != nil {
+			item.Error = result.Error.Error()
+		}
+		meta.Items = append(meta.Items, item)
+	}
+}
+
+
+// WRITE METADATA FILE INTO BACKUP DIRECTORY
+// Returns the written content, so its checksum can go into the completion marker.
+func (app *BackupApp) writeMetadata(meta *BackupMetadata) ([]byte, error) {
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("serializing metadata: %w", err)
+	}
+	if data, err = app.sealReport(data); err != nil {
+		return nil, fmt.Errorf("encrypting metadata: %w", err)
+	}
+	if err := app.writeBytes(filepath.Join(app.bkpDestFullPath, MetadataFileName), data); err != nil {
+		return nil, fmt.Errorf("writing metadata: %w", err)
+	}
+	return data, nil
+}
+
+
+// READ METADATA FILE OF EXISTING BACKUP
+func readMetadata(dir string) (*BackupMetadata, error) {
+	data, err := readReportData(filepath.Join(dir, MetadataFileName))
+	if err != nil {
+		return nil, err
+	}
+	meta := &BackupMetadata{}
+	if err := yaml.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("parsing metadata: %w", err)
+	}
+	return meta, nil
+}
+
+
+// FINALIZE BACKUP DIRECTORY (report, metadata, and completion marker as the very last write)
+func (app *BackupApp) finalizeBackup(meta *BackupMetadata, summary []summaryLine) error {
+	if err := app.makeDir(filepath.Join(app.bkpDestFullPath, ReportDirName), 0755); err != nil {
+		return fmt.Errorf("creating report directory: %w", err)
+	}
+
+	manifestData, err := app.writeManifest()
+	if err != nil {
+		return err
+	}
+
+	metaData, err := app.writeMetadata(meta)
+	if err != nil {
+		return err
+	}
+
+	// Report files, keyed by path relative to the backup directory
+	signed := map[string][]byte{
+		reportFile(ManifestFileName):   manifestData,
+		MetadataFileName:               metaData,
+		reportFile(SummaryFileName):    []byte(summaryText(summary)),
+		reportFile(SkippedFileName):    app.skippedReport(),
+		reportFile(LogExcerptFileName): []byte(logger.History()),
+	}
+	names := []string{reportFile(ManifestFileName), MetadataFileName, reportFile(SummaryFileName), reportFile(SkippedFileName), reportFile(LogExcerptFileName)}
+	if config, err := os.ReadFile(app.configFile); err == nil {
+		signed[reportFile(ConfigCopyFileName)] = config
+		names = append(names, reportFile(ConfigCopyFileName))
+	} else {
+		logger.Warn(fmt.Sprintf("Config file is not copied into the backup: %v\n", err))
+	}
+	if app.BkpConfig.CloudPlaceholders == CloudPlaceholdersRecord && len(app.placeholders) > 0 {
+		signed[reportFile(PlaceholdersFileName)] = app.placeholdersReport()
+		names = append(names, reportFile(PlaceholdersFileName))
+	}
+	for _, name := range names[2:] {
+		if signed[name], err = app.sealReport(signed[name]); err != nil {
+			return fmt.Errorf("encrypting %s: %w", name, err)
+		}
+		if err := app.writeBytes(filepath.Join(app.bkpDestFullPath, filepath.FromSlash(name)), signed[name]); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	// Pack index is written when packing finishes ('pack_small_files')
+	if app.tarOut == nil {
+		if index, err := os.ReadFile(filepath.Join(app.bkpDestFullPath, filepath.FromSlash(packIndexName()))); err == nil {
+			signed[packIndexName()] = index
+			names = append(names, packIndexName())
+		}
+	}
+
+	// Signature covers the manifest, so every copied file is covered too
+	key, err := app.signingKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if err := app.writeBytes(filepath.Join(app.bkpDestFullPath, ReportDirName, SignatureFileName), signatureText(key, names, signed)); err != nil {
+			return fmt.Errorf("writing signature: %w", err)
+		}
+	}
+
+	// Everything above must be on disk before the marker claims the backup is complete
+	if app.tarOut == nil && app.BkpConfig.Durability == DurabilityFsync {
+		for _, dir := range []string{filepath.Join(app.bkpDestFullPath, ReportDirName), app.bkpDestFullPath} {
+			if err := syncPath(dir); err != nil {
+				return fmt.Errorf("flushing backup directory: %w", err)
+			}
+		}
+	}
+
+	if err := app.writeBytes(filepath.Join(app.bkpDestFullPath, CompleteMarkerName), completeMarker(metaData)); err != nil {
+		return fmt.Errorf("writing completion marker: %w", err)
+	}
+
+	if app.tarOut == nil && app.BkpConfig.Durability == DurabilityFsync {
+		return syncPath(app.bkpDestFullPath)
+	}
+	return nil
+}
+
+
+// validateDestination rejects item destinations that would mix item content into the backup report folder.
+func validateDestination(destination string) error {
+	parts := strings.FieldsFunc(destination, func(r rune) bool { return r == '/' || r == '\\' })
+	if len(parts) > 0 && strings.EqualFold(parts[0], ReportDirName) {
+		return fmt.Errorf("%q value %q is reserved for backup report, specify different %q", "destination", destination, "destination")
+	}
+	return nil
+}
+
+
+// checkDestinationCollisions rejects items whose destinations are the same, or one inside the other,
+// since the later item would overwrite (or mix its files into) the content of the earlier one.
+// Destinations are compared case-insensitively, as on Windows and macOS file systems.
+func checkDestinationCollisions(items []BackupItem) error {
+	normalized := make([]string, len(items))
+	for i, item := range items {
+		normalized[i] = strings.ToLower(filepath.Clean(filepath.FromSlash(item.Destination)))
+	}
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			a, b := normalized[i], normalized[j]
+			relation := ""
+			switch {
+			case a == b:
+				relation = "the same destination"
+			case strings.HasPrefix(b, a+string(filepath.Separator)), strings.HasPrefix(a, b+string(filepath.Separator)):
+				relation = "nested destinations"
+			default:
+				continue
+			}
+			return fmt.Errorf("items %d (%s) and %d (%s) have %s (%q and %q): set a different %q for one of them, or use %q",
+				i+1, itemSourceLabel(items[i]), j+1, itemSourceLabel(items[j]), relation, items[i].Destination, items[j].Destination, "destination", "layout: mirror")
+		}
+	}
+	return nil
+}
+
+
+// defaultDestination returns the destination of the item that doesn't specify one: the source leaf name,
+// or with 'layout: mirror' the whole source path (e.g. "C/Users/me/Documents", or "host/var/www" for remote sources),
+// so items with the same leaf name don't collide.
+func defaultDestination(layout, source string) string {
+	if isRemoteSource(source) {
+		if rs, err := parseRemoteSource(source); err == nil && layout == LayoutMirror {
+			return filepath.FromSlash(path.Join(rs.Host, path.Clean("/"+rs.Path)))
+		}
+		return remoteBaseName(source)
+	}
+	if layout == LayoutMirror {
+		if abs, err := filepath.Abs(source); err == nil {
+			volume := filepath.VolumeName(abs)
+			// "C:" -> "C", "\\server\share" -> "server\share"
+			mirrored := filepath.Join(strings.Trim(strings.TrimSuffix(volume, ":"), `\/`), abs[len(volume):])
+			if mirrored = strings.TrimLeft(mirrored, `\/`); mirrored != "" {
+				return mirrored
+			}
+		}
+	}
+	return filepath.Base(source)
+}
+
+
+// expandDestination replaces placeholders of the item destination with values of the run time
+// (e.g. 'photos/{{year}}/{{date}}' -> 'photos/2025/2025-06-30').
+func expandDestination(destination string, now time.Time) (string, error) {
+	host, _ := os.Hostname()
+	_, week := now.ISOWeek()
+	values := map[string]string{
+		"year":    now.Format("2006"),
+		"month":   now.Format("01"),
+		"day":     now.Format("02"),
+		"date":    now.Format("2006-01-02"),
+		"time":    now.Format("150405"),
+		"week":    fmt.Sprintf("%02d", week),
+		"weekday": strings.ToLower(now.Weekday().String()),
+		"host":    host,
+	}
+
+	unknown := ""
+	expanded := destinationPlaceholder.ReplaceAllStringFunc(destination, func(placeholder string) string {
+		name := strings.ToLower(destinationPlaceholder.FindStringSubmatch(placeholder)[1])
+		value, ok := values[name]
+		if !ok && unknown == "" {
+			unknown = placeholder
+		}
+		return value
+	})
+	if unknown != "" {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, "{{"+name+"}}")
+		}
+		slices.Sort(names)
+		return "", fmt.Errorf("%q value %q has unknown placeholder %s. Expected one of: %s", "destination", destination, unknown, strings.Join(names, ", "))
+	}
+	return expanded, nil
+}
+
+
+// reportFile returns the slash-separated path of the report file, relative to the backup directory.
+func reportFile(name string) string {
+	return ReportDirName + "/" + name
+}
+
+
+// completeMarker returns the content of the completion marker for the given metadata.
+func completeMarker(metaData []byte) []byte {
+	sum := sha256.Sum256(metaData)
+	return []byte(fmt.Sprintf("sha256 %s  %s\n", hex.EncodeToString(sum[:]), MetadataFileName))
+}
+
+
+// backupState inspects the backup directory and tells whether it's complete, failed, partial or legacy.
+// Backup is complete only if the marker matches the checksum of the metadata file, and the metadata
+// tells that the run succeeded. Encrypted metadata that can't be read is taken as complete.
+func backupState(dir string) string {
+	metaData, metaErr := os.ReadFile(filepath.Join(dir, MetadataFileName))
+	marker, markerErr := os.ReadFile(filepath.Join(dir, CompleteMarkerName))
+
+	if os.IsNotExist(metaErr) && os.IsNotExist(markerErr) {
+		return BackupLegacy
+	}
+	if metaErr != nil || markerErr != nil {
+		return BackupPartial
+	}
+	if !bytes.Equal(marker, completeMarker(metaData)) {
+		return BackupPartial
+	}
+	if meta, err := readMetadata(dir); err == nil && !meta.Success {
+		return BackupFailed
+	}
+	return BackupComplete
+}
+
+
+// backupSealed reports whether the backup in the state was finished (complete or failed),
+// so its manifest lists the files it has.
+func backupSealed(state string) bool {
+	return state == BackupComplete || state == BackupFailed
+}
+
+
+// summaryText renders summary lines as plain text (no colors or labels).
+func summaryText(summary []summaryLine) string {
+	var sb strings.Builder
+	for _, line := range summary {
+		sb.WriteString(line.msg)
+	}
+	return strings.TrimLeft(sb.String(), "\n")
+}
+
+
+// skippedReport renders source paths that were not copied, one 'path<TAB>reason' line per path.
+func (app *BackupApp) skippedReport() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# path\treason\n")
+	for _, entry := range app.skipped {
+		fmt.Fprintf(&buf, "%s\t%s\n", manifestPathEscaper.Replace(entry.path), manifestPathEscaper.Replace(entry.reason))
+	}
+	return buf.Bytes()
+}
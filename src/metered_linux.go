@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NetworkManager 'Metered' property (NMMetered): 1 - yes, 3 - guessed yes (e.g. a phone hotspot)
+var nmMeteredValues = []string{"u 1", "u 3"}
+
+
+// isMeteredConnection reads the metered hint of the primary connection from NetworkManager over D-Bus.
+func isMeteredConnection() (bool, error) {
+	out, err := exec.Command("busctl", "--system", "get-property", "org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager", "Metered").CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("reading NetworkManager metered hint: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	value := strings.TrimSpace(string(out))
+	for _, metered := range nmMeteredValues {
+		if value == metered {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package main
+
+// isMeteredConnection is not supported on this platform, 'on_metered' is not applied.
+func isMeteredConnection() (bool, error) {
+	return false, errMeteredUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Cost of the internet connection profile (WinRT), "Fixed" and "Variable" are metered
+const meteredCostScript = "[Windows.Networking.Connectivity.NetworkInformation,Windows.Networking.Connectivity,ContentType=WindowsRuntime] | Out-Null; " +
+	"$p = [Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile(); " +
+	"if ($p) { $p.GetConnectionCost().NetworkCostType }"
+
+
+// isMeteredConnection reads the cost of the internet connection, set by the "Metered connection" setting
+// (or by Windows for cellular connections).
+func isMeteredConnection() (bool, error) {
+	out, err := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", meteredCostScript).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("reading connection cost: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	cost := strings.TrimSpace(string(out))
+	return cost == "Fixed" || cost == "Variable", nil
+}
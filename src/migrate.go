@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"strings"
+)
+
+// 'migrate' converts existing backups to the layout of the current config, in place, after it was changed:
+//   --from <dir>      - backups are moved from the previous 'bkp_dest_dir' on the same drive
+//   pack_small_files  - small files of unpacked backups are packed, or packed backups are unpacked if it's off
+//   dedup: hardlink   - unchanged files (same path and checksum) are hard-linked to the previous backup,
+//                       so existing backups take the space they would have taken with dedup from the start
+// Backup names, metadata and manifests are kept, so retention, history and 'find' see the same backups.
+// The state of the last run ('status') moves with the backups.
+// Archives ('-to-stdout') are not kept on the destination, see 'export' to turn a backup into one.
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// MIGRATION RESULTS
+type migrateResult struct {
+	moved       int   // backups moved from the previous 'bkp_dest_dir'
+	packed      int   // backups packed
+	unpacked    int   // backups unpacked
+	packedFiles int
+	linked      int   // files hard-linked to the previous backup
+	linkedBytes int64 // space freed by hard-linking
+}
+
+
+
+//////////////  MIGRATE COMMAND  //////////////////////////////////////////////
+
+// RUN 'MIGRATE' COMMAND
+func runMigrateCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		from       = flags.String("from", "", "Previous 'bkp_dest_dir' to move backups from (relative to the destination, like 'bkp_dest_dir').")
+		dryRun     = flags.Bool("dry-run", false, "Only show what would be converted.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	root := app.bkpDestFullPath
+	logger.Signature(fmt.Sprintf("\n====  Migrating backups in: %s  ===\n", root))
+
+	var result migrateResult
+	if *from != "" {
+		old := filepath.Join(app.bkpDest, *from)
+		logger.Plain(fmt.Sprintf("Moving backups from %q... ", old))
+		if result.moved, err = moveBackupRoot(old, root, *dryRun); err != nil {
+			logger.Plain("\n")
+			logger.Fatal(fmt.Sprintf("Moving backups failed: %v\n\n", err), style.Bold())
+			return 1
+		}
+		logger.Ok("\n")
+		if *dryRun {
+			root = old // nothing is moved, the rest is shown for the backups where they are
+		}
+	}
+
+	if err := app.migrateBackups(root, *dryRun, &result); err != nil {
+		logger.Fatal(fmt.Sprintf("Migration failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if !*dryRun {
+		if err := refreshCatalog(root); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to update catalog: %v\n", err))
+		}
+	}
+
+	printMigrate(result, *dryRun)
+	return 0
+}
+
+
+// MIGRATE BACKUPS IN THE BACKUP ROOT TO THE CONFIGURED LAYOUT
+// Packing goes first: packed files are not hard-linked.
+func (app *BackupApp) migrateBackups(root string, dryRun bool, result *migrateResult) error {
+	backups, err := loadCheckedBackups(root)
+	if err != nil {
+		return err
+	}
+
+	limit := app.BkpConfig.packSmallFilesParsed
+	key, err := app.signingKey()
+	if err != nil {
+		return err
+	}
+	immutable := app.BkpConfig.immutableBackups()
+	for _, b := range backups {
+		switch {
+		case limit == 0 && len(b.packs) > 0:
+			logger.Plain(fmt.Sprintf("Unpacking %s\n", b.name))
+			if !dryRun {
+				if _, err := unpackBackup(b.backupDir, immutable); err != nil {
+					return fmt.Errorf("unpacking %s: %w", b.name, err)
+				}
+			}
+			result.unpacked++
+		case limit > 0 && len(b.packs) == 0:
+			logger.Plain(fmt.Sprintf("Packing %s\n", b.name))
+			files, err := packBackup(b, limit, dryRun, key, immutable)
+			if err != nil {
+				return fmt.Errorf("packing %s: %w", b.name, err)
+			}
+			result.packed++
+			result.packedFiles += files
+		}
+	}
+	if app.BkpConfig.Dedup != DedupHardlink {
+		return nil
+	}
+
+	// Pack indexes may have changed
+	if !dryRun && result.packed+result.unpacked > 0 {
+		if backups, err = loadCheckedBackups(root); err != nil {
+			return err
+		}
+	}
+
+	// Oldest first, so each backup links to files already linked to the ones before it
+	for i := len(backups) - 2; i >= 0; i-- {
+		b := backups[i]
+		problems, err := checkBackup(b, backups[i+1])
+		if err != nil {
+			return err
+		}
+		var linked int
+		for _, p := range problems {
+			if p.kind != CheckNotLinked || (dryRun && uint64(p.entry.size) <= limit) {
+				continue // would be packed first
+			}
+			if !dryRun {
+				if err := b.unlock(); err != nil {
+					return err
+				}
+				if err := relinkUnchanged(p); err != nil {
+					logger.Warn(fmt.Sprintf("%s: %s is not linked: %v\n", b.name, p.entry.path, err))
+					continue
+				}
+			}
+			linked++
+			result.linkedBytes += p.entry.size
+		}
+		if linked > 0 {
+			logger.Plain(fmt.Sprintf("Linking %d unchanged files of %s\n", linked, b.name))
+		}
+		result.linked += linked
+		if b.unlocked {
+			if err := lockBackup(b.path, immutable); err != nil {
+				return fmt.Errorf("restoring read-only protection of %s: %w", b.name, err)
+			}
+			b.unlocked = false
+		}
+	}
+	return nil
+}
+
+
+// packBackup packs the files of the backup up to the size limit, as 'pack_small_files' would have.
+// The pack index is written before the files are removed, so an interruption leaves them in both places.
+// Returns the number of packed files.
+func packBackup(b *checkedBackup, limit uint64, dryRun bool, key []byte, immutable bool) (int, error) {
+	type candidate struct {
+		disk string
+		info os.FileInfo
+	}
+	var candidates []candidate
+	for _, entry := range b.entries {
+		if uint64(entry.size) > limit {
+			continue
+		}
+		disk, err := b.diskPath(entry)
+		if err != nil {
+			return 0, err
+		}
+		info, err := os.Lstat(filepath.Join(b.path, disk))
+		if err != nil || !info.Mode().IsRegular() {
+			continue // missing files are reported by 'check'
+		}
+		candidates = append(candidates, candidate{disk: disk, info: info})
+	}
+	if dryRun || len(candidates) == 0 {
+		return len(candidates), nil
+	}
+
+	if err := b.unlock(); err != nil {
+		return 0, err
+	}
+	w := &packWriter{dir: filepath.Join(b.path, ReportDirName, PackDirName), limit: limit}
+	for _, c := range candidates {
+		data, err := os.ReadFile(filepath.Join(b.path, c.disk))
+		if err != nil {
+			w.closePack(false)
+			return 0, err
+		}
+		entry := packEntry{size: int64(len(data)), mode: c.info.Mode().Perm(), modTime: c.info.ModTime(), path: c.disk}
+		if err := w.add(entry, data, false); err != nil {
+			w.closePack(false)
+			return 0, err
+		}
+	}
+	if err := w.closePack(true); err != nil {
+		return 0, err
+	}
+	for _, entry := range w.index {
+		b.packs[entry.path] = entry
+	}
+	if err := b.writePackIndex(key); err != nil {
+		return 0, fmt.Errorf("writing pack index: %w", err)
+	}
+
+	for _, c := range candidates {
+		if err := os.Remove(filepath.Join(b.path, c.disk)); err != nil {
+			return 0, err
+		}
+	}
+	if b.unlocked {
+		if err := lockBackup(b.path, immutable); err != nil {
+			return 0, fmt.Errorf("restoring read-only protection: %w", err)
+		}
+		b.unlocked = false
+	}
+	return len(candidates), nil
+}
+
+
+// moveBackupRoot moves the content of the previous backup root into the current one (renamed as a whole
+// if the current one doesn't exist yet), and the state of the last run with it. Returns the number of backups.
+func moveBackupRoot(old, root string, dryRun bool) (int, error) {
+	if filepath.Clean(old) == filepath.Clean(root) {
+		return 0, fmt.Errorf("%q is the current %q", old, "bkp_dest_dir")
+	}
+	entries, err := os.ReadDir(old)
+	if err != nil {
+		return 0, err
+	}
+	backups := 0
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), Prefix+"-") {
+			backups++
+		}
+	}
+	if dryRun {
+		return backups, nil
+	}
+
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(old, root); err != nil {
+			return 0, err
+		}
+	} else {
+		for _, entry := range entries {
+			target := filepath.Join(root, entry.Name())
+			if _, err := os.Lstat(target); err == nil {
+				if entry.Name() == CatalogDirName || entry.Name() == LegacyCatalogFileName || entry.Name() == CanaryFileName {
+					continue // rebuilt or already planted
+				}
+				return 0, fmt.Errorf("%q exists in both %q and %q", entry.Name(), old, root)
+			}
+			if err := os.Rename(filepath.Join(old, entry.Name()), target); err != nil {
+				return 0, err
+			}
+		}
+		for _, name := range []string{CatalogDirName, LegacyCatalogFileName, CanaryFileName} {
+			os.RemoveAll(filepath.Join(old, name))
+		}
+		os.Remove(old) // left if anything else is there
+	}
+
+	// 'status' follows the backups
+	state, err := readState()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("State file is not readable, it's not updated: %v\n", err))
+		return backups, nil
+	}
+	moved := false
+	for i := range state.Runs {
+		if state.Runs[i].Destination == old {
+			state.Runs[i].Destination = root
+			moved = true
+		}
+	}
+	if moved {
+		if err := writeState(state); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to save state file: %v\n", err))
+		}
+	}
+	return backups, nil
+}
+
+
+// PRINT MIGRATION RESULTS
+func printMigrate(result migrateResult, dryRun bool) {
+	note := ""
+	if dryRun {
+		note = " (dry run, nothing is changed)"
+	}
+	logger.Plain("\n")
+	if result.moved+result.packed+result.unpacked+result.linked == 0 {
+		logger.Ok(fmt.Sprintf("Backups already match the configured layout%s.\n", note), style.NoLabel())
+		return
+	}
+	if result.moved > 0 {
+		logger.Plain(fmt.Sprintf("Backups moved: %d\n", result.moved))
+	}
+	if result.packed > 0 {
+		logger.Plain(fmt.Sprintf("Backups packed: %d (%d files)\n", result.packed, result.packedFiles))
+	}
+	if result.unpacked > 0 {
+		logger.Plain(fmt.Sprintf("Backups unpacked: %d\n", result.unpacked))
+	}
+	if result.linked > 0 {
+		logger.Plain(fmt.Sprintf("Files hard-linked: %d (%s freed)\n", result.linked, formatBytes(uint64(result.linkedBytes))))
+	}
+	logger.Ok(fmt.Sprintf("Migration is complete%s.\n", note), style.NoLabel())
+}
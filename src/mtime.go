@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Unchanged files are recognized by size and modification time recorded in the manifest (see 'dedup'
+// and 'compare'). The times are read from the item source, so its file system decides how exact they are:
+// FAT and exFAT store them in 2-second steps and in local time without zone, so the same file reads
+// an hour off after a DST change or on a machine in another time zone.
+// 'mtime_tolerance' is the difference that still counts as the same time. With 'auto' (default),
+// it's 2s for sources on FAT/exFAT and exact for others. On FAT/exFAT, whole-hour shifts are ignored too.
+// The file system is detected for each item source.
+
+const (
+	MtimeToleranceAuto string        = "auto"
+	FATMtimeTolerance  time.Duration = 2 * time.Second
+	FATMaxZoneShift    time.Duration = 26 * time.Hour // time zones are up to this far apart
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// MODIFICATION TIME COMPARISON OF THE ITEM SOURCE
+type mtimeRule struct {
+	tolerance time.Duration // differences up to this are ignored
+	fat       bool          // source is on FAT/exFAT: whole-hour shifts (DST, time zone) are ignored too
+}
+
+
+
+//////////////  MTIME FUNCTIONS  //////////////////////////////////////////////
+
+// mtimeRule returns how modification times of the item source are compared.
+func (app *BackupApp) mtimeRule(item BackupItem) mtimeRule {
+	rule := mtimeRule{}
+	if !isStreamItem(item) && !isRemoteSource(item.Source) {
+		rule.fat = isFATVolume(item.Source)
+	}
+	switch {
+	case app.BkpConfig.MtimeTolerance != MtimeToleranceAuto:
+		rule.tolerance = app.BkpConfig.mtimeToleranceParsed
+	case rule.fat:
+		rule.tolerance = FATMtimeTolerance
+	}
+	return rule
+}
+
+
+// same reports whether the modification times are the same within the rule.
+func (r mtimeRule) same(a, b time.Time) bool {
+	diff := a.Sub(b).Abs()
+	if r.fat && diff <= FATMaxZoneShift {
+		diff = min(diff%time.Hour, time.Hour-diff%time.Hour)
+	}
+	return diff <= r.tolerance
+}
+
+
+// describe returns the rule for the review, e.g. "2s (FAT/exFAT source, whole-hour shifts ignored)".
+func (r mtimeRule) describe() string {
+	if r.fat {
+		return fmt.Sprintf("%s (FAT/exFAT source, whole-hour shifts ignored)", r.tolerance)
+	}
+	return r.tolerance.String()
+}
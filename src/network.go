@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Laptop backups over a mobile hotspot or a capped connection can be deferred or slowed down ('network'):
+//   on_metered   - what a run that transfers data over the network does on a metered connection:
+//                  "run" (default), "defer" (the run doesn't start, the next scheduled one tries again)
+//                  or "throttle" (transfers are limited to 'metered_rate', shared by all copy workers)
+//   metered_rate - transfer rate limit per second for "throttle" (e.g. "256kb")
+//   upload_schedule - upload rate by time of day, e.g. "22:00-06:00 full, else 1mb": comma-separated windows
+//                  'HH:MM-HH:MM <rate>' (local time, a window may run past midnight, the first one that matches
+//                  applies) and 'else <rate>' for the rest of the day (default full). Rate is "full" (no limit)
+//                  or a size per second. Applies to writes to a UNC destination; the rate follows the clock
+//                  during the run. With a metered connection throttled too, the lower rate applies.
+// Data goes over the network for 'ssh://' sources and UNC destinations ('\\nas\backup'); runs with only local
+// sources and destination are not affected. Whether the connection is metered is read once, when the run starts:
+// Windows connection cost ("Metered connection" setting), NetworkManager metered hint (Linux).
+// Not detected on other platforms. Network mounts (NFS, SMB mounted in the OS) are seen as local.
+
+const (
+	MeteredRun          string = "run"
+	MeteredDefer        string = "defer"
+	MeteredThrottle     string = "throttle"
+	MeteredRateDefault  string = "256kb"
+	LimitMinMeteredRate uint64 = 16 * KB // also the lowest rate of 'upload_schedule'
+	UploadRateFull      string = "full"
+	UploadScheduleElse  string = "else"
+)
+
+var meteredPolicies = []string{MeteredRun, MeteredDefer, MeteredThrottle}
+
+var errMeteredUnsupported = errors.New("metered connections are not detected on this platform")
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// NETWORK SETTINGS
+type NetworkConfig struct {
+	OnMetered			string `yaml:"on_metered,omitempty"` // "run", "defer" or "throttle"
+	MeteredRate			string `yaml:"metered_rate,omitempty"` // transfer rate limit per second for "throttle" (e.g. "256kb")
+	meteredRateParsed	uint64	// set implicitly by parsing MeteredRate
+	UploadSchedule		string `yaml:"upload_schedule,omitempty"` // upload rate by time of day (e.g. "22:00-06:00 full, else 1mb")
+	uploadScheduleParsed	*uploadSchedule	// set implicitly by parsing UploadSchedule
+	UploadChunk			string `yaml:"upload_chunk,omitempty"` // files are written to a UNC destination in chunks of this size (see upload.go)
+	uploadChunkParsed	uint64	// set implicitly by parsing UploadChunk
+	UploadRetries		uint8  `yaml:"upload_retries"` // attempts to resume an upload per chunk (0 - a dropped connection fails the file)
+}
+
+
+// UPLOAD RATE BY TIME OF DAY ('upload_schedule')
+type uploadSchedule struct {
+	windows []uploadWindow
+	other   uint64 // rate outside of the windows, bytes per second (0 - full)
+}
+
+
+// TIME WINDOW OF UPLOAD SCHEDULE
+type uploadWindow struct {
+	start time.Duration // since midnight
+	end   time.Duration // before 'start' if the window runs past midnight
+	rate  uint64        // bytes per second, 0 - full
+}
+
+
+// TRANSFER RATE LIMIT SHARED BY COPY WORKERS
+type rateLimiter struct {
+	mu       sync.Mutex
+	rate     float64         // bytes per second
+	schedule *uploadSchedule // the rate follows the schedule instead, if set (0 - not limited)
+	current  float64         // rate of the schedule applied last
+	due      time.Time       // when the bytes read so far are within the rate
+	dest     bool            // writes to the destination are limited too (UNC destination)
+}
+
+
+// READER LIMITED TO THE RATE
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+
+
+//////////////  NETWORK FUNCTIONS  ////////////////////////////////////////////
+
+// validate checks the network settings.
+func (c *NetworkConfig) validate() error {
+	c.OnMetered = strings.ToLower(c.OnMetered)
+	if !slices.Contains(meteredPolicies, c.OnMetered) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "network.on_metered", c.OnMetered, strings.Join(meteredPolicies, ", "))
+	}
+	rate, err := parseDiskSize(c.MeteredRate)
+	if err != nil || rate < LimitMinMeteredRate {
+		return fmt.Errorf("%q value %q is not supported. Expected a size of at least 16kb (e.g. '256kb', '1mb')", "network.metered_rate", c.MeteredRate)
+	}
+	c.meteredRateParsed = rate
+	chunk, err := parseDiskSize(c.UploadChunk)
+	if err != nil || chunk < LimitMinUploadChunk {
+		return fmt.Errorf("%q value %q is not supported. Expected a size of at least 64kb (e.g. '8mb')", "network.upload_chunk", c.UploadChunk)
+	}
+	c.uploadChunkParsed = chunk
+	if c.UploadSchedule != "" {
+		if c.uploadScheduleParsed, err = parseUploadSchedule(c.UploadSchedule); err != nil {
+			return fmt.Errorf("%q value %q is not supported: %v. Expected e.g. '22:00-06:00 full, else 1mb'", "network.upload_schedule", c.UploadSchedule, err)
+		}
+	}
+	return nil
+}
+
+
+// CHECK NETWORK CONNECTION BEFORE THE RUN
+// Returns an error if the run should not start ('on_metered: defer'), limits transfers with "throttle".
+// Runs that don't transfer data over the network are not checked.
+func (app *BackupApp) checkNetwork() error {
+	c := app.BkpConfig.Network
+	app.scheduleUploads()
+	if c.OnMetered == MeteredRun || !app.usesNetwork() {
+		return nil
+	}
+	metered, err := isMeteredConnection()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to detect a metered connection, %q is not applied: %v\n", "network.on_metered", err))
+		return nil
+	}
+	if !metered {
+		return nil
+	}
+	if c.OnMetered == MeteredDefer {
+		return fmt.Errorf("the connection is metered (%q %s)", "network.on_metered", c.OnMetered)
+	}
+	_, networkDest := uncShare(app.bkpDest)
+	app.rateLimit = &rateLimiter{rate: float64(c.meteredRateParsed), dest: networkDest && !app.toStdout}
+	logger.Warn(fmt.Sprintf("The connection is metered, transfers are limited to %s per second.\n", c.MeteredRate))
+	return nil
+}
+
+
+// LIMIT UPLOADS BY SCHEDULE ('upload_schedule')
+// Applies to runs with a UNC destination only.
+func (app *BackupApp) scheduleUploads() {
+	schedule := app.BkpConfig.Network.uploadScheduleParsed
+	app.uploadLimit = nil
+	if _, networkDest := uncShare(app.bkpDest); schedule == nil || !networkDest || app.toStdout {
+		return
+	}
+	app.uploadLimit = &rateLimiter{schedule: schedule, dest: true}
+	rate := schedule.rateAt(time.Now())
+	app.uploadLimit.current = float64(rate)
+	logger.Info(fmt.Sprintf("Uploads follow %q, now at %s.\n", "network.upload_schedule", formatUploadRate(rate)))
+}
+
+
+// usesNetwork reports whether the run transfers data over the network: 'ssh://' sources or a UNC destination.
+func (app *BackupApp) usesNetwork() bool {
+	if _, ok := uncShare(app.bkpDest); ok && !app.toStdout {
+		return true
+	}
+	return slices.ContainsFunc(app.BkpConfig.BkpItems, func(item BackupItem) bool { return isRemoteSource(item.Source) })
+}
+
+
+// limitRate returns the reader limited to the metered rate, or the reader itself if transfers are not limited
+// (or it's limited already).
+func (app *BackupApp) limitRate(r io.Reader) io.Reader {
+	return limitReader(r, app.rateLimit)
+}
+
+
+// limitUpload returns the reader limited by 'upload_schedule', or the reader itself if uploads are not scheduled.
+func (app *BackupApp) limitUpload(r io.Reader) io.Reader {
+	return limitReader(r, app.uploadLimit)
+}
+
+
+// limitReader returns the reader limited by the limiter, unless it's nil or the reader is limited by it already.
+func limitReader(r io.Reader, limiter *rateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	for lr, ok := r.(*limitedReader); ok; lr, ok = lr.r.(*limitedReader) {
+		if lr.limiter == limiter {
+			return r
+		}
+	}
+	return &limitedReader{r: r, limiter: limiter}
+}
+
+
+// rateNow returns the rate in bytes per second, 0 if not limited. Changes of the scheduled rate are logged.
+// Caller holds 'mu'.
+func (l *rateLimiter) rateNow(now time.Time) float64 {
+	if l.schedule == nil {
+		return l.rate
+	}
+	rate := float64(l.schedule.rateAt(now))
+	if rate != l.current {
+		l.current = rate
+		logger.Info(fmt.Sprintf("Upload rate changed to %s (%q).\n", formatUploadRate(uint64(rate)), "network.upload_schedule"))
+	}
+	return rate
+}
+
+
+// wait blocks until reading n more bytes keeps the rate. Time not used for reading is not saved up.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	rate := l.rateNow(now)
+	if l.due.Before(now) || rate == 0 {
+		l.due = now
+	}
+	if rate > 0 {
+		l.due = l.due.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	}
+	delay := l.due.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+
+// chunk returns how much to read at a time: a quarter of a second worth of data, so workers take turns smoothly.
+// Returns 0 if not limited.
+func (l *rateLimiter) chunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := l.rateNow(time.Now())
+	if rate == 0 {
+		return 0
+	}
+	return max(int(rate/4), 1)
+}
+
+
+// Read reads at most a chunk of the rate at a time.
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if chunk := lr.limiter.chunk(); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := lr.r.Read(p)
+	lr.limiter.wait(n)
+	return n, err
+}
+
+
+
+//////////////  UPLOAD SCHEDULE  //////////////////////////////////////////////
+
+// parseUploadSchedule parses 'upload_schedule', e.g. "22:00-06:00 full, 12:00-13:00 4mb, else 1mb".
+func parseUploadSchedule(value string) (*uploadSchedule, error) {
+	schedule := &uploadSchedule{}
+	var hasElse bool
+	for _, rule := range strings.Split(value, ",") {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q is not a window and a rate", strings.TrimSpace(rule))
+		}
+		rate, err := parseUploadRate(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if strings.ToLower(fields[0]) == UploadScheduleElse {
+			if hasElse {
+				return nil, fmt.Errorf("%q is given more than once", UploadScheduleElse)
+			}
+			schedule.other, hasElse = rate, true
+			continue
+		}
+		from, to, ok := strings.Cut(fields[0], "-")
+		window := uploadWindow{rate: rate}
+		if !ok {
+			return nil, fmt.Errorf("window %q is not 'HH:MM-HH:MM'", fields[0])
+		}
+		if window.start, err = parseTimeOfDay(from); err == nil {
+			window.end, err = parseTimeOfDay(to)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %v", fields[0], err)
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("window %q is empty", fields[0])
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+	return schedule, nil
+}
+
+
+// parseUploadRate parses the rate of the schedule: "full" (0) or a size per second.
+func parseUploadRate(value string) (uint64, error) {
+	if strings.ToLower(value) == UploadRateFull {
+		return 0, nil
+	}
+	rate, err := parseDiskSize(value)
+	if err != nil || rate < LimitMinMeteredRate {
+		return 0, fmt.Errorf("rate %q is not %q or a size of at least 16kb", value, UploadRateFull)
+	}
+	return rate, nil
+}
+
+
+// parseTimeOfDay parses 'HH:MM' into the time since midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day (HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+
+// rateAt returns the rate of the schedule at the time (local), 0 - full.
+func (s *uploadSchedule) rateAt(now time.Time) uint64 {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	for _, w := range s.windows {
+		if w.start < w.end && clock >= w.start && clock < w.end {
+			return w.rate
+		}
+		if w.start > w.end && (clock >= w.start || clock < w.end) {
+			return w.rate
+		}
+	}
+	return s.other
+}
+
+
+// formatUploadRate returns the rate for messages.
+func formatUploadRate(rate uint64) string {
+	if rate == 0 {
+		return "full speed"
+	}
+	if rate%MB == 0 {
+		return fmt.Sprintf("%dmb per second", rate/MB)
+	}
+	return fmt.Sprintf("%dkb per second", rate/KB)
+}
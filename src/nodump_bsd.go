@@ -0,0 +1,18 @@
+//go:build darwin || freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// File flag set by 'chflags nodump' (sys/stat.h)
+const ufNodump uint32 = 0x00000001
+
+
+// hasNodumpFlag reports whether the file or directory has the "nodump" flag ('chflags nodump').
+func hasNodumpFlag(path string, info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && uint32(stat.Flags)&ufNodump != 0
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode flag set by 'chattr +d' (linux/fs.h)
+const fsNodumpFlag uint32 = 0x00000040
+
+
+// hasNodumpFlag reports whether the file or directory has the "no dump" flag ('chattr +d').
+// File systems without inode flags report none.
+func hasNodumpFlag(path string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return false
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	return err == nil && flags&fsNodumpFlag != 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "os"
+
+// hasNodumpFlag reports no flag: the platform has no "nodump" flag.
+func hasNodumpFlag(path string, info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const offloadMethod = "copy_file_range"
+
+
+// copyOffload copies 'src' into a new file at 'dest' in the kernel (copy_file_range), without passing the data
+// through user space. On some file systems blocks are shared (reflink) or copied server-side (NFS, SMB).
+// Returns errOffloadUnsupported if the kernel or the file systems can't do it and nothing was copied.
+func copyOffload(dest string, src *os.File, chunk int, durable bool, report func(int64)) (int64, error) {
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer destFile.Close()
+
+	var written int64
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(destFile.Fd()), nil, chunk, 0)
+		if err != nil {
+			unsupported := errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) ||
+				errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EBADF)
+			if written == 0 && unsupported {
+				return 0, errOffloadUnsupported
+			}
+			return written, err
+		}
+		if n == 0 {
+			break
+		}
+		written += int64(n)
+		report(int64(n))
+	}
+
+	if durable {
+		return written, destFile.Sync()
+	}
+	return written, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package main
+
+import "os"
+
+const offloadMethod = ""
+
+
+// copyOffload is not supported on this platform, files are copied through the copy buffer.
+func copyOffload(dest string, src *os.File, chunk int, durable bool, report func(int64)) (int64, error) {
+	return 0, errOffloadUnsupported
+}
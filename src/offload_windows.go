@@ -0,0 +1,98 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const offloadMethod = "CopyFileEx"
+
+var (
+	procCopyFileExW = windows.NewLazySystemDLL("kernel32.dll").NewProc("CopyFileExW")
+
+	// Callbacks can't be released, so one progress routine serves all copies; 'lpData' selects the copy
+	copyProgressOnce    sync.Once
+	copyProgressRoutine uintptr
+	copyProgressCopies  sync.Map // id -> *offloadCopy
+	copyProgressNextID  atomic.Uintptr
+)
+
+
+// FILE BEING COPIED BY COPYFILEEX
+type offloadCopy struct {
+	report func(int64)
+	copied int64 // bytes of the main data stream reported so far
+}
+
+
+// copyOffload copies 'src' into a new file at 'dest' with CopyFileEx, which lets Windows pick the fastest way
+// (e.g. server-side copy on SMB, block cloning on ReFS) and also carries over file attributes and alternate data streams.
+// Returns errOffloadUnsupported if the file can't be copied that way (e.g. encrypted file to a non-NTFS destination).
+func copyOffload(dest string, src *os.File, chunk int, durable bool, report func(int64)) (int64, error) {
+	srcPtr, err := windows.UTF16PtrFromString(src.Name())
+	if err != nil {
+		return 0, err
+	}
+	destPtr, err := windows.UTF16PtrFromString(dest)
+	if err != nil {
+		return 0, err
+	}
+
+	// Sizes are passed to the progress routine as 64-bit values, which don't fit callback arguments of 32-bit builds
+	state := &offloadCopy{report: report}
+	var routine, id uintptr
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		copyProgressOnce.Do(func() { copyProgressRoutine = windows.NewCallback(copyProgress) })
+		routine, id = copyProgressRoutine, copyProgressNextID.Add(1)
+		copyProgressCopies.Store(id, state)
+		defer copyProgressCopies.Delete(id)
+	}
+
+	ok, _, err := procCopyFileExW.Call(uintptr(unsafe.Pointer(srcPtr)), uintptr(unsafe.Pointer(destPtr)), routine, id, 0, 0)
+	if ok == 0 {
+		if errors.Is(err, windows.ERROR_NOT_SUPPORTED) || errors.Is(err, windows.ERROR_INVALID_FUNCTION) ||
+			errors.Is(err, windows.ERROR_ENCRYPTION_FAILED) {
+			return 0, errOffloadUnsupported
+		}
+		return 0, err
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		return state.copied, err
+	}
+	if rest := info.Size() - state.copied; rest > 0 {
+		report(rest)
+	}
+
+	// FlushFileBuffers needs write access
+	if durable {
+		f, err := os.OpenFile(dest, os.O_WRONLY, 0)
+		if err != nil {
+			return info.Size(), err
+		}
+		defer f.Close()
+		return info.Size(), f.Sync()
+	}
+	return info.Size(), nil
+}
+
+
+// copyProgress is the CopyFileEx progress routine. It reports progress of the main data stream (stream 1).
+func copyProgress(totalSize, totalTransferred, streamSize, streamTransferred uintptr, stream, reason uint32, src, dest windows.Handle, id uintptr) uintptr {
+	if value, ok := copyProgressCopies.Load(id); ok && stream == 1 {
+		state := value.(*offloadCopy)
+		if n := int64(streamTransferred) - state.copied; n > 0 {
+			state.copied += n
+			state.report(n)
+		}
+	}
+	return 0 // PROGRESS_CONTINUE
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With 'pack_small_files', files up to the size limit are appended to pack files of the backup
+// (report/packs/pack-NNNN.dat) instead of being created one by one, which saves the per-file create,
+// chmod and attribute calls that dominate backups of millions of tiny files.
+// The pack index (report/packs/smbkp-pack-index.tsv) lists the location, permissions and modification time
+// of each packed file under its destination path. Packed files are listed in the manifest like the others,
+// so 'verify', 'find', 'compare' and 'dedup' work as usual (packed files are copied, not hard-linked).
+// The pack index is signed along with the manifest (and signed again when 'check' or 'migrate' rewrite it).
+// Its paths are checked when it's read, so a tampered index can't point outside the backup directory.
+// There is no restore command: a backup is restored by copying its files back. 'export' reads packed files
+// transparently, and 'unpack' extracts them into place, which turns the backup into a plain copy again before
+// a restore by copying. Only content, permissions and modification time of packed files are kept.
+
+const (
+	PackDirName            string = "packs"
+	PackIndexFileName      string = "smbkp-pack-index.tsv"
+	PackIndexHeader        string = "# smbkp pack index v1\n# pack\toffset\tsize\tmode\tmtime\tpath\n"
+	PackFileMaxSize        int64  = 256 * MB // a new pack file is started when the current one would exceed it
+	LimitMaxPackSmallFiles uint64 = 1 * MB
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PACKED FILE (one line of the pack index)
+type packEntry struct {
+	pack    string // pack file name
+	offset  int64
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	path    string // slash-separated destination path on disk, relative to the backup directory
+}
+
+
+// PACK FILES OF THE CURRENT RUN
+// Safe for concurrent use by copy workers.
+type packWriter struct {
+	mu     sync.Mutex
+	dir    string
+	limit  uint64 // files up to this size are packed
+	file   *os.File
+	name   string
+	seq    int
+	offset int64
+	index  []packEntry
+}
+
+
+
+//////////////  PACKING FUNCTIONS  ////////////////////////////////////////////
+
+// START PACKING SMALL FILES (if 'pack_small_files' is set and backup is written to disk)
+func (app *BackupApp) startPacking() {
+	if app.BkpConfig.packSmallFilesParsed == 0 || app.toStdout {
+		return
+	}
+	app.packs = &packWriter{
+		dir:   filepath.Join(app.bkpDestFullPath, ReportDirName, PackDirName),
+		limit: app.BkpConfig.packSmallFilesParsed,
+	}
+}
+
+
+// packed reports whether the file goes into a pack file instead of its own destination file.
+func (app *BackupApp) packed(info os.FileInfo) bool {
+	return app.packs != nil && app.tarOut == nil && uint64(info.Size()) <= app.packs.limit
+}
+
+
+// PACK FILE CONTENT UNDER ITS DESTINATION PATH
+// The file is read before the pack is locked, so slow sources don't hold up other workers
+// (packed files are small, so reading them into memory is cheap).
+func (app *BackupApp) packFile(dest string, r io.Reader, info os.FileInfo, settle func() error) error {
+	rel, err := filepath.Rel(app.bkpDestFullPath, dest)
+	if err != nil {
+		return err
+	}
+	hash := newHash(app.BkpConfig.HashAlgorithm)
+	data, err := io.ReadAll(io.TeeReader(r, hash))
+	if err != nil {
+		return err
+	}
+	if settle != nil {
+		if err := settle(); err != nil {
+			return err
+		}
+	}
+
+	entry := packEntry{size: int64(len(data)), mode: info.Mode().Perm(), modTime: info.ModTime(), path: filepath.ToSlash(rel)}
+	if err := app.packs.add(entry, data, app.BkpConfig.Durability == DurabilityFsync); err != nil {
+		return fmt.Errorf("packing: %w", err)
+	}
+	app.recordFile(dest, entry.size, info.ModTime(), hash.Sum(nil))
+	return nil
+}
+
+
+// add appends the content to the current pack file (starting a new one if needed) and indexes it.
+func (w *packWriter) add(entry packEntry, data []byte, durable bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || (w.offset > 0 && w.offset+entry.size > PackFileMaxSize) {
+		if err := w.closePack(durable); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			return err
+		}
+		w.seq++
+		w.name = fmt.Sprintf("pack-%04d.dat", w.seq)
+		f, err := os.Create(filepath.Join(w.dir, w.name))
+		if err != nil {
+			return err
+		}
+		w.file, w.offset = f, 0
+	}
+
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	entry.pack, entry.offset = w.name, w.offset
+	w.offset += entry.size
+	w.index = append(w.index, entry)
+	return nil
+}
+
+
+// closePack closes the current pack file, syncing it to disk with 'durable'.
+func (w *packWriter) closePack(durable bool) error {
+	if w.file == nil {
+		return nil
+	}
+	f := w.file
+	w.file = nil
+	if durable {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+
+// FINISH PACKING: CLOSE THE LAST PACK FILE AND WRITE THE PACK INDEX
+// Must be called after all files are copied.
+func (app *BackupApp) finishPacking() error {
+	w := app.packs
+	if w == nil {
+		return nil
+	}
+	app.packs = nil
+	if err := w.closePack(app.BkpConfig.Durability == DurabilityFsync); err != nil {
+		return err
+	}
+	if len(w.index) == 0 {
+		return nil
+	}
+
+	data, err := app.sealReport(packIndexText(w.index))
+	if err != nil {
+		return fmt.Errorf("encrypting pack index: %w", err)
+	}
+	if err := app.writeBytes(filepath.Join(w.dir, PackIndexFileName), data); err != nil {
+		return fmt.Errorf("writing pack index: %w", err)
+	}
+	logger.Verbose(fmt.Sprintf("Packed %d small files into %d pack files.\n", len(w.index), w.seq))
+	return nil
+}
+
+
+// packIndexText formats the pack index file content.
+func packIndexText(entries []packEntry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(PackIndexHeader)
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "%s\t%d\t%d\t%o\t%s\t%s\n", entry.pack, entry.offset, entry.size, entry.mode, entry.modTime.UTC().Format(time.RFC3339Nano), manifestPathEscaper.Replace(entry.path))
+	}
+	return buf.Bytes()
+}
+
+
+// readPackIndex returns the packed files of the backup by their paths on disk (empty if nothing was packed).
+func readPackIndex(dir string) (map[string]packEntry, error) {
+	data, err := readReportData(filepath.Join(dir, ReportDirName, PackDirName, PackIndexFileName))
+	if os.IsNotExist(err) {
+		return map[string]packEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]packEntry)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("pack index line %d: expected 6 fields, got %d", line, len(fields))
+		}
+		offset, err1 := strconv.ParseInt(fields[1], 10, 64)
+		size, err2 := strconv.ParseInt(fields[2], 10, 64)
+		mode, err3 := strconv.ParseUint(fields[3], 8, 32)
+		modTime, err4 := time.Parse(time.RFC3339Nano, fields[4])
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return nil, fmt.Errorf("pack index line %d is malformed", line)
+		}
+		path := manifestPathUnescaper.Replace(fields[5])
+		if fields[0] != filepath.Base(fields[0]) || fields[0] == "." || fields[0] == ".." || !localRelPath(path) {
+			return nil, fmt.Errorf("pack index line %d points outside the backup directory", line)
+		}
+		entry := packEntry{
+			pack:    fields[0],
+			offset:  offset,
+			size:    size,
+			mode:    os.FileMode(mode).Perm(),
+			modTime: modTime,
+			path:    path,
+		}
+		index[entry.path] = entry
+	}
+	return index, scanner.Err()
+}
+
+
+// packIndexName returns the slash-separated path of the pack index, relative to the backup directory.
+func packIndexName() string {
+	return reportFile(PackDirName) + "/" + PackIndexFileName
+}
+
+
+// localRelPath reports whether the slash-separated path stays inside the directory it's relative to:
+// not absolute, and without '..' components.
+func localRelPath(path string) bool {
+	if path == "" || strings.HasPrefix(path, "/") || filepath.IsAbs(filepath.FromSlash(path)) || filepath.VolumeName(filepath.FromSlash(path)) != "" {
+		return false
+	}
+	for _, part := range strings.Split(strings.ReplaceAll(path, `\`, "/"), "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+
+// insideDir reports whether the path is inside the directory.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+
+// openPacked returns a reader of the packed file content. The caller closes the pack file.
+func openPacked(dir string, entry packEntry) (*os.File, io.Reader, error) {
+	packDir := filepath.Join(dir, ReportDirName, PackDirName)
+	path := filepath.Join(packDir, entry.pack)
+	if !insideDir(packDir, path) {
+		return nil, nil, fmt.Errorf("pack file %q is outside the pack directory", entry.pack)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, io.NewSectionReader(f, entry.offset, entry.size), nil
+}
+
+
+// packedChecksum returns the size and hex-encoded checksum of the packed file.
+func packedChecksum(dir string, entry packEntry, algorithm string) (int64, string, error) {
+	f, r, err := openPacked(dir, entry)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := newHash(algorithm)
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+
+
+//////////////  UNPACK COMMAND  ///////////////////////////////////////////////
+
+// RUN 'UNPACK' COMMAND
+func runUnpackCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backup, err := resolveBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+
+	unpacked, err := unpackBackup(backup, app.BkpConfig.immutableBackups())
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Unpacking failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if unpacked == 0 {
+		logger.Info(fmt.Sprintf("Backup %s has no packed files.\n\n", backup.name))
+		return 0
+	}
+	logger.Ok(fmt.Sprintf("Unpacked %d files, backup %s is a plain copy now.\n\n", unpacked, backup.name), style.Bold())
+	return 0
+}
+
+
+// UNPACK PACKED FILES OF THE BACKUP INTO PLACE
+// Pack files are removed once everything is extracted. Protection of read-only backups is lifted
+// for the time of unpacking. Returns the number of unpacked files.
+func unpackBackup(backup backupDir, immutable bool) (int, error) {
+	index, err := readPackIndex(backup.path)
+	if err != nil {
+		return 0, err
+	}
+	if len(index) == 0 {
+		return 0, nil
+	}
+
+	info, err := os.Stat(backup.path)
+	if err != nil {
+		return 0, err
+	}
+	protected := info.Mode().Perm()&0200 == 0
+	if protected {
+		if err := unlockBackup(backup.path); err != nil {
+			return 0, fmt.Errorf("lifting read-only protection: %w", err)
+		}
+	}
+
+	spin := newSpinner("Unpacking")
+	unpacked := 0
+	for _, entry := range index {
+		if err := unpackFile(backup.path, entry); err != nil {
+			spin.clear()
+			return unpacked, fmt.Errorf("%s: %w", entry.path, err)
+		}
+		unpacked++
+		spin.update(fmt.Sprintf("%d/%d files", unpacked, len(index)))
+	}
+	spin.clear()
+
+	if err := os.RemoveAll(filepath.Join(backup.path, ReportDirName, PackDirName)); err != nil {
+		return unpacked, fmt.Errorf("removing pack files: %w", err)
+	}
+	if err := updateSignature(backup.path, packIndexName(), nil); err != nil {
+		return unpacked, fmt.Errorf("updating signature: %w", err)
+	}
+	if protected {
+		if err := lockBackup(backup.path, immutable); err != nil {
+			return unpacked, fmt.Errorf("restoring read-only protection: %w", err)
+		}
+	}
+	return unpacked, nil
+}
+
+
+// unpackFile extracts the packed file to its destination path, with its permissions and modification time.
+func unpackFile(dir string, entry packEntry) error {
+	f, r, err := openPacked(dir, entry)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dest := filepath.Join(dir, filepath.FromSlash(entry.path))
+	if !insideDir(dir, dest) {
+		return fmt.Errorf("destination is outside the backup directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dest, entry.mode); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, entry.modTime, entry.modTime)
+}
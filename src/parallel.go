@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"simple-backup/src/style"
+	"sync"
+)
+
+// With 'parallel_items', several items are backed up at the same time (useful when the destination
+// is faster than any single source). Items start in the order they are listed; an item with 'after'
+// starts only when the listed items (by destination) have finished, and later items wait for it to start.
+// Running items share one status line, and their results are merged into the summary in the listed order.
+// Items are backed up one by one for the tar stream (it's sequential) and when any item uses 'run_as'
+// (credentials are switched for the whole process). With '-exit-on-error', no new item starts after
+// a failure and the run stops once the running items finish (without asking).
+
+const (
+	ParallelItemsDefault  uint16 = 1
+	LimitMaxParallelItems uint16 = 16
+)
+
+
+
+//////////////  PARALLEL ITEMS FUNCTIONS  /////////////////////////////////////
+
+// parallelItems returns the number of items to back up at the same time, and why it's reduced to 1 (if it is).
+func (app *BackupApp) parallelItems() (int, string) {
+	workers := int(app.BkpConfig.ParallelItems)
+	if workers <= 1 {
+		return 1, ""
+	}
+	if app.toStdout {
+		return 1, "tar stream is sequential"
+	}
+	for _, item := range app.BkpConfig.BkpItems {
+		if item.RunAs != "" {
+			return 1, fmt.Sprintf("%q switches credentials of the whole process", "run_as")
+		}
+	}
+	return min(workers, len(app.BkpConfig.BkpItems)), ""
+}
+
+
+// BACK UP ALL ITEMS
+// Returns results in the order items are listed.
+func (app *BackupApp) runItems() ([]BackupResult, error) {
+	workers, _ := app.parallelItems()
+	if workers == 1 {
+		return app.runItemsSequentially()
+	}
+	return app.runItemsInParallel(workers)
+}
+
+
+// BACK UP ITEMS ONE BY ONE
+func (app *BackupApp) runItemsSequentially() ([]BackupResult, error) {
+	var results []BackupResult
+	for i, item := range app.BkpConfig.BkpItems {
+		result, err := app.runItem(i, item)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+
+		if result.Error != nil && app.exitOnError {
+			if !app.nonInteractive {
+				logger.Warn("\n"+tr(msgExitOnErrorPrompt), style.NoLabel())
+				if !app.awaitAnswer(msgAnswerNo) {
+					return nil, fmt.Errorf("backup stopped (with user consent) due to error: %w", result.Error)
+				}
+			} else {
+				return nil, fmt.Errorf("backup stopped due to error: %w", result.Error)
+			}
+		}
+	}
+	return results, nil
+}
+
+
+// BACK UP ITEMS IN PARALLEL ('PARALLEL_ITEMS')
+// Items are started in the listed order, each one after its 'after' dependencies have finished.
+func (app *BackupApp) runItemsInParallel(workers int) ([]BackupResult, error) {
+	items := app.BkpConfig.BkpItems
+	deps, err := itemDependencies(items)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("Backing up up to %d items at the same time.\n", workers))
+
+	app.board = &progressBoard{}
+	defer func() { app.board = nil }()
+
+	results := make([]BackupResult, len(items))
+	done := make([]chan struct{}, len(items))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	slots := make(chan struct{}, workers)
+	var (
+		mu      sync.Mutex
+		fatal   error // credentials can't be restored
+		failure error // first failed item, stops the run with '-exit-on-error'
+		wg      sync.WaitGroup
+	)
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return fatal != nil || (failure != nil && app.exitOnError)
+	}
+
+	for i, item := range items {
+		for _, dep := range deps[i] {
+			<-done[dep]
+		}
+		slots <- struct{}{}
+		if stopped() {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer handlePanic()
+			defer wg.Done()
+			defer close(done[i])
+			defer func() { <-slots }()
+
+			result, err := app.runItem(i, item)
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			if err != nil && fatal == nil {
+				fatal = err
+			}
+			if result.Error != nil && failure == nil {
+				failure = result.Error
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fatal != nil {
+		return nil, fatal
+	}
+	if failure != nil && app.exitOnError {
+		return nil, fmt.Errorf("backup stopped due to error: %w", failure)
+	}
+	return results, nil
+}
+
+
+// itemDependencies returns indexes of the items each item has to wait for ('after').
+// Items can wait only for items listed before them, so dependencies can't form a cycle.
+func itemDependencies(items []BackupItem) ([][]int, error) {
+	deps := make([][]int, len(items))
+	for i, item := range items {
+		for _, after := range item.After {
+			target := path.Clean(filepath.ToSlash(after))
+			found := slices.IndexFunc(items[:i], func(other BackupItem) bool {
+				return path.Clean(filepath.ToSlash(other.Destination)) == target
+			})
+			if found < 0 {
+				return nil, fmt.Errorf("item %d: %q value %q doesn't match the destination of any item listed before it", i+1, "after", after)
+			}
+			deps[i] = append(deps[i], found)
+		}
+	}
+	return deps, nil
+}
+
+
+// progressOf returns the progress of the item the destination file belongs to.
+func (app *BackupApp) progressOf(dest string) *progress {
+	if app.board != nil {
+		return app.board.progressOf(dest)
+	}
+	return app.progress
+}
+
+
+// addSkipped records source paths that were not copied. Safe for concurrent use.
+func (app *BackupApp) addSkipped(entries ...skippedEntry) {
+	app.skippedMu.Lock()
+	defer app.skippedMu.Unlock()
+	app.skipped = append(app.skipped, entries...)
+}
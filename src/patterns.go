@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Include and exclude patterns of an item are compiled once, when its source is walked, instead of being
+// evaluated as strings for every path. Patterns are relative to the item source and matched level by level
+// ('/' between levels, or '\' on Windows):
+//   '*', '?', '[...]' - within one level (see filepath.Match), e.g. '*.tmp' matches 'a.tmp' at the top level only
+//   '**'              - any number of levels, e.g. '**/*.tmp' matches 'a.tmp', 'x/a.tmp' and 'x/y/a.tmp'
+// A pattern that matches a directory covers everything in it ('cache' covers 'cache/x', not 'cache2/x').
+// With include patterns, directories on the way to a possible match are walked too (e.g. 'src' for 'src/*.go'),
+// other paths are left out. Patterns without wildcards are looked up in a tree of their levels,
+// so a long list of them doesn't slow down the walk.
+
+const PatternAnyLevels string = "**"
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// COMPILED INCLUDE AND EXCLUDE PATTERNS OF AN ITEM
+type patternMatcher struct {
+	include *patternSet // nil if the item has no include patterns
+	exclude *patternSet
+}
+
+
+// PATTERNS OF ONE KIND
+type patternSet struct {
+	literal *patternNode    // patterns without wildcards, by level
+	globs   []globPattern
+}
+
+
+// LEVEL OF LITERAL PATTERNS
+type patternNode struct {
+	children map[string]*patternNode
+	pattern  string // set if a pattern ends at this level
+}
+
+
+// PATTERN WITH WILDCARDS
+type globPattern struct {
+	pattern string
+	levels  []globLevel
+}
+
+
+// LEVEL OF A PATTERN WITH WILDCARDS
+// Names are checked against the literal text around wildcards first, which settles most of them.
+type globLevel struct {
+	pattern string
+	prefix  string // literal text before the first wildcard
+	suffix  string // literal text after the last wildcard, if it can be told
+	exact   bool   // no wildcards, the name is the prefix
+	simple  bool   // a single '*' between prefix and suffix, nothing else to check
+}
+
+
+
+//////////////  PATTERN FUNCTIONS  ////////////////////////////////////////////
+
+// compilePatterns compiles include and exclude patterns of an item. Empty patterns are ignored.
+func compilePatterns(include, exclude []string) (*patternMatcher, error) {
+	m := &patternMatcher{}
+	var err error
+	if len(include) > 0 {
+		if m.include, err = compilePatternSet(include); err != nil {
+			return nil, err
+		}
+	}
+	if m.exclude, err = compilePatternSet(exclude); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+
+// compilePatternSet sorts the patterns into the tree of literal ones and the list of ones with wildcards.
+func compilePatternSet(patterns []string) (*patternSet, error) {
+	s := &patternSet{literal: &patternNode{}}
+	for _, pattern := range patterns {
+		levels := patternLevels(pattern)
+		if len(levels) == 0 {
+			continue
+		}
+		literal := true
+		for _, level := range levels {
+			if _, err := filepath.Match(level, ""); err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+			literal = literal && !hasWildcards(level)
+		}
+		if !literal {
+			glob := globPattern{pattern: pattern}
+			for _, level := range levels {
+				glob.levels = append(glob.levels, compileLevel(level))
+			}
+			s.globs = append(s.globs, glob)
+			continue
+		}
+		node := s.literal
+		for _, level := range levels {
+			child := node.children[level]
+			if child == nil {
+				if node.children == nil {
+					node.children = make(map[string]*patternNode)
+				}
+				child = &patternNode{}
+				node.children[level] = child
+			}
+			node = child
+		}
+		if node.pattern == "" {
+			node.pattern = pattern
+		}
+	}
+	return s, nil
+}
+
+
+// EXPLAIN WHY INCLUDE/EXCLUDE PATTERNS LEAVE THE PATH OUT
+// 'relPath' is relative to the item source. Returns empty string if the path is included.
+func (m *patternMatcher) exclusion(relPath string, isDir bool) string {
+	levels := strings.Split(filepath.ToSlash(relPath), "/")
+	if m.include != nil {
+		if _, ok := m.include.match(levels); !ok && !(isDir && m.include.leadsTo(levels)) {
+			return "excluded: no include pattern matches"
+		}
+	}
+
+	// Exclude takes priority
+	if pattern, ok := m.exclude.match(levels); ok {
+		return fmt.Sprintf("excluded: pattern %q", pattern)
+	}
+	return ""
+}
+
+
+// match returns the pattern that matches the path or a directory it's in.
+func (s *patternSet) match(levels []string) (string, bool) {
+	node := s.literal
+	for _, level := range levels {
+		if node = node.children[level]; node == nil {
+			break
+		}
+		if node.pattern != "" {
+			return node.pattern, true
+		}
+	}
+	for _, glob := range s.globs {
+		if covered, _ := matchLevels(glob.levels, levels); covered {
+			return glob.pattern, true
+		}
+	}
+	return "", false
+}
+
+
+// leadsTo reports whether paths in the directory may match a pattern.
+func (s *patternSet) leadsTo(levels []string) bool {
+	node := s.literal
+	for _, level := range levels {
+		if node = node.children[level]; node == nil {
+			break
+		}
+	}
+	if node != nil && len(node.children) > 0 {
+		return true
+	}
+	for _, glob := range s.globs {
+		if _, partial := matchLevels(glob.levels, levels); partial {
+			return true
+		}
+	}
+	return false
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// matchLevels matches the path against the pattern, level by level. Returns whether the pattern matches
+// the path or a directory it's in (covered), and whether the path ends before the pattern does (partial),
+// so paths in it may match.
+func matchLevels(pattern []globLevel, path []string) (covered, partial bool) {
+	for len(pattern) > 0 {
+		if len(path) == 0 {
+			return false, true
+		}
+		if pattern[0].pattern == PatternAnyLevels {
+			// Zero levels, or one more level and try again
+			if covered, partial = matchLevels(pattern[1:], path); covered {
+				return true, partial
+			}
+			covered, more := matchLevels(pattern, path[1:])
+			return covered, partial || more
+		}
+		if !pattern[0].match(path[0]) {
+			return false, false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return true, false
+}
+
+
+// compileLevel finds the literal text around wildcards of the pattern level.
+func compileLevel(level string) globLevel {
+	l := globLevel{pattern: level}
+	first := strings.IndexAny(level, wildcards())
+	if first < 0 {
+		l.prefix, l.exact = level, true
+		return l
+	}
+	l.prefix = level[:first]
+	if strings.ContainsAny(level, `[\`) {
+		return l // the end of a character class or an escaped character is not literal
+	}
+	last := strings.LastIndexAny(level, wildcards())
+	l.suffix = level[last+1:]
+	l.simple = first == last && level[first] == '*'
+	return l
+}
+
+
+// match reports whether the name matches the pattern level.
+func (l globLevel) match(name string) bool {
+	if l.exact {
+		return name == l.prefix
+	}
+	if len(name) < len(l.prefix)+len(l.suffix) || !strings.HasPrefix(name, l.prefix) || !strings.HasSuffix(name, l.suffix) {
+		return false
+	}
+	if l.simple {
+		return true
+	}
+	ok, _ := filepath.Match(l.pattern, name)
+	return ok
+}
+
+
+// patternLevels splits the pattern into levels. Empty and '.' levels are dropped, repeated '**' are merged.
+func patternLevels(pattern string) []string {
+	if runtime.GOOS == "windows" {
+		pattern = strings.ReplaceAll(pattern, `\`, "/")
+	}
+	var levels []string
+	for _, level := range strings.Split(pattern, "/") {
+		if level == "" || level == "." || (level == PatternAnyLevels && len(levels) > 0 && levels[len(levels)-1] == PatternAnyLevels) {
+			continue
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+
+// hasWildcards reports whether the pattern level has characters special to filepath.Match.
+func hasWildcards(level string) bool {
+	return strings.ContainsAny(level, wildcards())
+}
+
+
+// wildcards returns the characters special to filepath.Match ('\' escapes characters, except on Windows).
+func wildcards() string {
+	if runtime.GOOS == "windows" {
+		return "*?["
+	}
+	return `*?[\`
+}
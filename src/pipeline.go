@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// The '-to-stdout' tar stream goes through a pipeline of stages on its way to stdout:
+//   read (sources) -> tar -> compress ('compression') -> encrypt ('encryption.gpg_recipients') -> write (stdout)
+// Each stage wraps the writer of the next one, so stages combine freely, and a new stage (another compressor,
+// encryption tool, or a writer other than stdout) is added in 'streamStages' without touching the copy code.
+// Directory backups are plain copies: verification, hard-linking and copy offload depend on it.
+
+const (
+	CompressionNone string = "none"
+	CompressionGzip string = "gzip"
+	ZstdBinary      string = "zstd" // 'export' to .tar.zst archives
+)
+
+var compressionMethods = []string{CompressionNone, CompressionGzip}
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// STAGE OF THE STREAM PIPELINE
+type pipelineStage interface {
+	name() string
+	// wrap returns the writer of the stage, which writes its output to 'next'.
+	// Closing it flushes the stage, but doesn't close 'next'.
+	wrap(next io.Writer) (io.WriteCloser, error)
+}
+
+
+// COMPRESSION STAGE
+type gzipStage struct{}
+
+
+// ZSTD COMPRESSION STAGE (external 'zstd' tool)
+type zstdStage struct{}
+
+
+// EXTERNAL PROCESS OF A STAGE (e.g. gpg), READING THE STREAM FROM STDIN
+type processPipe struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+}
+
+
+// RUNNING STREAM PIPELINE
+type streamPipeline struct {
+	writers []io.WriteCloser // stage writers, from the first stage to the last
+}
+
+
+
+//////////////  PIPELINE FUNCTIONS  ///////////////////////////////////////////
+
+// streamStages returns the configured stages of the tar stream, in the order the data goes through them.
+func (app *BackupApp) streamStages() []pipelineStage {
+	var stages []pipelineStage
+	if app.BkpConfig.Compression == CompressionGzip {
+		stages = append(stages, gzipStage{})
+	}
+	if len(app.BkpConfig.Encryption.GPGRecipients) > 0 {
+		stages = append(stages, gpgStage{config: app.BkpConfig.Encryption})
+	}
+	return stages
+}
+
+
+// OPEN PIPELINE OF THE STAGES WRITING TO 'OUT'
+// Returns the writer of the first stage (or 'out' itself, if there are no stages).
+func openPipeline(stages []pipelineStage, out io.Writer) (*streamPipeline, io.Writer, error) {
+	pipeline := &streamPipeline{writers: make([]io.WriteCloser, len(stages))}
+	w := out
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage, err := stages[i].wrap(w)
+		if err != nil {
+			pipeline.writers = pipeline.writers[i+1:]
+			pipeline.close()
+			return nil, nil, err
+		}
+		pipeline.writers[i] = stage
+		w = stage
+	}
+	return pipeline, w, nil
+}
+
+
+// close flushes the stages in data order, so each one gets the rest of the data before it's closed.
+// Returns the first error.
+func (p *streamPipeline) close() error {
+	var first error
+	for _, w := range p.writers {
+		if err := w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+
+// describePipeline returns the stages for the configuration review (e.g. "tar -> gzip -> gpg -> stdout").
+func describePipeline(stages []pipelineStage) string {
+	names := []string{"tar"}
+	for _, stage := range stages {
+		names = append(names, stage.name())
+	}
+	return strings.Join(append(names, "stdout"), " -> ")
+}
+
+
+func (gzipStage) name() string { return "gzip" }
+
+
+// wrap starts gzip compression into the next stage.
+func (gzipStage) wrap(next io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(next), nil
+}
+
+
+func (zstdStage) name() string { return "zstd" }
+
+
+// wrap starts zstd compression (all cores) into the next stage.
+func (zstdStage) wrap(next io.Writer) (io.WriteCloser, error) {
+	return startProcessPipe("zstd", exec.Command(ZstdBinary, "-q", "-c", "-T0"), next)
+}
+
+
+// START EXTERNAL PROCESS WRITING ITS OUTPUT TO THE NEXT STAGE
+func startProcessPipe(name string, cmd *exec.Cmd, next io.Writer) (*processPipe, error) {
+	pipe := &processPipe{name: name, cmd: cmd}
+	pipe.cmd.Stdout = next
+	pipe.cmd.Stderr = &pipe.stderr
+	stdin, err := pipe.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	pipe.stdin = stdin
+	if err := pipe.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+	return pipe, nil
+}
+
+
+// Write passes the stream to the process.
+func (p *processPipe) Write(data []byte) (int, error) {
+	n, err := p.stdin.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", p.name, err) // process output is reported by Close
+	}
+	return n, nil
+}
+
+
+// Close ends the input and waits until the process writes the rest of its output.
+func (p *processPipe) Close() error {
+	p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w%s", p.name, err, p.details())
+	}
+	return nil
+}
+
+
+// details returns the process error output, if any, to append to an error.
+func (p *processPipe) details() string {
+	if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+		return " (" + msg + ")"
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Cloud sync clients (OneDrive, Dropbox, iCloud on Windows) keep "online-only" files as placeholders:
+// reading one downloads the whole file, which may be gigabytes or fail when offline.
+// 'cloud_placeholders' decides what happens to them:
+//   skip    - not copied, listed in the skipped report
+//   hydrate - downloaded and copied like any other file
+//   record  - not copied, listed with size and modification time in 'report/smbkp-placeholders.tsv' (default)
+// Placeholders are recognized by their Windows attributes, other platforms have none.
+
+const (
+	CloudPlaceholdersSkip    string = "skip"
+	CloudPlaceholdersHydrate string = "hydrate"
+	CloudPlaceholdersRecord  string = "record"
+	PlaceholdersFileName     string = "smbkp-placeholders.tsv"
+)
+
+var cloudPlaceholderPolicies = []string{CloudPlaceholdersSkip, CloudPlaceholdersHydrate, CloudPlaceholdersRecord}
+
+
+
+//////////////  PLACEHOLDER FUNCTIONS  ////////////////////////////////////////
+
+// cloudPlaceholder applies the 'cloud_placeholders' policy to the online-only file.
+// Returns true if the file is not copied.
+func (wl *workList) cloudPlaceholder(policy, path string, info os.FileInfo) bool {
+	wl.placeholders = append(wl.placeholders, workEntry{path: path, info: info})
+	switch policy {
+	case CloudPlaceholdersSkip:
+		wl.skip(path, "excluded: cloud placeholder")
+		return true
+	case CloudPlaceholdersRecord:
+		return true
+	}
+	return false
+}
+
+
+// REPORT CLOUD PLACEHOLDERS OF THE ITEM
+func (app *BackupApp) reportPlaceholders(work *workList) {
+	if len(work.placeholders) == 0 {
+		return
+	}
+	app.placeholders = append(app.placeholders, work.placeholders...)
+
+	var size int64
+	for _, entry := range work.placeholders {
+		size += entry.info.Size()
+	}
+	switch app.BkpConfig.CloudPlaceholders {
+	case CloudPlaceholdersHydrate:
+		logger.Warn(fmt.Sprintf("%d online-only files (%s) will be downloaded from cloud storage.\n", len(work.placeholders), formatBytes(uint64(size))))
+	default:
+		logger.Sub(fmt.Sprintf("Online-only files not copied (%q): %d (%s)\n", "cloud_placeholders: "+app.BkpConfig.CloudPlaceholders, len(work.placeholders), formatBytes(uint64(size))))
+	}
+}
+
+
+// placeholdersReport renders recorded placeholders, one 'path<TAB>size<TAB>mtime' line per file.
+func (app *BackupApp) placeholdersReport() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# path\tsize\tmtime\n")
+	for _, entry := range app.placeholders {
+		fmt.Fprintf(&buf, "%s\t%d\t%s\n", manifestPathEscaper.Replace(entry.path), entry.info.Size(), entry.info.ModTime().UTC().Format(time.RFC3339))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 'plan' prints what the next backup run would do, in a machine-readable form:
+// effective configuration, destination, file and byte estimates of each item, and retention actions.
+// Nothing is written to the destination, so the plan can be reviewed (or attached to a ticket) before the run.
+// Console messages go to stderr, so stdout has the plan only.
+
+const (
+	PlanOutputYAML string = "yaml"
+	PlanOutputJSON string = "json"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PLAN OF THE NEXT BACKUP RUN
+type backupPlan struct {
+	Version     string          `json:"version" yaml:"version"`
+	Generated   time.Time       `json:"generated" yaml:"generated"`
+	Host        string          `json:"host" yaml:"host"`
+	ConfigFile  string          `json:"config_file" yaml:"config_file"`
+	Config      map[string]any  `json:"config" yaml:"config"` // effective configuration, with defaults applied
+	Destination planDestination `json:"destination" yaml:"destination"`
+	Items       []planItem      `json:"items" yaml:"items"`
+	Retention   planRetention   `json:"retention" yaml:"retention"`
+}
+
+
+// DESTINATION OF THE PLANNED RUN
+type planDestination struct {
+	Root          string `json:"root" yaml:"root"` // backup directory of the run is created in it
+	FreeSpace     uint64 `json:"free_space" yaml:"free_space"`
+	RequiredSpace uint64 `json:"required_space" yaml:"required_space"` // estimated size of items plus 'min_free_space'
+	Sufficient    bool   `json:"sufficient" yaml:"sufficient"`
+}
+
+
+// ESTIMATE OF THE PLANNED ITEM
+type planItem struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Files       int    `json:"files" yaml:"files"`
+	Dirs        int    `json:"dirs" yaml:"dirs"`
+	Bytes       *int64 `json:"bytes" yaml:"bytes"` // unknown for streams
+	Skipped     int    `json:"skipped" yaml:"skipped"`
+	Truncated   string `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+	OverMaxSize bool   `json:"over_max_size,omitempty" yaml:"over_max_size,omitempty"` // 'max_size_action' applies
+	Error       string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+
+// RETENTION ACTIONS AFTER A SUCCESSFUL RUN
+type planRetention struct {
+	BackupsToKeep        uint16       `json:"backups_to_keep" yaml:"backups_to_keep"`
+	ExistingBackups      int          `json:"existing_backups" yaml:"existing_backups"`
+	Action               string       `json:"action" yaml:"action"`                               // "remove" or "trash"
+	RequiresConfirmation bool         `json:"requires_confirmation" yaml:"requires_confirmation"` // more than 'confirm_above' backups
+	Remove               []planBackup `json:"remove" yaml:"remove"`
+}
+
+
+// EXISTING BACKUP PLANNED FOR REMOVAL
+type planBackup struct {
+	Name    string    `json:"name" yaml:"name"`
+	State   string    `json:"state" yaml:"state"`
+	Created time.Time `json:"created" yaml:"created"`
+	Size    uint64    `json:"size" yaml:"size"`
+}
+
+
+
+//////////////  PLAN COMMAND  /////////////////////////////////////////////////
+
+// RUN 'PLAN' COMMAND
+func runPlanCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		output     = flags.StringP("output", "o", PlanOutputYAML, "Output format: yaml or json.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+	logger.SetOutput(os.Stderr)
+
+	if *output != PlanOutputYAML && *output != PlanOutputJSON {
+		logger.Fatal(fmt.Sprintf("%q value %q is not supported. Expected %q or %q\n\n", "output", *output, PlanOutputYAML, PlanOutputJSON), style.Bold())
+		return 1
+	}
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	defer app.closeRemoteClients()
+
+	plan, err := app.buildPlan()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Plan failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	var data []byte
+	if *output == PlanOutputJSON {
+		data, err = json.MarshalIndent(plan, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(plan)
+	}
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Plan failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+
+// BUILD PLAN OF THE NEXT BACKUP RUN
+// Items are enumerated the same way the backup does it, so estimates include patterns and limits.
+func (app *BackupApp) buildPlan() (*backupPlan, error) {
+	config, err := effectiveConfig(app.BkpConfig)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	plan := &backupPlan{
+		Version:    Version,
+		Generated:  time.Now().UTC().Truncate(time.Second),
+		Host:       host,
+		ConfigFile: app.configFile,
+		Config:     config,
+		Items:      []planItem{},
+	}
+
+	// Items
+	var total int64
+	for _, item := range app.BkpConfig.BkpItems {
+		logger.Plain(fmt.Sprintf("Estimating %s\n", itemSourceLabel(item)))
+		entry := planItem{Source: itemSourceLabel(item), Destination: item.Destination}
+		work, err := app.enumerateItem(item)
+		if err != nil {
+			entry.Error = err.Error()
+			plan.Items = append(plan.Items, entry)
+			continue
+		}
+		entry.Files, entry.Dirs, entry.Skipped, entry.Truncated = work.files, work.dirs, len(work.skipped), work.truncated
+		if !isStreamItem(item) {
+			bytes := work.bytes
+			entry.Bytes = &bytes
+			entry.OverMaxSize = item.maxSizeParsed > 0 && uint64(bytes) > item.maxSizeParsed
+			total += bytes
+		}
+		plan.Items = append(plan.Items, entry)
+	}
+
+	// Destination
+	plan.Destination.Root = app.bkpDestFullPath
+	plan.Destination.RequiredSpace = uint64(total) + app.BkpConfig.Retention.minFreeSpaceParsed
+	if freeSpace, _, err := getFreeSpace(app.bkpDest); err == nil {
+		plan.Destination.FreeSpace = freeSpace
+		plan.Destination.Sufficient = freeSpace >= plan.Destination.RequiredSpace
+	} else {
+		logger.Warn(fmt.Sprintf("Failed to get free space of %q: %v\n", app.bkpDest, err))
+	}
+
+	// Retention (the new backup takes the first slot, like after a successful run)
+	retention := app.BkpConfig.Retention
+	plan.Retention = planRetention{BackupsToKeep: retention.BackupsToKeep, Action: "remove", Remove: []planBackup{}}
+	if retention.UseTrash {
+		plan.Retention.Action = "trash"
+	}
+	backups, err := listBackups(app.bkpDestFullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+	plan.Retention.ExistingBackups = len(backups)
+	next := filepath.Join(app.bkpDestFullPath, "next")
+	removed := guardLastComplete(backups, planCleanup(backups, next, int(retention.BackupsToKeep)))
+	for _, backup := range removed {
+		plan.Retention.Remove = append(plan.Retention.Remove, planBackup{
+			Name:    backup.name,
+			State:   backup.state,
+			Created: backup.created,
+			Size:    dirSize(backup.path),
+		})
+	}
+	plan.Retention.RequiresConfirmation = retention.ConfirmAbove > 0 && len(removed) > int(retention.ConfirmAbove)
+
+	return plan, nil
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// effectiveConfig converts the configuration (with defaults applied) into a map with YAML field names,
+// so it reads the same way as the config file in both output formats.
+func effectiveConfig(config Config) (map[string]any, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("encoding configuration: %w", err)
+	}
+	var fields map[string]any
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decoding configuration: %w", err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On laptops, backups can be kept from draining the battery and from being cut short by sleep ('power'):
+//   min_battery    - charge (percent) below which a run on battery doesn't start (0 - no limit)
+//   on_low_battery - "refuse": the run doesn't start, a started run goes on (default);
+//                    "pause": the run waits for the charger (or the charge to come back), before it starts
+//                    and between files while copying
+//   inhibit_sleep  - asks the OS not to sleep while the backup runs: systemd-inhibit (Linux),
+//                    SetThreadExecutionState (Windows), caffeinate (macOS). The display may still turn off.
+// Battery charge is read from /sys/class/power_supply (Linux), GetSystemPowerStatus (Windows) or 'pmset' (macOS).
+// Computers without a battery run as on AC power.
+
+const (
+	PowerRefuse        string        = "refuse"
+	PowerPause         string        = "pause"
+	PowerCheckInterval time.Duration = 30 * time.Second // how often the battery is read while copying, and while paused
+	PowerInhibitReason string        = "Backup in progress"
+)
+
+var powerPolicies = []string{PowerRefuse, PowerPause}
+
+var errPowerUnsupported = errors.New("not supported on this platform")
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// POWER SETTINGS
+type PowerConfig struct {
+	MinBattery   uint8  `yaml:"min_battery,omitempty"` // percent, 0 - no limit
+	OnLowBattery string `yaml:"on_low_battery,omitempty"` // "refuse" or "pause"
+	InhibitSleep bool   `yaml:"inhibit_sleep,omitempty"` // keep the computer awake while the backup runs
+}
+
+
+// POWER SOURCE AND BATTERY CHARGE
+type powerStatus struct {
+	onBattery bool
+	percent   int // remaining charge, -1 if there is no battery or it's not known
+}
+
+
+// BATTERY CHECKS WHILE COPYING ('on_low_battery: pause')
+type powerGuard struct {
+	mu      sync.Mutex // copy workers wait on it while the run is paused
+	checked time.Time
+}
+
+
+
+//////////////  POWER FUNCTIONS  //////////////////////////////////////////////
+
+// validate checks the power settings.
+func (c *PowerConfig) validate() error {
+	if c.MinBattery > 100 {
+		return fmt.Errorf("%q value %d is out of range. Expected a percentage (0-100)", "power.min_battery", c.MinBattery)
+	}
+	c.OnLowBattery = strings.ToLower(c.OnLowBattery)
+	if !slices.Contains(powerPolicies, c.OnLowBattery) {
+		return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "power.on_low_battery", c.OnLowBattery, strings.Join(powerPolicies, ", "))
+	}
+	return nil
+}
+
+
+// lowBattery reports whether the computer runs on battery charged below 'min_battery'.
+func (c PowerConfig) lowBattery(status powerStatus) bool {
+	return c.MinBattery > 0 && status.onBattery && status.percent >= 0 && status.percent < int(c.MinBattery)
+}
+
+
+// CHECK POWER SOURCE BEFORE THE RUN
+// Returns an error if the run should not start ('on_low_battery: refuse'), waits for power with "pause".
+func (app *BackupApp) checkPower() error {
+	c := app.BkpConfig.Power
+	if c.MinBattery == 0 {
+		return nil
+	}
+	status, err := readPowerStatus()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to read battery charge, %q is not applied: %v\n", "power.min_battery", err))
+		return nil
+	}
+	if !c.lowBattery(status) {
+		return nil
+	}
+	if c.OnLowBattery == PowerRefuse {
+		return fmt.Errorf("on battery at %d%%, below %q %d%%. Connect the charger, or lower the limit", status.percent, "power.min_battery", c.MinBattery)
+	}
+	app.waitForPower()
+	return nil
+}
+
+
+// WAIT FOR POWER ('on_low_battery: pause')
+// Called before each file is copied, the battery is read at most once per 'PowerCheckInterval'.
+// A file being copied is finished first, other workers wait here until the run is resumed.
+func (app *BackupApp) waitForPower() {
+	c := app.BkpConfig.Power
+	if c.MinBattery == 0 || c.OnLowBattery != PowerPause {
+		return
+	}
+	g := &app.power
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Since(g.checked) < PowerCheckInterval {
+		return
+	}
+
+	var paused time.Time
+	for {
+		status, err := readPowerStatus()
+		g.checked = time.Now()
+		if err != nil || !c.lowBattery(status) {
+			break
+		}
+		if paused.IsZero() {
+			paused = time.Now()
+			logger.Warn(fmt.Sprintf("Paused: on battery at %d%%, below %q %d%%. Waiting for the charger...\n", status.percent, "power.min_battery", c.MinBattery))
+		}
+		time.Sleep(PowerCheckInterval)
+	}
+	if !paused.IsZero() {
+		logger.Info(fmt.Sprintf("Resumed after %s.\n", formatDurationSeconds(time.Since(paused))))
+	}
+}
+
+
+// PREVENT SLEEP WHILE THE BACKUP RUNS ('inhibit_sleep')
+// Returns the function that lets the computer sleep again.
+func (app *BackupApp) preventSleep() func() {
+	if !app.BkpConfig.Power.InhibitSleep {
+		return func() {}
+	}
+	release, err := inhibitSleep(PowerInhibitReason)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to keep the computer awake, it may sleep during the backup: %v\n", err))
+		return func() {}
+	}
+	logger.Verbose("Sleep is inhibited while the backup runs.\n")
+	return release
+}
@@ -0,0 +1,44 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 'pmset -g batt' lists the internal battery with its charge, e.g. " -InternalBattery-0 (id=123)	85%; discharging"
+var pmsetBatteryCharge = regexp.MustCompile(`InternalBattery[^\t]*\t(\d+)%`)
+
+
+// readPowerStatus reads the power source and battery charge from 'pmset'.
+func readPowerStatus() (powerStatus, error) {
+	status := powerStatus{percent: -1}
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return status, fmt.Errorf("pmset: %w", err)
+	}
+	if m := pmsetBatteryCharge.FindStringSubmatch(string(out)); m != nil {
+		status.percent, _ = strconv.Atoi(m[1])
+		status.onBattery = strings.Contains(string(out), "'Battery Power'")
+	}
+	return status, nil
+}
+
+
+// inhibitSleep runs 'caffeinate' for as long as the app runs (it exits with the app, if killed),
+// 'reason' is not shown on this platform.
+func inhibitSleep(reason string) (func(), error) {
+	cmd := exec.Command("caffeinate", "-i", "-w", strconv.Itoa(os.Getpid()))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("caffeinate: %w", err)
+	}
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}, nil
+}
@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	powerSupplyDir      string        = "/sys/class/power_supply"
+	InhibitStartTimeout time.Duration = 300 * time.Millisecond // systemd-inhibit that fails exits within it
+)
+
+
+// readPowerStatus reads power supplies from sysfs. Batteries of peripherals (mice, keyboards) are ignored,
+// several system batteries are averaged.
+func readPowerStatus() (powerStatus, error) {
+	status := powerStatus{percent: -1}
+	dirs, err := os.ReadDir(powerSupplyDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	var adapter, online, discharging bool
+	var charge, batteries int
+	for _, dir := range dirs {
+		supply := filepath.Join(powerSupplyDir, dir.Name())
+		switch readSysfsValue(supply, "type") {
+		case "Mains", "USB", "Wireless":
+			adapter = true
+			online = online || readSysfsValue(supply, "online") == "1"
+		case "Battery":
+			if readSysfsValue(supply, "scope") == "Device" {
+				continue
+			}
+			capacity, err := strconv.Atoi(readSysfsValue(supply, "capacity"))
+			if err != nil {
+				continue
+			}
+			charge += capacity
+			batteries++
+			discharging = discharging || readSysfsValue(supply, "status") == "Discharging"
+		}
+	}
+	if batteries == 0 {
+		return status, nil
+	}
+	status.percent = charge / batteries
+	status.onBattery = !online && (adapter || discharging)
+	return status, nil
+}
+
+
+// readSysfsValue returns the trimmed content of the attribute file, empty if it can't be read.
+func readSysfsValue(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+
+// inhibitSleep takes a systemd-inhibit lock, held by a child process that exits when its stdin is closed,
+// so the lock is released even if the app is killed.
+func inhibitSleep(reason string) (func(), error) {
+	tool, err := exec.LookPath("systemd-inhibit")
+	if err != nil {
+		return nil, fmt.Errorf("systemd-inhibit: %w", errPowerUnsupported)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, "--what=sleep:idle", "--who="+StateDirName, "--why="+reason, "--mode=block", "cat")
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("systemd-inhibit: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return nil, fmt.Errorf("systemd-inhibit: %v: %s", err, strings.TrimSpace(stderr.String()))
+	case <-time.After(InhibitStartTimeout):
+	}
+	return func() {
+		stdin.Close()
+		<-exited
+	}, nil
+}
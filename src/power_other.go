@@ -0,0 +1,14 @@
+//go:build !linux && !windows && !darwin
+
+package main
+
+// readPowerStatus is not supported on this platform, 'min_battery' is not applied.
+func readPowerStatus() (powerStatus, error) {
+	return powerStatus{percent: -1}, errPowerUnsupported
+}
+
+
+// inhibitSleep is not supported on this platform.
+func inhibitSleep(reason string) (func(), error) {
+	return nil, errPowerUnsupported
+}
@@ -0,0 +1,71 @@
+//go:build windows
+
+package main
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetSystemPowerStatus    = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+	procSetThreadExecutionState = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetThreadExecutionState")
+)
+
+const (
+	esContinuous          = 0x80000000
+	esSystemRequired      = 0x00000001
+	acLineOffline         = 0
+	batteryFlagNoBattery  = 128
+	batteryPercentUnknown = 255
+)
+
+// SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+
+// readPowerStatus reads the power source and battery charge with GetSystemPowerStatus.
+func readPowerStatus() (powerStatus, error) {
+	status := powerStatus{percent: -1}
+	var s systemPowerStatus
+	if ok, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&s))); ok == 0 {
+		return status, err
+	}
+	if s.BatteryFlag&batteryFlagNoBattery != 0 || s.BatteryLifePercent == batteryPercentUnknown {
+		return status, nil
+	}
+	status.percent = int(s.BatteryLifePercent)
+	status.onBattery = s.ACLineStatus == acLineOffline
+	return status, nil
+}
+
+
+// inhibitSleep sets the execution state of a thread of its own: the state lasts while the thread keeps it,
+// and goroutines move between threads. 'reason' is not shown on this platform.
+func inhibitSleep(reason string) (func(), error) {
+	result := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		runtime.LockOSThread() // not unlocked, the thread exits with the goroutine
+		if previous, _, err := procSetThreadExecutionState.Call(esContinuous | esSystemRequired); previous == 0 {
+			result <- err
+			return
+		}
+		result <- nil
+		<-done
+		procSetThreadExecutionState.Call(esContinuous)
+	}()
+	if err := <-result; err != nil {
+		return nil, err
+	}
+	return func() { close(done) }, nil
+}
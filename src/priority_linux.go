@@ -0,0 +1,26 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// Best-effort I/O scheduling class, lowest priority level (see ioprio_set(2))
+const (
+	ioprioClassShift  = 13
+	ioprioClassBE     = 2
+	ioprioLowestLevel = 7
+	ioprioWhoProcess  = 1
+	LowPriorityNice   = 10
+)
+
+// lowerPriority lowers CPU (nice) and I/O priority of the process, so backups don't disturb other work.
+func lowerPriority() error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, LowPriorityNice); err != nil {
+		return err
+	}
+	prio := ioprioClassBE<<ioprioClassShift | ioprioLowestLevel
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(prio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
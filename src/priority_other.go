@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const LowPriorityNice = 10
+
+// lowerPriority lowers CPU priority (nice) of the process; I/O priority is not changed on this platform.
+func lowerPriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, LowPriorityNice)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// lowerPriority switches the process into background mode, which lowers its CPU, I/O and memory priority.
+func lowerPriority() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// With 'obfuscate_names', a stolen backup drive doesn't reveal the directory structure of the machine:
+// - names of copied files and directories (item destinations included) are replaced by keyed hashes of their
+//   real paths, so the same file gets the same name in every backup (hard-linking and verification keep working);
+// - the manifest (which maps real paths to content), metadata and report files are encrypted,
+//   so the real names can be recovered only with the key.
+// The key is the config encryption key (SMBKP_CONFIG_KEY or keychain, see configcrypt.go).
+// File contents are not encrypted.
+
+const (
+	ReportEncryptedMagic string = "# smbkp encrypted report v1\n"
+	NamesKeySalt         string = "smbkp file names v1" // fixed, so names are the same across runs
+	ObfuscatedNameBytes  int    = 15                    // 24 characters in base32
+)
+
+var (
+	namesEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	namesKeyValue []byte // derived from the config key, once per process
+	namesKeyErr   error
+	namesKeyOnce  sync.Once
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// NAME OBFUSCATION OF THE CURRENT RUN
+type nameObfuscator struct {
+	key  []byte
+	real sync.Map // real slash-separated paths by obfuscated ones, relative to the backup directory
+}
+
+
+
+//////////////  PRIVACY FUNCTIONS  ////////////////////////////////////////////
+
+// namesKey returns the key obfuscated names are derived from.
+func namesKey() ([]byte, error) {
+	namesKeyOnce.Do(func() {
+		passphrase, err := configKey()
+		if err != nil {
+			namesKeyErr = err
+			return
+		}
+		namesKeyValue, namesKeyErr = scrypt.Key([]byte(passphrase), []byte(NamesKeySalt), 1<<15, 8, 1, 32)
+	})
+	return namesKeyValue, namesKeyErr
+}
+
+
+// START NAME OBFUSCATION (if 'obfuscate_names' is set)
+// Fails before anything is written if there is no key.
+func (app *BackupApp) startNameObfuscation() error {
+	if !app.BkpConfig.ObfuscateNames {
+		return nil
+	}
+	key, err := namesKey()
+	if err != nil {
+		return fmt.Errorf("%q requires the config key: %w", "obfuscate_names", err)
+	}
+	app.names = &nameObfuscator{key: key}
+	logger.Info("File and directory names are obfuscated, manifest and reports are encrypted.\n")
+	return nil
+}
+
+
+// obfuscatePath returns the obfuscated form of the slash-separated path relative to the backup directory.
+// Each name is derived from the real path up to it, so equal names in different directories differ.
+func obfuscatePath(key []byte, rel string) string {
+	parts := strings.Split(path.Clean(rel), "/")
+	obfuscated := make([]string, len(parts))
+	for i := range parts {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(strings.Join(parts[:i+1], "/")))
+		obfuscated[i] = strings.ToLower(namesEncoding.EncodeToString(mac.Sum(nil)[:ObfuscatedNameBytes]))
+	}
+	return strings.Join(obfuscated, "/")
+}
+
+
+// destPath returns the destination of the path relative to the backup directory (obfuscated if enabled).
+func (app *BackupApp) destPath(rel string) string {
+	if app.names == nil {
+		return filepath.Join(app.bkpDestFullPath, rel)
+	}
+	real := path.Clean(filepath.ToSlash(rel))
+	obfuscated := obfuscatePath(app.names.key, real)
+	app.names.real.Store(obfuscated, real)
+	return filepath.Join(app.bkpDestFullPath, filepath.FromSlash(obfuscated))
+}
+
+
+// manifestPath returns the real slash-separated path of the destination path relative to the backup directory.
+func (app *BackupApp) manifestPath(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if app.names == nil {
+		return rel
+	}
+	if real, ok := app.names.real.Load(rel); ok {
+		return real.(string)
+	}
+	return rel
+}
+
+
+// sealReport encrypts the report file content if names are obfuscated.
+func (app *BackupApp) sealReport(data []byte) ([]byte, error) {
+	if app.names == nil {
+		return data, nil
+	}
+	passphrase, err := configKey()
+	if err != nil {
+		return nil, err
+	}
+	return sealBytes(data, passphrase, ReportEncryptedMagic)
+}
+
+
+// readReportData reads the report or metadata file of a backup, decrypting it if needed.
+func readReportData(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, []byte(ReportEncryptedMagic)) {
+		return data, err
+	}
+	passphrase, err := configKey()
+	if err != nil {
+		return nil, fmt.Errorf("%s is encrypted ('obfuscate_names'), but %w", filepath.Base(path), err)
+	}
+	return openBytes(data, passphrase, ReportEncryptedMagic)
+}
+
+
+// backupObfuscated reports whether the backup was made with 'obfuscate_names' (its metadata is encrypted).
+func backupObfuscated(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, MetadataFileName))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(ReportEncryptedMagic))
+	n, _ := f.Read(head)
+	return string(head[:n]) == ReportEncryptedMagic
+}
+
+
+// backupDiskPath returns the slash-separated path of the manifest entry on disk, relative to the backup directory.
+func backupDiskPath(p string, obfuscated bool) (string, error) {
+	if !obfuscated {
+		return p, nil
+	}
+	key, err := namesKey()
+	if err != nil {
+		return "", err
+	}
+	return obfuscatePath(key, p), nil
+}
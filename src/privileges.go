@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"strings"
+)
+
+// Files that can't be read for lack of permissions (e.g. other users' or system files when run unprivileged)
+// are not failures of the item: they are skipped as "inaccessible", listed in the skipped report and counted
+// in the summary. '--elevate' relaunches the app as administrator (UAC prompt) or root (sudo) to include them.
+
+const SkipInaccessible string = "inaccessible"
+
+
+
+//////////////  PRIVILEGES FUNCTIONS  /////////////////////////////////////////
+
+// REPORT MISSING PRIVILEGES BEFORE THE RUN
+func (app *BackupApp) checkPrivileges() {
+	if isElevated() {
+		return
+	}
+	logger.Verbose(fmt.Sprintf("Not running as %s: files the current user can't read are skipped as inaccessible (see '--elevate').\n", privilegedUser()))
+}
+
+
+// skipInaccessible records the source file that couldn't be read for lack of permissions. Safe for concurrent use.
+func (app *BackupApp) skipInaccessible(path string, err error) {
+	app.skippedMu.Lock()
+	defer app.skippedMu.Unlock()
+	app.skipped = append(app.skipped, skippedEntry{path: path, reason: SkipInaccessible + ": " + err.Error()})
+}
+
+
+// isInaccessible reports whether the error is a permission denied on the source path itself
+// (as opposed to the destination, which is a real failure).
+func isInaccessible(err error, source string) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Path == source && errors.Is(err, fs.ErrPermission)
+}
+
+
+// inaccessibleCount returns the number of source paths skipped for lack of permissions.
+func (app *BackupApp) inaccessibleCount() int {
+	var count int
+	for _, entry := range app.skipped {
+		if strings.HasPrefix(entry.reason, SkipInaccessible+":") {
+			count++
+		}
+	}
+	return count
+}
+
+
+// privilegedUser names the account that can read everything on this platform.
+func privilegedUser() string {
+	if runtime.GOOS == "windows" {
+		return "administrator"
+	}
+	return "root"
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isElevated reports whether the process runs as root.
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
+
+
+// relaunchElevated replaces the process with the same command run through sudo (which prompts for the password).
+// Returns only if it fails.
+func relaunchElevated() error {
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(sudo, append([]string{"sudo", "--", exe}, os.Args[1:]...), os.Environ())
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isElevated reports whether the process runs as administrator.
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+
+// relaunchElevated starts the app again with the same arguments through the UAC prompt.
+// The elevated copy runs in its own console window; this process should exit once it's started.
+func relaunchElevated() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	args := make([]string, len(os.Args)-1)
+	for i, arg := range os.Args[1:] {
+		args[i] = windows.EscapeArg(arg)
+	}
+	verbPtr, _ := windows.UTF16PtrFromString("runas")
+	exePtr, _ := windows.UTF16PtrFromString(exe)
+	argsPtr, _ := windows.UTF16PtrFromString(strings.Join(args, " "))
+	cwdPtr, _ := windows.UTF16PtrFromString(cwd)
+	return windows.ShellExecute(0, verbPtr, exePtr, argsPtr, cwdPtr, windows.SW_NORMAL)
+}
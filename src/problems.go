@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Source paths that were skipped because of a problem (inaccessible, protected, failed or missing item),
+// or copied while they were changing (see changing.go), are summarized by cause and directory,
+// so thousands of files denied under one tree take a couple of summary lines.
+// Directories of each cause are merged into their parents until they fit 'SummaryProblemDirs' lines.
+// The full list stays in the skipped report (report/smbkp-skipped.tsv).
+
+const (
+	SummaryProblemDirs int = 5 // directories listed under each cause
+)
+
+// Skip reasons (by prefix) that are problems rather than deliberate exclusions
+var problemReasons = []string{SkipInaccessible, SkipChanged, "protected", "failed", "missing"}
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// SKIPPED PATHS OF ONE CAUSE
+type problemGroup struct {
+	cause string
+	count int
+	dirs  []problemDir // most affected first
+}
+
+
+// SKIPPED PATHS UNDER ONE DIRECTORY
+type problemDir struct {
+	dir   string
+	count int
+}
+
+
+
+//////////////  PROBLEM FUNCTIONS  ////////////////////////////////////////////
+
+// problemCause returns the cause of the skip reason without the path, e.g. "inaccessible: permission denied"
+// for "inaccessible: open /home/x/file: permission denied", or empty string if the reason is not a problem.
+func problemCause(reason string) string {
+	kind, details, _ := strings.Cut(reason, ": ")
+	isProblem := false
+	for _, prefix := range problemReasons {
+		isProblem = isProblem || kind == prefix
+	}
+	if !isProblem {
+		return ""
+	}
+	// The last non-empty segment, as errors of commands may end with their (empty) output
+	segments := strings.Split(details, ": ")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if strings.TrimSpace(segments[i]) != "" {
+			details = strings.TrimSpace(segments[i])
+			break
+		}
+	}
+	return kind + ": " + details
+}
+
+
+// groupProblems groups skipped paths with problems by cause (most frequent first) and directory.
+func groupProblems(skipped []skippedEntry) []problemGroup {
+	dirsByCause := make(map[string]map[string]int)
+	labelsByCause := make(map[string]map[string]int) // items without a path are listed as is, never merged
+	for _, entry := range skipped {
+		cause := problemCause(entry.reason)
+		if cause == "" {
+			continue
+		}
+		if entry.label {
+			if labelsByCause[cause] == nil {
+				labelsByCause[cause] = make(map[string]int)
+			}
+			labelsByCause[cause][entry.path]++
+			continue
+		}
+		if dirsByCause[cause] == nil {
+			dirsByCause[cause] = make(map[string]int)
+		}
+		dirsByCause[cause][filepath.Dir(entry.path)]++
+	}
+	for cause := range labelsByCause {
+		if dirsByCause[cause] == nil {
+			dirsByCause[cause] = make(map[string]int)
+		}
+	}
+
+	var groups []problemGroup
+	for cause, dirs := range dirsByCause {
+		group := problemGroup{cause: cause}
+		for dir, count := range mergeProblemDirs(dirs, SummaryProblemDirs) {
+			group.count += count
+			group.dirs = append(group.dirs, problemDir{dir: dir, count: count})
+		}
+		for label, count := range labelsByCause[cause] {
+			group.count += count
+			group.dirs = append(group.dirs, problemDir{dir: label, count: count})
+		}
+		sort.Slice(group.dirs, func(i, j int) bool {
+			if group.dirs[i].count != group.dirs[j].count {
+				return group.dirs[i].count > group.dirs[j].count
+			}
+			return group.dirs[i].dir < group.dirs[j].dir
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return groups[i].cause < groups[j].cause
+	})
+	return groups
+}
+
+
+// mergeProblemDirs merges the deepest directories into their parents until there are at most 'limit' of them
+// (or only roots are left).
+func mergeProblemDirs(counts map[string]int, limit int) map[string]int {
+	depth := func(dir string) int {
+		if filepath.Dir(dir) == dir {
+			return 0
+		}
+		return strings.Count(filepath.ToSlash(filepath.Clean(dir)), "/")
+	}
+
+	for len(counts) > limit {
+		deepest := 0
+		for dir := range counts {
+			deepest = max(deepest, depth(dir))
+		}
+		if deepest == 0 {
+			break
+		}
+		merged := make(map[string]int, len(counts))
+		for dir, count := range counts {
+			if depth(dir) == deepest {
+				dir = filepath.Dir(dir)
+			}
+			merged[dir] += count
+		}
+		counts = merged
+	}
+	return counts
+}
+
+
+// problemSummary returns the summary lines of skipped paths with problems, grouped by cause and directory.
+func (app *BackupApp) problemSummary() []string {
+	app.skippedMu.Lock()
+	groups := groupProblems(app.skipped)
+	app.skippedMu.Unlock()
+	if len(groups) == 0 {
+		return nil
+	}
+
+	lines := []string{fmt.Sprintf("Skipped or inconsistent, by cause (full list in %s):\n", reportFile(SkippedFileName))}
+	for _, group := range groups {
+		lines = append(lines, fmt.Sprintf("  %s: %d\n", group.cause, group.count))
+		for i, dir := range group.dirs {
+			if i == SummaryProblemDirs {
+				lines = append(lines, fmt.Sprintf("    ... and %d more directories\n", len(group.dirs)-i))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("    %s: %d\n", dir.dir, dir.count))
+		}
+	}
+	return lines
+}
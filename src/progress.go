@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ProgressBarLength    int           = 50
+	ProgressBarMinLength int           = 10
+	ProgressPlainEvery   time.Duration = 10 * time.Second // plain-text progress rate when console is not a terminal
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PROGRESS STATUS LINE OF THE ITEM BEING BACKED UP
+// All methods are no-op on nil receiver, so copy functions can report progress unconditionally.
+// Safe for concurrent use by copy workers.
+type progress struct {
+	mu         sync.Mutex
+	total      int // number of entries (files and directories) to process
+	processed  int
+	lastUpdate int // last rendered percentage
+
+	// Current file (reported while it's being copied, so huge files don't freeze the status line).
+	// With several copy workers, the most recently started file is shown.
+	current *progressReader
+
+	interval   time.Duration
+	lastRender time.Time
+	lastPlain  time.Time
+
+	// Items backed up in parallel ('parallel_items') share one status line
+	board   *progressBoard
+	label   string       // item number, e.g. "[2]"
+	root    string       // item destination, to find the progress of copied files
+	percent atomic.Int32 // read by the board without taking 'mu'
+}
+
+
+// STATUS LINE OF ITEMS BACKED UP IN PARALLEL ('parallel_items')
+// Each running item gets a short bar; lock order is progress, then board.
+type progressBoard struct {
+	mu     sync.Mutex
+	active []*progress // in start order
+}
+
+
+// progressReader reports every read of the file to the progress status line.
+type progressReader struct {
+	r      io.Reader
+	p      *progress
+	name   string
+	size   int64
+	copied int64
+	note   string // shown after the bytes copied, e.g. chunk of an upload
+}
+
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(pr, int64(n))
+	return n, err
+}
+
+
+
+
+//////////////  PROGRESS FUNCTIONS  ///////////////////////////////////////////
+
+// NEW PROGRESS STATUS LINE
+func newProgress(total int, interval time.Duration) *progress {
+	return &progress{
+		total:      total,
+		lastUpdate: -1,
+		interval:   interval,
+		lastPlain:  time.Now(),
+	}
+}
+
+
+// ENTRY PROCESSED (file copied or directory created)
+func (p *progress) itemDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed++
+	p.current = nil
+	if p.total > 0 {
+		percentage := int(float64(p.processed) * 100 / float64(p.total))
+		p.percent.Store(int32(percentage))
+		if percentage > p.lastUpdate {
+			p.lastUpdate = percentage
+			p.render()
+		}
+	}
+}
+
+
+// ENTRY FOUND WHILE COPYING IS ALREADY RUNNING ('copy_while_scanning')
+// The percentage may go back a little, until enumeration completes.
+func (p *progress) grow() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total++
+}
+
+
+// START COPYING FILE
+// Returns the reader wrapped to report copied bytes.
+func (p *progress) startFile(name string, size int64, r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = &progressReader{r: r, p: p, name: name, size: size}
+	p.lastRender = time.Now()
+	return p.current
+}
+
+
+// BYTES COPIED FROM FILE
+func (p *progress) add(pr *progressReader, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr.copied += n
+	if p.current != nil && time.Since(p.lastRender) >= p.interval {
+		p.render()
+	}
+}
+
+
+// BYTES COPIED FROM FILE, BYPASSING ITS READER (e.g. by copy offload)
+// 'r' is the reader returned by startFile.
+func (p *progress) copied(r io.Reader, n int64) {
+	if pr, ok := r.(*progressReader); ok {
+		p.add(pr, n)
+	}
+}
+
+
+// NOTE ON FILE BEING COPIED (e.g. "chunk 3/12")
+// 'r' is the reader returned by startFile.
+func (p *progress) fileNote(r io.Reader, note string) {
+	pr, ok := r.(*progressReader)
+	if p == nil || !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr.note = note
+	if p.current == pr {
+		p.render()
+	}
+}
+
+
+// DRAW STATUS LINE (console only, to avoid cluttering of log file)
+// Terminal width is checked on every redraw, so resizing the window is handled.
+func (p *progress) render() {
+	p.lastRender = time.Now()
+
+	percentage := 0
+	if p.total > 0 {
+		percentage = p.processed * 100 / p.total
+	}
+
+	width, isTerminal := logger.Terminal()
+	if !isTerminal {
+		p.renderPlain(percentage)
+		return
+	}
+	if p.board != nil {
+		p.board.render()
+		return
+	}
+
+	// Leave the last column empty, writing into it wraps the line on some terminals
+	room := width - 1
+
+	// Intra-file progress: bytes copied of current file, followed by file name fitted into the terminal.
+	// The bar gives up some of its length on narrow terminals, so the details remain visible.
+	details := ""
+	if p.current != nil {
+		details = fmt.Sprintf(" %s/%s ", formatBytes(uint64(p.current.copied)), formatBytes(uint64(p.current.size)))
+		if p.current.note != "" {
+			details += p.current.note + " "
+		}
+	}
+	barRoom := room - 2
+	if details != "" {
+		barRoom -= len(details) + ProgressBarMinLength
+	}
+	line := "[" + progressBar(percentage, barRoom) + "]"
+
+	if details != "" {
+		nameRoom := room - len([]rune(line)) - len(details)
+		if nameRoom > 3 {
+			line += details + ellipsize(p.current.name, nameRoom)
+		}
+	}
+
+	logger.StatusLine(line)
+}
+
+
+// PRINT PLAIN-TEXT PROGRESS (console is not a terminal, status line can't be redrawn)
+func (p *progress) renderPlain(percentage int) {
+	if time.Since(p.lastPlain) < ProgressPlainEvery {
+		return
+	}
+	p.lastPlain = time.Now()
+
+	msg := fmt.Sprintf("  %d%% (%d/%d)", percentage, p.processed, p.total)
+	if p.label != "" {
+		msg = fmt.Sprintf("  %s %d%% (%d/%d)", p.label, percentage, p.processed, p.total)
+	}
+	if p.current != nil {
+		msg += fmt.Sprintf(", %s %s/%s", p.current.name, formatBytes(uint64(p.current.copied)), formatBytes(uint64(p.current.size)))
+		if p.current.note != "" {
+			msg += " " + p.current.note
+		}
+	}
+	logger.Screen(msg + "\n")
+}
+
+
+// CLEAR STATUS LINE
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	if p.board != nil {
+		p.board.remove(p)
+		return
+	}
+	if _, isTerminal := logger.Terminal(); isTerminal {
+		logger.ClearStatusLine()
+	}
+}
+
+
+// ADD PROGRESS OF THE ITEM STARTED IN PARALLEL
+func (b *progressBoard) add(p *progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p.board = b
+	b.active = append(b.active, p)
+}
+
+
+// REMOVE PROGRESS OF THE FINISHED ITEM (the status line is cleared when no item is left)
+func (b *progressBoard) remove(p *progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, active := range b.active {
+		if active == p {
+			b.active = append(b.active[:i], b.active[i+1:]...)
+			break
+		}
+	}
+	if _, isTerminal := logger.Terminal(); isTerminal && len(b.active) == 0 {
+		logger.ClearStatusLine()
+	}
+}
+
+
+// progressOf returns the progress of the item the destination file belongs to (the longest matching root).
+func (b *progressBoard) progressOf(dest string) *progress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found *progress
+	for _, p := range b.active {
+		if (dest == p.root || strings.HasPrefix(dest, p.root+string(filepath.Separator))) && (found == nil || len(p.root) > len(found.root)) {
+			found = p
+		}
+	}
+	return found
+}
+
+
+// DRAW SHARED STATUS LINE (terminal only): a short bar and percentage of each running item
+func (b *progressBoard) render() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.active) == 0 {
+		return
+	}
+
+	width, _ := logger.Terminal()
+	slot := (width - 1) / len(b.active)
+	parts := make([]string, 0, len(b.active))
+	for _, p := range b.active {
+		percentage := int(p.percent.Load())
+		part := fmt.Sprintf("%s %3d%%", p.label, percentage)
+		if barRoom := slot - len(part) - 3; barRoom >= ProgressBarMinLength {
+			part = fmt.Sprintf("%s [%s] %3d%%", p.label, progressBar(percentage, barRoom), percentage)
+		}
+		parts = append(parts, part)
+	}
+	logger.StatusLine(ellipsize(strings.Join(parts, " "), width-1))
+}
+
+
+// progressBar draws the bar for the given percentage, fitted into the available room.
+func progressBar(percentage, room int) string {
+	length := ProgressBarLength
+	if room < length {
+		length = room
+	}
+	if length < ProgressBarMinLength {
+		length = ProgressBarMinLength
+	}
+
+	completed := percentage * length / 100
+	if completed > length {
+		completed = length
+	}
+	return strings.Repeat("■", completed) + strings.Repeat(".", length-completed)
+}
+
+
+
+//////////////  SPINNER  //////////////////////////////////////////////////////
+
+const (
+	SpinnerFrames string        = `|/-\`
+	SpinnerEvery  time.Duration = 100 * time.Millisecond
+)
+
+
+// SPINNER WITH RUNNING STATUS (shown while the amount of work is still unknown)
+type spinner struct {
+	label      string
+	frame      int
+	shown      bool
+	lastRender time.Time
+}
+
+
+// NEW SPINNER
+func newSpinner(label string) *spinner {
+	return &spinner{label: label}
+}
+
+
+// UPDATE SPINNER STATUS (redrawn at most every SpinnerEvery, terminal only)
+func (s *spinner) update(status string) {
+	if s == nil || time.Since(s.lastRender) < SpinnerEvery {
+		return
+	}
+	width, isTerminal := logger.Terminal()
+	if !isTerminal {
+		return
+	}
+	s.lastRender = time.Now()
+	s.frame = (s.frame + 1) % len(SpinnerFrames)
+	s.shown = true
+	line := []rune(fmt.Sprintf("%c %s: %s", SpinnerFrames[s.frame], s.label, status))
+	if len(line) > width-1 {
+		line = line[:width-1]
+	}
+	logger.StatusLine(string(line))
+}
+
+
+// CLEAR SPINNER
+func (s *spinner) clear() {
+	if s == nil || !s.shown {
+		return
+	}
+	logger.ClearStatusLine()
+	s.shown = false
+}
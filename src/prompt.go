@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+)
+
+// All interactive questions are answered through 'awaitAnswer', so they behave the same way:
+// answers are accepted in English and in the current language, and with 'prompt_timeout' set,
+// an unanswered question gets the 'prompt_default' answer (e.g. when a scheduler started the app without '-n').
+
+const (
+	PromptDefaultCancel  string = "cancel"  // don't do what is asked (e.g. don't start backup, stop on error)
+	PromptDefaultProceed string = "proceed" // do what is asked (e.g. start backup, continue after error)
+)
+
+var (
+	stdinLines     chan string // lines typed by the user, read in background
+	stdinLinesOnce sync.Once
+
+	// Limits the "Press Enter to exit" pause too, which has no application object at hand
+	exitPauseTimeout time.Duration
+)
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// readLine waits for a line from stdin, at most 'timeout' (0 means no limit).
+// Returns false on timeout or when stdin is closed.
+// Stdin is read by a single background reader, so a line typed after a timeout answers the next prompt.
+func readLine(timeout time.Duration) (string, bool) {
+	stdinLinesOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			reader := bufio.NewReader(os.Stdin)
+			for {
+				line, err := reader.ReadString('\n')
+				if line != "" || err == nil {
+					stdinLines <- line
+				}
+				if err != nil {
+					close(stdinLines)
+					return
+				}
+			}
+		}()
+	})
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case line, ok := <-stdinLines:
+		return line, ok
+	case <-expired:
+		return "", false
+	}
+}
+
+
+// WAIT FOR ANSWER TO THE PRINTED YES/NO QUESTION
+// Returns true if the user gave the 'proceed' answer (msgAnswerYes or msgAnswerNo, depending on the question).
+// Without an answer in 'prompt_timeout' (or without stdin), the configured default is applied and logged.
+func (app *BackupApp) awaitAnswer(proceed message) bool {
+	timeout := app.BkpConfig.promptTimeoutParsed
+	response, ok := readLine(timeout)
+	if ok {
+		return isAnswer(response, proceed)
+	}
+
+	answer := app.BkpConfig.PromptDefault
+	if timeout > 0 {
+		logger.Warn(tr(msgPromptTimedOut, timeout, answer))
+	} else {
+		logger.Warn(tr(msgPromptNoInput, answer))
+	}
+	return answer == PromptDefaultProceed
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// With 'read_only', completed backups are protected against accidental changes (and basic ransomware):
+// write permissions are removed from all files and directories, and on Linux the immutable flag is set
+// where permitted (requires root). Retention lifts the protection before pruning a backup.
+// With 'dedup: hardlink', backups share the inodes of unchanged files, so the protection of one is the protection
+// of all: the immutable flag is not set (the next backup couldn't link files, and retention couldn't unlink
+// shared ones), and lifting the protection leaves shared files read-only, only their directories become writable.
+
+// errImmutableUnsupported is returned where the immutable flag can't be used on this platform.
+var errImmutableUnsupported = errors.New("immutable flag is not supported on this platform")
+
+
+
+//////////////  PROTECTION FUNCTIONS  /////////////////////////////////////////
+
+// MAKE BACKUP DIRECTORY READ-ONLY
+// Directories are processed after their content, since nothing can be changed inside a protected directory.
+// The immutable flag is set only with 'immutable' (see immutableBackups).
+func lockBackup(path string, immutable bool) error {
+	var paths []string
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		info, err := os.Lstat(paths[i])
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(paths[i], info.Mode().Perm()&^0222); err != nil {
+			return err
+		}
+
+		// Immutable flag is best effort: without permission, read-only mode is still applied
+		if immutable {
+			if err := setImmutable(paths[i], true); err != nil {
+				immutable = false
+				if !errors.Is(err, errImmutableUnsupported) {
+					logger.Info(fmt.Sprintf("Backup is made read-only, but not immutable: %v\n", err))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+
+// LIFT READ-ONLY PROTECTION OF BACKUP DIRECTORY (before it's removed or moved)
+// Only protected backups (the backup directory is not writable) are processed, so pruning unprotected ones
+// costs nothing extra. The backup directory itself is processed last, so an interrupted unlock is finished next time.
+// Files hard-linked into other backups stay read-only, since their write permission is shared with them;
+// removing such a file only needs a writable directory. Their immutable flag, if any, is still cleared,
+// as immutable files can't be unlinked.
+func unlockBackup(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0200 != 0 {
+		return nil
+	}
+
+	var paths []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	shared := 0
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := setImmutable(paths[i], false); err != nil && !errors.Is(err, errImmutableUnsupported) && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+		info, err := os.Lstat(paths[i])
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && linkCount(paths[i], info) > 1 {
+			shared++
+			continue
+		}
+		if info.Mode().Perm()&0200 == 0 {
+			if err := os.Chmod(paths[i], info.Mode().Perm()|0200); err != nil {
+				return err
+			}
+		}
+	}
+	if shared > 0 {
+		logger.Verbose(fmt.Sprintf("%d files shared with other backups stay read-only.\n", shared))
+	}
+	return nil
+}
+
+
+// immutableBackups reports whether protected backups also get the immutable flag:
+// not with 'dedup: hardlink', where backups share files.
+func (c *Config) immutableBackups() bool {
+	return c.Dedup != DedupHardlink
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// setImmutable is not supported: read-only permissions (FILE_ATTRIBUTE_READONLY on Windows) are used alone.
+func setImmutable(path string, immutable bool) error {
+	return errImmutableUnsupported
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"simple-backup/src/style"
+	"slices"
+	"strings"
+)
+
+// 'max_size' keeps one runaway folder (e.g. Downloads) from filling the destination and starving other items.
+// The size of the item is known after enumeration (patterns and other limits applied); if it's over the limit,
+// 'max_size_action' decides what happens:
+//   abort    - the item is not copied and counts as failed (default)
+//   truncate - files are copied in walk order until the limit, the rest is listed as skipped
+//   prompt   - the user is asked whether to copy the item partially (non-interactive runs abort)
+
+const (
+	MaxSizeAbort    string = "abort"
+	MaxSizeTruncate string = "truncate"
+	MaxSizePrompt   string = "prompt"
+)
+
+var maxSizeActions = []string{MaxSizeAbort, MaxSizeTruncate, MaxSizePrompt}
+
+var errItemTooLarge = errors.New("item is too large")
+
+
+
+//////////////  QUOTA FUNCTIONS  //////////////////////////////////////////////
+
+// validateMaxSize parses 'max_size' of the item and checks 'max_size_action'.
+func validateMaxSize(item *BackupItem) error {
+	if item.MaxSizeAction != "" {
+		item.MaxSizeAction = strings.ToLower(item.MaxSizeAction)
+		if !slices.Contains(maxSizeActions, item.MaxSizeAction) {
+			return fmt.Errorf("%q value %q is not supported. Expected one of: %s", "max_size_action", item.MaxSizeAction, strings.Join(maxSizeActions, ", "))
+		}
+	}
+	if item.MaxSize == "" {
+		return nil
+	}
+	size, err := parseDiskSize(item.MaxSize)
+	if err != nil || size == 0 {
+		return fmt.Errorf("%q value %q has invalid format. Expected format is a number followed by 'mb' or 'gb' (e.g., '500mb', '50gb')", "max_size", item.MaxSize)
+	}
+	item.maxSizeParsed = size
+	return nil
+}
+
+
+// APPLY 'MAX_SIZE' OF THE ITEM TO ITS WORK LIST
+// Returns an error wrapping errItemTooLarge if the item must not be copied.
+func (app *BackupApp) applyMaxSize(item BackupItem, work *workList) error {
+	limit := item.maxSizeParsed
+	if limit == 0 || uint64(work.bytes) <= limit {
+		return nil
+	}
+	over := fmt.Errorf("%w: %s of files exceed %q (%s)", errItemTooLarge, formatBytes(uint64(work.bytes)), "max_size", item.MaxSize)
+
+	switch item.MaxSizeAction {
+	case MaxSizeTruncate:
+	case MaxSizePrompt:
+		if app.nonInteractive {
+			logger.Warn(fmt.Sprintf("%v, not copying the item in non-interactive mode.\n", over))
+			return over
+		}
+		logger.Warn(fmt.Sprintf("%v.\n", over))
+		logger.Info(tr(msgMaxSizePrompt, item.MaxSize), style.NoLabel())
+		if !app.awaitAnswer(msgAnswerYes) {
+			return over
+		}
+	default:
+		return over
+	}
+
+	work.truncateToSize(limit)
+	work.truncated = fmt.Sprintf("%q limit reached, only the first %s of files are copied", "max_size", item.MaxSize)
+	return nil
+}
+
+
+// truncateToSize keeps files (in walk order) as long as their total size fits the limit,
+// the rest is listed as skipped. Directories and symlinks are kept.
+func (wl *workList) truncateToSize(limit uint64) {
+	kept := wl.entries[:0]
+	var size uint64
+	for _, entry := range wl.entries {
+		if entry.linkTarget == "" && !entry.info.IsDir() {
+			if size+uint64(entry.info.Size()) > limit {
+				wl.skip(entry.path, "excluded: max_size")
+				wl.files--
+				wl.bytes -= entry.info.Size()
+				continue
+			}
+			size += uint64(entry.info.Size())
+		}
+		kept = append(kept, entry)
+	}
+	wl.entries = kept
+}
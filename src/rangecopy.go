@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// With several 'copy_workers', local files of at least 'RangeCopyMinSize' are copied in ranges of 'RangeCopyChunk'
+// that any worker can take. The worker that picked the file copies its ranges in order, and workers left without
+// files to copy join in, taking the next ranges (see copyEntries). So a few large files don't keep single workers busy
+// while the others are idle, and copying approaches the throughput of the disks, with files of any size.
+// Files copied in ranges are not hashed on the way: they are read back once copied (by 'hash_workers', if set).
+// Tar streams, packed files, remote sources, rate-limited and resumable (UNC) uploads are copied whole.
+
+const (
+	RangeCopyMinSize int64 = 64 * MB
+	RangeCopyChunk   int64 = 16 * MB
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// LARGE FILE BEING COPIED IN RANGES
+type rangedCopy struct {
+	src     *os.File
+	dest    *os.File
+	size    int64
+	copied  func(n int64) // progress of the file
+	mu      sync.Mutex    // guards 'next' and 'err'
+	next    int64         // offset of the next range to take
+	err     error         // first failure, no more ranges are taken after it
+	helpers sync.WaitGroup
+}
+
+
+// FILES BEING COPIED IN RANGES (shared by copy workers of all items)
+type rangeBoard struct {
+	mu     sync.Mutex
+	copies []*rangedCopy
+}
+
+
+
+//////////////  RANGED COPY FUNCTIONS  ////////////////////////////////////////
+
+// startRangeCopies lets copy workers share large files, if there are several of them.
+func (app *BackupApp) startRangeCopies() {
+	if app.BkpConfig.CopyWorkers > 1 && !app.toStdout {
+		app.ranges = &rangeBoard{}
+	}
+}
+
+
+// rangedCopyable reports whether the local source file is copied in ranges.
+func (app *BackupApp) rangedCopyable(srcFile *os.File, info os.FileInfo) bool {
+	return app.ranges != nil && srcFile != nil && info.Size() >= RangeCopyMinSize
+}
+
+
+// COPY LARGE FILE IN RANGES, WITH HELP OF IDLE WORKERS
+// The file is offered to other workers while its ranges are copied, and closed only after all of them are done.
+// With 'durable', the content is synced to disk. Returns the number of bytes copied.
+func (app *BackupApp) copyRanged(dest string, src *os.File, size int64, durable bool, copied func(n int64)) (int64, error) {
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer destFile.Close()
+	if err := destFile.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	rc := &rangedCopy{src: src, dest: destFile, size: size, copied: copied}
+	app.ranges.add(rc)
+	rc.run(app)
+	app.ranges.remove(rc)
+	rc.helpers.Wait()
+
+	if rc.err != nil {
+		return 0, rc.err
+	}
+	if durable {
+		if err := destFile.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+
+// help copies ranges of a file another worker is copying. Returns false if there is none left to help with.
+func (b *rangeBoard) help(app *BackupApp) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	var joined *rangedCopy
+	for _, rc := range b.copies {
+		if rc.join() {
+			joined = rc
+			break
+		}
+	}
+	b.mu.Unlock()
+	if joined == nil {
+		return false
+	}
+
+	defer joined.helpers.Done()
+	logger.Debug(fmt.Sprintf("Helping to copy %s\n", joined.dest.Name()))
+	joined.run(app)
+	return true
+}
+
+
+// add offers the file to idle workers.
+func (b *rangeBoard) add(rc *rangedCopy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.copies = append(b.copies, rc)
+}
+
+
+// remove takes the file off the board once all its ranges are taken.
+func (b *rangeBoard) remove(rc *rangedCopy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.copies {
+		if b.copies[i] == rc {
+			b.copies = append(b.copies[:i], b.copies[i+1:]...)
+			return
+		}
+	}
+}
+
+
+// join registers a helper, if the file has ranges left to take.
+func (rc *rangedCopy) join() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.next >= rc.size || rc.err != nil {
+		return false
+	}
+	rc.helpers.Add(1)
+	return true
+}
+
+
+// take returns the next range to copy, if any is left.
+func (rc *rangedCopy) take() (int64, int64, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.next >= rc.size || rc.err != nil {
+		return 0, 0, false
+	}
+	off := rc.next
+	n := min(RangeCopyChunk, rc.size-off)
+	rc.next += n
+	return off, n, true
+}
+
+
+// fail records the first failure, so no more ranges are taken.
+func (rc *rangedCopy) fail(err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.err == nil {
+		rc.err = err
+	}
+}
+
+
+// run copies ranges of the file until none is left.
+func (rc *rangedCopy) run(app *BackupApp) {
+	for {
+		off, n, ok := rc.take()
+		if !ok {
+			return
+		}
+		written, err := app.copyBuffered(io.NewOffsetWriter(rc.dest, off), io.NewSectionReader(rc.src, off, n))
+		if err == nil && written < n {
+			err = fmt.Errorf("file shrunk while being copied (%d of %d bytes read at offset %d)", written, n, off)
+		}
+		if err != nil {
+			rc.fail(err)
+			return
+		}
+		rc.copied(written)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"time"
+)
+
+// 'refresh' brings permissions and attributes of backed up files in line with the live sources,
+// without copying any data: after fixing permissions on the source, the backup (the latest complete
+// one by default) converges in seconds instead of another full run.
+// Only files with unchanged content (same size and modification time as in the manifest, see 'mtime_tolerance')
+// are refreshed, changed files need a backup run. Backups keep source modification times in the manifest
+// and don't keep ownership, so neither is refreshed. Files hard-linked between backups ('dedup: hardlink')
+// share permissions, and packed files ('pack_small_files') keep the ones recorded in the pack index.
+// Read-only backups are unlocked for the time of the refresh.
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// REFRESH OF ONE ITEM
+type itemRefresh struct {
+	source    string
+	files     int
+	refreshed int // permissions or attributes applied
+	current   int // already up to date
+	changed   int // content changed since the backup, not refreshed
+	missing   int // not in the backup (or packed)
+	err       error
+}
+
+
+// BACKUP BEING REFRESHED
+type refreshTarget struct {
+	backup     backupDir
+	files      map[string]manifestEntry // manifest entries by path
+	obfuscated bool
+	dryRun     bool
+	protected  bool // backup is read-only
+	unlocked   bool // protection was lifted and must be restored
+}
+
+
+
+//////////////  REFRESH COMMAND  //////////////////////////////////////////////
+
+// RUN 'REFRESH' COMMAND
+func runRefreshCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		dryRun     = flags.Bool("dry-run", false, "Only count the files that would be refreshed.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+	defer app.closeRemoteClients()
+
+	backup, err := resolveCompleteBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+	manifest, err := readManifest(backup.path)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to read manifest of %s: %v\n\n", backup.name, err), style.Bold())
+		return 1
+	}
+	info, err := os.Stat(backup.path)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+
+	target := &refreshTarget{
+		backup:     backup,
+		files:      make(map[string]manifestEntry, len(manifest)),
+		obfuscated: backupObfuscated(backup.path),
+		dryRun:     *dryRun,
+		protected:  info.Mode().Perm()&0200 == 0,
+	}
+	for _, entry := range manifest {
+		target.files[entry.path] = entry
+	}
+
+	logger.Signature(fmt.Sprintf("\n====  Refreshing permissions of: %s (%s ago)  ===\n", backup.name, formatAge(time.Since(backup.created))))
+	var results []itemRefresh
+	for _, item := range app.BkpConfig.BkpItems {
+		if isStreamItem(item) {
+			continue
+		}
+		logger.Plain(fmt.Sprintf("Refreshing %s\n", itemSourceLabel(item)))
+		results = append(results, app.refreshItem(item, target))
+	}
+
+	if target.unlocked {
+		if err := lockBackup(backup.path, app.BkpConfig.immutableBackups()); err != nil {
+			logger.Err(fmt.Sprintf("Failed to restore read-only protection: %v\n", err))
+			return 1
+		}
+	}
+	return printRefresh(results, *dryRun)
+}
+
+
+// REFRESH PERMISSIONS AND ATTRIBUTES OF BACKED UP FILES OF THE ITEM
+func (app *BackupApp) refreshItem(item BackupItem, target *refreshTarget) itemRefresh {
+	result := itemRefresh{source: itemSourceLabel(item)}
+	work, err := app.enumerateItem(item)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	dest := path.Clean(filepath.ToSlash(item.Destination))
+	for _, entry := range work.entries {
+		if entry.linkTarget != "" || entry.info.IsDir() {
+			continue
+		}
+		result.files++
+
+		// Single-file source is copied to the item destination itself
+		key := dest
+		if work.root.IsDir() {
+			key = path.Join(dest, filepath.ToSlash(entry.relPath))
+		}
+		backedUp, ok := target.files[key]
+		if !ok {
+			result.missing++
+			continue
+		}
+		modTime := entry.info.ModTime().UTC().Truncate(time.Second) // manifest keeps whole seconds
+		if entry.info.Size() != backedUp.size || !work.mtimes.same(modTime, backedUp.modTime) {
+			result.changed++
+			continue
+		}
+
+		refreshed, err := target.refreshFile(key, entry.info)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			result.missing++
+		case err != nil:
+			result.err = fmt.Errorf("%s: %w", entry.path, err)
+			return result
+		case refreshed:
+			result.refreshed++
+		default:
+			result.current++
+		}
+	}
+	return result
+}
+
+
+// refreshFile applies permissions and attributes of the source file to its copy in the backup.
+// Returns false if they were up to date.
+func (t *refreshTarget) refreshFile(key string, source os.FileInfo) (bool, error) {
+	diskPath, err := backupDiskPath(key, t.obfuscated)
+	if err != nil {
+		return false, err
+	}
+	dest := filepath.Join(t.backup.path, filepath.FromSlash(diskPath))
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return false, err
+	}
+
+	// Read-only backups have write permissions removed from every file (and restored while unlocked)
+	current, perm := info.Mode().Perm(), source.Mode().Perm()
+	if t.protected {
+		current &^= 0222
+		perm &^= 0222
+	}
+	hidden, system := fileAttributes(info)
+	sourceHidden, sourceSystem := fileAttributes(source)
+	if current == perm && hidden == sourceHidden && system == sourceSystem {
+		return false, nil
+	}
+	if t.dryRun {
+		return true, nil
+	}
+
+	if t.protected && !t.unlocked {
+		if err := unlockBackup(t.backup.path); err != nil {
+			return false, fmt.Errorf("lifting read-only protection: %w", err)
+		}
+		t.unlocked = true
+	}
+	if err := os.Chmod(dest, source.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := copyAttributes(dest, source); err != nil {
+		return false, fmt.Errorf("copying attributes: %w", err)
+	}
+	return true, nil
+}
+
+
+// PRINT REFRESH RESULTS
+// Returns exit code: 1 if any item failed.
+func printRefresh(results []itemRefresh, dryRun bool) int {
+	logger.Plain("\nItems:\n", style.Bold())
+	table := style.NewTable("Files", "Refreshed", "Up to date", "Changed", "Missing", "Source").AlignRight(0, 1, 2, 3, 4)
+	var refreshed, changed, failed int
+	for _, result := range results {
+		if result.err != nil {
+			table.Row("-", "-", "-", "-", "-", fmt.Sprintf("%s (%v)", result.source, result.err))
+			failed++
+			continue
+		}
+		table.Row(fmt.Sprint(result.files), fmt.Sprint(result.refreshed), fmt.Sprint(result.current), fmt.Sprint(result.changed), fmt.Sprint(result.missing), result.source)
+		refreshed += result.refreshed
+		changed += result.changed
+	}
+	logger.Plain(table.Render())
+
+	logger.Plain("\n")
+	if changed > 0 {
+		logger.Info(fmt.Sprintf("%d files changed since the backup and were not refreshed. Run a backup to protect them.\n", changed))
+	}
+	if failed > 0 {
+		logger.Err(fmt.Sprintf("%d items could not be refreshed.\n", failed))
+		return 1
+	}
+	if dryRun {
+		logger.Ok(fmt.Sprintf("%d files would be refreshed.\n", refreshed), style.NoLabel())
+		return 0
+	}
+	logger.Ok(fmt.Sprintf("Refreshed %d files.\n", refreshed), style.NoLabel())
+	return 0
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	RemoteSourceScheme string = "ssh://"
+	RemoteDefaultPort  string = "22"
+	KnownHostsEnv      string = "SMBKP_SSH_KNOWN_HOSTS" // known_hosts file instead of '~/.ssh/known_hosts'
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// REMOTE SOURCE LOCATION (parsed from 'ssh://user@host[:port]:/path')
+type remoteSource struct {
+	User string
+	Host string
+	Port string
+	Path string
+}
+
+
+// address returns "host:port" suitable for dialing.
+func (rs *remoteSource) address() string {
+	return net.JoinHostPort(rs.Host, rs.Port)
+}
+
+
+// String returns the canonical representation of the remote source.
+func (rs *remoteSource) String() string {
+	return fmt.Sprintf("%s%s@%s:%s", RemoteSourceScheme, rs.User, rs.address(), rs.Path)
+}
+
+
+
+//////////////  PARSING  //////////////////////////////////////////////////////
+
+// isRemoteSource reports whether the item source points to a remote machine.
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(strings.ToLower(source), RemoteSourceScheme)
+}
+
+
+// parseRemoteSource parses sources in the following forms:
+//
+//	ssh://user@host:/var/www       (scp-like, default port)
+//	ssh://user@host:2222/var/www   (explicit port)
+//	ssh://host/var/www             (current user, default port)
+//
+// Paths that do not start with '/' are resolved by the server relative to the login directory.
+func parseRemoteSource(source string) (*remoteSource, error) {
+	if !isRemoteSource(source) {
+		return nil, fmt.Errorf("%q is not a remote source", source)
+	}
+	rest := source[len(RemoteSourceScheme):]
+
+	rs := &remoteSource{Port: RemoteDefaultPort}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rs.User = rest[:i]
+		rest = rest[i+1:]
+	} else {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("%q: user is not specified and current user can not be determined: %w", source, err)
+		}
+		rs.User = u.Username
+	}
+
+	i := strings.IndexAny(rest, ":/")
+	if i < 0 {
+		rs.Host = rest
+		rs.Path = "."
+	} else if rest[i] == '/' {
+		rs.Host = rest[:i]
+		rs.Path = rest[i:]
+	} else {
+		rs.Host = rest[:i]
+		after := rest[i+1:]
+		// Optional numeric port between ':' and the path
+		j := 0
+		for j < len(after) && after[j] >= '0' && after[j] <= '9' {
+			j++
+		}
+		if j > 0 && (j == len(after) || after[j] == '/') {
+			rs.Port = after[:j]
+			after = after[j:]
+			if after == "" {
+				after = "."
+			}
+		}
+		rs.Path = after
+	}
+
+	if rs.Host == "" {
+		return nil, fmt.Errorf("%q: host is not specified", source)
+	}
+	if rs.User == "" {
+		return nil, fmt.Errorf("%q: user is not specified", source)
+	}
+	if rs.Path == "" {
+		rs.Path = "."
+	}
+
+	return rs, nil
+}
+
+
+// remoteBaseName returns the leaf name of the remote path, used as default item destination.
+func remoteBaseName(source string) string {
+	rs, err := parseRemoteSource(source)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(rs.Path)
+	if base == "/" || base == "." {
+		return rs.Host
+	}
+	return base
+}
+
+
+
+//////////////  CONNECTION  ///////////////////////////////////////////////////
+
+// GET (OR OPEN) SFTP CLIENT FOR REMOTE SOURCE
+// Connections are cached per user/host/port and closed by closeRemoteClients.
+func (app *BackupApp) sftpClient(rs *remoteSource, keyFile string) (*sftp.Client, error) {
+	key := rs.User + "@" + rs.address()
+	app.remoteMu.Lock() // items backed up in parallel share the sessions
+	defer app.remoteMu.Unlock()
+	if client, ok := app.remoteClients[key]; ok {
+		return client, nil
+	}
+
+	auth, err := sshAuthMethods(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", rs.address(), &ssh.ClientConfig{
+		User:            rs.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", rs.address(), err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("starting sftp session on %s: %w", rs.address(), err)
+	}
+
+	if app.remoteClients == nil {
+		app.remoteClients = make(map[string]*sftp.Client)
+	}
+	app.remoteClients[key] = client
+	return client, nil
+}
+
+
+// CLOSE ALL CACHED SFTP CLIENTS
+func (app *BackupApp) closeRemoteClients() {
+	app.remoteMu.Lock()
+	defer app.remoteMu.Unlock()
+	for key, client := range app.remoteClients {
+		client.Close()
+		delete(app.remoteClients, key)
+	}
+}
+
+
+// sshAuthMethods collects authentication methods from the explicit key file (if any),
+// the running ssh-agent and the default private keys in ~/.ssh.
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+
+	keyFiles := []string{}
+	if keyFile != "" {
+		keyFiles = append(keyFiles, keyFile)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	for _, file := range keyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if keyFile != "" {
+				return nil, fmt.Errorf("reading ssh key: %w", err)
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			if keyFile != "" {
+				return nil, fmt.Errorf("parsing ssh key %q (passphrase-protected keys must be loaded into ssh-agent): %w", file, err)
+			}
+			continue
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh credentials found: start ssh-agent or specify %q for the item", "ssh_key")
+	}
+
+	return methods, nil
+}
+
+
+// sshHostKeyCallback verifies remote hosts against the user's known_hosts file
+// (or the file from SMBKP_SSH_KNOWN_HOSTS, for service accounts without a profile).
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	knownHostsFile := os.Getenv(KnownHostsEnv)
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locating known_hosts: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading %q (connect to the host once with 'ssh' to add its key): %w", knownHostsFile, err)
+	}
+	return callback, nil
+}
+
+
+
+//////////////  BACKUP FUNCTIONS  /////////////////////////////////////////////
+
+// ENUMERATE REMOTE SOURCE, APPLYING INCLUDE/EXCLUDE PATTERNS
+// Patterns are evaluated against OS-specific relative paths, same as for local sources.
+func (app *BackupApp) enumerateRemote(item BackupItem, spin *spinner) (*workList, error) {
+	rs, err := parseRemoteSource(item.Source)
+	if err != nil {
+		return nil, err
+	}
+	client, err := app.sftpClient(rs, item.SSHKey)
+	if err != nil {
+		return nil, err
+	}
+
+	srcInfo, err := client.Stat(rs.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := &workList{root: srcInfo, remote: client}
+
+	if !srcInfo.IsDir() {
+		wl.add(workEntry{path: rs.Path, info: srcInfo}) // A single file
+		return wl, nil
+	}
+
+	if wl.patterns, err = compilePatterns(item.Include, item.Exclude); err != nil {
+		return nil, err
+	}
+	root := path.Clean(rs.Path)
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if !remoteInaccessible(err) {
+				return nil, err
+			}
+			wl.skip(walker.Path(), SkipInaccessible+": "+err.Error())
+			walker.SkipDir()
+			continue
+		}
+
+		remotePath := walker.Path()
+		if remotePath == root {
+			continue
+		}
+
+		relPath := filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(remotePath, root), "/"))
+		info := walker.Stat()
+
+		if reason := wl.patterns.exclusion(relPath, info.IsDir()); reason != "" {
+			wl.skip(remotePath, reason)
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if reason := attributeExclusion(item, info); reason != "" {
+			wl.skip(remotePath, reason)
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		exists := func(name string) bool {
+			_, err := client.Lstat(path.Join(remotePath, name))
+			return err == nil
+		}
+		if reason := optOutExclusion(item, remotePath, info, exists); reason != "" {
+			wl.skip(remotePath, reason)
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if reason := app.backupFolderExclusion(remotePath, info, false, exists); reason != "" {
+			wl.skip(remotePath, reason)
+			walker.SkipDir()
+			continue
+		}
+		if wl.beyondDepth(item, remotePath, relPath) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		entry := workEntry{path: remotePath, relPath: relPath, info: info}
+
+		// Resolve symlinks now, so the copy phase knows what to do with them
+		if info.Mode()&os.ModeSymlink != 0 {
+			stat, err := client.Stat(remotePath) // This follows the symlink
+			if err != nil {
+				if !remoteInaccessible(err) {
+					return nil, err
+				}
+				wl.skip(remotePath, SkipInaccessible+": "+err.Error()) // e.g. a dangling link
+				continue
+			}
+			if stat.IsDir() {
+				// It's a symlink to a directory, it will be recreated
+				target, err := client.ReadLink(remotePath)
+				if err != nil {
+					if !remoteInaccessible(err) {
+						return nil, err
+					}
+					wl.skip(remotePath, SkipInaccessible+": "+err.Error())
+					continue
+				}
+				entry.linkTarget = target
+			} else {
+				// It's a symlink to a file, it will be copied as a regular file
+				entry.info = stat
+			}
+		}
+
+		if wl.filesLimitReached(item) {
+			break
+		}
+		wl.add(entry)
+		spin.update(fmt.Sprintf("%d files, %d directories, %s", wl.files, wl.dirs, formatBytes(uint64(wl.bytes))))
+	}
+
+	return wl, nil
+}
+
+
+// remoteInaccessible reports whether the remote enumeration error is about a single path that can't be read
+// (no permission, or removed meanwhile), which is skipped like on local sources instead of failing the item.
+func remoteInaccessible(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist)
+}
+
+
+// COPY REMOTE FILE
+func (app *BackupApp) copyRemoteFile(client *sftp.Client, src, dest string, progressCb func()) error {
+	srcFile, err := client.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Large copy buffer lets sftp.File issue concurrent read requests, which is much faster than sequential reads
+	// (not when transfers are limited on a metered connection)
+	if err := app.writeFile(dest, app.limitRate(srcFile), srcInfo, nil); err != nil {
+		return err
+	}
+
+	progressCb()
+	return nil
+}
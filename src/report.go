@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"strings"
+)
+
+// 'report' shows what takes space in a backup: per-item breakdown, largest files and largest directories.
+// Sizes come from the manifest; backups without manifest are measured on disk.
+
+const ReportTopDefault int = 10
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// SIZE OF THE BACKUP PART (item, directory or file)
+type sizeEntry struct {
+	path  string
+	files int
+	size  int64
+}
+
+
+
+//////////////  REPORT COMMAND  ///////////////////////////////////////////////
+
+// RUN 'REPORT' COMMAND
+func runReportCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+		top        = flags.IntP("top", "t", ReportTopDefault, "Number of largest files and directories to show.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	if *top < 1 {
+		logger.Fatal(fmt.Sprintf("%q value must be positive\n\n", "top"), style.Bold())
+		return 1
+	}
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backup, err := resolveBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+
+	if err := reportBackup(backup, *top); err != nil {
+		logger.Fatal(fmt.Sprintf("Report failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+	return 0
+}
+
+
+// PRINT SPACE REPORT OF THE BACKUP
+func reportBackup(backup backupDir, top int) error {
+	logger.Signature(fmt.Sprintf("\n====  Backup report: %s  ===\n", backup.name))
+
+	files, err := backupFiles(backup)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	logger.Plain(fmt.Sprintf("Total: %d files, %s\n", len(files), formatBytes(uint64(total))))
+	if len(files) == 0 {
+		return nil
+	}
+
+	// Per item
+	var destinations []string
+	if meta, err := readMetadata(backup.path); err == nil {
+		for _, item := range meta.Items {
+			destinations = append(destinations, path.Clean(filepath.ToSlash(item.Destination)))
+		}
+	}
+	items := make(map[string]*sizeEntry)
+	for _, file := range files {
+		name := itemOfPath(file.path, destinations)
+		if items[name] == nil {
+			items[name] = &sizeEntry{path: name}
+		}
+		items[name].files++
+		items[name].size += file.size
+	}
+	printSizeTable("Items", sortedBySize(items, 0), total, true)
+
+	// Largest directories (each directory includes its subdirectories)
+	dirs := make(map[string]*sizeEntry)
+	for _, file := range files {
+		for dir := path.Dir(file.path); dir != "."; dir = path.Dir(dir) {
+			if dirs[dir] == nil {
+				dirs[dir] = &sizeEntry{path: dir}
+			}
+			dirs[dir].files++
+			dirs[dir].size += file.size
+		}
+	}
+	printSizeTable(fmt.Sprintf("Largest directories (top %d)", top), sortedBySize(dirs, top), total, true)
+
+	// Largest files
+	largest := make([]sizeEntry, len(files))
+	copy(largest, files)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].size > largest[j].size })
+	if len(largest) > top {
+		largest = largest[:top]
+	}
+	printSizeTable(fmt.Sprintf("Largest files (top %d)", top), largest, total, false)
+
+	logger.Plain("\n")
+	return nil
+}
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// backupFiles returns the files copied into the backup, with sizes from the manifest if it exists.
+func backupFiles(backup backupDir) ([]sizeEntry, error) {
+	var files []sizeEntry
+
+	entries, err := readManifest(backup.path)
+	if err == nil {
+		for _, entry := range entries {
+			files = append(files, sizeEntry{path: entry.path, files: 1, size: entry.size})
+		}
+		return files, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	logger.Info("Backup has no manifest, measuring files on disk.\n")
+	err = filepath.WalkDir(backup.path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(backup.path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if backupOwnFiles[rel] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, sizeEntry{path: rel, files: 1, size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+
+// itemOfPath returns the item destination that contains the path (the longest match),
+// or the first path component if the backup has no metadata.
+func itemOfPath(p string, destinations []string) string {
+	item := ""
+	for _, dest := range destinations {
+		if (p == dest || strings.HasPrefix(p, dest+"/")) && len(dest) > len(item) {
+			item = dest
+		}
+	}
+	if item == "" {
+		item, _, _ = strings.Cut(p, "/")
+	}
+	return item
+}
+
+
+// sortedBySize returns entries from the largest to the smallest, at most 'limit' (0 means all).
+func sortedBySize(entries map[string]*sizeEntry, limit int) []sizeEntry {
+	sorted := make([]sizeEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].size != sorted[j].size {
+			return sorted[i].size > sorted[j].size
+		}
+		return sorted[i].path < sorted[j].path
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+
+// printSizeTable prints entries with their size and share of the total.
+func printSizeTable(title string, entries []sizeEntry, total int64, showFiles bool) {
+	logger.Plain(fmt.Sprintf("\n%s:\n", title), style.Bold())
+	table := style.NewTable("Size", "Share", "Files", "Path").AlignRight(0, 1, 2)
+	if !showFiles {
+		table = style.NewTable("Size", "Share", "Path").AlignRight(0, 1)
+	}
+	for _, entry := range entries {
+		share := 0.0
+		if total > 0 {
+			share = float64(entry.size) * 100 / float64(total)
+		}
+		cells := []string{formatBytes(uint64(entry.size)), fmt.Sprintf("%.1f%%", share)}
+		if showFiles {
+			cells = append(cells, fmt.Sprint(entry.files))
+		}
+		table.Row(append(cells, entry.path)...)
+	}
+	logger.Plain(table.Render())
+}
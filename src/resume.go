@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An interrupted run (laptop lid closed, battery drained, reboot) can be continued with 'backup --continue':
+// instead of starting a new backup and walking the sources again, the files the interrupted run had queued
+// but not copied yet are copied into its backup directory, which is then finalized as usual.
+// Two files in the report folder of the running backup make that possible:
+//   queue/item-N.tsv   - files of item N in copy order ('copy_order'), written once its directories are created
+//   smbkp-journal.tsv  - manifest entries of copied files, appended as files are copied
+// A queued file counts as copied if it's in the journal and its copy has the same size; files removed from
+// the source since are listed as skipped. Items that were not queued (not reached yet, single files,
+// remote sources, 'copy_while_scanning') are copied again, and so are packed files ('pack_small_files'),
+// as the pack index is only written at the end. Both files are removed when the backup is finalized.
+// Not available for '-to-stdout' archives, nor with 'obfuscate_names' (the files would list real names).
+
+const (
+	QueueDirName      string        = "queue"
+	QueueHeader       string        = "# smbkp queue v1" // followed by item source and destination
+	JournalFileName   string        = "smbkp-journal.tsv"
+	JournalFlushEvery time.Duration = 2 * time.Second // lines not flushed when the run is killed are copied again
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// INTERRUPTED RUN BEING CONTINUED ('--continue')
+type resumeState struct {
+	backup  backupDir
+	journal map[string]manifestEntry // files copied by the interrupted run, by manifest path
+}
+
+
+// JOURNAL OF FILES COPIED BY THE RUN
+type runJournal struct {
+	file    *os.File
+	w       *bufio.Writer
+	flushed time.Time
+}
+
+
+
+//////////////  RESUME FUNCTIONS  /////////////////////////////////////////////
+
+// PREPARE TO CONTINUE THE INTERRUPTED RUN
+// The latest backup must be partial and have a file queue. Item destinations are taken from its queues,
+// so placeholders (e.g. '{{time}}') resolve to what the interrupted run used.
+func (app *BackupApp) prepareContinue() error {
+	if app.toStdout {
+		return fmt.Errorf("%q doesn't apply to '-to-stdout' archives", "--continue")
+	}
+	if app.BkpConfig.ObfuscateNames {
+		return fmt.Errorf("%q is not supported with %q", "--continue", "obfuscate_names")
+	}
+	backups, err := listBackups(app.bkpDestFullPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups in %q, there is no interrupted run to continue", app.bkpDestFullPath)
+	}
+	latest := backups[0]
+	if latest.state != BackupPartial {
+		return fmt.Errorf("the latest backup %s is %s, there is no interrupted run to continue", latest.name, latest.state)
+	}
+	if _, err := os.Stat(filepath.Join(latest.path, ReportDirName, QueueDirName)); err != nil {
+		return fmt.Errorf("backup %s has no file queue (interrupted before its first item was queued, or made by an earlier version), start a new run instead", latest.name)
+	}
+
+	journal, err := readJournal(latest.path)
+	if err != nil {
+		return fmt.Errorf("reading journal of %s: %w", latest.name, err)
+	}
+	for i := range app.BkpConfig.BkpItems {
+		item := &app.BkpConfig.BkpItems[i]
+		if source, destination, _, err := readQueue(latest.path, i); err == nil && source == item.Source {
+			item.Destination = destination
+		}
+	}
+
+	app.resume = &resumeState{backup: latest, journal: journal}
+	logger.Info(fmt.Sprintf("Continuing interrupted backup %s (%d files copied so far).\n", latest.name, len(journal)))
+	return nil
+}
+
+
+// START JOURNAL OF COPIED FILES
+// Appended to when the interrupted run is continued.
+func (app *BackupApp) startJournal() error {
+	if app.toStdout || app.names != nil {
+		return nil
+	}
+	dir := filepath.Join(app.bkpDestFullPath, ReportDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating report directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, JournalFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("creating journal: %w", err)
+	}
+	app.journal = &runJournal{file: f, w: bufio.NewWriter(f), flushed: time.Now()}
+
+	// The line being written when the run was killed is left incomplete, the next one starts anew
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			app.journal.w.WriteByte('\n')
+		}
+	}
+	return nil
+}
+
+
+// add appends the manifest entry to the journal. Caller holds 'manifestMu'.
+func (j *runJournal) add(entry manifestEntry) {
+	fmt.Fprintf(j.w, "%s\t%d\t%s\t%s\n", entry.sum, entry.size, entry.modTime.UTC().Format(time.RFC3339), manifestPathEscaper.Replace(entry.path))
+	if time.Since(j.flushed) >= JournalFlushEvery {
+		j.w.Flush()
+		j.flushed = time.Now()
+	}
+}
+
+
+// CLOSE JOURNAL
+// Lines not flushed yet are written, so a run that fails can be continued from where it stopped.
+func (app *BackupApp) closeJournal() {
+	app.manifestMu.Lock()
+	defer app.manifestMu.Unlock()
+	if app.journal == nil {
+		return
+	}
+	app.journal.w.Flush()
+	app.journal.file.Close()
+	app.journal = nil
+}
+
+
+// FINISH JOURNAL
+// Removes the journal and file queues: the backup is about to be finalized, there is nothing to continue.
+func (app *BackupApp) finishJournal() {
+	if app.journal == nil {
+		return
+	}
+	app.closeJournal()
+	dir := filepath.Join(app.bkpDestFullPath, ReportDirName)
+	if err := os.Remove(filepath.Join(dir, JournalFileName)); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove journal: %v\n", err))
+	}
+	if err := os.RemoveAll(filepath.Join(dir, QueueDirName)); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to remove file queues: %v\n", err))
+	}
+}
+
+
+// writeQueue saves the files of the item in copy order, before they are copied, so an interrupted run
+// can be continued. Items that can't be continued (remote sources) are not queued.
+func (app *BackupApp) writeQueue(work *workList, files []workEntry) {
+	if app.journal == nil || work.queued || work.remote != nil {
+		return
+	}
+	index := slices.IndexFunc(app.BkpConfig.BkpItems, func(item BackupItem) bool { return item.Destination == work.item.Destination })
+	if index < 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\t%s\t%s\n", QueueHeader, manifestPathEscaper.Replace(app.BkpConfig.BkpItems[index].Source), manifestPathEscaper.Replace(work.item.Destination))
+	for _, entry := range files {
+		buf.WriteString(manifestPathEscaper.Replace(filepath.ToSlash(entry.relPath)))
+		buf.WriteByte('\n')
+	}
+
+	// Written aside and renamed, so a queue is either complete or missing
+	dir := filepath.Join(app.bkpDestFullPath, ReportDirName, QueueDirName)
+	file := filepath.Join(dir, queueFileName(index))
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = app.writeBytes(file+ManifestSpoolSuffix, buf.Bytes())
+	}
+	if err == nil {
+		err = os.Rename(file+ManifestSpoolSuffix, file)
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to save file queue, the item can't be continued if the run is interrupted: %v\n", err))
+		return
+	}
+	work.queued = true
+}
+
+
+// RESUME ITEM FROM THE QUEUE OF THE INTERRUPTED RUN
+// Returns the work list of files that are not copied yet, or nil if the item was not queued
+// (it's enumerated as usual then). Files copied by the interrupted run go into the manifest as they are.
+func (app *BackupApp) resumedWork(index int, item BackupItem) *workList {
+	if app.resume == nil {
+		return nil
+	}
+	source, destination, paths, err := readQueue(app.resume.backup.path, index)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn(fmt.Sprintf("File queue of the item is not readable, the item is copied again: %v\n", err))
+		}
+		return nil
+	}
+	if source != app.BkpConfig.BkpItems[index].Source || destination != item.Destination {
+		return nil // config changed since
+	}
+	root, err := os.Stat(item.Source)
+	if err != nil || !root.IsDir() {
+		return nil // reported by the enumeration
+	}
+
+	start := time.Now()
+	work := &workList{item: item, root: root, queued: true}
+	for _, rel := range paths {
+		p := filepath.Join(item.Source, rel)
+		info, err := os.Stat(p)
+		if err != nil {
+			work.skip(p, "missing: removed since the interrupted run")
+			continue
+		}
+		dest := app.destPath(filepath.Join(item.Destination, rel))
+		if entry, ok := app.resume.journal[path.Join(filepath.ToSlash(item.Destination), filepath.ToSlash(rel))]; ok {
+			if copied, err := os.Lstat(dest); err == nil && copied.Mode().IsRegular() && copied.Size() == entry.size {
+				work.files++
+				work.bytes += entry.size
+				work.resumed++
+				work.fileCopied(entry.size)
+				app.counters.fileDone(entry.size)
+				app.restoreManifestEntry(entry)
+				continue
+			}
+		}
+		os.Remove(dest) // partly copied, or in the way of a hard link ('dedup: hardlink')
+		work.add(workEntry{path: p, relPath: rel, info: info})
+	}
+	work.elapsed = time.Since(start)
+	return work
+}
+
+
+// restoreManifestEntry adds the file copied by the interrupted run to the manifest (it's in the journal already).
+func (app *BackupApp) restoreManifestEntry(entry manifestEntry) {
+	app.manifestMu.Lock()
+	defer app.manifestMu.Unlock()
+	if app.manifestSpool != nil {
+		app.spoolManifestEntry(entry)
+		return
+	}
+	app.manifest = append(app.manifest, entry)
+}
+
+
+// readJournal returns the entries of the journal by path. Lines that are incomplete are ignored.
+func readJournal(dir string) (map[string]manifestEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ReportDirName, JournalFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]manifestEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	journal := make(map[string]manifestEntry)
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, err := parseManifestLine(line); err == nil {
+			journal[entry.path] = entry
+		}
+	}
+	return journal, nil
+}
+
+
+// readQueue returns the item source and destination recorded in its queue, and the queued paths (OS-specific).
+func readQueue(dir string, index int) (string, string, []string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ReportDirName, QueueDirName, queueFileName(index)))
+	if err != nil {
+		return "", "", nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	header := strings.Split(lines[0], "\t")
+	if header[0] != QueueHeader || len(header) != 3 {
+		return "", "", nil, fmt.Errorf("%s: unknown format", queueFileName(index))
+	}
+	var paths []string
+	for _, line := range lines[1:] {
+		if line != "" {
+			paths = append(paths, filepath.FromSlash(manifestPathUnescaper.Replace(line)))
+		}
+	}
+	return manifestPathUnescaper.Replace(header[1]), manifestPathUnescaper.Replace(header[2]), paths, nil
+}
+
+
+// queueFileName returns the name of the queue file of the item.
+func queueFileName(index int) string {
+	return fmt.Sprintf("item-%d.tsv", index+1)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Destination drives can be rotated ('rotation'), as in the classic offsite rotation: one drive is attached
+// while the others are kept elsewhere. Each run goes to the least recently used drive that is attached
+// (the one whose newest backup is the oldest, drives without backups first, ties in list order),
+// so with all drives attached they take turns, and a drive that is away is skipped until it's back.
+// A drive counts as attached if 'bkp_dest_dir' or the config file is in its root, so an empty mount point
+// of a detached drive is not mistaken for it: copy the config file to a new drive before its first run.
+// Each drive keeps its own backups, retention applies per drive ('backups_to_keep' of the drive, if set).
+// 'status' shows which backups each drive held when it was last used.
+// The destination given with '-bkp-dest' (or found) only locates the config, unless the run is continued
+// ('--continue'), which stays on it.
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// ROTATION SETTINGS
+type RotationConfig struct {
+	Drives []RotationDrive `yaml:"drives,omitempty"`
+}
+
+
+// DRIVE IN ROTATION
+type RotationDrive struct {
+	Path          string `yaml:"path"`                      // drive or mount point
+	Name          string `yaml:"name,omitempty"`            // shown in review and 'status' (defaults to path)
+	BackupsToKeep uint16 `yaml:"backups_to_keep,omitempty"` // overrides 'retention.backups_to_keep' on this drive
+}
+
+
+// DRIVE CANDIDATE FOR THE RUN
+type rotationCandidate struct {
+	drive    RotationDrive
+	lastUsed time.Time // creation time of its newest backup, zero if it has none
+}
+
+
+
+//////////////  ROTATION FUNCTIONS  ///////////////////////////////////////////
+
+// validate checks the rotation settings.
+func (c *RotationConfig) validate() error {
+	var paths []string
+	for i := range c.Drives {
+		drive := &c.Drives[i]
+		if strings.TrimSpace(drive.Path) == "" {
+			return fmt.Errorf("%q: drive %d has no %q", "rotation.drives", i+1, "path")
+		}
+		if slices.Contains(paths, drive.Path) {
+			return fmt.Errorf("%q: drive %q is listed more than once", "rotation.drives", drive.Path)
+		}
+		paths = append(paths, drive.Path)
+		if drive.Name == "" {
+			drive.Name = drive.Path
+		}
+	}
+	return nil
+}
+
+
+// SELECT ROTATION DRIVE FOR THE RUN
+// Switches the destination to the least recently used drive that is attached,
+// and applies its retention. Does nothing without 'rotation'.
+func (app *BackupApp) selectRotationDrive() error {
+	drives := app.BkpConfig.Rotation.Drives
+	if len(drives) == 0 || app.toStdout {
+		return nil
+	}
+
+	var candidates []rotationCandidate
+	var away []string
+	for _, drive := range drives {
+		root := filepath.Join(drive.Path, app.BkpConfig.BkpDestDir)
+		if !rotationDriveAttached(drive.Path, root) {
+			away = append(away, drive.Name)
+			continue
+		}
+		candidate := rotationCandidate{drive: drive}
+		if backups, err := listBackups(root); err == nil && len(backups) > 0 {
+			candidate.lastUsed = backups[0].created
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("none of %d drives in %q is attached (a drive needs %q or %q in its root)", len(drives), "rotation.drives", app.BkpConfig.BkpDestDir, ConfigFileDefault)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].lastUsed.Before(candidates[j].lastUsed) })
+	selected := candidates[0].drive
+
+	app.bkpDest = selected.Path
+	app.bkpDestFullPath = filepath.Join(app.bkpDest, app.BkpConfig.BkpDestDir)
+	app.backupRoot, _ = filepath.Abs(app.bkpDestFullPath)
+	app.rotation = &selected
+	if selected.BackupsToKeep > 0 {
+		app.BkpConfig.Retention.BackupsToKeep = max(selected.BackupsToKeep, LimitMinBackupsToKeep)
+	}
+
+	logger.Info(fmt.Sprintf("Rotation: backing up to drive %q (%d of %d attached, least recently used).\n", selected.Name, len(candidates), len(drives)))
+	if len(away) > 0 {
+		logger.Sub(fmt.Sprintf("  Not attached: %s\n", strings.Join(away, ", ")))
+	}
+	return nil
+}
+
+
+// rotationDriveAttached reports whether the drive holds backups or the config file, rather than being
+// an empty mount point.
+func rotationDriveAttached(path, root string) bool {
+	if err := connectDestination(path); err != nil {
+		return false
+	}
+	if info, err := os.Stat(root); err == nil && info.IsDir() && filepath.Clean(root) != filepath.Clean(path) {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(path, ConfigFileDefault))
+	return err == nil
+}
+
+
+// rotationBackups returns the names of complete backups on the drive, newest first.
+func rotationBackups(root string) []string {
+	backups, err := listBackups(root)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, backup := range backups {
+		if backup.state == BackupComplete {
+			names = append(names, backup.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// 'run_as' reads a local item with the credentials of another user (e.g. a root-run scheduled backup
+// reading '/home/alice' as 'alice'), so the user's permissions and ACLs apply instead of blanket root access.
+// The effective user and groups of the process are switched for the item and restored after it.
+// Copies of the item are owned by the user. Unix only; the app must run as root.
+
+
+
+//////////////  RUN AS FUNCTIONS  /////////////////////////////////////////////
+
+// validateRunAs checks that 'run_as' can be applied to the item.
+func validateRunAs(item BackupItem) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("%q is not supported on Windows", "run_as")
+	}
+	if isStreamItem(item) || isRemoteSource(item.Source) {
+		return fmt.Errorf("%q is supported for local path items only", "run_as")
+	}
+	return nil
+}
+
+
+// SWITCH TO 'RUN_AS' USER OF THE ITEM
+// Returns the function restoring the original credentials (no-op if the item has no 'run_as').
+// Item destination is prepared by the original user first, since the backup directory isn't writable by the item user.
+func (app *BackupApp) runAsItemUser(item BackupItem) (func() error, error) {
+	noop := func() error { return nil }
+	if item.RunAs == "" {
+		return noop, nil
+	}
+	if !isElevated() {
+		return noop, fmt.Errorf("%q requires running as root", "run_as")
+	}
+	creds, err := lookupCredentials(item.RunAs)
+	if err != nil {
+		return noop, fmt.Errorf("%q user %q: %w", "run_as", item.RunAs, err)
+	}
+
+	if !app.toStdout {
+		if err := prepareRunAsDestination(item, app.destPath(item.Destination), creds); err != nil {
+			return noop, fmt.Errorf("preparing destination for %q: %w", "run_as", err)
+		}
+	}
+
+	// Files queued for hashing were written by the original user and may not be readable by the item user
+	app.waitHashing()
+
+	restore, err := switchCredentials(creds)
+	if err != nil {
+		return noop, fmt.Errorf("switching to user %q: %w", item.RunAs, err)
+	}
+	logger.Sub(fmt.Sprintf("Reading as user %q\n", item.RunAs))
+	return restore, nil
+}
+
+
+// prepareRunAsDestination creates the item destination owned by the item user:
+// the directory for directory sources, an empty file for file sources.
+func prepareRunAsDestination(item BackupItem, dest string, creds *credentials) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(item.Source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err := os.MkdirAll(dest, info.Mode().Perm()|0700); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return os.Chown(dest, creds.uid, creds.gid)
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// CREDENTIALS OF 'RUN_AS' USER
+type credentials struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+
+// lookupCredentials returns user and group IDs of the user (name or numeric ID).
+func lookupCredentials(name string) (*credentials, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+	creds := &credentials{}
+	if creds.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, err
+	}
+	if creds.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, err
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range groupIDs {
+		if gid, err := strconv.Atoi(id); err == nil {
+			creds.groups = append(creds.groups, gid)
+		}
+	}
+	return creds, nil
+}
+
+
+// switchCredentials sets effective user and groups of the process (all threads) to the credentials.
+// The real user stays root, so the returned function can switch back.
+func switchCredentials(creds *credentials) (func() error, error) {
+	groups, err := syscall.Getgroups()
+	if err != nil {
+		return nil, err
+	}
+	gid := os.Getegid()
+
+	restore := func() error {
+		if err := syscall.Seteuid(0); err != nil {
+			return err
+		}
+		if err := syscall.Setegid(gid); err != nil {
+			return err
+		}
+		return syscall.Setgroups(groups)
+	}
+
+	if err := syscall.Setgroups(creds.groups); err != nil {
+		return nil, err
+	}
+	if err := syscall.Setegid(creds.gid); err != nil {
+		restore()
+		return nil, err
+	}
+	if err := syscall.Seteuid(creds.uid); err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// CREDENTIALS OF 'RUN_AS' USER (not supported on Windows)
+type credentials struct {
+	uid int
+	gid int
+}
+
+
+// lookupCredentials is not supported on Windows ('run_as' is rejected by config validation).
+func lookupCredentials(name string) (*credentials, error) {
+	return nil, errors.New("not supported on Windows")
+}
+
+
+// switchCredentials is not supported on Windows.
+func switchCredentials(creds *credentials) (func() error, error) {
+	return nil, errors.New("not supported on Windows")
+}
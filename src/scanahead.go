@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// With 'copy_while_scanning', a local directory item starts copying as soon as the first entries are found,
+// instead of after the whole tree is counted: the walker sends entries to the copy through a bounded feed,
+// so time-to-first-byte doesn't depend on the size of the tree, and the file list is never held in memory.
+// Totals (and the progress percentage) grow while the walk runs, so limits that need them up front
+// ('max_size') and largest-first scheduling of 'copy_workers' don't apply. Directories are still created
+// before their content, since the walk sends them first.
+
+const (
+	ScanAheadFeedSize int = 1024 // entries found but not copied yet; the walker waits when the feed is full
+)
+
+
+
+//////////////  SCAN-AHEAD FUNCTIONS  /////////////////////////////////////////
+
+// scanAheadRoot returns the source directory info if the item is copied while it's being enumerated,
+// or nil if it's enumerated up front.
+func (app *BackupApp) scanAheadRoot(item BackupItem) os.FileInfo {
+	if !app.BkpConfig.CopyWhileScanning || isStreamItem(item) || isRemoteSource(item.Source) || item.maxSizeParsed > 0 {
+		return nil
+	}
+	info, err := os.Stat(item.Source)
+	if err != nil || !info.IsDir() {
+		return nil // single files are not worth it, errors are reported by the regular enumeration
+	}
+	return info
+}
+
+
+// START ENUMERATING DIRECTORY ITEM INTO THE FEED OF ITS WORK LIST
+// 'onAdd' is called for each entry that reports progress. The returned channel gets the result of the walk;
+// work list totals are complete once it's received. The feed must be drained (see 'finishScanning').
+func (app *BackupApp) enumerateAhead(item BackupItem, root os.FileInfo, onAdd func(workEntry)) (*workList, <-chan error) {
+	wl := &workList{item: item, root: root, feed: make(chan workEntry, ScanAheadFeedSize), onAdd: onAdd, mtimes: app.mtimeRule(item)}
+	done := make(chan error, 1)
+
+	go func() {
+		defer handlePanic()
+		start := time.Now()
+		// Each real directory is walked once, which breaks symlink cycles and avoids duplicate content
+		err := app.walkLocal(item, wl, nil, item.Source, "", make(map[string]string))
+		wl.elapsed = time.Since(start)
+		close(wl.feed)
+		done <- err
+	}()
+	return wl, done
+}
+
+
+// FINISH ENUMERATION RUNNING ALONGSIDE THE COPY
+// Entries the copy didn't take (it failed or stopped early) are dropped, so the walker can end.
+// Returns the copy error, or the enumeration error if the copy succeeded.
+func finishScanning(work *workList, scanned <-chan error, copyErr error) error {
+	for range work.feed {
+	}
+	if err := <-scanned; copyErr == nil {
+		return err
+	}
+	return copyErr
+}
+
+
+// COPY DIRECTORY ENTRIES FROM THE FEED AS THEY ARE FOUND
+// Directories and symlinks are created by the dispatcher in walk order, files are copied by workers.
+// 'dest' is the item destination, relative to the backup directory.
+func (app *BackupApp) copyFeed(work *workList, dest string, workers int, progressCb func()) error {
+	if workers == 1 {
+		for entry := range work.feed {
+			destPath := app.destPath(filepath.Join(dest, entry.relPath))
+			if entry.linkTarget != "" || entry.info.IsDir() {
+				if err := app.createEntry(entry, destPath, progressCb); err != nil {
+					return err
+				}
+			} else if err := app.copyEntry(work, entry, destPath, progressCb); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Copy files concurrently, stop dispatching on the first error
+	queue := make(chan workEntry, workers)
+	errs := make(chan error, workers+1)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer handlePanic()
+			defer wg.Done()
+			for entry := range queue {
+				if err := app.copyEntry(work, entry, app.destPath(filepath.Join(dest, entry.relPath)), progressCb); err != nil {
+					errs <- err
+					stopOnce.Do(func() { close(stop) })
+					return
+				}
+			}
+			// No files left, take ranges of large files other workers are copying
+			for app.ranges.help(app) {
+			}
+		}()
+	}
+
+dispatch:
+	for entry := range work.feed {
+		if entry.linkTarget != "" || entry.info.IsDir() {
+			if err := app.createEntry(entry, app.destPath(filepath.Join(dest, entry.relPath)), progressCb); err != nil {
+				errs <- err
+				break
+			}
+			continue
+		}
+		select {
+		case queue <- entry:
+		case <-stop:
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+
+	return <-errs // nil if channel is empty
+}
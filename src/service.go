@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Service mode ('--service') is for unattended deployment across workstations (e.g. a scheduled task
+// rolled out via Group Policy, running as a service account or gMSA):
+//   - no prompts and no "Press Enter" pause ('-non-interactive');
+//   - plain console output: no colors, no emoji (consoles of services are captured into files);
+//   - the log file is written as JSON lines ('--log-format json'), for log collectors;
+//   - no update check (release notices are for people, and locked-down networks may block it).
+// Authentication stays non-interactive: SMB destinations use the Kerberos ticket of the account running
+// the task (or '--dest-credential'), SFTP sources use 'ssh_key' of the item and a known_hosts file
+// deployed with the app (SMBKP_SSH_KNOWN_HOSTS), as service accounts have no profile with '~/.ssh'.
+
+const (
+	LogFormatText string = "text"
+	LogFormatJSON string = "json"
+)
+
+
+
+//////////////  SERVICE FUNCTIONS  ////////////////////////////////////////////
+
+// validateLogFormat checks the value of '--log-format'.
+func validateLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("%q value %q is not supported. Expected %q or %q", "-log-format", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+
+// jsonLogFields returns the fields added to every line of the JSON log.
+func jsonLogFields(runID string) map[string]string {
+	host, _ := os.Hostname()
+	return map[string]string{
+		"run":     runID,
+		"host":    host,
+		"version": Version,
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Started without arguments in a terminal, and with no '.smbkp.yaml' on any drive, the app walks the user
+// through the setup instead of failing: destination drive (from the detected ones), folders to back up
+// (common home folders are suggested), and number of backups to keep. The config is written to the root
+// of the destination, where the next runs find it, and the first backup goes through the usual review
+// and confirmation. Prompts are localized like the rest of the interactive flow (see i18n.go).
+// Everything else keeps its defaults, 'init-config' writes an example with all options.
+
+const SetupBackupsToKeep uint16 = 5 // suggested, the config default is the allowed minimum
+
+// Home folders suggested as sources (the ones that exist)
+var setupSuggestedFolders = []string{"Documents", "Pictures", "Desktop", "Music", "Videos", "Movies"}
+
+
+
+//////////////  SETUP FUNCTIONS  //////////////////////////////////////////////
+
+// needsSetup reports whether the guided setup should start: no arguments, a terminal, and no config on any drive.
+func needsSetup() bool {
+	if len(os.Args) > 1 || !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+	drives, err := getAvailableDrives()
+	if err != nil {
+		return false
+	}
+	for _, drive := range drives {
+		if _, err := os.Stat(filepath.Join(drive, ConfigFileDefault)); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+
+// RUN GUIDED SETUP
+// Writes the config into the root of the chosen destination and returns the destination,
+// or false if the setup was cancelled.
+func runSetup() (string, bool) {
+	fmt.Print(tr(msgSetupWelcome))
+
+	dest, ok := setupDestination()
+	if !ok {
+		fmt.Print(tr(msgSetupCancelled))
+		return "", false
+	}
+	folders, ok := setupFolders()
+	if !ok {
+		fmt.Print(tr(msgSetupCancelled))
+		return "", false
+	}
+	keep, ok := setupBackupsToKeep()
+	if !ok {
+		fmt.Print(tr(msgSetupCancelled))
+		return "", false
+	}
+
+	path := filepath.Join(dest, ConfigFileDefault)
+	data := setupConfig(folders, keep)
+	if err := parseConfig(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Generated configuration is not valid: %v\n", err)
+		return "", false
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save configuration: %v\n", err)
+		return "", false
+	}
+	fmt.Print(tr(msgSetupWritten, path))
+	return dest, true
+}
+
+
+// setupDestination asks for the destination drive (or a directory on it).
+func setupDestination() (string, bool) {
+	drives, _ := getAvailableDrives()
+	if len(drives) == 0 {
+		fmt.Print(tr(msgSetupNoDrives))
+	} else {
+		fmt.Print(tr(msgSetupDrives))
+		for i, drive := range drives {
+			free := ""
+			if _, size, err := getFreeSpace(drive); err == nil {
+				free = fmt.Sprintf(" (%s free)", size)
+			}
+			fmt.Printf("  %d. %s%s\n", i+1, drive, free)
+		}
+	}
+
+	for {
+		fmt.Print(tr(msgSetupDrivePrompt))
+		answer, ok := readLine(0)
+		if !ok {
+			return "", false
+		}
+		answer = strings.TrimSpace(answer)
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(drives) {
+			return drives[n-1], true
+		}
+		if path := expandHome(answer); path != "" {
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				return path, true
+			}
+		}
+		fmt.Print(tr(msgSetupInvalid, answer))
+	}
+}
+
+
+// setupFolders asks for the folders to back up, suggesting the common ones in the home directory.
+func setupFolders() ([]string, bool) {
+	var suggested []string
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range setupSuggestedFolders {
+			if info, err := os.Stat(filepath.Join(home, name)); err == nil && info.IsDir() {
+				suggested = append(suggested, filepath.Join(home, name))
+			}
+		}
+	}
+	if len(suggested) > 0 {
+		fmt.Print(tr(msgSetupFolders))
+		for i, folder := range suggested {
+			fmt.Printf("  %d. %s\n", i+1, folder)
+		}
+	}
+
+	var folders []string
+	add := func(folder string) {
+		for _, added := range folders {
+			if added == folder {
+				return
+			}
+		}
+		folders = append(folders, folder)
+		fmt.Print(tr(msgSetupFolderAdded, folder))
+	}
+	for {
+		fmt.Print(tr(msgSetupFoldersPrompt))
+		answer, ok := readLine(0)
+		if !ok {
+			return nil, false
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			if len(folders) > 0 {
+				return folders, true
+			}
+			fmt.Print(tr(msgSetupNoFolders))
+			continue
+		}
+
+		// Numbers of suggested folders, or a path
+		var picked []string
+		for _, field := range strings.Fields(answer) {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(suggested) {
+				picked = nil
+				break
+			}
+			picked = append(picked, suggested[n-1])
+		}
+		if picked == nil {
+			if info, err := os.Stat(expandHome(answer)); err == nil && info.IsDir() {
+				picked = append(picked, expandHome(answer))
+			}
+		}
+		if picked == nil {
+			fmt.Print(tr(msgSetupInvalid, answer))
+			continue
+		}
+		for _, folder := range picked {
+			add(folder)
+		}
+	}
+}
+
+
+// setupBackupsToKeep asks for the number of backups to keep.
+func setupBackupsToKeep() (uint16, bool) {
+	for {
+		fmt.Print(tr(msgSetupKeepPrompt, SetupBackupsToKeep))
+		answer, ok := readLine(0)
+		if !ok {
+			return 0, false
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return SetupBackupsToKeep, true
+		}
+		if n, err := strconv.ParseUint(answer, 10, 16); err == nil && uint16(n) >= LimitMinBackupsToKeep {
+			return uint16(n), true
+		}
+		fmt.Print(tr(msgSetupInvalid, answer))
+	}
+}
+
+
+// setupConfig returns the config file content for the answers. Items get unique destinations
+// (folder names, with a number added to repeated ones).
+func setupConfig(folders []string, keep uint16) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Created by the guided setup on %s.\n", time.Now().Format("2006-01-02"))
+	b.WriteString("# All options are described in the example config: 'simple-backup -init-config'.\n")
+	fmt.Fprintf(&b, "bkp_dest_dir: %s\n", BackupDestDirDefault)
+	b.WriteString("retention:\n")
+	fmt.Fprintf(&b, "  backups_to_keep: %d\n", keep)
+	b.WriteString("bkp_items:\n")
+
+	used := make(map[string]bool)
+	for _, folder := range folders {
+		dest := defaultDestination("", folder)
+		for i := 2; used[strings.ToLower(dest)]; i++ {
+			dest = fmt.Sprintf("%s-%d", defaultDestination("", folder), i)
+		}
+		used[strings.ToLower(dest)] = true
+		fmt.Fprintf(&b, "  - source: %s\n", yamlQuote(folder))
+		fmt.Fprintf(&b, "    destination: %s\n", yamlQuote(dest))
+	}
+	return []byte(b.String())
+}
+
+
+// yamlQuote returns the string as a single-quoted YAML scalar (backslashes of Windows paths are kept as they are).
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+
+// expandHome replaces the leading '~' of the path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !(runtime.GOOS == "windows" && strings.HasPrefix(path, `~\`)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// With 'snapshot: true', a local item is copied from a read-only snapshot of its volume taken right before the copy,
+// so files that change during the backup are captured at a single point in time:
+//   Linux - btrfs snapshot of the subvolume with the source ('btrfs' tool, requires root);
+//   macOS - APFS local snapshot ('tmutil'), mounted read-only for the copy (requires root).
+// The snapshot is removed after the item. If it can't be taken, the live source is copied with a warning.
+
+// Returned by createSnapshot when the platform or the file system of the source can't be snapshotted
+var errSnapshotUnsupported = errors.New("snapshots are not supported")
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// SNAPSHOT OF THE ITEM SOURCE
+type sourceSnapshot struct {
+	source  string       // path of the item source inside the snapshot
+	release func() error // removes the snapshot
+}
+
+
+
+//////////////  SNAPSHOT FUNCTIONS  ///////////////////////////////////////////
+
+// validateSnapshot checks that 'snapshot' can be applied to the item.
+func validateSnapshot(item BackupItem) error {
+	if isStreamItem(item) || isRemoteSource(item.Source) {
+		return fmt.Errorf("%q is supported for local path items only", "snapshot")
+	}
+	return nil
+}
+
+
+// SNAPSHOT ITEM SOURCE
+// Returns the item reading from the snapshot, and the function removing the snapshot.
+// Falls back to the live source (with a warning) if the snapshot can't be taken.
+func (app *BackupApp) snapshotItem(item BackupItem, index int) (BackupItem, func()) {
+	noop := func() {}
+	if !item.Snapshot {
+		return item, noop
+	}
+
+	snap, err := createSnapshot(item.Source, fmt.Sprintf("%s-%d", app.runID, index))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Snapshot of the source is not taken, copying live files: %v\n", err))
+		return item, noop
+	}
+	logger.Sub(fmt.Sprintf("Reading from snapshot %s\n", snap.source))
+
+	release := func() {
+		if err := snap.release(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to remove snapshot %s: %v\n", snap.source, err))
+		}
+	}
+	item.Source = snap.source
+	return item, release
+}
@@ -0,0 +1,79 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// 'tmutil localsnapshot' prints the date the snapshot is named after
+var tmutilSnapshotDate = regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
+
+
+// createSnapshot takes an APFS local snapshot and mounts the snapshot of the source volume read-only.
+// 'id' is not used: APFS local snapshots are named by date.
+func createSnapshot(source, id string) (*sourceSnapshot, error) {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, err
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(abs, &fs); err != nil {
+		return nil, err
+	}
+	if fsType := unix.ByteSliceToString(fs.Fstypename[:]); fsType != "apfs" {
+		return nil, fmt.Errorf("%w: source is on %s, not APFS", errSnapshotUnsupported, fsType)
+	}
+	volume := unix.ByteSliceToString(fs.Mntonname[:])
+	rel, err := filepath.Rel(volume, abs)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command("tmutil", "localsnapshot").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("tmutil localsnapshot: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	date := tmutilSnapshotDate.FindString(string(out))
+	if date == "" {
+		return nil, fmt.Errorf("tmutil localsnapshot: unexpected output: %s", strings.TrimSpace(string(out)))
+	}
+	deleteSnapshot := func() error {
+		if out, err := exec.Command("tmutil", "deletelocalsnapshots", date).CombinedOutput(); err != nil {
+			return fmt.Errorf("tmutil deletelocalsnapshots: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	mountPoint, err := os.MkdirTemp("", "smbkp-snapshot-")
+	if err != nil {
+		deleteSnapshot()
+		return nil, err
+	}
+	name := "com.apple.TimeMachine." + date + ".local"
+	if out, err := exec.Command("mount_apfs", "-o", "ro", "-s", name, volume, mountPoint).CombinedOutput(); err != nil {
+		os.Remove(mountPoint)
+		deleteSnapshot()
+		return nil, fmt.Errorf("mount_apfs: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	release := func() error {
+		if out, err := exec.Command("umount", mountPoint).CombinedOutput(); err != nil {
+			return fmt.Errorf("umount: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		os.Remove(mountPoint)
+		return deleteSnapshot()
+	}
+	return &sourceSnapshot{source: filepath.Join(mountPoint, rel), release: release}, nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode number of the root directory of every btrfs subvolume
+const btrfsSubvolumeRootInode uint64 = 256
+
+
+// createSnapshot takes a read-only btrfs snapshot of the subvolume with the source.
+// The snapshot is placed in the root of the subvolume, so it's on the same file system.
+func createSnapshot(source, id string) (*sourceSnapshot, error) {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, err
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(abs, &fs); err != nil {
+		return nil, err
+	}
+	if uint32(fs.Type) != unix.BTRFS_SUPER_MAGIC {
+		return nil, fmt.Errorf("%w: source is not on btrfs (LVM snapshots are not supported)", errSnapshotUnsupported)
+	}
+
+	subvolume, err := btrfsSubvolume(abs)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(subvolume, abs)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := filepath.Join(subvolume, ".smbkp-snapshot-"+id)
+	if out, err := exec.Command("btrfs", "subvolume", "snapshot", "-r", subvolume, snapshot).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("btrfs subvolume snapshot: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	release := func() error {
+		if out, err := exec.Command("btrfs", "subvolume", "delete", snapshot).CombinedOutput(); err != nil {
+			return fmt.Errorf("btrfs subvolume delete: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return &sourceSnapshot{source: filepath.Join(snapshot, rel), release: release}, nil
+}
+
+
+// btrfsSubvolume returns the root directory of the btrfs subvolume with the path.
+func btrfsSubvolume(path string) (string, error) {
+	dir := path
+	if info, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	for {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Ino == btrfsSubvolumeRootInode {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("btrfs subvolume of %s is not found", path)
+		}
+		dir = parent
+	}
+}
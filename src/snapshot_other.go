@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// createSnapshot is not supported on this platform, the live source is copied.
+func createSnapshot(source, id string) (*sourceSnapshot, error) {
+	return nil, errSnapshotUnsupported
+}
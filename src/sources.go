@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Local item sources are checked before the run starts, so a detached external drive is reported once,
+// up front, instead of as a failure among the results. 'missing_source' decides what happens then:
+//   error - the run doesn't start
+//   warn  - the run starts, items with missing sources fail (default)
+//   skip  - the run starts, items with missing sources are skipped and not counted as failures
+// Remote sources are checked when the item connects, stream items have no source path.
+
+const (
+	MissingSourceError string = "error"
+	MissingSourceWarn  string = "warn"
+	MissingSourceSkip  string = "skip"
+)
+
+var missingSourcePolicies = []string{MissingSourceError, MissingSourceWarn, MissingSourceSkip}
+
+
+
+//////////////  SOURCE FUNCTIONS  /////////////////////////////////////////////
+
+// CHECK THAT LOCAL ITEM SOURCES EXIST
+// Applies 'missing_source' to the sources that are not found. Returns an error if the run must not start.
+func (app *BackupApp) checkSources() error {
+	policy := app.BkpConfig.MissingSource
+	var missing []string
+	for i := range app.BkpConfig.BkpItems {
+		item := &app.BkpConfig.BkpItems[i]
+		if isStreamItem(*item) || isRemoteSource(item.Source) {
+			continue
+		}
+		if _, err := os.Stat(item.Source); !errors.Is(err, os.ErrNotExist) {
+			continue // other errors (e.g. access denied) are reported by the item itself
+		}
+		missing = append(missing, fmt.Sprintf("[%d] %s", i+1, item.Source))
+		item.missing = policy == MissingSourceSkip
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	list := strings.Join(missing, ", ")
+	switch {
+	case policy == MissingSourceError:
+		return fmt.Errorf("sources not found (is the drive attached?): %s. Set %q to %q or %q to back up the other items", list, "missing_source", MissingSourceWarn, MissingSourceSkip)
+	case policy == MissingSourceSkip && len(missing) == len(app.BkpConfig.BkpItems):
+		return fmt.Errorf("sources not found (is the drive attached?): %s. Nothing to backup", list)
+	case policy == MissingSourceSkip:
+		logger.Warn(fmt.Sprintf("Sources not found, these items are skipped: %s\n", list))
+	default:
+		logger.Warn(fmt.Sprintf("Sources not found, these items will fail: %s\n", list))
+	}
+	return nil
+}
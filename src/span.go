@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A backup that doesn't fit on one drive can span several ('span_volumes'): volumes listed in the config
+// (drives or mount points, each with 'bkp_dest_dir' on it) take the items the destination has no room for.
+// Sources are measured before the run (like 'estimate'), then items are placed in config order, moving on
+// to the next volume when the current one is full (free space less 'retention.min_free_space').
+// An item is never split, so it must fit on one volume.
+// Each volume gets a backup directory of its own (a part), which is a complete backup of its items:
+// it has its own manifest and metadata, can be verified and restored alone, and retention on each volume
+// applies to its parts. Parts of the run share the run ID, recorded in their metadata ('span'), by which
+// 'export' finds the other parts and stitches them into one archive.
+// Volumes that are not attached are left out. Not available for '-to-stdout' archives, nor with '--continue'.
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// PART OF A SPANNED BACKUP (in metadata of each part)
+type SpanMetadata struct {
+	ID      string `yaml:"id"`      // run ID, shared by all parts
+	Volume  int    `yaml:"volume"`  // number of the part, from 1
+	Volumes int    `yaml:"volumes"` // number of parts
+}
+
+
+// ITEMS PLACED ON ONE VOLUME
+type spanPart struct {
+	volume string // destination drive or mount point
+	items  []int  // indexes of 'bkp_items'
+	bytes  uint64
+}
+
+
+// VOLUME AVAILABLE FOR THE RUN
+type spanVolume struct {
+	root string
+	free uint64 // free space less 'retention.min_free_space'
+}
+
+
+
+//////////////  SPAN FUNCTIONS  ///////////////////////////////////////////////
+
+// validateSpanVolumes checks the volumes listed in 'span_volumes'.
+func validateSpanVolumes(volumes []string) error {
+	for i, volume := range volumes {
+		if strings.TrimSpace(volume) == "" {
+			return fmt.Errorf("%q value %d is empty", "span_volumes", i+1)
+		}
+		if slices.Contains(volumes[:i], volume) {
+			return fmt.Errorf("%q value %q is listed more than once", "span_volumes", volume)
+		}
+	}
+	return nil
+}
+
+
+// PLAN SPANNED BACKUP
+// Returns the items of each volume, or nil if the backup fits on the destination (or doesn't span).
+func (app *BackupApp) planSpan() ([]spanPart, error) {
+	if len(app.BkpConfig.SpanVolumes) == 0 || app.toStdout {
+		return nil, nil
+	}
+	if app.resume != nil {
+		return nil, fmt.Errorf("%q is not supported with %q", "--continue", "span_volumes")
+	}
+
+	// Volumes
+	minFree := app.BkpConfig.Retention.minFreeSpaceParsed
+	var volumes []spanVolume
+	for _, root := range append([]string{app.bkpDest}, app.BkpConfig.SpanVolumes...) {
+		if root != app.bkpDest {
+			if err := connectDestination(root); err != nil {
+				logger.Warn(fmt.Sprintf("Volume %q is left out: %v\n", root, err))
+				continue
+			}
+		}
+		free, _, err := getFreeSpace(root)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Volume %q is left out, failed to get its free space: %v\n", root, err))
+			continue
+		}
+		volume := spanVolume{root: root}
+		if free > minFree {
+			volume.free = free - minFree
+		}
+		volumes = append(volumes, volume)
+	}
+
+	// Items, by size
+	logger.Plain(fmt.Sprintf("Measuring sources to place items on %d volumes...\n", len(volumes)))
+	plan, err := app.buildPlan()
+	if err != nil {
+		return nil, err
+	}
+	var parts []spanPart
+	current := 0
+	for i, item := range plan.Items {
+		var size uint64
+		if item.Bytes != nil {
+			size = uint64(*item.Bytes)
+		}
+		for current < len(volumes) && volumes[current].free < size {
+			current++
+		}
+		if current == len(volumes) {
+			return nil, fmt.Errorf("item [%d] %s (%s) doesn't fit on the destination or any volume after it in %q", i+1, item.Source, formatBytes(size), "span_volumes")
+		}
+		volumes[current].free -= size
+		if len(parts) == 0 || parts[len(parts)-1].volume != volumes[current].root {
+			parts = append(parts, spanPart{volume: volumes[current].root})
+		}
+		part := &parts[len(parts)-1]
+		part.items = append(part.items, i)
+		part.bytes += size
+	}
+	if len(parts) == 1 && parts[0].volume == app.bkpDest {
+		return nil, nil
+	}
+	return parts, nil
+}
+
+
+// PRINT PLAN OF SPANNED BACKUP
+func printSpanPlan(parts []spanPart) {
+	logger.Plain(fmt.Sprintf("Backup spans %d volumes:\n", len(parts)))
+	for i, part := range parts {
+		var items []string
+		for _, index := range part.items {
+			items = append(items, fmt.Sprintf("[%d]", index+1))
+		}
+		logger.Sub(fmt.Sprintf("  %d. %s: items %s (%s)\n", i+1, part.volume, strings.Join(items, " "), formatBytes(part.bytes)))
+	}
+}
+
+
+// NEW APP FOR ONE PART OF SPANNED BACKUP
+// Items that depend on items of earlier parts ('after') don't wait, those are backed up already.
+func (app *BackupApp) spanPartApp(parts []spanPart, number int) *BackupApp {
+	part := parts[number-1]
+	p := &BackupApp{
+		configFile:     app.configFile,
+		runID:          app.runID,
+		BkpConfig:      app.BkpConfig,
+		bkpDest:        part.volume,
+		exitOnError:    app.exitOnError,
+		nonInteractive: app.nonInteractive,
+		assumeYes:      app.assumeYes,
+		rateLimit:      app.rateLimit,
+		span:           &SpanMetadata{ID: app.runID, Volume: number, Volumes: len(parts)},
+	}
+	if part.volume == app.bkpDest {
+		p.rotation = app.rotation
+	}
+	p.BkpConfig.BkpItems = nil
+	for _, index := range part.items {
+		item := app.BkpConfig.BkpItems[index]
+		item.After = slices.DeleteFunc(slices.Clone(item.After), func(after string) bool {
+			target := path.Clean(filepath.ToSlash(after))
+			return !slices.ContainsFunc(p.BkpConfig.BkpItems, func(other BackupItem) bool {
+				return path.Clean(filepath.ToSlash(other.Destination)) == target
+			})
+		})
+		p.BkpConfig.BkpItems = append(p.BkpConfig.BkpItems, item)
+	}
+	p.bkpDestFullPath = filepath.Join(part.volume, p.BkpConfig.BkpDestDir)
+	p.backupRoot, _ = filepath.Abs(p.bkpDestFullPath)
+	p.scheduleUploads()
+	return p
+}
+
+
+// FIND PARTS OF SPANNED BACKUP
+// Returns the parts in volume order, searched for on the destination and 'span_volumes'
+// (just the backup, if it doesn't span).
+func (app *BackupApp) spanParts(backup backupDir) ([]backupDir, error) {
+	meta, err := readMetadata(backup.path)
+	if err != nil || meta.Span == nil || meta.Span.Volume < 1 || meta.Span.Volume > meta.Span.Volumes {
+		return []backupDir{backup}, nil
+	}
+	span := meta.Span
+	parts := make([]backupDir, span.Volumes)
+	parts[span.Volume-1] = backup
+
+	for _, root := range append([]string{app.bkpDest}, app.BkpConfig.SpanVolumes...) {
+		backups, err := listBackups(filepath.Join(root, app.BkpConfig.BkpDestDir))
+		if err != nil {
+			continue
+		}
+		for _, other := range backups {
+			if other.state != BackupComplete {
+				continue
+			}
+			if meta, err := readMetadata(other.path); err == nil && meta.Span != nil && meta.Span.ID == span.ID && meta.Span.Volume >= 1 && meta.Span.Volume <= span.Volumes {
+				parts[meta.Span.Volume-1] = other
+			}
+		}
+	}
+
+	var missing []string
+	for i, part := range parts {
+		if part.path == "" {
+			missing = append(missing, fmt.Sprint(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("backup %s is part %d of %d, complete parts %s are not found on the destination or %q (are the volumes attached?)", backup.name, span.Volume, span.Volumes, strings.Join(missing, ", "), "span_volumes")
+	}
+	return parts, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The state file keeps the outcome of the last run for each backup destination, in the user configuration directory
+// ('~/.config/simple-backup/state.yaml' on Linux, '%AppData%\simple-backup\state.yaml' on Windows).
+// 'status' reads it without touching the destinations, so it is fast enough for login-shell prompts,
+// and reports destinations whose last successful backup is older than their 'stale_after',
+// as well as runs in progress (from their progress checkpoints). For rotated drives ('rotation'), the backups
+// each drive held after its last run are kept too, so 'status' shows them while the drive is away.
+
+const (
+	StateDirName      string = "simple-backup"
+	StateFileName     string = "state.yaml"
+	StaleAfterDefault string = "7d"
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// LAST RUN OF ONE BACKUP DESTINATION
+type runState struct {
+	Destination string     `yaml:"destination"` // backup root ('bkp_dest_dir' of the destination)
+	ConfigFile  string     `yaml:"config_file"`
+	LastRun     time.Time  `yaml:"last_run"`
+	LastRunID   string     `yaml:"last_run_id,omitempty"`
+	LastSuccess *time.Time `yaml:"last_success,omitempty"`
+	LastBackup  string     `yaml:"last_backup,omitempty"` // directory name of the last successful backup
+	LastError   string     `yaml:"last_error,omitempty"`  // error of the last run, if it failed
+	StaleAfter  string     `yaml:"stale_after"`
+	Progress    *runProgress `yaml:"progress,omitempty"` // checkpoint of the run in progress (see checkpoint.go)
+	Drive       string     `yaml:"drive,omitempty"`   // name of the rotation drive (see rotation.go)
+	Backups     []string   `yaml:"backups,omitempty"` // complete backups the rotation drive held after its last run
+}
+
+
+// STATE FILE CONTENT
+type appState struct {
+	Runs        []runState        `yaml:"runs"`
+	UpdateCheck *updateCheckState `yaml:"update_check,omitempty"` // see update.go
+}
+
+
+
+//////////////  STATE FUNCTIONS  //////////////////////////////////////////////
+
+// statePath returns the path of the state file.
+func statePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, StateDirName, StateFileName), nil
+}
+
+
+// readState reads the state file. A missing file is an empty state.
+func readState() (*appState, error) {
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &appState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &appState{}
+	if err := yaml.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return state, nil
+}
+
+
+// RECORD OUTCOME OF THE RUN IN STATE FILE
+// Failures to save the state are reported, but don't fail the run.
+func (app *BackupApp) recordRunState(root string, runErr error) {
+	if app.toStdout {
+		return
+	}
+	state, err := readState()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("State file is not readable, it's recreated: %v\n", err))
+		state = &appState{}
+	}
+
+	run := state.run(root)
+	now := time.Now().UTC().Truncate(time.Second)
+	run.ConfigFile = app.configFile
+	run.LastRun = now
+	run.LastRunID = app.runID
+	run.StaleAfter = app.BkpConfig.StaleAfter
+	run.LastError = ""
+	run.Progress = nil
+	if runErr != nil {
+		run.LastError = runErr.Error()
+	} else {
+		run.LastSuccess = &now
+		run.LastBackup = filepath.Base(app.bkpDestFullPath)
+	}
+	if app.rotation != nil {
+		run.Drive = app.rotation.Name
+		run.Backups = rotationBackups(root)
+	}
+
+	if err := writeState(state); err != nil {
+		logger.Warn(fmt.Sprintf("Failed to save state file: %v\n", err))
+	}
+}
+
+
+// run returns the state of the backup root, adding it if it's not recorded yet.
+func (state *appState) run(root string) *runState {
+	for i := range state.Runs {
+		if state.Runs[i].Destination == root {
+			return &state.Runs[i]
+		}
+	}
+	state.Runs = append(state.Runs, runState{Destination: root})
+	return &state.Runs[len(state.Runs)-1]
+}
+
+
+// writeState replaces the state file, so a failed write never leaves a truncated file.
+func writeState(state *appState) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+
+
+//////////////  STATUS COMMAND  ///////////////////////////////////////////////
+
+// RUN 'STATUS' COMMAND
+// Exits with non-zero code if any destination is stale (or has never been backed up successfully).
+func runStatusCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		maxAge = flags.String("max-age", "", "Staleness threshold for all destinations (e.g. '36h', '7d'). Defaults to 'stale_after' of each destination.")
+		quiet  = flags.BoolP("quiet", "q", false, "Print stale destinations only (nothing if all are fresh), e.g. for login-shell prompts.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	var threshold time.Duration
+	if *maxAge != "" {
+		var err error
+		if threshold, err = parseDuration(*maxAge); err != nil || threshold <= 0 {
+			logger.Fatal(fmt.Sprintf("%q value %q has invalid format. Expected a positive duration (e.g., '36h', '7d')\n\n", "max-age", *maxAge), style.Bold())
+			return 1
+		}
+	}
+
+	state, err := readState()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to read state file: %v\n\n", err), style.Bold())
+		return 1
+	}
+	if len(state.Runs) == 0 {
+		logger.Warn("No backups recorded yet.\n")
+		return 1
+	}
+
+	sort.Slice(state.Runs, func(i, j int) bool { return state.Runs[i].Destination < state.Runs[j].Destination })
+	table := style.NewTable("Destination", "Last success", "Age", "Status", "Details").AlignRight(2)
+	stale, interrupted := 0, 0
+	for _, run := range state.Runs {
+		limit := threshold
+		if limit == 0 {
+			if limit, err = parseDuration(run.StaleAfter); err != nil || limit <= 0 {
+				limit, _ = parseDuration(StaleAfterDefault)
+			}
+		}
+
+		lastSuccess, age, status, details := "never", "-", "OK", ""
+		if run.LastSuccess != nil {
+			lastSuccess = run.LastSuccess.Local().Format("2006-01-02 15:04")
+			age = formatAge(time.Since(*run.LastSuccess))
+		}
+		if run.LastSuccess == nil || time.Since(*run.LastSuccess) > limit {
+			status = "STALE"
+			details = fmt.Sprintf("no successful backup within %s", formatAge(limit))
+			stale++
+			if *quiet {
+				logger.Plain(fmt.Sprintf("Backup of %s is stale (last success: %s)\n", run.Destination, lastSuccess))
+			}
+		}
+		if run.LastError != "" {
+			if status == "OK" {
+				status = "FAILED"
+			}
+			details = "last run failed: " + run.LastError
+		}
+		if p := run.Progress; p != nil {
+			if time.Since(p.Updated) <= ProgressStaleAfter {
+				status = "RUNNING"
+				details = "run in progress: " + p.describe()
+			} else {
+				details = fmt.Sprintf("run started %s was interrupted (%s)", p.Started.Local().Format("2006-01-02 15:04"), p.describe())
+				interrupted++
+			}
+		}
+		table.Row(run.Destination, lastSuccess, age, status, details)
+	}
+
+	if !*quiet {
+		logger.Plain("\n" + table.Render())
+		if interrupted > 0 {
+			logger.Info("Interrupted runs can be continued with 'backup --continue'.\n")
+		}
+		printRotationStatus(state.Runs)
+	}
+	if stale > 0 {
+		return 1
+	}
+	return 0
+}
+
+
+// PRINT BACKUPS HELD BY ROTATION DRIVES
+// As of the last run on each drive, so drives that are away are shown too.
+// The least recently used drive gets the next run, if it's attached then.
+func printRotationStatus(runs []runState) {
+	var drives []runState
+	for _, run := range runs {
+		if run.Drive != "" {
+			drives = append(drives, run)
+		}
+	}
+	if len(drives) == 0 {
+		return
+	}
+	sort.SliceStable(drives, func(i, j int) bool { return drives[i].LastRun.Before(drives[j].LastRun) })
+
+	table := style.NewTable("Drive", "Last run", "Backups", "Newest", "Oldest", "Next").AlignRight(2)
+	for i, run := range drives {
+		newest, oldest, next := "-", "-", ""
+		if len(run.Backups) > 0 {
+			newest, oldest = run.Backups[0], run.Backups[len(run.Backups)-1]
+		}
+		if i == 0 {
+			next = "next"
+		}
+		table.Row(run.Drive, run.LastRun.Local().Format("2006-01-02 15:04"), fmt.Sprint(len(run.Backups)), newest, oldest, next)
+	}
+	logger.Plain("\nRotation drives:\n" + table.Render())
+}
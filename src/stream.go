@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	ItemTypePath   string = "path"
+	ItemTypeStream string = "stream"
+	StdinLabel     string = "<stdin>"
+)
+
+
+
+//////////////  HELPERS  //////////////////////////////////////////////////////
+
+// isStreamItem reports whether the item reads a stream instead of a file system path.
+func isStreamItem(item BackupItem) bool {
+	return strings.EqualFold(item.Type, ItemTypeStream)
+}
+
+
+// isStdinItem reports whether the item reads the stream from stdin of the current process.
+func isStdinItem(item BackupItem) bool {
+	return isStreamItem(item) && item.Command == ""
+}
+
+
+// itemSourceLabel returns a human-readable description of the item source.
+func itemSourceLabel(item BackupItem) string {
+	if !isStreamItem(item) {
+		return item.Source
+	}
+	if item.Command == "" {
+		return StdinLabel
+	}
+	return "$ " + item.Command
+}
+
+
+// shellCommand wraps the command line into the OS-specific shell invocation.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+
+// validateStreamItem checks stream-specific attributes of the item.
+func validateStreamItem(item BackupItem) error {
+	if item.Destination == "" {
+		return fmt.Errorf("%q is required for items of type %q", "destination", ItemTypeStream)
+	}
+	if err := validateDestination(item.Destination); err != nil {
+		return err
+	}
+	if item.Source != "" {
+		return fmt.Errorf("%q is not supported for items of type %q, use %q to read from a command output", "source", ItemTypeStream, "command")
+	}
+	if len(item.Include) > 0 || len(item.Exclude) > 0 {
+		return fmt.Errorf("%q and %q are not supported for items of type %q", "include", "exclude", ItemTypeStream)
+	}
+	if item.IncludeHidden != nil || item.SkipSystemAttrib {
+		return fmt.Errorf("%q and %q are not supported for items of type %q", "include_hidden", "skip_system_attrib", ItemTypeStream)
+	}
+	if item.MaxDepth != 0 || item.MaxFiles != 0 {
+		return fmt.Errorf("%q and %q are not supported for items of type %q", "max_depth", "max_files", ItemTypeStream)
+	}
+	if item.FollowSymlinks {
+		return fmt.Errorf("%q is not supported for items of type %q", "follow_symlinks", ItemTypeStream)
+	}
+	if item.SkipNodump || len(item.ExcludeIfPresent) > 0 {
+		return fmt.Errorf("%q and %q are not supported for items of type %q", "skip_nodump", "exclude_if_present", ItemTypeStream)
+	}
+	if item.MaxSize != "" || item.MaxSizeAction != "" {
+		return fmt.Errorf("%q and %q are not supported for items of type %q", "max_size", "max_size_action", ItemTypeStream)
+	}
+	return nil
+}
+
+
+
+//////////////  BACKUP FUNCTIONS  /////////////////////////////////////////////
+
+// ADD STDIN STREAM ITEM (from '-stream' command-line argument)
+func (app *BackupApp) addStdinStream(destination string) error {
+	item := BackupItem{
+		Type:        ItemTypeStream,
+		Destination: destination,
+	}
+	if err := validateStreamItem(item); err != nil {
+		return err
+	}
+	app.BkpConfig.BkpItems = append(app.BkpConfig.BkpItems, item)
+	return app.reserveStdin()
+}
+
+
+// RESERVE STDIN FOR BACKUP STREAM
+// Stdin can only feed one item, and user prompts can't read from it anymore.
+func (app *BackupApp) reserveStdin() error {
+	var count int
+	for _, item := range app.BkpConfig.BkpItems {
+		if isStdinItem(item) {
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	if count > 1 {
+		return fmt.Errorf("only one item can read from %s, found %d", StdinLabel, count)
+	}
+	if !app.nonInteractive {
+		logger.Info(fmt.Sprintf("Backup stream is read from %s, switching to non-interactive mode.\n", StdinLabel))
+		app.nonInteractive = true
+	}
+	return nil
+}
+
+
+// BACKUP STREAM ITEM (stdin or command's stdout)
+func (app *BackupApp) backupStreamItem(item BackupItem, destPath string, progressCb func()) error {
+	// Tar headers require the size up front, so the stream is spooled to a temporary file first
+	if app.tarOut != nil {
+		spool, err := os.CreateTemp("", Prefix+"-stream-*")
+		if err != nil {
+			return fmt.Errorf("creating spool file: %w", err)
+		}
+		spoolPath := spool.Name()
+		spool.Close()
+		defer os.Remove(spoolPath)
+		os.Chmod(spoolPath, 0644) // same as files created by os.Create
+
+		if _, _, err := app.readStream(item, spoolPath); err != nil {
+			return err
+		}
+		if _, err := app.copyFile(spoolPath, destPath, func() {}); err != nil {
+			return err
+		}
+		progressCb()
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("creating destination directory: %w", err)
+	}
+	size, sum, err := app.readStream(item, destPath)
+	if err != nil {
+		return err
+	}
+	app.recordFile(destPath, size, time.Now(), sum)
+
+	progressCb()
+	return nil
+}
+
+
+// READ STREAM (stdin or command's stdout) INTO FILE
+// Returns the size and checksum ('hash_algorithm') of the content.
+func (app *BackupApp) readStream(item BackupItem, destPath string) (int64, []byte, error) {
+	var src io.Reader = os.Stdin
+	var cmd *exec.Cmd
+	var stderr bytes.Buffer
+
+	if item.Command != "" {
+		cmd = shellCommand(item.Command)
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return 0, nil, fmt.Errorf("preparing command: %w", err)
+		}
+		if err := cmd.Start(); err != nil {
+			return 0, nil, fmt.Errorf("starting command: %w", err)
+		}
+		src = stdout
+	}
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		if cmd != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return 0, nil, err
+	}
+
+	hash := newHash(app.BkpConfig.HashAlgorithm)
+	size, copyErr := io.Copy(io.MultiWriter(destFile, hash), src)
+	if copyErr == nil && app.BkpConfig.Durability == DurabilityFsync {
+		copyErr = destFile.Sync()
+	}
+	closeErr := destFile.Close()
+
+	if cmd != nil {
+		if err := cmd.Wait(); err != nil && copyErr == nil {
+			copyErr = fmt.Errorf("command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+
+	// Never leave a truncated stream behind, it would look like a valid backup
+	if copyErr != nil {
+		os.Remove(destPath)
+		return 0, nil, copyErr
+	}
+
+	return size, hash.Sum(nil), nil
+}
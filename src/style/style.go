@@ -1,32 +1,254 @@
+// Package style is the single output module of the app: every message goes through Style.
+//
+// Levels: Plain, Sub, Info, Warn, Err, Fatal, Ok and Signature differ by color and default label.
+// Labels: Info/Warn/Err/Fatal/Ok prefix the message with "[INFO]", "[WARNING]", etc., NoLabel() suppresses it.
+// Bold: Bold() makes the message bold on the screen.
+// Routing: each message is printed to the screen (stdout, or SetOutput) with colors, and written
+// to the log.Logger passed to New as plain text, one log entry per line (io.Discard logger disables the log file).
+// Call Flush before exit, so the last incomplete line is not lost.
+// Screen, StatusLine and ClearStatusLine write transient screen output only, which is never logged.
+// Display: SetTheme (colors), SetEmoji (Icon), SetVerbosity and SetShowSub control the screen output.
+// Log level (SetLogLevel) filters logged messages the same way, independently of the screen verbosity.
+// SetLogJSON switches the log to JSON lines for log collectors.
+// Messages never get an automatic newline.
 package style
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
 )
 
 // Style controls how log messages are printed to the screen and optionally to a log file.
 type Style struct {
-	out    *os.File
-	logger *log.Logger
+	out       *os.File
+	logger    *log.Logger
+	history   *history
+	theme     Theme
+	emoji     bool
+	verbosity Level
+	logLevel  Level
+	showSub   bool
+
+	logMu           sync.Mutex
+	pending         strings.Builder   // incomplete line, waiting for the rest of it to be logged
+	pendingSeverity int               // most severe message kind in the pending line
+	jsonFields      map[string]string // static fields of JSON log lines (nil - plain text log)
+}
+
+// Level is the verbosity of the screen output, and the minimum verbosity a message needs to be shown.
+type Level int
+
+const (
+	LevelQuiet   Level = iota // warnings and errors only
+	LevelNormal               // default
+	LevelVerbose              // additional details
+	LevelDebug                // everything
+)
+
+// levelNames maps verbosity names (as used in configuration) to levels.
+var levelNames = map[string]Level{
+	"quiet":   LevelQuiet,
+	"normal":  LevelNormal,
+	"verbose": LevelVerbose,
+	"debug":   LevelDebug,
+}
+
+// Theme is a set of ANSI color sequences for message kinds. Empty sequence means no color.
+type Theme struct {
+	Sub       string
+	Info      string
+	Warn      string
+	Err       string
+	Ok        string
+	Signature string
+	NoBold    bool // don't use bold either (no ANSI sequences at all)
+}
+
+// Themes by name
+var themes = map[string]Theme{
+	// 24-bit colors for sub-messages and signatures
+	"default": {Sub: ansiSubGray, Info: ansiFgCyan, Warn: ansiFgYellow, Err: ansiFgRed, Ok: ansiFgGreen, Signature: ansiSignature},
+	// 8 colors only, for terminals without true color support
+	"basic": {Sub: ansiFgGray, Info: ansiFgCyan, Warn: ansiFgYellow, Err: ansiFgRed, Ok: ansiFgGreen, Signature: ansiFgMagenta},
+	// no colors, for terminals and logs that show ANSI sequences as garbage
+	"mono": {NoBold: true},
+}
+
+// Severities of logged lines in JSON log, by message label (unlabeled messages are "info")
+var severities = []string{"debug", "info", "warning", "error", "fatal"}
+
+var labelSeverity = map[string]int{
+	"[DEBUG]":   0,
+	"[INFO]":    1,
+	"[OK]":      1,
+	"[WARNING]": 2,
+	"[ERROR]":   3,
+	"[FATAL]":   4,
+}
+
+// history keeps the most recent messages (plain text) in a ring buffer.
+type history struct {
+	mu       sync.Mutex
+	messages []string
+	next     int
+	full     bool
 }
 
 // New creates a new Style that prints to stdout and uses the provided log.Logger
 // for optional log-file output.
+// Colors follow the NO_COLOR convention (https://no-color.org).
 func New(logger *log.Logger) *Style {
+	theme := themes["default"]
+	if os.Getenv("NO_COLOR") != "" {
+		theme = themes["mono"]
+	}
 	return &Style{
-		out:    os.Stdout,
-		logger: logger,
+		out:       os.Stdout,
+		logger:    logger,
+		theme:     theme,
+		emoji:     true,
+		verbosity: LevelNormal,
+		logLevel:  LevelNormal,
+		showSub:   true,
+	}
+}
+
+// SetLogJSON writes the log as JSON lines: {"time":..., "level":..., "msg":...} plus the static fields
+// (e.g. run ID). The log.Logger passed to New should have no prefix and flags then.
+func (s *Style) SetLogJSON(fields map[string]string) {
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	s.jsonFields = fields
+}
+
+// SetOutput redirects screen output (e.g. to os.Stderr when stdout carries data).
+func (s *Style) SetOutput(out *os.File) {
+	s.out = out
+}
+
+// ThemeNames returns names of the available themes, sorted.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTheme selects the color theme by name (see ThemeNames).
+func (s *Style) SetTheme(name string) error {
+	theme, ok := themes[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, expected one of: %s", name, strings.Join(ThemeNames(), ", "))
+	}
+	s.theme = theme
+	return nil
+}
+
+// ParseLevel returns the verbosity level by name: quiet, normal, verbose or debug.
+func ParseLevel(name string) (Level, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return LevelNormal, fmt.Errorf("unknown verbosity %q, expected one of: quiet, normal, verbose, debug", name)
+	}
+	return level, nil
+}
+
+// SetVerbosity hides screen messages that need higher verbosity.
+func (s *Style) SetVerbosity(level Level) {
+	s.verbosity = level
+}
+
+// SetLogLevel filters logged messages by level, like SetVerbosity does for the screen.
+func (s *Style) SetLogLevel(level Level) {
+	s.logLevel = level
+}
+
+// Enabled reports whether messages of the level are shown or logged at all.
+// Allows to skip preparing messages that would be dropped, e.g. per-file Debug messages.
+func (s *Style) Enabled(level Level) bool {
+	return s != nil && (level <= s.verbosity || level <= s.logLevel)
+}
+
+// SetEmoji switches emoji returned by Icon on or off.
+func (s *Style) SetEmoji(enabled bool) {
+	s.emoji = enabled
+}
+
+// SetShowSub shows or hides Sub messages on the screen (they are still logged).
+func (s *Style) SetShowSub(show bool) {
+	s.showSub = show
+}
+
+// Icon returns the emoji, or its text replacement if emoji are switched off.
+func (s *Style) Icon(emoji, text string) string {
+	if s != nil && !s.emoji {
+		return text
+	}
+	return emoji
+}
+
+// KeepHistory makes Style remember the last 'size' printed messages (plain text, as logged),
+// so they can be saved elsewhere, e.g. as a log excerpt. Transient screen output is not kept.
+func (s *Style) KeepHistory(size int) {
+	if size <= 0 {
+		s.history = nil
+		return
+	}
+	s.history = &history{messages: make([]string, size)}
+}
+
+// History returns the remembered messages concatenated, oldest first.
+// Empty if KeepHistory was not called.
+func (s *Style) History() string {
+	if s == nil || s.history == nil {
+		return ""
+	}
+	h := s.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var sb strings.Builder
+	if h.full {
+		for _, msg := range h.messages[h.next:] {
+			sb.WriteString(msg)
+		}
+	}
+	for _, msg := range h.messages[:h.next] {
+		sb.WriteString(msg)
+	}
+	return sb.String()
+}
+
+// remember adds the message to history, overwriting the oldest one when full.
+func (h *history) remember(msg string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages[h.next] = msg
+	h.next++
+	if h.next == len(h.messages) {
+		h.next = 0
+		h.full = true
 	}
 }
 
 // ---- Options ----
 
 type options struct {
-	bold    bool
-	noLabel bool
+	bold      bool
+	noLabel   bool
+	screenOff bool // log only
 }
 
 // Option configures how a Style method behaves.
@@ -45,31 +267,43 @@ func NoLabel() Option {
 // ---- ANSI helpers ----
 
 const (
-	ansiReset = "\x1b[0m"
-	ansiBold  = "\x1b[1m"
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiEraseLine = "\x1b[K" // erase from cursor to the end of line
 
 	// 8-color ANSI
-	ansiFgCyan   = "\x1b[36m"
-	ansiFgYellow = "\x1b[33m"
-	ansiFgRed    = "\x1b[31m"
-	ansiFgGreen  = "\x1b[32m"
+	ansiFgCyan    = "\x1b[36m"
+	ansiFgYellow  = "\x1b[33m"
+	ansiFgRed     = "\x1b[31m"
+	ansiFgGreen   = "\x1b[32m"
+	ansiFgMagenta = "\x1b[35m"
+	ansiFgGray    = "\x1b[90m"
 
 	// 24‑bit RGB
 	ansiSubGray   = "\x1b[38;2;150;150;150m"
 	ansiSignature = "\x1b[38;2;242;103;18m"
 )
 
+// ANSI sequences in message text (e.g. from command output), removed from the log
+var ansiSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
 // core printing helper; NEVER appends newline.
-func (s *Style) print(msg, color, defaultLabel string, opts ...Option) {
+// The message is shown if verbosity is at least 'level', and logged if log level is at least 'level'.
+func (s *Style) print(level Level, msg, color, defaultLabel string, opts ...Option) {
 	if s == nil {
 		return
 	}
-
 	cfg := options{}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
 
+	show := level <= s.verbosity && !cfg.screenOff
+	logged := level <= s.logLevel
+	if !show && !logged {
+		return
+	}
+
 	text := msg
 	if defaultLabel != "" && !cfg.noLabel {
 		text = defaultLabel + " " + text
@@ -82,7 +316,7 @@ func (s *Style) print(msg, color, defaultLabel string, opts ...Option) {
 		prefix += color
 		suffix = ansiReset
 	}
-	if cfg.bold {
+	if cfg.bold && !s.theme.NoBold {
 		prefix = ansiBold + prefix
 		if suffix == "" {
 			suffix = ansiReset
@@ -90,55 +324,186 @@ func (s *Style) print(msg, color, defaultLabel string, opts ...Option) {
 	}
 
 	// Print to screen, no automatic newline.
-	fmt.Fprint(s.out, prefix+text+suffix)
+	if show {
+		fmt.Fprint(s.out, prefix+text+suffix)
+	}
+
+	if logged {
+		severity, ok := labelSeverity[defaultLabel]
+		if !ok {
+			severity = 1
+		}
+		s.writeLog(text, severity)
+		if s.history != nil {
+			s.history.remember(text)
+		}
+	}
+}
+
+// writeLog writes complete lines of the text to the log, without ANSI sequences and empty lines.
+// The incomplete last line waits for the next message, so a line printed in parts is logged as one entry
+// (with the most severe kind of its parts).
+func (s *Style) writeLog(text string, severity int) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	s.pending.WriteString(ansiSequence.ReplaceAllString(text, ""))
+	s.pendingSeverity = max(s.pendingSeverity, severity)
+	buffered := s.pending.String()
+	end := strings.LastIndex(buffered, "\n")
+	if end < 0 {
+		return
+	}
+	for _, line := range strings.Split(buffered[:end], "\n") {
+		if strings.TrimSpace(line) != "" {
+			s.logLine(line)
+		}
+	}
+	s.pending.Reset()
+	s.pending.WriteString(buffered[end+1:])
+	s.pendingSeverity = severity
+	if strings.TrimSpace(buffered[end+1:]) == "" {
+		s.pendingSeverity = 0
+	}
+}
+
+// logLine writes one line to the log, as plain text or as a JSON object. Caller holds logMu.
+func (s *Style) logLine(line string) {
+	if s.jsonFields == nil {
+		s.logger.Print(line)
+		return
+	}
+	// Fixed fields go first, so lines read well without a log viewer too
+	fields := [][2]string{{"time", time.Now().Format(time.RFC3339)}, {"level", severities[s.pendingSeverity]}, {"msg", strings.TrimSpace(line)}}
+	keys := make([]string, 0, len(s.jsonFields))
+	for key := range s.jsonFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, [2]string{key, s.jsonFields[key]})
+	}
+
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, field := range fields {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		key, _ := json.Marshal(field[0]) // strings always marshal
+		value, _ := json.Marshal(field[1])
+		sb.Write(key)
+		sb.WriteString(":")
+		sb.Write(value)
+	}
+	sb.WriteString("}")
+	s.logger.Print(sb.String())
+}
+
+// Flush logs the incomplete last line, if any.
+func (s *Style) Flush() {
+	if s == nil {
+		return
+	}
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	if line := s.pending.String(); strings.TrimSpace(line) != "" {
+		s.logLine(line)
+	}
+	s.pending.Reset()
+	s.pendingSeverity = 0
+}
 
-	// Write to log output via logger (plain text, no ANSI codes).
-    s.logger.Print(strings.TrimLeft(text, "\n"))
+// Screen prints a message to the screen only, as is. Never logged.
+// Useful for transient output, like progress bars.
+func (s *Style) Screen(msg string) {
+	if s == nil || s.verbosity == LevelQuiet {
+		return
+	}
+	fmt.Fprint(s.out, msg)
+}
+
+// Terminal returns the width of the screen output and whether it is a terminal at all
+// (false when output is redirected to a file or pipe).
+func (s *Style) Terminal() (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+	width, _, err := term.GetSize(int(s.out.Fd()))
+	if err != nil || width <= 0 {
+		return 0, false
+	}
+	return width, true
+}
+
+// StatusLine redraws the current screen line in place with a single write, to avoid flicker.
+// The message must fit into the terminal width, otherwise it wraps. Never logged.
+func (s *Style) StatusLine(msg string) {
+	s.Screen("\r" + msg + ansiEraseLine)
+}
+
+// ClearStatusLine erases the current screen line and returns the cursor to its beginning.
+func (s *Style) ClearStatusLine() {
+	s.Screen("\r" + ansiEraseLine)
 }
 
 // Plain prints a simple message, optionally bold, optionally logged.
 // No color, no label.
 func (s *Style) Plain(msg string, opts ...Option) {
-	s.print(msg, "", "", opts...)
+	s.print(LevelNormal, msg, "", "", opts...)
 }
 
 // Sub prints a "sub" message in RGB(150,150,150), optionally bold, optionally logged.
 func (s *Style) Sub(msg string, opts ...Option) {
-	s.print(msg, ansiSubGray, "", opts...)
+	if s != nil && !s.showSub {
+		opts = append(opts, func(o *options) { o.screenOff = true })
+	}
+	s.print(LevelNormal, msg, s.theme.Sub, "", opts...)
 }
 
 // Info prints an info message in FgCyan, optionally bold, with "[INFO]" by default
 // (suppressed if NoLabel is passed), and optionally logged.
 func (s *Style) Info(msg string, opts ...Option) {
-	s.print(msg, ansiFgCyan, "[INFO]", opts...)
+	s.print(LevelNormal, msg, s.theme.Info, "[INFO]", opts...)
 }
 
 // Warn prints a warning message in FgYellow, optionally bold, with "[WARN]" by default
 // (suppressed if NoLabel is passed), and optionally logged.
 func (s *Style) Warn(msg string, opts ...Option) {
-	s.print(msg, ansiFgYellow, "[WARNING]", opts...)
+	s.print(LevelQuiet, msg, s.theme.Warn, "[WARNING]", opts...)
 }
 
 // Err prints an error message in FgRed, optionally bold, with "[ERROR]" by default
 // (suppressed if NoLabel is passed), and optionally logged.
 func (s *Style) Err(msg string, opts ...Option) {
-	s.print(msg, ansiFgRed, "[ERROR]", opts...)
+	s.print(LevelQuiet, msg, s.theme.Err, "[ERROR]", opts...)
 }
 
 // Fatal prints an error message in FgRed, optionally bold, with "[FATAL]" by default
 // (suppressed if NoLabel is passed), and optionally logged.
 func (s *Style) Fatal(msg string, opts ...Option) {
-	s.print(msg, ansiFgRed, "[FATAL]", opts...)
+	s.print(LevelQuiet, msg, s.theme.Err, "[FATAL]", opts...)
 }
 
 // Ok prints a success message in FgGreen, optionally bold, with "[OK]" by default
 // (suppressed if NoLabel is passed), and optionally logged.
 func (s *Style) Ok(msg string, opts ...Option) {
-	s.print(msg, ansiFgGreen, "[OK]", opts...)
+	s.print(LevelNormal, msg, s.theme.Ok, "[OK]", opts...)
 }
 
 // Signature prints a signature message in RGB(242,103,18), optionally bold, optionally logged.
 // No label.
 func (s *Style) Signature(msg string, opts ...Option) {
-	s.print(msg, ansiSignature, "", opts...)
+	s.print(LevelNormal, msg, s.theme.Signature, "", opts...)
+}
+
+// Verbose prints an additional details message (no color, no label), if verbosity is at least "verbose".
+func (s *Style) Verbose(msg string, opts ...Option) {
+	s.print(LevelVerbose, msg, "", "", opts...)
+}
+
+// Debug prints a debug message in the sub-message color with "[DEBUG]" by default
+// (suppressed if NoLabel is passed), if verbosity is "debug".
+func (s *Style) Debug(msg string, opts ...Option) {
+	s.print(LevelDebug, msg, s.theme.Sub, "[DEBUG]", opts...)
 }
@@ -0,0 +1,133 @@
+package style
+
+import "strings"
+
+// Longest part of the last column covered by separator lines
+const separatorLastColumn = 40
+
+// Table renders rows of text cells as aligned columns, with optional header and totals row.
+// Rendered text has no colors, so it can be printed with any Style method and saved as is.
+type Table struct {
+	headers []string
+	rows    [][]string
+	totals  []string
+	right   map[int]bool
+	indent  string
+}
+
+// NewTable creates a table with the column headers (no header line is rendered if all are empty).
+func NewTable(headers ...string) *Table {
+	return &Table{headers: headers, right: make(map[int]bool), indent: "  "}
+}
+
+// AlignRight aligns the columns (zero-based indexes) to the right, e.g. for numbers.
+func (t *Table) AlignRight(columns ...int) *Table {
+	for _, col := range columns {
+		t.right[col] = true
+	}
+	return t
+}
+
+// Row adds a row. Missing cells are rendered empty.
+func (t *Table) Row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Totals sets the totals row, rendered after a separator line.
+func (t *Table) Totals(cells ...string) {
+	t.totals = cells
+}
+
+// Len returns the number of rows, not counting header and totals.
+func (t *Table) Len() int {
+	return len(t.rows)
+}
+
+// Render returns the table as text, one line per row, each line ends with newline.
+// The last column is not padded, so long text (e.g. errors) doesn't widen the whole table.
+func (t *Table) Render() string {
+	all := t.rows
+	hasHeader := strings.Join(t.headers, "") != ""
+	if hasHeader {
+		all = append([][]string{t.headers}, all...)
+	}
+	if t.totals != nil {
+		all = append(all, t.totals)
+	}
+
+	columns := 0
+	for _, row := range all {
+		columns = max(columns, len(row))
+	}
+	widths := make([]int, columns)
+	for _, row := range all {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	var sb strings.Builder
+	line := func(row []string) {
+		var lb strings.Builder
+		lb.WriteString(t.indent)
+		for i := 0; i < columns; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			pad := strings.Repeat(" ", widths[i]-displayWidth(cell))
+			switch {
+			case t.right[i]:
+				lb.WriteString(pad + cell)
+			case i == columns-1:
+				lb.WriteString(cell)
+			default:
+				lb.WriteString(cell + pad)
+			}
+			if i < columns-1 {
+				lb.WriteString("  ")
+			}
+		}
+		sb.WriteString(strings.TrimRight(lb.String(), " "))
+		sb.WriteString("\n")
+	}
+	separator := func() {
+		total := 0
+		for i, w := range widths {
+			if i == columns-1 {
+				w = min(w, separatorLastColumn)
+			}
+			total += w + 2
+		}
+		sb.WriteString(t.indent + strings.Repeat("-", max(total-2, 0)) + "\n")
+	}
+
+	if hasHeader {
+		line(t.headers)
+		separator()
+	}
+	for _, row := range t.rows {
+		line(row)
+	}
+	if t.totals != nil {
+		separator()
+		line(t.totals)
+	}
+	return sb.String()
+}
+
+// displayWidth approximates the number of terminal columns the text takes:
+// emoji take two, variation selectors and zero-width joiners take none.
+func displayWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		switch {
+		case r == 0xFE0F || r == 0x200D:
+		case r >= 0x1F300 && r <= 0x1FAFF, r >= 0x2600 && r <= 0x27BF:
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
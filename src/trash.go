@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// With 'retention.use_trash', pruned backups are moved into '<bkp_dest_dir>/.trash' instead of being removed.
+// They are purged after 'retention.trash_grace_period', or earlier when the destination runs out of free space.
+// Trash lives on the same drive, so moving a backup there is a cheap rename.
+const (
+	TrashDirName            string = ".trash"
+	TrashGracePeriodDefault string = "7d"
+)
+
+
+
+//////////////  TRASH FUNCTIONS  //////////////////////////////////////////////
+
+// REMOVE BACKUP (or move it into trash)
+func (app *BackupApp) removeBackup(backupRoot string, backup backupDir) error {
+	// Lift read-only protection, if any
+	if err := unlockBackup(backup.path); err != nil {
+		return fmt.Errorf("lifting read-only protection: %w", err)
+	}
+
+	if !app.BkpConfig.Retention.UseTrash {
+		logger.Sub(fmt.Sprintf("  removing old backup: %s\n", backup.path))
+		return os.RemoveAll(backup.path)
+	}
+
+	trashDir := filepath.Join(backupRoot, TrashDirName)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return err
+	}
+
+	// Same name can be trashed again only if the backup was restored from trash manually
+	trashPath := filepath.Join(trashDir, backup.name)
+	if err := os.RemoveAll(trashPath); err != nil {
+		return err
+	}
+
+	logger.Sub(fmt.Sprintf("  moving old backup to trash: %s\n", backup.path))
+	if err := os.Rename(backup.path, trashPath); err != nil {
+		return err
+	}
+
+	// Grace period counts from the moment of trashing
+	now := time.Now()
+	return os.Chtimes(trashPath, now, now)
+}
+
+
+// PURGE TRASHED BACKUPS OLDER THAN GRACE PERIOD
+func (app *BackupApp) purgeExpiredTrash(backupRoot string) {
+	entries := trashEntries(backupRoot)
+	for _, entry := range entries {
+		if time.Since(entry.created) < app.BkpConfig.Retention.trashGracePeriodParsed {
+			continue
+		}
+		logger.Sub(fmt.Sprintf("  purging backup from trash: %s\n", entry.name))
+		if err := removeUnlocked(entry.path); err != nil {
+			logger.Err(fmt.Sprintf("Failed to purge backup from trash: %s\n", entry.path))
+		}
+	}
+}
+
+
+// PURGE TRASHED BACKUPS (oldest first) UNTIL FREE SPACE REACHES 'required'
+// Returns free space after purging.
+func (app *BackupApp) purgeTrashForSpace(backupRoot string, required uint64) (uint64, error) {
+	freeSpace, _, err := getFreeSpace(app.bkpDest)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := trashEntries(backupRoot)
+	for i := len(entries) - 1; i >= 0 && freeSpace < required; i-- {
+		logger.Sub(fmt.Sprintf("  purging backup from trash to free up space: %s\n", entries[i].name))
+		if err := removeUnlocked(entries[i].path); err != nil {
+			return freeSpace, fmt.Errorf("purging %s: %w", entries[i].path, err)
+		}
+		if freeSpace, _, err = getFreeSpace(app.bkpDest); err != nil {
+			return 0, err
+		}
+	}
+	return freeSpace, nil
+}
+
+
+// removeUnlocked removes the directory, lifting read-only protection first, in case it's still there.
+func removeUnlocked(path string) error {
+	if err := unlockBackup(path); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+
+// trashEntries lists trashed backups, sorted from most to least recently trashed.
+// Entry creation time is the moment of trashing.
+func trashEntries(backupRoot string) []backupDir {
+	trashDir := filepath.Join(backupRoot, TrashDirName)
+	dirEntries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return nil
+	}
+
+	var entries []backupDir
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, backupDir{
+			name:    dirEntry.Name(),
+			path:    filepath.Join(trashDir, dirEntry.Name()),
+			created: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].created.After(entries[j].created)
+	})
+	return entries
+}
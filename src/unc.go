@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Scheduled runs often have no interactive session with the NAS share mapped, so a UNC destination
+// ('\\nas\backup') would be unreachable. Credentials for it are taken from (first found):
+//   - a Windows Credential Manager entry named with '--dest-credential' or SMBKP_DEST_CREDENTIAL
+//     (generic credential: 'cmdkey /generic:nas /user:NAS\backup /pass'; for a "Windows credential"
+//     of the server itself, Windows supplies it on its own and no option is needed);
+//   - SMBKP_DEST_USER and SMBKP_DEST_PASSWORD environment variables.
+// The share is connected for the process only (not mapped to a drive letter, not remembered after logoff).
+// Other platforms mount network shares in the OS (e.g. 'mount -t cifs'), UNC paths are not supported there.
+
+const (
+	DestCredentialEnv string = "SMBKP_DEST_CREDENTIAL"
+	DestUserEnv       string = "SMBKP_DEST_USER"
+	DestPasswordEnv   string = "SMBKP_DEST_PASSWORD"
+)
+
+// Credential Manager entry from '--dest-credential' (takes precedence over SMBKP_DEST_CREDENTIAL)
+var destCredentialTarget string
+
+
+
+//////////////  UNC FUNCTIONS  ////////////////////////////////////////////////
+
+// CONNECT TO UNC DESTINATION WITH CONFIGURED CREDENTIALS
+// Does nothing for other destinations, or if no credentials are configured (the session's own are used then).
+func connectDestination(dest string) error {
+	share, ok := uncShare(dest)
+	if !ok {
+		return nil
+	}
+
+	target := destCredentialTarget
+	if target == "" {
+		target = os.Getenv(DestCredentialEnv)
+	}
+	var user, password string
+	switch {
+	case target != "":
+		var err error
+		if user, password, err = readStoredCredential(target); err != nil {
+			return fmt.Errorf("reading credential %q: %w", target, err)
+		}
+	case os.Getenv(DestUserEnv) != "":
+		user, password = os.Getenv(DestUserEnv), os.Getenv(DestPasswordEnv)
+	default:
+		return nil
+	}
+
+	logger.Plain(fmt.Sprintf("Connecting to %q as %q... ", share, user))
+	if err := connectShare(share, user, password); err != nil {
+		logger.Plain("\n")
+		return fmt.Errorf("connecting to %q: %w", share, err)
+	}
+	logger.Ok("\n")
+	return nil
+}
+
+
+// uncShare returns the share part of the UNC path ('\\server\share\dir' -> '\\server\share').
+func uncShare(path string) (string, bool) {
+	path = strings.ReplaceAll(path, "/", `\`)
+	rest, ok := strings.CutPrefix(path, `\\`)
+	if !ok || strings.HasPrefix(rest, `?\`) || strings.HasPrefix(rest, `.\`) {
+		return "", false
+	}
+	parts := strings.SplitN(rest, `\`, 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return `\\` + parts[0] + `\` + parts[1], true
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errUNCUnsupported = errors.New("UNC destinations are supported on Windows only, mount the share instead")
+
+
+// readStoredCredential is not available without Windows Credential Manager.
+func readStoredCredential(target string) (string, string, error) {
+	return "", "", errUNCUnsupported
+}
+
+
+// connectShare is not available, network shares are mounted in the OS.
+func connectShare(share, user, password string) error {
+	return errUNCUnsupported
+}
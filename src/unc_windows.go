@@ -0,0 +1,103 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	credTypeGeneric          uint32        = 1
+	resourceTypeDisk         uint32        = 1
+	connectTemporary         uint32        = 4
+	errorSessionCredConflict syscall.Errno = 1219 // the share is already connected with other credentials
+)
+
+var (
+	procCredReadW           = windows.NewLazySystemDLL("advapi32.dll").NewProc("CredReadW")
+	procCredFree            = windows.NewLazySystemDLL("advapi32.dll").NewProc("CredFree")
+	procWNetAddConnection2W = windows.NewLazySystemDLL("mpr.dll").NewProc("WNetAddConnection2W")
+)
+
+// CREDENTIALW (wincred.h)
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// NETRESOURCEW (winnetwk.h)
+type netResource struct {
+	Scope       uint32
+	Type        uint32
+	DisplayType uint32
+	Usage       uint32
+	LocalName   *uint16
+	RemoteName  *uint16
+	Comment     *uint16
+	Provider    *uint16
+}
+
+
+// readStoredCredential reads user name and password of the generic credential from Credential Manager.
+func readStoredCredential(target string) (string, string, error) {
+	targetPtr, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return "", "", err
+	}
+	var cred *credential
+	if ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(targetPtr)), uintptr(credTypeGeneric), 0, uintptr(unsafe.Pointer(&cred))); ret == 0 {
+		return "", "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	user := windows.UTF16PtrToString(cred.UserName)
+	if user == "" {
+		return "", "", errors.New("credential has no user name")
+	}
+	// Password is stored as UTF-16 without terminating zero
+	blob := unsafe.Slice((*uint16)(unsafe.Pointer(cred.CredentialBlob)), cred.CredentialBlobSize/2)
+	return user, windows.UTF16ToString(blob), nil
+}
+
+
+// connectShare connects the share for this logon session, without a drive letter.
+// A share that is already connected with other credentials is used as it is.
+func connectShare(share, user, password string) error {
+	sharePtr, err := windows.UTF16PtrFromString(share)
+	if err != nil {
+		return err
+	}
+	userPtr, err := windows.UTF16PtrFromString(user)
+	if err != nil {
+		return err
+	}
+	passwordPtr, err := windows.UTF16PtrFromString(password)
+	if err != nil {
+		return err
+	}
+	resource := netResource{Type: resourceTypeDisk, RemoteName: sharePtr}
+	ret, _, _ := procWNetAddConnection2W.Call(uintptr(unsafe.Pointer(&resource)), uintptr(unsafe.Pointer(passwordPtr)), uintptr(unsafe.Pointer(userPtr)), uintptr(connectTemporary))
+	switch errno := syscall.Errno(ret); errno {
+	case 0:
+		return nil
+	case errorSessionCredConflict:
+		logger.Warn("the share is already connected with other credentials, using that connection. ")
+		return nil
+	default:
+		return errno
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simple-backup/src/style"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backup runs check once per week whether a newer release exists, and print a one-line notice with
+// the link to its release notes. The result of the last check is kept in the state file (see state.go),
+// so runs in between don't touch the network, and a failed check is not retried until the next week.
+// The check is turned off with 'update_check: false'. 'version --check' checks right away, for scripts.
+
+const (
+	LatestReleaseURL    string        = "https://api.github.com/repos/PavelStsefanovich/simple-backup/releases/latest"
+	UpdateCheckInterval time.Duration = 7 * 24 * time.Hour
+	UpdateCheckTimeout  time.Duration = 5 * time.Second
+)
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// LATEST RELEASE (as returned by GitHub API)
+type releaseInfo struct {
+	TagName string `json:"tag_name"`
+	URL     string `json:"html_url"` // release page with the changelog
+}
+
+
+// RESULT OF THE LAST UPDATE CHECK (kept in state file)
+type updateCheckState struct {
+	Checked       time.Time `yaml:"checked"`
+	LatestVersion string    `yaml:"latest_version,omitempty"`
+	ReleaseURL    string    `yaml:"release_url,omitempty"`
+}
+
+
+
+//////////////  UPDATE FUNCTIONS  /////////////////////////////////////////////
+
+// fetchLatestRelease asks GitHub for the latest release.
+func fetchLatestRelease() (releaseInfo, error) {
+	var release releaseInfo
+	client := &http.Client{Timeout: UpdateCheckTimeout}
+	req, err := http.NewRequest(http.MethodGet, LatestReleaseURL, nil)
+	if err != nil {
+		return release, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", Prefix, Version))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return release, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return release, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return release, fmt.Errorf("parsing response: %w", err)
+	}
+	if release.TagName == "" {
+		return release, fmt.Errorf("response has no release version")
+	}
+	return release, nil
+}
+
+
+// CHECK FOR NEWER RELEASE (at most once per week)
+// Prints a notice if the last known release is newer than this version. Failures are logged at debug level only.
+func (app *BackupApp) checkForUpdates() {
+	if app.BkpConfig.UpdateCheck != nil && !*app.BkpConfig.UpdateCheck {
+		return
+	}
+	state, err := readState()
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Update check skipped: %v\n", err))
+		return
+	}
+
+	if state.UpdateCheck == nil || time.Since(state.UpdateCheck.Checked) >= UpdateCheckInterval {
+		check := &updateCheckState{Checked: time.Now().UTC().Truncate(time.Second)}
+		if release, err := fetchLatestRelease(); err != nil {
+			logger.Debug(fmt.Sprintf("Update check failed: %v\n", err))
+		} else {
+			check.LatestVersion, check.ReleaseURL = release.TagName, release.URL
+		}
+		state.UpdateCheck = check
+		if err := writeState(state); err != nil {
+			logger.Debug(fmt.Sprintf("Failed to save update check: %v\n", err))
+		}
+	}
+
+	if check := state.UpdateCheck; check.LatestVersion != "" && newerVersion(check.LatestVersion, Version) {
+		logger.Info(fmt.Sprintf("Simple Backup %s is available (this is v%s). What's new: %s\n", check.LatestVersion, Version, check.ReleaseURL))
+	}
+}
+
+
+// newerVersion tells whether version 'a' is newer than 'b' (e.g. "v0.2.0" and "0.1.0").
+// Versions are compared by numeric components; pre-release suffixes are ignored.
+func newerVersion(a, b string) bool {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na = pa[i]
+		}
+		if i < len(pb) {
+			nb = pb[i]
+		}
+		if na != nb {
+			return na > nb
+		}
+	}
+	return false
+}
+
+
+// versionParts splits the version into numeric components ("v1.2.3-rc1" -> 1, 2, 3).
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	version, _, _ = strings.Cut(version, "-")
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		parts = append(parts, num)
+	}
+	return parts
+}
+
+
+
+//////////////  VERSION COMMAND  //////////////////////////////////////////////
+
+// RUN 'VERSION' COMMAND
+// With '--check', exits with code 1 if a newer release exists, and 2 if the check failed.
+func runVersionCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	check := flags.Bool("check", false, "Check whether a newer release exists (exit code 1 if it does, 2 if the check failed).")
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	if !*check {
+		printVersion()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	release, err := fetchLatestRelease()
+	if err != nil {
+		logger.Err(fmt.Sprintf("Update check failed: %v\n", err), style.Bold())
+		return 2
+	}
+	if state, err := readState(); err == nil {
+		state.UpdateCheck = &updateCheckState{Checked: time.Now().UTC().Truncate(time.Second), LatestVersion: release.TagName, ReleaseURL: release.URL}
+		writeState(state)
+	}
+
+	if newerVersion(release.TagName, Version) {
+		logger.Plain(fmt.Sprintf("v%s\nNewer release available: %s\n%s\n", Version, release.TagName, release.URL))
+		return 1
+	}
+	logger.Plain(fmt.Sprintf("v%s\nUp to date.\n", Version))
+	return 0
+}
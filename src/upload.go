@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Files written to a UNC destination ('\\nas\backup') are uploaded in chunks of 'network.upload_chunk',
+// so a dropped connection (Wi-Fi roaming, NAS or VPN restart) doesn't fail the file:
+// each chunk is flushed to the server before the next one is read, and its checksum is kept.
+// When a write fails, the share is connected again and the file reopened after a delay (growing up to
+// 'UploadRetryMaxDelay'), the last confirmed chunk is read back and checked against its checksum,
+// and the upload goes on from there, up to 'network.upload_retries' times per chunk.
+// A file whose confirmed part doesn't match anymore can't be resumed (the source was read once) and fails.
+// The chunk being uploaded, and retries, are shown in the progress line.
+
+const (
+	UploadChunkDefault   string        = "8mb"
+	UploadRetriesDefault uint8         = 5
+	LimitMinUploadChunk  uint64        = 64 * KB
+	UploadRetryDelay     time.Duration = 2 * time.Second // doubled with every retry
+	UploadRetryMaxDelay  time.Duration = time.Minute
+)
+
+var errUploadDamaged = errors.New("uploaded part doesn't match its checksum")
+
+
+
+//////////////  STRUCTS  //////////////////////////////////////////////////////
+
+// FILE BEING UPLOADED IN CHUNKS
+type chunkedUpload struct {
+	dest      string
+	file      *os.File
+	confirmed int64    // bytes flushed to the server
+	lastStart int64    // offset of the last confirmed chunk
+	lastSum   [32]byte // checksum of the last confirmed chunk
+	chunks    int64    // number of chunks of the file (by its size when the copy started)
+	done      int64    // confirmed chunks
+	note      func(string)
+}
+
+
+
+//////////////  UPLOAD FUNCTIONS  /////////////////////////////////////////////
+
+// chunkedUploads reports whether files are written to the destination in chunks (UNC destination).
+func (app *BackupApp) chunkedUploads() bool {
+	_, networkDest := uncShare(app.bkpDest)
+	return networkDest && app.tarOut == nil && app.BkpConfig.Network.uploadChunkParsed > 0
+}
+
+
+// UPLOAD FILE IN CHUNKS
+// 'note' shows the chunk and retries in the progress line of the file.
+func (app *BackupApp) copyChunked(dest string, r io.Reader, size int64, note func(string)) (int64, error) {
+	chunkSize := app.BkpConfig.Network.uploadChunkParsed
+	f, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	u := &chunkedUpload{dest: dest, file: f, chunks: max((size+int64(chunkSize)-1)/int64(chunkSize), 1), note: note}
+	defer func() {
+		if u.file != nil {
+			u.file.Close()
+		}
+	}()
+
+	// Files smaller than a chunk don't hold a chunk-sized buffer
+	buf := make([]byte, min(chunkSize, uint64(max(size, int64(LimitMinUploadChunk)))))
+	for n := int64(1); ; n++ {
+		k, readErr := io.ReadFull(r, buf)
+		if k > 0 {
+			if u.chunks > 1 {
+				note(fmt.Sprintf("chunk %d/%d", n, max(n, u.chunks)))
+			}
+			if err := app.uploadChunk(u, buf[:k], n); err != nil {
+				return u.confirmed, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return u.confirmed, nil
+		}
+		if readErr != nil {
+			return u.confirmed, readErr
+		}
+	}
+}
+
+
+// uploadChunk writes the chunk after the confirmed part and flushes it to the server,
+// resuming the upload if that fails.
+func (app *BackupApp) uploadChunk(u *chunkedUpload, chunk []byte, n int64) error {
+	retries := int(app.BkpConfig.Network.UploadRetries)
+	delay := UploadRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := errors.New("file is not open")
+		if u.file != nil {
+			if _, err = u.file.WriteAt(chunk, u.confirmed); err == nil {
+				err = u.file.Sync()
+			}
+		}
+		if err == nil {
+			u.lastStart, u.lastSum = u.confirmed, sha256.Sum256(chunk)
+			u.confirmed += int64(len(chunk))
+			u.done++
+			return nil
+		}
+		if attempt == retries {
+			return fmt.Errorf("upload failed at chunk %d (%d retries): %w", n, retries, err)
+		}
+
+		logger.Warn(fmt.Sprintf("Upload of %q interrupted at chunk %d: %v. Retrying in %s...\n", u.dest, n, err, formatDurationSeconds(delay)))
+		u.note(fmt.Sprintf("chunk %d retry %d/%d", n, attempt+1, retries))
+		time.Sleep(delay)
+		delay = min(delay*2, UploadRetryMaxDelay)
+		if err := app.resumeUpload(u); errors.Is(err, errUploadDamaged) {
+			return fmt.Errorf("chunk %d: %w", n, err)
+		} else if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to resume upload of %q: %v\n", u.dest, err))
+		}
+	}
+}
+
+
+// resumeUpload opens the file again after a failed write, checks the last confirmed chunk
+// and cuts off whatever was written after it.
+func (app *BackupApp) resumeUpload(u *chunkedUpload) error {
+	if u.file != nil {
+		u.file.Close()
+		u.file = nil
+	}
+	if err := connectDestination(app.bkpDest); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(u.dest, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if u.confirmed > 0 {
+		last := make([]byte, u.confirmed-u.lastStart)
+		if _, err := f.ReadAt(last, u.lastStart); err != nil && err != io.EOF {
+			f.Close()
+			return err
+		} else if sum := sha256.Sum256(last); err == io.EOF || !bytes.Equal(sum[:], u.lastSum[:]) {
+			f.Close()
+			return errUploadDamaged
+		}
+	}
+	if err := f.Truncate(u.confirmed); err != nil {
+		f.Close()
+		return err
+	}
+	u.file = f
+	logger.Info(fmt.Sprintf("Resuming upload of %q after %d confirmed chunks.\n", u.dest, u.done))
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"simple-backup/src/style"
+	"strings"
+)
+
+// 'verify' checks the backup content against its manifest, and the manifest against its signature.
+// Detects corrupted, modified, missing and planted files.
+
+// Files of the backup directory that are not listed in the manifest
+var backupOwnFiles = map[string]bool{
+	MetadataFileName:                 true,
+	CompleteMarkerName:               true,
+	reportFile(SummaryFileName):      true,
+	reportFile(ManifestFileName):     true,
+	reportFile(SkippedFileName):      true,
+	reportFile(LogExcerptFileName):   true,
+	reportFile(ConfigCopyFileName):   true,
+	reportFile(SignatureFileName):    true,
+	reportFile(PlaceholdersFileName): true,
+}
+
+
+
+//////////////  VERIFY COMMAND  ///////////////////////////////////////////////
+
+// RUN 'VERIFY' COMMAND
+func runVerifyCommand(cmd *command, args []string) int {
+	flags, showHelp := newCommandFlags(cmd)
+	var (
+		configFile = flags.StringP("config", "c", "", "Path to configuration file.")
+		bkpDest    = flags.StringP("bkp-dest", "b", "", "Backup destination drive or mount. Auto-discovered if not specified.")
+	)
+	flags.Parse(args)
+
+	if *showHelp {
+		flags.Usage()
+		return 0
+	}
+
+	initConsoleLogger()
+
+	app, err := NewBackupApp(*bkpDest, *configFile, false, true, false)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to initialize application: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	backup, err := resolveBackup(app.bkpDestFullPath, flags.Arg(0))
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("%v\n\n", err), style.Bold())
+		return 1
+	}
+
+	problems, err := app.verifyBackup(backup)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Verification failed: %v\n\n", err), style.Bold())
+		return 1
+	}
+
+	if backup.state != BackupLegacy {
+		recordVerified(app.bkpDestFullPath, backup.name, problems)
+	}
+
+	logger.Plain("\n")
+	if problems > 0 {
+		logger.Err(fmt.Sprintf("BACKUP VERIFICATION FAILED (%d problems)!\n\n", problems), style.NoLabel(), style.Bold())
+		return 2
+	}
+	logger.Ok("BACKUP VERIFIED SUCCESSFULLY!\n\n", style.NoLabel(), style.Bold())
+	return 0
+}
+
+
+// VERIFY BACKUP DIRECTORY
+// Returns the number of problems found.
+func (app *BackupApp) verifyBackup(backup backupDir) (int, error) {
+	problems, _, err := app.verifyBackupPart(backup, 0, nil)
+	return problems, err
+}
+
+
+// VERIFY PART OF BACKUP DIRECTORY
+// Content is checked from the manifest entry 'from' until the budget runs out (nil - no budget).
+// Completion and signature are checked when starting from the first entry, unexpected files after the last one.
+// Returns the number of problems found, and the entry to continue from (-1 when the backup is verified).
+func (app *BackupApp) verifyBackupPart(backup backupDir, from int, budget *verifyBudget) (int, int, error) {
+	logger.Signature(fmt.Sprintf("\n====  Verifying backup: %s  ===\n", backup.name))
+	problems := 0
+	if from == 0 {
+		var err error
+		if problems, err = app.verifyBackupState(backup); err != nil {
+			return problems, 0, err
+		}
+		if backup.state == BackupLegacy {
+			return problems, -1, nil
+		}
+	}
+
+	// Content
+	entries, err := readManifest(backup.path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Err("Manifest is missing, content can't be verified.\n")
+		return problems + 1, -1, nil
+	}
+	if err != nil {
+		return problems, from, err
+	}
+	if from > 0 {
+		logger.Info(fmt.Sprintf("Continuing from file %d of %d.\n", from+1, len(entries)))
+	}
+	contentProblems, next, err := app.verifyBackupContent(backup, entries, from, budget)
+	problems += contentProblems
+	if err != nil || next < len(entries) {
+		return problems, next, err
+	}
+
+	// Files that were not copied by the backup
+	listed := make(map[string]bool, len(entries))
+	obfuscated := backupObfuscated(backup.path)
+	adsListed := false
+	for _, entry := range entries {
+		diskPath, err := backupDiskPath(entry.path, obfuscated)
+		if err != nil {
+			return problems, from, err
+		}
+		listed[diskPath] = true
+		adsListed = adsListed || strings.HasPrefix(diskPath, reportFile(ADSDirName)+"/")
+	}
+	// Sidecars of alternate data streams are listed in the manifest, except in backups made before they were
+	adsUnlisted := false
+	if meta, err := readMetadata(backup.path); err == nil && meta.IncludeADS && !adsListed {
+		adsUnlisted = true
+	}
+	// Pack index and the pack files it points to ('pack_small_files') are not in the manifest
+	packed, err := readPackIndex(backup.path)
+	if err != nil {
+		return problems, from, err
+	}
+	packFiles := make(map[string]bool)
+	for _, entry := range packed {
+		packFiles[reportFile(PackDirName)+"/"+entry.pack] = true
+	}
+	if len(packed) > 0 {
+		packFiles[packIndexName()] = true
+	}
+	err = filepath.WalkDir(backup.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(backup.path, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		unlisted := packFiles[rel] || (adsUnlisted && strings.HasPrefix(rel, reportFile(ADSDirName)+"/"))
+		if !listed[rel] && !backupOwnFiles[rel] && !unlisted {
+			logger.Err(fmt.Sprintf("Unexpected file: %s\n", rel))
+			problems++
+		}
+		return nil
+	})
+	if err != nil {
+		return problems, from, err
+	}
+	return problems, -1, nil
+}
+
+
+// verifyBackupState checks completion and signature of the backup. Returns the number of problems found.
+func (app *BackupApp) verifyBackupState(backup backupDir) (int, error) {
+	problems := 0
+
+	// Completion
+	switch backup.state {
+	case BackupComplete:
+		logger.Ok("Backup is complete.\n")
+	case BackupFailed:
+		logger.Warn("Backup is complete, but its run failed: some items or files are missing (see 'history').\n")
+	case BackupLegacy:
+		logger.Warn("Backup was created by an older version, its content can't be verified.\n")
+		return 0, nil
+	default:
+		logger.Err("Backup is partial (interrupted), or its metadata was modified.\n")
+		problems++
+	}
+
+	// Signature
+	key, err := app.signingKey()
+	if err != nil {
+		return problems, err
+	}
+	_, sigErr := os.Stat(filepath.Join(backup.path, ReportDirName, SignatureFileName))
+	switch {
+	case key == nil && sigErr == nil:
+		logger.Warn(fmt.Sprintf("Backup is signed, but no signing key is configured ('signing_key_file' or %s), signature is not checked.\n", SigningKeyEnv))
+	case key == nil:
+		logger.Info("Backup is not signed.\n")
+	case sigErr != nil:
+		logger.Err("Backup is not signed, though signing key is configured.\n")
+		problems++
+	default:
+		required := []string{reportFile(ManifestFileName), MetadataFileName}
+		if _, err := os.Stat(filepath.Join(backup.path, filepath.FromSlash(packIndexName()))); err == nil {
+			required = append(required, packIndexName())
+		}
+		mismatched, err := verifySignature(backup.path, key, required)
+		if err != nil {
+			return problems, err
+		}
+		if len(mismatched) == 0 {
+			logger.Ok("Signature is valid.\n")
+		}
+		for _, name := range mismatched {
+			logger.Err(fmt.Sprintf("Signature doesn't match: %s\n", name))
+			problems++
+		}
+	}
+
+	return problems, nil
+}
+
+
+// verifyBackupContent checks files of the manifest entries from 'from' against their checksums,
+// until the budget runs out. Returns the number of problems found, and the entry to continue from.
+func (app *BackupApp) verifyBackupContent(backup backupDir, entries []manifestEntry, from int, budget *verifyBudget) (int, int, error) {
+	algorithm, err := manifestAlgorithm(backup.path)
+	if err != nil {
+		return 0, from, err
+	}
+
+	packed, err := readPackIndex(backup.path)
+	if err != nil {
+		return 0, from, err
+	}
+
+	obfuscated := backupObfuscated(backup.path)
+	problems, matched := 0, 0
+	spin := newSpinner("Verifying")
+	defer spin.clear()
+	for i := from; i < len(entries); i++ {
+		if budget.spent() {
+			spin.clear()
+			logger.Info(fmt.Sprintf("Verification budget is spent, stopped at file %d of %d (%d matching).\n", i+1, len(entries), matched))
+			return problems, i, nil
+		}
+		entry := entries[i]
+		diskPath, err := backupDiskPath(entry.path, obfuscated)
+		if err != nil {
+			return problems, i, err
+		}
+		spin.update(fmt.Sprintf("%d/%d files", i+1, len(entries)))
+
+		var size int64
+		var sum string
+		if pack, ok := packed[diskPath]; ok {
+			size, sum, err = packedChecksum(backup.path, pack, algorithm)
+		} else {
+			size, sum, err = fileChecksum(filepath.Join(backup.path, filepath.FromSlash(diskPath)), algorithm)
+		}
+		budget.read(size)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			spin.clear()
+			logger.Err(fmt.Sprintf("Missing: %s\n", entry.path))
+			problems++
+		case err != nil:
+			spin.clear()
+			logger.Err(fmt.Sprintf("Unreadable: %s (%v)\n", entry.path, err))
+			problems++
+		case size != entry.size || sum != entry.sum:
+			spin.clear()
+			logger.Err(fmt.Sprintf("Modified: %s\n", entry.path))
+			problems++
+		default:
+			matched++
+		}
+	}
+	spin.clear()
+
+	logger.Plain(fmt.Sprintf("Files matching the manifest: %d of %d\n", matched, len(entries)-from))
+	return problems, len(entries), nil
+}